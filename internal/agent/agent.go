@@ -0,0 +1,211 @@
+// Package agent runs hostcfg as a long-running daemon that converges the host
+// on an interval.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/z0mbix/hostcfg/internal/engine"
+	"github.com/z0mbix/hostcfg/internal/resource"
+	"github.com/z0mbix/hostcfg/internal/state"
+)
+
+// Run statuses recorded in the report
+const (
+	StatusUnchanged = "unchanged" // nothing needed changing
+	StatusChanged   = "changed"   // changes were applied
+	StatusPending   = "pending"   // changes are needed but were not applied (noop)
+	StatusFailed    = "failed"    // the run failed
+	StatusLocked    = "locked"    // another run held the lock
+)
+
+// ConvergeFunc loads the configuration, plans and, unless noop is set,
+// applies. It returns the plan even when the apply fails.
+type ConvergeFunc func(ctx context.Context, noop bool) (*engine.PlanResult, error)
+
+// Agent periodically converges the host
+type Agent struct {
+	Interval   time.Duration // time between runs
+	Splay      time.Duration // maximum random delay added before each run
+	Timeout    time.Duration // maximum duration of a run, the interval if zero
+	Noop       bool          // only plan and report, never apply
+	ReportPath string        // where the last-run report is written
+	Store      *state.Store  // state store, whose lock prevents overlapping runs
+	Converge   ConvergeFunc
+	Out        io.Writer
+}
+
+// Report describes the last run
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   float64   `json:"duration_seconds"`
+	Noop       bool      `json:"noop"`
+	Status     string    `json:"status"`
+	Add        int       `json:"add"`
+	Change     int       `json:"change"`
+	Destroy    int       `json:"destroy"`
+	Skip       int       `json:"skip"`
+	Resources  []string  `json:"resources,omitempty"` // resources with changes
+	Error      string    `json:"error,omitempty"`
+}
+
+// Run converges the host every interval until ctx is cancelled. The first run
+// starts after a random splay; a value on wake starts a run immediately.
+// Cancelling ctx stops the agent once the current run has finished, which is
+// only cut short by the run timeout.
+func (a *Agent) Run(ctx context.Context, wake <-chan struct{}) error {
+	mode := "apply"
+	if a.Noop {
+		mode = "noop"
+	}
+	a.logf("Agent started (%s mode, interval %s, splay %s)", mode, a.Interval, a.Splay)
+
+	delay := a.splay()
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.logf("Agent stopped")
+			return nil
+		case <-wake:
+			timer.Stop()
+			a.logf("Run requested")
+		case <-timer.C:
+		}
+
+		runCtx, cancel := a.runContext(ctx)
+		report := a.RunOnce(runCtx)
+		cancel()
+		if ctx.Err() != nil {
+			a.logf("Agent stopped")
+			return nil
+		}
+
+		delay = a.Interval + a.splay()
+		a.logf("Run %s, next run at %s", report.Status, time.Now().Add(delay).Format(time.RFC3339))
+	}
+}
+
+// RunOnce performs a single run while holding the lock and writes the report
+func (a *Agent) RunOnce(ctx context.Context) *Report {
+	report := &Report{
+		StartedAt: time.Now().UTC(),
+		Noop:      a.Noop,
+	}
+
+	lock, err := a.Store.Lock()
+	if err != nil {
+		// Another run is converging the host; leave the last report alone
+		a.logf("Skipping run: %v", err)
+		report.Status = StatusLocked
+		report.Error = err.Error()
+		return report
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			a.logf("Warning: %v", err)
+		}
+	}()
+
+	a.logf("Starting run")
+	result, err := a.Converge(ctx, a.Noop)
+
+	report.FinishedAt = time.Now().UTC()
+	report.Duration = report.FinishedAt.Sub(report.StartedAt).Seconds()
+	if result != nil {
+		report.Add = result.ToAdd
+		report.Change = result.ToChange
+		report.Destroy = result.ToDestroy
+		report.Skip = result.ToSkip
+		for _, r := range result.Resources {
+			id := resource.ID(r)
+			if plan := result.Plans[id]; plan != nil && plan.HasChanges() {
+				report.Resources = append(report.Resources, id)
+			}
+		}
+	}
+
+	switch {
+	case err != nil:
+		report.Status = StatusFailed
+		report.Error = err.Error()
+		a.logf("Run failed: %v", err)
+	case result == nil || !result.HasChanges():
+		report.Status = StatusUnchanged
+	case a.Noop:
+		report.Status = StatusPending
+	default:
+		report.Status = StatusChanged
+	}
+
+	if err := a.writeReport(report); err != nil {
+		a.logf("Warning: %v", err)
+	}
+	return report
+}
+
+// runContext returns the context of a run, which is not cancelled with ctx
+// so that stopping the agent lets the run finish, but is bounded by the
+// timeout
+func (a *Agent) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = a.Interval
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+// splay returns a random delay up to the configured splay
+func (a *Agent) splay() time.Duration {
+	if a.Splay <= 0 {
+		return 0
+	}
+	return rand.N(a.Splay)
+}
+
+// writeReport writes the report atomically, so readers never see a partial file
+func (a *Agent) writeReport(report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	dir := filepath.Dir(a.ReportPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	tmpFile, err := os.CreateTemp(dir, ".report-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	_, err = tmpFile.Write(append(data, '\n'))
+	_ = tmpFile.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, a.ReportPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) logf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(a.Out, "%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// DefaultReportPath returns the report location next to the state file
+func DefaultReportPath(store *state.Store) string {
+	return filepath.Join(filepath.Dir(store.Path()), "last_run.json")
+}
@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/z0mbix/hostcfg/internal/engine"
+	"github.com/z0mbix/hostcfg/internal/state"
+)
+
+func newTestAgent(t *testing.T, converge ConvergeFunc) *Agent {
+	t.Helper()
+
+	dir := t.TempDir()
+	return &Agent{
+		Interval:   time.Hour,
+		ReportPath: filepath.Join(dir, "last_run.json"),
+		Store:      state.NewStore(filepath.Join(dir, "state.json")),
+		Converge:   converge,
+		Out:        &bytes.Buffer{},
+	}
+}
+
+func readReport(t *testing.T, path string) *Report {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("failed to parse report: %v", err)
+	}
+	return &report
+}
+
+func TestAgent_RunOnce_Status(t *testing.T) {
+	tests := []struct {
+		name     string
+		noop     bool
+		result   *engine.PlanResult
+		err      error
+		expected string
+	}{
+		{"unchanged", false, &engine.PlanResult{}, nil, StatusUnchanged},
+		{"changed", false, &engine.PlanResult{ToAdd: 1, ToChange: 2}, nil, StatusChanged},
+		{"pending", true, &engine.PlanResult{ToAdd: 1, ToChange: 2}, nil, StatusPending},
+		{"failed", false, &engine.PlanResult{ToAdd: 1}, errors.New("apply failed"), StatusFailed},
+		{"failed to load", false, nil, errors.New("bad config"), StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotNoop bool
+			a := newTestAgent(t, func(ctx context.Context, noop bool) (*engine.PlanResult, error) {
+				gotNoop = noop
+				return tt.result, tt.err
+			})
+			a.Noop = tt.noop
+
+			report := a.RunOnce(context.Background())
+			if report.Status != tt.expected {
+				t.Errorf("expected status %s, got %s", tt.expected, report.Status)
+			}
+			if gotNoop != tt.noop {
+				t.Errorf("expected converge to be called with noop=%v", tt.noop)
+			}
+
+			written := readReport(t, a.ReportPath)
+			if written.Status != tt.expected || written.Noop != tt.noop {
+				t.Errorf("unexpected report on disk: %+v", written)
+			}
+			if tt.result != nil && (written.Add != tt.result.ToAdd || written.Change != tt.result.ToChange) {
+				t.Errorf("expected counts from the plan, got %+v", written)
+			}
+			if tt.err != nil && written.Error != tt.err.Error() {
+				t.Errorf("expected error %q in report, got %q", tt.err, written.Error)
+			}
+			if written.FinishedAt.Before(written.StartedAt) {
+				t.Errorf("finished before it started: %+v", written)
+			}
+
+			// The lock is released after the run
+			if _, err := os.Stat(a.Store.Path() + ".lock"); !os.IsNotExist(err) {
+				t.Error("expected lock to be released")
+			}
+		})
+	}
+}
+
+func TestAgent_RunOnce_Locked(t *testing.T) {
+	called := false
+	a := newTestAgent(t, func(ctx context.Context, noop bool) (*engine.PlanResult, error) {
+		called = true
+		return &engine.PlanResult{}, nil
+	})
+
+	lock, err := a.Store.Lock()
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	report := a.RunOnce(context.Background())
+	if report.Status != StatusLocked {
+		t.Errorf("expected status %s, got %s", StatusLocked, report.Status)
+	}
+	if called {
+		t.Error("converge should not run while another run holds the lock")
+	}
+	if _, err := os.Stat(a.ReportPath); !os.IsNotExist(err) {
+		t.Error("a skipped run should not replace the last report")
+	}
+}
+
+func TestAgent_Run_Wake(t *testing.T) {
+	var mu sync.Mutex
+	runs := 0
+	ran := make(chan struct{}, 10)
+
+	a := newTestAgent(t, func(ctx context.Context, noop bool) (*engine.PlanResult, error) {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		ran <- struct{}{}
+		return &engine.PlanResult{}, nil
+	})
+	// Long enough that only the first run and wake-ups happen during the test
+	a.Interval = time.Hour
+	var out bytes.Buffer
+	a.Out = &out
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wake := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx, wake) }()
+
+	waitForRun := func() {
+		t.Helper()
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a run")
+		}
+	}
+
+	// With no splay the first run starts straight away
+	waitForRun()
+
+	// A wake-up starts another run without waiting for the interval
+	wake <- struct{}{}
+	waitForRun()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop after cancel")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs != 2 {
+		t.Errorf("expected 2 runs, got %d", runs)
+	}
+	if !strings.Contains(out.String(), "Run requested") {
+		t.Errorf("expected wake-up to be logged, got:\n%s", out.String())
+	}
+}
+
+func TestAgent_Run_StopDuringRun(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var runErr error
+
+	a := newTestAgent(t, func(ctx context.Context, noop bool) (*engine.PlanResult, error) {
+		close(started)
+		<-release
+		runErr = ctx.Err()
+		return &engine.PlanResult{ToChange: 1}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx, nil) }()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a run")
+	}
+
+	// Stopping the agent lets the run in progress finish
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("agent stopped before the run finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop after the run")
+	}
+	if runErr != nil {
+		t.Errorf("expected the run not to be cancelled, got %v", runErr)
+	}
+	if report := readReport(t, a.ReportPath); report.Status != StatusChanged {
+		t.Errorf("expected the run to be reported as %s, got %s", StatusChanged, report.Status)
+	}
+}
+
+func TestAgent_Run_Timeout(t *testing.T) {
+	a := newTestAgent(t, func(ctx context.Context, noop bool) (*engine.PlanResult, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	a.Timeout = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runCtx, stop := a.runContext(ctx)
+	defer stop()
+
+	report := a.RunOnce(runCtx)
+	if report.Status != StatusFailed || !strings.Contains(report.Error, "deadline exceeded") {
+		t.Errorf("expected the run to time out, got %+v", report)
+	}
+}
+
+func TestAgent_Splay(t *testing.T) {
+	a := &Agent{Splay: 10 * time.Millisecond}
+	for range 100 {
+		if d := a.splay(); d < 0 || d >= a.Splay {
+			t.Fatalf("splay %s out of range [0, %s)", d, a.Splay)
+		}
+	}
+
+	a.Splay = 0
+	if d := a.splay(); d != 0 {
+		t.Errorf("expected no splay, got %s", d)
+	}
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/z0mbix/hostcfg/internal/agent"
+	"github.com/z0mbix/hostcfg/internal/engine"
+)
+
+var (
+	agentInterval    time.Duration
+	agentSplay       time.Duration
+	agentTimeout     time.Duration
+	agentNoop        bool
+	agentReport      string
+	agentParallelism int
+)
+
+// NewAgentCmd creates the agent command
+func NewAgentCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run continuously, converging the system on an interval",
+		Long: `The agent command runs until it is stopped, converging the system
+every interval. Each run fetches the configuration again (including
+remote sources), gathers facts, plans and applies the changes without
+asking for confirmation. With --noop, runs only plan and report.
+
+Runs start after a random delay of up to --splay, so a fleet of hosts
+does not converge at the same moment. A lock next to the state file
+prevents runs from overlapping, including with 'hostcfg apply'. The
+result of each run is written to a JSON report.
+
+Send SIGHUP to start a run immediately. SIGINT or SIGTERM stop the
+agent once the current run has finished, or after --timeout.`,
+		Args: cobra.NoArgs,
+		RunE: runAgent,
+	}
+
+	cmd.Flags().DurationVar(&agentInterval, "interval", 30*time.Minute,
+		"Time between runs")
+	cmd.Flags().DurationVar(&agentSplay, "splay", 0,
+		"Maximum random delay added before each run")
+	cmd.Flags().DurationVar(&agentTimeout, "timeout", 0,
+		"Maximum duration of a run (default: the interval)")
+	cmd.Flags().BoolVar(&agentNoop, "noop", false,
+		"Only plan and report changes, never apply them")
+	cmd.Flags().StringVar(&agentReport, "report", "",
+		"Path to the last-run report (default: last_run.json next to the state file)")
+	cmd.Flags().IntVarP(&agentParallelism, "parallelism", "p", 1,
+		"Number of independent resources to apply concurrently")
+
+	return cmd
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	if agentInterval <= 0 {
+		return fmt.Errorf("--interval must be greater than zero")
+	}
+	if agentSplay < 0 {
+		return fmt.Errorf("--splay cannot be negative")
+	}
+	if agentTimeout < 0 {
+		return fmt.Errorf("--timeout cannot be negative")
+	}
+
+	store := stateStore()
+	reportPath := agentReport
+	if reportPath == "" {
+		reportPath = agent.DefaultReportPath(store)
+	}
+
+	a := &agent.Agent{
+		Interval:   agentInterval,
+		Splay:      agentSplay,
+		Timeout:    agentTimeout,
+		Noop:       agentNoop,
+		ReportPath: reportPath,
+		Store:      store,
+		Converge:   converge,
+		Out:        os.Stdout,
+	}
+
+	// SIGINT and SIGTERM stop the agent, SIGHUP starts a run. The agent does
+	// not pass this context to the run in progress, so it finishes first.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	wake := make(chan struct{}, 1)
+	go func() {
+		for range hup {
+			select {
+			case wake <- struct{}{}:
+			default:
+				// A run is already requested
+			}
+		}
+	}()
+
+	return a.Run(ctx, wake)
+}
+
+// converge runs a single agent cycle: load the configuration from scratch,
+// plan, and apply unless noop is set. The agent holds the state lock.
+func converge(ctx context.Context, noop bool) (*engine.PlanResult, error) {
+	path, isDir, err := findConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	configDir := path
+	if !isDir {
+		configDir = filepath.Dir(path)
+	}
+
+	// A new executor gathers facts again
+	out := os.Stdout
+	executor := engine.NewExecutor(out, false)
+	configureSources(executor, configPath)
+	executor.SetParallelism(agentParallelism)
+	executor.SetStateStore(stateStore())
+
+	// External providers are started when their resource types are used
+	providers := loadProviders()
+	defer func() { _ = providers.Close() }()
+
+	if err := loadVariables(executor, configDir); err != nil {
+		return nil, err
+	}
+
+	if isDir {
+		err = executor.LoadDirectory(path)
+	} else {
+		err = executor.LoadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := executor.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.SkipOrphans("removed from configuration, use 'hostcfg apply --prune' to delete")
+
+	executor.PrintPlan(result)
+	if noop {
+		return result, nil
+	}
+	if !result.HasChanges() {
+		// Record the resources and outputs, as apply does, so that resources
+		// removed from the configuration later can be pruned
+		return result, executor.SaveState(result)
+	}
+
+	_, _ = fmt.Fprintln(out)
+	if err := executor.Apply(ctx, result, false); err != nil {
+		return result, err
+	}
+
+	_, _ = fmt.Fprintf(out, "\nApply complete! Resources: %d added, %d changed, %d destroyed.\n",
+		result.ToAdd, result.ToChange, result.ToDestroy)
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/z0mbix/hostcfg/internal/agent"
+	"github.com/z0mbix/hostcfg/internal/state"
+)
+
+func TestAgent_NoChangesWritesState(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	hclPath := filepath.Join(tmpDir, "main.hcl")
+	motd := filepath.Join(tmpDir, "motd")
+
+	// The host is already converged, so the run changes nothing
+	if err := os.WriteFile(motd, []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	config := `
+resource "file" "motd" {
+  path    = "` + motd + `"
+  content = "hello\n"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	oldConfig, oldState := configPath, statePath
+	configPath, statePath = hclPath, filepath.Join(tmpDir, "state.json")
+	t.Cleanup(func() { configPath, statePath = oldConfig, oldState })
+
+	store := stateStore()
+	a := &agent.Agent{
+		Interval:   time.Hour,
+		ReportPath: filepath.Join(tmpDir, "last_run.json"),
+		Store:      store,
+		Converge:   converge,
+		Out:        io.Discard,
+	}
+	// The run holds the state lock while it saves the state
+	report := a.RunOnce(context.Background())
+	if report.Status != agent.StatusUnchanged {
+		t.Fatalf("expected the run to change nothing, got %+v", report)
+	}
+
+	st, err := state.NewStore(store.Path()).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if _, ok := st.Get("file.motd"); !ok {
+		t.Errorf("expected file.motd in state, got %v", st.IDs())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -13,20 +14,31 @@ import (
 )
 
 var (
-	dryRun      bool
-	autoApprove bool
+	dryRun            bool
+	autoApprove       bool
+	prune             bool
+	rollbackOnFailure bool
+	keepGoing         bool
+	applyParallelism  int
 )
 
 // NewApplyCmd creates the apply command
 func NewApplyCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "apply",
+		Use:   "apply [plan-file]",
 		Short: "Apply changes to bring the system to desired state",
 		Long: `The apply command reads the configuration and makes the necessary
 changes to bring the system to the desired state.
 
 By default, it will show the plan and ask for confirmation before
-applying changes. Use -y/--yes or --auto-approve to skip confirmation.`,
+applying changes. Use -y/--yes or --auto-approve to skip confirmation.
+
+Given a plan file saved with 'hostcfg plan --out', it applies that plan
+without asking for confirmation, refusing to run if the configuration or
+the state of any resource has changed since the plan was created.
+Sensitive variables are not saved in plan files, so they must be set
+again with -e or --var-file.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: runApply,
 	}
 
@@ -34,6 +46,16 @@ applying changes. Use -y/--yes or --auto-approve to skip confirmation.`,
 		"Show what would be done without making changes")
 	cmd.Flags().BoolVarP(&autoApprove, "yes", "y", false,
 		"Skip interactive approval before applying")
+	cmd.Flags().IntVarP(&applyParallelism, "parallelism", "p", 1,
+		"Number of independent resources to apply concurrently")
+	cmd.Flags().BoolVar(&prune, "prune", false,
+		"Delete resources that were removed from the configuration")
+	cmd.Flags().BoolVar(&rollbackOnFailure, "rollback-on-failure", false,
+		"Revert applied resources if any resource fails to apply")
+	cmd.Flags().BoolVar(&keepGoing, "keep-going", false,
+		"Continue after a failure, skipping only resources that depend on it")
+	cmd.Flags().StringVar(&outputFormat, "format", "text",
+		"Output format for the plan: text or json")
 	cmd.Flags().Bool("auto-approve", false,
 		"Skip interactive approval before applying (alias for --yes)")
 	// Make --auto-approve an alias for --yes
@@ -50,8 +72,27 @@ applying changes. Use -y/--yes or --auto-approve to skip confirmation.`,
 func runApply(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
+	jsonOutput, err := isJSONFormat(outputFormat)
+	if err != nil {
+		return err
+	}
+	out := humanOutput(jsonOutput)
+
+	// Load saved plan
+	var saved *engine.SavedPlan
+	if len(args) == 1 {
+		saved, err = readSavedPlan(args[0])
+		if err != nil {
+			return err
+		}
+	}
+
 	// Find config
-	path, isDir, err := engine.FindConfigFile(configPath)
+	cfgPath := configPath
+	if saved != nil {
+		cfgPath = saved.Config
+	}
+	path, isDir, err := findConfig(cfgPath)
 	if err != nil {
 		return err
 	}
@@ -63,11 +104,48 @@ func runApply(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create executor
-	useColors := !noColor && isTerminal()
-	executor := engine.NewExecutor(os.Stdout, useColors)
+	useColors := !noColor && isTerminal() && !jsonOutput
+	executor := engine.NewExecutor(out, useColors)
+	configureSources(executor, cfgPath)
+	executor.SetParallelism(applyParallelism)
+	executor.SetRollbackOnFailure(rollbackOnFailure)
+	executor.SetKeepGoing(keepGoing)
+	store := stateStore()
+	executor.SetStateStore(store)
 
-	// Load variables (auto-load files, --var-file, -e)
-	if err := loadVariables(executor, configDir); err != nil {
+	// External providers are started when their resource types are used
+	providers := loadProviders()
+	defer func() { _ = providers.Close() }()
+
+	// Load variables (auto-load files, --var-file, -e, or the saved plan's)
+	if saved != nil {
+		// Sensitive variables are not saved in plan files, so they are
+		// loaded again. The saved values of the others take precedence.
+		if sensitive := saved.SensitiveVariables(); len(sensitive) > 0 {
+			cliVars, err := parseVariables(variables)
+			if err != nil {
+				return err
+			}
+			for name := range cliVars {
+				if !slices.Contains(sensitive, name) {
+					return fmt.Errorf("-e %s: only the sensitive variables of a saved plan can be set", name)
+				}
+			}
+			if err := loadVariables(executor, configDir); err != nil {
+				return err
+			}
+		} else if len(variables) > 0 || len(varFiles) > 0 {
+			return fmt.Errorf("--var and --var-file can only be used with a saved plan to set its sensitive variables")
+		}
+		values, err := saved.VariableValues()
+		if err != nil {
+			return err
+		}
+		for name, value := range values {
+			executor.SetVariableValue(name, value)
+			executor.SetVariableSource(name, "the saved plan "+args[0])
+		}
+	} else if err := loadVariables(executor, configDir); err != nil {
 		return err
 	}
 
@@ -82,28 +160,61 @@ func runApply(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Never change the host at the same time as another run. The lock is
+	// taken before planning, so the plan cannot go stale before it is applied.
+	if !dryRun {
+		lock, err := store.Lock()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Release() }()
+	}
+
 	// Generate plan
 	result, err := executor.Plan(ctx)
 	if err != nil {
 		return err
 	}
 
-	// Print plan
-	executor.PrintPlan(result)
+	// Leave resources removed from the configuration alone unless pruning,
+	// or the saved plan was created with --prune
+	if (saved == nil && !prune) || (saved != nil && !saved.Prune) {
+		result.SkipOrphans("removed from configuration, use --prune to delete")
+	}
+	if saved != nil {
+		// Only apply what was reviewed
+		if err := executor.VerifyPlan(saved, result); err != nil {
+			return err
+		}
+	}
 
-	// Check if there are changes
-	if !result.HasChanges() {
-		return nil
+	// Print plan
+	if jsonOutput {
+		if err := result.WriteJSON(os.Stdout); err != nil {
+			return err
+		}
+	} else {
+		executor.PrintPlan(result)
 	}
 
 	// Dry run stops here
 	if dryRun {
-		return nil
+		return printOutputs(executor, jsonOutput)
 	}
 
-	// Ask for confirmation unless auto-approve
-	if !autoApprove {
-		fmt.Print("\nDo you want to apply these changes? (yes/no): ")
+	// Check if there are changes
+	if !result.HasChanges() {
+		// Record the resources and outputs, which may have changed even
+		// though nothing is applied, such as when a variable changed
+		if err := executor.SaveState(result); err != nil {
+			return err
+		}
+		return printOutputs(executor, jsonOutput)
+	}
+
+	// Ask for confirmation unless auto-approve or applying a saved plan
+	if !autoApprove && saved == nil {
+		_, _ = fmt.Fprint(out, "\nDo you want to apply these changes? (yes/no): ")
 		reader := bufio.NewReader(os.Stdin)
 		response, err := reader.ReadString('\n')
 		if err != nil {
@@ -111,20 +222,53 @@ func runApply(cmd *cobra.Command, args []string) error {
 		}
 		response = strings.TrimSpace(strings.ToLower(response))
 		if response != "yes" && response != "y" {
-			fmt.Println("Apply cancelled.")
+			_, _ = fmt.Fprintln(out, "Apply cancelled.")
 			return nil
 		}
 	}
 
-	fmt.Println()
+	_, _ = fmt.Fprintln(out)
 
 	// Apply changes
 	if err := executor.Apply(ctx, result, false); err != nil {
 		return err
 	}
 
-	fmt.Printf("\nApply complete! Resources: %d added, %d changed, %d destroyed.\n",
+	_, _ = fmt.Fprintf(out, "\nApply complete! Resources: %d added, %d changed, %d destroyed.\n",
 		result.ToAdd, result.ToChange, result.ToDestroy)
 
+	return printOutputs(executor, jsonOutput)
+}
+
+// printOutputs prints the configuration's output values after a text plan or
+// apply. JSON output only contains the plan; use 'hostcfg output' instead.
+func printOutputs(executor *engine.Executor, jsonOutput bool) error {
+	if jsonOutput {
+		return nil
+	}
+	outputs, err := executor.Outputs()
+	if err != nil {
+		return err
+	}
+	executor.PrintOutputs(outputs)
 	return nil
 }
+
+// readSavedPlan reads a plan file for apply, checking it was created by this
+// version of hostcfg and that no flags would change what it applies
+func readSavedPlan(path string) (*engine.SavedPlan, error) {
+	if configPath != "" || prune {
+		return nil, fmt.Errorf("--config and --prune cannot be used when applying a saved plan, use 'hostcfg plan --prune --out' to prune")
+	}
+
+	saved, err := engine.ReadPlanFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if saved.Version != version {
+		return nil, fmt.Errorf("plan file %s was created by hostcfg %s, but this is hostcfg %s",
+			path, saved.Version, version)
+	}
+
+	return saved, nil
+}
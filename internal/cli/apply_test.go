@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/z0mbix/hostcfg/internal/state"
+)
+
+// runCLI runs hostcfg with args, failing the test on error
+func runCLI(t *testing.T, args ...string) {
+	t.Helper()
+	cmd := NewRootCmd()
+	cmd.SetArgs(args)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("hostcfg %v failed: %v", args, err)
+	}
+}
+
+func TestApply_NoChangesWritesState(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	hclPath := filepath.Join(tmpDir, "main.hcl")
+	statePath := filepath.Join(tmpDir, "state.json")
+	motd := filepath.Join(tmpDir, "motd")
+
+	// The host is already converged, so the first apply changes nothing
+	if err := os.WriteFile(motd, []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	config := `
+resource "file" "motd" {
+  path    = "` + motd + `"
+  content = "hello\n"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	runCLI(t, "apply", "-y", "-c", hclPath, "--state", statePath)
+
+	st, err := state.NewStore(statePath).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if _, ok := st.Get("file.motd"); !ok {
+		t.Fatalf("expected file.motd in state, got %v", st.IDs())
+	}
+
+	// So removing the resource from the configuration lets it be pruned
+	if err := os.WriteFile(hclPath, []byte(""), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	runCLI(t, "apply", "-y", "--prune", "-c", hclPath, "--state", statePath)
+	if _, err := os.Stat(motd); !os.IsNotExist(err) {
+		t.Errorf("expected the orphaned file to be pruned, got %v", err)
+	}
+}
+
+func TestApply_SavedPlanPrune(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	hclPath := filepath.Join(tmpDir, "main.hcl")
+	statePath := filepath.Join(tmpDir, "state.json")
+	planPath := filepath.Join(tmpDir, "host.plan")
+	motd := filepath.Join(tmpDir, "motd")
+
+	config := `
+resource "file" "motd" {
+  path    = "` + motd + `"
+  content = "hello\n"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	runCLI(t, "apply", "-y", "-c", hclPath, "--state", statePath)
+	if err := os.WriteFile(hclPath, []byte(""), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	// A plan saved without --prune leaves the removed resource alone
+	runCLI(t, "plan", "-c", hclPath, "--state", statePath, "--out", planPath)
+	runCLI(t, "apply", "--state", statePath, planPath)
+	if _, err := os.Stat(motd); err != nil {
+		t.Fatalf("expected the orphaned file to be left alone: %v", err)
+	}
+
+	// A plan saved with --prune deletes it
+	runCLI(t, "plan", "--prune", "-c", hclPath, "--state", statePath, "--out", planPath)
+	runCLI(t, "apply", "--state", statePath, planPath)
+	if _, err := os.Stat(motd); !os.IsNotExist(err) {
+		t.Errorf("expected the orphaned file to be pruned, got %v", err)
+	}
+}
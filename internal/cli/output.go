@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z0mbix/hostcfg/internal/engine"
+)
+
+var outputFormatFlag string
+
+// NewOutputCmd creates the output command
+func NewOutputCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "output [NAME]",
+		Short: "Show output values from the last apply",
+		Long: `The output command shows the values of the output blocks recorded
+in the state file by the last apply.
+
+With a NAME, only that output's value is printed, which is useful in
+scripts. Use --format json for machine-readable output.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runOutput,
+	}
+
+	cmd.Flags().StringVar(&outputFormatFlag, "format", "text",
+		"Output format: text or json")
+
+	return cmd
+}
+
+// jsonOutputValue is an output in the JSON format
+type jsonOutputValue struct {
+	Value       json.RawMessage `json:"value"`
+	Type        json.RawMessage `json:"type"`
+	Description string          `json:"description,omitempty"`
+	Sensitive   bool            `json:"sensitive,omitempty"`
+}
+
+func runOutput(cmd *cobra.Command, args []string) error {
+	jsonOutput, err := isJSONFormat(outputFormatFlag)
+	if err != nil {
+		return err
+	}
+
+	st, err := stateStore().Load()
+	if err != nil {
+		return err
+	}
+	values, err := engine.StateOutputs(st)
+	if err != nil {
+		return err
+	}
+
+	// A single output
+	if len(args) == 1 {
+		name := args[0]
+		o, ok := st.Outputs[name]
+		if !ok {
+			return fmt.Errorf("output %q not found, outputs are recorded by 'hostcfg apply'", name)
+		}
+		if jsonOutput {
+			_, _ = fmt.Fprintln(os.Stdout, string(o.Value))
+			return nil
+		}
+		_, _ = fmt.Fprintln(os.Stdout, engine.FormatValue(values[name]))
+		return nil
+	}
+
+	if jsonOutput {
+		outputs := make(map[string]jsonOutputValue, len(st.Outputs))
+		for name, o := range st.Outputs {
+			outputs[name] = jsonOutputValue(*o)
+		}
+		data, err := json.MarshalIndent(outputs, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(os.Stdout, string(data))
+		return nil
+	}
+
+	if len(values) == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "No outputs found, outputs are recorded by 'hostcfg apply'.")
+		return nil
+	}
+	engine.WriteOutputs(os.Stdout, values)
+	return nil
+}
@@ -2,13 +2,23 @@ package cli
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/z0mbix/hostcfg/internal/engine"
 )
 
+var (
+	outputFormat    string
+	planParallelism int
+	planOut         string
+	planPrune       bool
+)
+
 // NewPlanCmd creates the plan command
 func NewPlanCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -18,18 +28,37 @@ func NewPlanCmd() *cobra.Command {
 would be made to bring the system to the desired state. No changes
 are actually applied.
 
-This is equivalent to 'hostcfg apply --dry-run'.`,
+This is equivalent to 'hostcfg apply --dry-run'.
+
+Use --out to save the plan to a file, which 'hostcfg apply <file>' then
+applies exactly as reviewed. Resources removed from the configuration are
+left alone by a saved plan unless it is created with --prune.`,
+		Args: cobra.NoArgs,
 		RunE: runPlan,
 	}
 
+	cmd.Flags().IntVarP(&planParallelism, "parallelism", "p", 1,
+		"Number of independent resources to plan concurrently")
+	cmd.Flags().StringVar(&outputFormat, "format", "text",
+		"Output format: text or json")
+	cmd.Flags().StringVarP(&planOut, "out", "o", "",
+		"Save the plan to a file for 'hostcfg apply <file>'")
+	cmd.Flags().BoolVar(&planPrune, "prune", false,
+		"Delete resources removed from the configuration when applying the saved plan")
+
 	return cmd
 }
 
 func runPlan(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
+	jsonOutput, err := isJSONFormat(outputFormat)
+	if err != nil {
+		return err
+	}
+
 	// Find config
-	path, isDir, err := engine.FindConfigFile(configPath)
+	path, isDir, err := findConfig(configPath)
 	if err != nil {
 		return err
 	}
@@ -41,8 +70,15 @@ func runPlan(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create executor
-	useColors := !noColor && isTerminal()
-	executor := engine.NewExecutor(os.Stdout, useColors)
+	useColors := !noColor && isTerminal() && !jsonOutput
+	executor := engine.NewExecutor(humanOutput(jsonOutput), useColors)
+	configureSources(executor, configPath)
+	executor.SetParallelism(planParallelism)
+	executor.SetStateStore(stateStore())
+
+	// External providers are started when their resource types are used
+	providers := loadProviders()
+	defer func() { _ = providers.Close() }()
 
 	// Load variables (auto-load files, --var-file, -e)
 	if err := loadVariables(executor, configDir); err != nil {
@@ -65,13 +101,56 @@ func runPlan(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if planOut != "" && !planPrune {
+		// Show the saved plan as apply will run it
+		result.SkipOrphans("removed from configuration, use --prune to delete")
+	}
 
 	// Print plan
-	executor.PrintPlan(result)
+	if jsonOutput {
+		if err := result.WriteJSON(os.Stdout); err != nil {
+			return err
+		}
+	} else {
+		executor.PrintPlan(result)
+	}
+	if err := printOutputs(executor, jsonOutput); err != nil {
+		return err
+	}
+
+	// Save plan
+	if planOut != "" {
+		if err := executor.SavePlan(planOut, result, version, planPrune); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(humanOutput(jsonOutput), "\nSaved the plan to %s. To apply it, run:\n  hostcfg apply %s\n",
+			planOut, planOut)
+	}
 
 	return nil
 }
 
+// isJSONFormat checks the --format flag, returning true for json output
+func isJSONFormat(format string) (bool, error) {
+	switch strings.ToLower(format) {
+	case "text":
+		return false, nil
+	case "json":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported format: %s (supported: text, json)", format)
+	}
+}
+
+// humanOutput returns where progress messages are written. With json output
+// they go to stderr so stdout only contains the JSON document.
+func humanOutput(jsonOutput bool) io.Writer {
+	if jsonOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
 func isTerminal() bool {
 	fileInfo, _ := os.Stdout.Stat()
 	return (fileInfo.Mode() & os.ModeCharDevice) != 0
@@ -1,19 +1,27 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/z0mbix/hostcfg/internal/config"
 	"github.com/z0mbix/hostcfg/internal/engine"
+	"github.com/z0mbix/hostcfg/internal/provider"
+	"github.com/z0mbix/hostcfg/internal/resource"
+	"github.com/z0mbix/hostcfg/internal/source"
+	"github.com/z0mbix/hostcfg/internal/state"
 )
 
 var (
-	configPath string
-	variables  []string
-	varFiles   []string
-	noColor    bool
+	configPath   string
+	variables    []string
+	varFiles     []string
+	noColor      bool
+	statePath    string
+	cacheDir     string
+	providerDirs []string
 
 	// Version information (set by main)
 	version = "dev"
@@ -46,19 +54,27 @@ exec commands, and hostname configuration.`,
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "",
-		"Path to config file or directory (default: hostcfg.hcl or current directory)")
+		"Path to config file or directory, or a remote git:: or https:// source (default: hostcfg.hcl or current directory)")
 	rootCmd.PersistentFlags().StringArrayVarP(&variables, "var", "e", nil,
 		"Set a variable (key=value)")
 	rootCmd.PersistentFlags().StringArrayVarP(&varFiles, "var-file", "f", nil,
 		"Path to variable file (can be used multiple times)")
 	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false,
 		"Disable colored output")
+	rootCmd.PersistentFlags().StringVar(&statePath, "state", "",
+		"Path to the state file (default: /var/lib/hostcfg/state.json, or ~/.local/state/hostcfg/state.json for non-root users)")
+	rootCmd.PersistentFlags().StringArrayVar(&providerDirs, "provider-dir", nil,
+		"Directory to search for hostcfg-provider-* executables (can be used multiple times)")
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "",
+		"Directory for fetched remote sources (default: /var/cache/hostcfg/sources, or ~/.cache/hostcfg/sources for non-root users)")
 
 	// Add subcommands
 	rootCmd.AddCommand(NewPlanCmd())
 	rootCmd.AddCommand(NewApplyCmd())
 	rootCmd.AddCommand(NewValidateCmd())
+	rootCmd.AddCommand(NewAgentCmd())
 	rootCmd.AddCommand(NewFactsCmd())
+	rootCmd.AddCommand(NewOutputCmd())
 	rootCmd.AddCommand(NewUpdateCmd())
 
 	return rootCmd
@@ -72,6 +88,53 @@ func Execute() {
 	}
 }
 
+// stateStore returns the state store for the --state path or the default location
+func stateStore() *state.Store {
+	if statePath != "" {
+		return state.NewStore(statePath)
+	}
+	return state.NewStore(state.DefaultPath())
+}
+
+// sourceFetcher returns the fetcher for remote sources, caching in --cache-dir
+// or the default location
+func sourceFetcher() *source.Fetcher {
+	if cacheDir != "" {
+		return source.NewFetcher(cacheDir)
+	}
+	return source.NewFetcher(source.DefaultCacheDir())
+}
+
+// findConfig finds the configuration, fetching remote sources into the cache
+// first so they load like a local directory
+func findConfig(path string) (string, bool, error) {
+	if source.IsRemote(path) {
+		dir, err := sourceFetcher().Fetch(context.Background(), path)
+		if err != nil {
+			return "", false, err
+		}
+		return dir, true, nil
+	}
+	return engine.FindConfigFile(path)
+}
+
+// configureSources sets up the executor to fetch remote roles and to record
+// a remote configuration by its source rather than its cache directory
+func configureSources(executor *engine.Executor, path string) {
+	executor.SetSourceFetcher(sourceFetcher())
+	if source.IsRemote(path) {
+		executor.SetConfigSource(path)
+	}
+}
+
+// loadProviders finds the external providers in --provider-dir, the default
+// provider directories and $PATH, to be started when their resource types
+// are used
+func loadProviders() *provider.Manager {
+	dirs := append(append([]string{}, providerDirs...), provider.DefaultDirs()...)
+	return provider.Load(resource.DefaultRegistry, dirs)
+}
+
 // parseVariables parses key=value variable assignments
 func parseVariables(vars []string) (map[string]string, error) {
 	result := make(map[string]string)
@@ -111,8 +174,10 @@ func loadVariables(executor *engine.Executor, configDir string) error {
 		if diags.HasErrors() {
 			return fmt.Errorf("failed to load variable files: %s", diags.Error())
 		}
+		sources := loader.Sources()
 		for name, value := range vars {
 			executor.SetVariableValue(name, value)
+			executor.SetVariableSource(name, "the var file at "+sources[name].String())
 		}
 	}
 
@@ -123,6 +188,7 @@ func loadVariables(executor *engine.Executor, configDir string) error {
 	}
 	for k, v := range cliVars {
 		executor.SetVariable(k, v)
+		executor.SetVariableSource(k, fmt.Sprintf("the -e %s flag", k))
 	}
 
 	return nil
@@ -26,7 +26,7 @@ This is useful for CI/CD pipelines or pre-commit hooks.`,
 
 func runValidate(cmd *cobra.Command, args []string) error {
 	// Find config
-	path, isDir, err := engine.FindConfigFile(configPath)
+	path, isDir, err := findConfig(configPath)
 	if err != nil {
 		return err
 	}
@@ -39,6 +39,11 @@ func runValidate(cmd *cobra.Command, args []string) error {
 
 	// Create executor
 	executor := engine.NewExecutor(os.Stdout, !noColor)
+	configureSources(executor, configPath)
+
+	// External providers are started when their resource types are used
+	providers := loadProviders()
+	defer func() { _ = providers.Close() }()
 
 	// Load variables (auto-load files, --var-file, -e)
 	if err := loadVariables(executor, configDir); err != nil {
@@ -61,6 +66,10 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	for _, warning := range executor.Warnings() {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
 	fmt.Println("Configuration is valid.")
 	return nil
 }
@@ -67,6 +67,10 @@ func standardFunctions() map[string]function.Function {
 		"basename": basenameFunc,
 		"dirname":  dirnameFunc,
 
+		// Sensitive values
+		"sensitive":    sensitiveFunc,
+		"nonsensitive": nonsensitiveFunc,
+
 		// Type conversion functions for for_each
 		"toset": tosetFunc,
 		"tomap": tomapFunc,
@@ -301,9 +305,14 @@ func makeTemplateFunc(ctxVars map[string]cty.Value, baseDir string) function.Fun
 				return cty.StringVal(""), err
 			}
 
-			// Convert all context variables to Go map
+			// Convert all context variables to Go map. Sensitive values are
+			// unmarked to render them, and the result is sensitive if it
+			// contains any of them.
 			tmplVars := make(map[string]interface{})
+			var secrets []string
 			for k, v := range ctxVars {
+				secrets = append(secrets, SensitiveStrings(v)...)
+				v, _ = v.UnmarkDeep()
 				tmplVars[k] = ctyToGoMap(v)
 			}
 
@@ -318,7 +327,13 @@ func makeTemplateFunc(ctxVars map[string]cty.Value, baseDir string) function.Fun
 				return cty.StringVal(""), err
 			}
 
-			return cty.StringVal(buf.String()), nil
+			result := cty.StringVal(buf.String())
+			for _, secret := range secrets {
+				if strings.Contains(buf.String(), secret) {
+					return result.Mark(SensitiveMark), nil
+				}
+			}
+			return result, nil
 		},
 	})
 }
@@ -1,10 +1,14 @@
 package config
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -34,6 +38,8 @@ func TestStandardFunctions(t *testing.T) {
 		"env", "file", "basename", "dirname",
 		// For-each functions
 		"toset", "tomap",
+		// Sensitive values
+		"sensitive", "nonsensitive",
 	}
 
 	for _, name := range expectedFuncs {
@@ -288,6 +294,68 @@ CONFIG_DIR=/opt/myapp/config`
 	}
 }
 
+func TestTemplateFunc_SensitiveVariables(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	ctxVars := map[string]cty.Value{
+		"var": cty.ObjectVal(map[string]cty.Value{
+			"user":     cty.StringVal("app"),
+			"password": cty.StringVal("hunter2").Mark(SensitiveMark),
+		}),
+	}
+	tmplFunc := makeTemplateFunc(ctxVars, tmpDir)
+
+	tests := []struct {
+		name          string
+		template      string
+		want          string
+		wantSensitive bool
+	}{
+		{name: "without secret", template: "user={{ .var.user }}", want: "user=app"},
+		{name: "with secret", template: "password={{ .var.password }}", want: "password=hunter2", wantSensitive: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(tmpDir, "test.tpl"), []byte(tt.template), 0644); err != nil {
+				t.Fatalf("failed to write template file: %v", err)
+			}
+			result, err := tmplFunc.Call([]cty.Value{cty.StringVal("test.tpl")})
+			if err != nil {
+				t.Fatalf("template failed: %v", err)
+			}
+			if got := IsSensitiveValue(result); got != tt.wantSensitive {
+				t.Errorf("expected sensitive %v, got %v", tt.wantSensitive, got)
+			}
+			if got, _ := result.Unmark(); got.AsString() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got.AsString())
+			}
+		})
+	}
+}
+
+func TestSensitiveFuncs(t *testing.T) {
+	secret, err := sensitiveFunc.Call([]cty.Value{cty.StringVal("hunter2")})
+	if err != nil {
+		t.Fatalf("sensitive failed: %v", err)
+	}
+	if !IsSensitiveValue(secret) {
+		t.Error("expected sensitive() to mark its value")
+	}
+
+	list := cty.ListVal([]cty.Value{cty.StringVal("public"), secret})
+	if got := SensitiveStrings(list); len(got) != 1 || got[0] != "hunter2" {
+		t.Errorf("expected only hunter2 to be sensitive, got %v", got)
+	}
+
+	revealed, err := nonsensitiveFunc.Call([]cty.Value{secret})
+	if err != nil {
+		t.Fatalf("nonsensitive failed: %v", err)
+	}
+	if IsSensitiveValue(revealed) || revealed.AsString() != "hunter2" {
+		t.Errorf("expected nonsensitive() to remove the mark, got %#v", revealed)
+	}
+}
+
 func TestTemplateFunc_MissingFile(t *testing.T) {
 	tmplFunc := makeTemplateFunc(map[string]cty.Value{}, "")
 
@@ -526,3 +594,69 @@ func TestTomapFunc_AlreadyMap(t *testing.T) {
 		t.Errorf("expected map type, got %s", result.Type().FriendlyName())
 	}
 }
+
+func TestParser_Secrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.URL.Path != "/v1/secret/data/myapp" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"user":"app","password":"hunter2"}}}`))
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	content := `
+variable "vault_token" {
+  type      = string
+  sensitive = true
+}
+
+secrets "vault" {
+  address = "` + server.URL + `"
+  token   = var.vault_token
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	p := NewParser()
+	p.SetVariable("vault_token", "root")
+	if _, diags := p.ParseFile(hclPath); diags.HasErrors() {
+		t.Fatalf("ParseFile failed: %s", diags.Error())
+	}
+
+	tests := []struct {
+		expr string
+		want cty.Value
+	}{
+		{expr: `secret("vault:myapp#password")`, want: cty.StringVal("hunter2")},
+		{expr: `secret_map("vault:myapp")["user"]`, want: cty.StringVal("app")},
+		{expr: `"${secret("vault:myapp#user")}@db"`, want: cty.StringVal("app@db")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(tt.expr), "test.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				t.Fatalf("failed to parse expression: %s", diags.Error())
+			}
+			val, diags := expr.Value(p.GetEvalContext())
+			if diags.HasErrors() {
+				t.Fatalf("failed to evaluate: %s", diags.Error())
+			}
+			if !IsSensitiveValue(val) {
+				t.Error("expected the secret to be sensitive")
+			}
+			if got, _ := val.Unmark(); !got.RawEquals(tt.want) {
+				t.Errorf("expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+
+	expr, _ := hclsyntax.ParseExpression([]byte(`secret("vault:other#password")`), "test.hcl", hcl.InitialPos)
+	if _, diags := expr.Value(p.GetEvalContext()); !diags.HasErrors() {
+		t.Error("expected error for a secret that cannot be read")
+	}
+}
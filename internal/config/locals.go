@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// LocalAttributes collects the attributes of locals blocks, rejecting names
+// that are defined more than once
+func LocalAttributes(blocks []*Locals) (hcl.Attributes, hcl.Diagnostics) {
+	attrs := make(hcl.Attributes)
+	var diags hcl.Diagnostics
+	for _, block := range blocks {
+		blockAttrs, blockDiags := block.Body.JustAttributes()
+		diags = append(diags, blockDiags...)
+		for name, attr := range blockAttrs {
+			if prev, exists := attrs[name]; exists {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Duplicate local value",
+					Detail:   fmt.Sprintf("local.%s was already defined at %s", name, prev.NameRange),
+					Subject:  &attr.NameRange,
+				})
+				continue
+			}
+			attrs[name] = attr
+		}
+	}
+	return attrs, diags
+}
+
+// LocalReferences returns the names of the locals an expression references,
+// such as "port" for ${local.port}
+func LocalReferences(expr hcl.Expression) []string {
+	if expr == nil {
+		return nil
+	}
+	var names []string
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != "local" || len(traversal) < 2 {
+			continue
+		}
+		if step, ok := traversal[1].(hcl.TraverseAttr); ok {
+			names = append(names, step.Name)
+		}
+	}
+	return names
+}
+
+// SortLocals orders local names so that each one comes after the locals it
+// references. References to undefined locals and reference cycles are errors.
+func SortLocals(attrs hcl.Attributes) ([]string, hcl.Diagnostics) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var diags hcl.Diagnostics
+	order := make([]string, 0, len(attrs))
+	visited := make(map[string]bool)
+	var path []string
+
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		for i, n := range path {
+			if n == name {
+				cycle := append(append([]string{}, path[i:]...), name)
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Cycle in local values",
+					Detail:   "local." + strings.Join(cycle, " -> local."),
+					Subject:  attrs[name].Expr.Range().Ptr(),
+				})
+				return
+			}
+		}
+
+		path = append(path, name)
+		attr := attrs[name]
+		for _, ref := range LocalReferences(attr.Expr) {
+			if _, ok := attrs[ref]; !ok {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Reference to undefined local value",
+					Detail:   fmt.Sprintf("local.%s references local.%s, which is not defined", name, ref),
+					Subject:  attr.Expr.Range().Ptr(),
+				})
+				continue
+			}
+			visit(ref)
+		}
+		path = path[:len(path)-1]
+
+		visited[name] = true
+		order = append(order, name)
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+	return order, diags
+}
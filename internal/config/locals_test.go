@@ -0,0 +1,102 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func parseLocals(t *testing.T, src string) []*Locals {
+	t.Helper()
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Fatalf("failed to parse: %s", diags.Error())
+	}
+	var blocks []*Locals
+	for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+		blocks = append(blocks, &Locals{Body: block.Body})
+	}
+	return blocks
+}
+
+func TestSortLocals(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		wantOrder string
+		wantErr   string
+	}{
+		{
+			name: "references across blocks",
+			src: `
+locals {
+  url  = "http://${local.host}:${local.port}"
+  port = 8080
+}
+
+locals {
+  host = "localhost"
+}
+`,
+			wantOrder: "host,port,url",
+		},
+		{
+			name: "cycle",
+			src: `
+locals {
+  a = local.b
+  b = local.c
+  c = local.a
+}
+`,
+			wantErr: "local.a -> local.b -> local.c -> local.a",
+		},
+		{
+			name: "undefined",
+			src: `
+locals {
+  a = local.missing
+}
+`,
+			wantErr: "local.a references local.missing, which is not defined",
+		},
+		{
+			name: "duplicate",
+			src: `
+locals {
+  a = 1
+}
+
+locals {
+  a = 2
+}
+`,
+			wantErr: "local.a was already defined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, diags := LocalAttributes(parseLocals(t, tt.src))
+			var order []string
+			if !diags.HasErrors() {
+				order, diags = SortLocals(attrs)
+			}
+
+			if tt.wantErr != "" {
+				if !diags.HasErrors() || !strings.Contains(diags.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got: %v", tt.wantErr, diags)
+				}
+				return
+			}
+			if diags.HasErrors() {
+				t.Fatalf("unexpected error: %s", diags.Error())
+			}
+			if got := strings.Join(order, ","); got != tt.wantOrder {
+				t.Errorf("expected order %s, got %s", tt.wantOrder, got)
+			}
+		})
+	}
+}
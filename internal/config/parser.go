@@ -9,7 +9,10 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/z0mbix/hostcfg/internal/secrets"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
 )
 
 // Parser handles parsing HCL configuration files
@@ -17,10 +20,15 @@ type Parser struct {
 	parser        *hclparse.Parser
 	variables     map[string]cty.Value
 	variableTypes map[string]cty.Type            // variable type constraints
+	sources       map[string]string               // variable name -> where its value was set
+	sensitive     map[string]bool                 // variables declared sensitive
 	resources     map[string]map[string]cty.Value // type -> name -> attributes
+	roleOutputs   map[string]cty.Value            // role name -> outputs
+	locals        map[string]cty.Value            // role directory ("" for main config) -> locals
 	baseDir       string                          // directory containing HCL files
 	roleBaseDir   string                          // current role's directory (empty if not in role)
 	facts         cty.Value                       // system facts for use in expressions
+	secrets       *secrets.Store                  // secret stores read by secret()
 }
 
 // NewParser creates a new HCL parser
@@ -29,7 +37,12 @@ func NewParser() *Parser {
 		parser:        hclparse.NewParser(),
 		variables:     make(map[string]cty.Value),
 		variableTypes: make(map[string]cty.Type),
+		sources:       make(map[string]string),
+		sensitive:     make(map[string]bool),
 		resources:     make(map[string]map[string]cty.Value),
+		roleOutputs:   make(map[string]cty.Value),
+		locals:        make(map[string]cty.Value),
+		secrets:       secrets.NewStore(),
 	}
 }
 
@@ -43,6 +56,28 @@ func (p *Parser) SetVariableValue(name string, value cty.Value) {
 	p.variables[name] = value
 }
 
+// SetVariableSource records where a variable's value was set, such as a var
+// file or the -e flag, for validation errors
+func (p *Parser) SetVariableSource(name, source string) {
+	p.sources[name] = source
+}
+
+// VariableSource returns where a variable's value was set, or "" if it was
+// not set outside the configuration
+func (p *Parser) VariableSource(name string) string {
+	return p.sources[name]
+}
+
+// IsSensitive reports whether a variable is declared sensitive
+func (p *Parser) IsSensitive(name string) bool {
+	return p.sensitive[name]
+}
+
+// SetSensitive marks a variable as sensitive, for variables declared by roles
+func (p *Parser) SetSensitive(name string) {
+	p.sensitive[name] = true
+}
+
 // SetVariableType sets a type constraint for a variable
 func (p *Parser) SetVariableType(name string, ty cty.Type) {
 	p.variableTypes[name] = ty
@@ -109,6 +144,27 @@ func (p *Parser) SetResourceAttributes(resourceType, resourceName string, attrs
 	p.resources[resourceType][resourceName] = cty.ObjectVal(attrs)
 }
 
+// SetRoleOutputs sets the outputs of a role for use in expressions, such as
+// role.redis.port
+func (p *Parser) SetRoleOutputs(roleName string, outputs map[string]cty.Value) {
+	p.roleOutputs[roleName] = cty.ObjectVal(outputs)
+}
+
+// SetLocals sets the local values of the main config (roleDir "") or of the
+// role in roleDir, for use in expressions evaluated in that context
+func (p *Parser) SetLocals(roleDir string, values map[string]cty.Value) {
+	p.locals[roleDir] = cty.ObjectVal(values)
+}
+
+// SetResourceValue sets the whole value of a resource for use in expressions,
+// such as cty.DynamicVal for a resource whose attributes are not known yet
+func (p *Parser) SetResourceValue(resourceType, resourceName string, val cty.Value) {
+	if p.resources[resourceType] == nil {
+		p.resources[resourceType] = make(map[string]cty.Value)
+	}
+	p.resources[resourceType][resourceName] = val
+}
+
 // ParseFile parses a single HCL file
 func (p *Parser) ParseFile(filename string) (*Config, hcl.Diagnostics) {
 	src, err := os.ReadFile(filename)
@@ -298,6 +354,40 @@ func (p *Parser) decodeConfig(body hcl.Body) (*Config, hcl.Diagnostics) {
 		}
 	}
 
+	// Return early if a default was invalid
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	// Check values against nullable and the validation rules
+	for _, v := range config.Variables {
+		if v.Sensitive {
+			p.sensitive[v.Name] = true
+		}
+
+		source := p.sources[v.Name]
+		if source == "" && HasExpression(v.Default) {
+			source = "the default value"
+		}
+		val, exists := p.variables[v.Name]
+		if !exists {
+			val = cty.NullVal(cty.DynamicPseudoType)
+		}
+
+		checked, checkDiags := CheckVariable(v, val, source)
+		diags = append(diags, checkDiags...)
+		if !checkDiags.HasErrors() && (exists || !checked.IsNull()) {
+			p.variables[v.Name] = checked
+		}
+	}
+
+	// Return early if a variable was invalid
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	diags = append(diags, p.configureSecrets(config.Secrets)...)
+
 	return &config, diags
 }
 
@@ -305,6 +395,9 @@ func (p *Parser) decodeConfig(body hcl.Body) (*Config, hcl.Diagnostics) {
 func (p *Parser) buildEvalContext(extra map[string]cty.Value) *hcl.EvalContext {
 	vars := make(map[string]cty.Value)
 	for k, v := range p.variables {
+		if p.sensitive[k] {
+			v = v.Mark(SensitiveMark)
+		}
 		vars[k] = v
 	}
 	for k, v := range extra {
@@ -328,10 +421,22 @@ func (p *Parser) buildEvalContext(extra map[string]cty.Value) *hcl.EvalContext {
 		}
 	}
 
+	// Add role outputs (e.g., role.redis.port)
+	if len(p.roleOutputs) > 0 {
+		ctxVars["role"] = cty.ObjectVal(p.roleOutputs)
+	}
+
+	// Add the locals of the current context (e.g., local.config_dir)
+	if locals, ok := p.locals[p.roleBaseDir]; ok {
+		ctxVars["local"] = locals
+	}
+
 	// Build functions map with template having access to context
 	// Use the effective base dir (role's base dir if in role context)
 	funcs := standardFunctions()
 	funcs["template"] = makeTemplateFunc(ctxVars, p.getEffectiveBaseDir())
+	funcs["secret"] = makeSecretFunc(p.secrets, p.getEffectiveBaseDir())
+	funcs["secret_map"] = makeSecretMapFunc(p.secrets, p.getEffectiveBaseDir())
 
 	return &hcl.EvalContext{
 		Variables: ctxVars,
@@ -365,6 +470,18 @@ func (p *Parser) BuildEvalContextWithEach(eachKey, eachValue cty.Value) *hcl.Eva
 	return ctx
 }
 
+// BuildEvalContextWithCount creates an eval context that includes the "count"
+// variable for use when evaluating resource bodies during count expansion
+func (p *Parser) BuildEvalContextWithCount(index int) *hcl.EvalContext {
+	ctx := p.buildEvalContext(nil)
+
+	ctx.Variables["count"] = cty.ObjectVal(map[string]cty.Value{
+		"index": cty.NumberIntVal(int64(index)),
+	})
+
+	return ctx
+}
+
 // EvaluateWhen evaluates the when expression and returns whether the resource should execute.
 // Returns: shouldExecute, failedConditionDescription, error
 // - Handles nil expression (returns true, "", nil)
@@ -379,6 +496,7 @@ func (p *Parser) EvaluateWhen(expr hcl.Expression, ctx *hcl.EvalContext) (bool,
 	if diags.HasErrors() {
 		return false, "", fmt.Errorf("failed to evaluate when expression: %s", diags.Error())
 	}
+	val, _ = val.UnmarkDeep()
 
 	// If the expression evaluates to null, treat it as "no when"
 	if val.IsNull() {
@@ -420,6 +538,56 @@ func (p *Parser) EvaluateWhen(expr hcl.Expression, ctx *hcl.EvalContext) (bool,
 	return true, "", nil
 }
 
+// EvaluateCount evaluates a count expression, returning -1 if count is not
+// set or null. The count must be a known, whole, non-negative number.
+func (p *Parser) EvaluateCount(expr hcl.Expression) (int, hcl.Diagnostics) {
+	if expr == nil {
+		return -1, nil
+	}
+
+	ctx := p.GetEvalContext()
+	val, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return -1, diags
+	}
+	val, _ = val.UnmarkDeep()
+
+	if val.IsNull() {
+		return -1, nil
+	}
+
+	if !val.IsKnown() {
+		return -1, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid count value",
+			Detail:   "count value must be known",
+			Subject:  expr.Range().Ptr(),
+		}}
+	}
+
+	numVal, err := convert.Convert(val, cty.Number)
+	if err != nil {
+		return -1, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid count type",
+			Detail:   fmt.Sprintf("count must be a number, got %s", val.Type().FriendlyName()),
+			Subject:  expr.Range().Ptr(),
+		}}
+	}
+
+	var count int
+	if err := gocty.FromCtyValue(numVal, &count); err != nil || count < 0 {
+		return -1, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid count value",
+			Detail:   fmt.Sprintf("count must be a whole number of zero or more, got %s", numVal.AsBigFloat().Text('f', -1)),
+			Subject:  expr.Range().Ptr(),
+		}}
+	}
+
+	return count, nil
+}
+
 // EvaluateForEach evaluates the for_each expression and returns the iteration items
 // Returns nil if there is no for_each expression or if it evaluates to null
 // For sets: returns map where key == value
@@ -449,6 +617,21 @@ func (p *Parser) EvaluateForEach(expr hcl.Expression) (map[string]cty.Value, hcl
 		}}
 	}
 
+	// The keys become part of resource names, so they cannot be sensitive
+	val, marks := val.Unmark()
+	sensitiveKeys := len(marks) > 0
+	if val.Type().IsSetType() {
+		sensitiveKeys = sensitiveKeys || val.ContainsMarked()
+	}
+	if sensitiveKeys {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid for_each value",
+			Detail:   "for_each value cannot be sensitive, as its keys are shown in resource names",
+			Subject:  expr.Range().Ptr(),
+		}}
+	}
+
 	result := make(map[string]cty.Value)
 
 	switch {
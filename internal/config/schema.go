@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
 )
 
 // Config represents the top-level configuration structure
@@ -9,6 +10,15 @@ type Config struct {
 	Variables []*Variable      `hcl:"variable,block"`
 	Resources []*ResourceBlock `hcl:"resource,block"`
 	Roles     []*RoleBlock     `hcl:"role,block"`
+	Outputs   []*Output        `hcl:"output,block"`
+	Locals    []*Locals        `hcl:"locals,block"`
+	Secrets   []*SecretsBlock  `hcl:"secrets,block"`
+}
+
+// SecretsBlock configures a secret store backend, such as secrets "vault"
+type SecretsBlock struct {
+	Backend string   `hcl:"backend,label"`
+	Body    hcl.Body `hcl:",remain"`
 }
 
 // RoleBlock represents a role instantiation in HCL
@@ -26,6 +36,29 @@ type Variable struct {
 	TypeExpr    hcl.Expression `hcl:"type,optional"`
 	Default     hcl.Expression `hcl:"default,optional"`
 	Description string         `hcl:"description,optional"`
+	Sensitive   bool           `hcl:"sensitive,optional"` // Hide the value in output
+	Nullable    *bool          `hcl:"nullable,optional"`  // Whether null is allowed (default true)
+
+	Validations []*VariableValidation `hcl:"validation,block"`
+}
+
+// VariableValidation is a custom rule a variable's value must satisfy
+type VariableValidation struct {
+	Condition    hcl.Expression `hcl:"condition"`
+	ErrorMessage string         `hcl:"error_message"`
+}
+
+// Output represents an output value definition in HCL
+type Output struct {
+	Name        string         `hcl:"name,label"`
+	Value       hcl.Expression `hcl:"value"`
+	Description string         `hcl:"description,optional"`
+}
+
+// Locals represents a locals block in HCL, whose attributes are named values
+// available as local.<name>
+type Locals struct {
+	Body hcl.Body `hcl:",remain"`
 }
 
 // ResourceBlock represents a resource definition in HCL
@@ -34,7 +67,10 @@ type ResourceBlock struct {
 	Name        string         `hcl:"name,label"`
 	Description hcl.Expression `hcl:"description,optional"`
 	DependsOn   []string       `hcl:"depends_on,optional"`
+	Notify      []string       `hcl:"notify,optional"`    // Resources to notify when this one changes
+	Subscribe   []string       `hcl:"subscribe,optional"` // Resources whose changes notify this one
 	ForEach     hcl.Expression `hcl:"for_each,optional"`
+	Count       hcl.Expression `hcl:"count,optional"`
 	When        hcl.Expression `hcl:"when,optional"`
 	Body        hcl.Body       `hcl:",remain"`
 
@@ -46,27 +82,79 @@ type ResourceBlock struct {
 	// ForEachKey stores the iteration key for expanded resources.
 	// Empty for non-for_each resources, set during expansion.
 	ForEachKey string
+
+	// CountIndex stores the index of expanded count resources.
+	// Nil for resources without count, set during expansion.
+	CountIndex *int
 }
 
 // FileResourceConfig holds file resource specific attributes
 type FileResourceConfig struct {
+	Path        string  `hcl:"path"`
+	Content     *string `hcl:"content,optional"`
+	Source      *string `hcl:"source,optional"`
+	Owner       *string `hcl:"owner,optional"`
+	Group       *string `hcl:"group,optional"`
+	Mode        *string `hcl:"mode,optional"`
+	Backup      *bool   `hcl:"backup,optional"`       // Keep a timestamped copy of replaced content
+	ValidateCmd *string `hcl:"validate_cmd,optional"` // Command run on the new file, %s is its path
+	Ensure      *string `hcl:"ensure,optional"`       // "present" or "absent"
+}
+
+// FileLineResourceConfig holds file_line resource specific attributes
+type FileLineResourceConfig struct {
+	Path         string  `hcl:"path"`
+	Line         *string `hcl:"line,optional"`
+	Match        *string `hcl:"match,optional"`         // Regex of the line to replace or remove
+	InsertAfter  *string `hcl:"insert_after,optional"`  // Regex, or "EOF" (default)
+	InsertBefore *string `hcl:"insert_before,optional"` // Regex, or "BOF"
+	Create       *bool   `hcl:"create,optional"`        // Create the file if it does not exist
+	Ensure       *string `hcl:"ensure,optional"`        // "present" or "absent"
+}
+
+// FileBlockResourceConfig holds file_block resource specific attributes
+type FileBlockResourceConfig struct {
+	Path         string  `hcl:"path"`
+	Block        *string `hcl:"block,optional"`
+	Marker       *string `hcl:"marker,optional"`        // Marker line template, "{mark}" is BEGIN or END
+	InsertAfter  *string `hcl:"insert_after,optional"`  // Regex, or "EOF" (default)
+	InsertBefore *string `hcl:"insert_before,optional"` // Regex, or "BOF"
+	Create       *bool   `hcl:"create,optional"`        // Create the file if it does not exist
+	Ensure       *string `hcl:"ensure,optional"`        // "present" or "absent"
+}
+
+// SettingResourceConfig holds json_setting, yaml_setting and toml_setting
+// resource specific attributes
+type SettingResourceConfig struct {
+	Path   string    `hcl:"path"`
+	Key    string    `hcl:"key"` // Dotted path to the setting (e.g., "log-opts.max-size")
+	Value  cty.Value `hcl:"value,optional"`
+	Create *bool     `hcl:"create,optional"` // Create the file if it does not exist
+	Ensure *string   `hcl:"ensure,optional"` // "present" or "absent"
+}
+
+// IniSettingResourceConfig holds ini_setting resource specific attributes
+type IniSettingResourceConfig struct {
 	Path    string  `hcl:"path"`
-	Content *string `hcl:"content,optional"`
-	Source  *string `hcl:"source,optional"`
-	Owner   *string `hcl:"owner,optional"`
-	Group   *string `hcl:"group,optional"`
-	Mode    *string `hcl:"mode,optional"`
+	Section *string `hcl:"section,optional"` // Omit for keys before the first section
+	Key     string  `hcl:"key"`
+	Value   *string `hcl:"value,optional"`
+	Create  *bool   `hcl:"create,optional"` // Create the file if it does not exist
 	Ensure  *string `hcl:"ensure,optional"` // "present" or "absent"
 }
 
 // DirectoryResourceConfig holds directory resource specific attributes
 type DirectoryResourceConfig struct {
-	Path      string  `hcl:"path"`
-	Owner     *string `hcl:"owner,optional"`
-	Group     *string `hcl:"group,optional"`
-	Mode      *string `hcl:"mode,optional"`
-	Recursive *bool   `hcl:"recursive,optional"`
-	Ensure    *string `hcl:"ensure,optional"` // "present" or "absent"
+	Path      string   `hcl:"path"`
+	Owner     *string  `hcl:"owner,optional"`
+	Group     *string  `hcl:"group,optional"`
+	Mode      *string  `hcl:"mode,optional"`
+	Recursive *bool    `hcl:"recursive,optional"`
+	Source    *string  `hcl:"source,optional"`    // Directory whose tree is copied into path
+	Templates *bool    `hcl:"templates,optional"` // Render *.tpl files in source with template()
+	Purge     *bool    `hcl:"purge,optional"`     // Remove files in path that are not in source
+	Ignore    []string `hcl:"ignore,optional"`    // Globs of paths that are neither copied nor purged
+	Ensure    *string  `hcl:"ensure,optional"`    // "present" or "absent"
 }
 
 // ExecResourceConfig holds exec resource specific attributes
@@ -101,9 +189,10 @@ type PackageResourceConfig struct {
 
 // ServiceResourceConfig holds service resource specific attributes
 type ServiceResourceConfig struct {
-	Name    string  `hcl:"name"`
-	Ensure  *string `hcl:"ensure,optional"`  // "running" or "stopped"
-	Enabled *bool   `hcl:"enabled,optional"` // Start on boot
+	Name     string  `hcl:"name"`
+	Ensure   *string `hcl:"ensure,optional"`    // "running" or "stopped"
+	Enabled  *bool   `hcl:"enabled,optional"`   // Start on boot
+	OnNotify *string `hcl:"on_notify,optional"` // "restart" (default) or "reload"
 }
 
 // UserResourceConfig holds user resource specific attributes
@@ -118,6 +207,14 @@ type UserResourceConfig struct {
 	System     *bool    `hcl:"system,optional"`  // Create as system user
 	CreateHome *bool    `hcl:"create_home,optional"`
 	Ensure     *string  `hcl:"ensure,optional"` // "present" or "absent"
+
+	Keys []UserKeyBlock `hcl:"key,block"` // SSH keys in ~/.ssh/authorized_keys
+}
+
+// UserKeyBlock is an SSH public key the user's authorized_keys file must contain
+type UserKeyBlock struct {
+	PublicKey string  `hcl:"public_key"`
+	Options   *string `hcl:"options,optional"` // e.g. "no-pty,no-port-forwarding"
 }
 
 // GroupResourceConfig holds group resource specific attributes
@@ -149,6 +246,18 @@ type DownloadResourceConfig struct {
 	Timeout  *int    `hcl:"timeout,optional"` // HTTP timeout in seconds (default: 30)
 }
 
+// ArchiveResourceConfig holds archive resource specific attributes
+type ArchiveResourceConfig struct {
+	Source          string   `hcl:"source"`                    // Path to the archive
+	Dest            string   `hcl:"dest"`                      // Directory to extract into
+	Format          *string  `hcl:"format,optional"`           // "tar", "tar.gz", "tar.bz2", "tar.xz" or "zip" (default: from the source's extension)
+	StripComponents *int     `hcl:"strip_components,optional"` // Leading path components to remove
+	Include         []string `hcl:"include,optional"`          // Globs of the paths to extract (default: all)
+	Exclude         []string `hcl:"exclude,optional"`          // Globs of the paths not to extract
+	Owner           *string  `hcl:"owner,optional"`
+	Group           *string  `hcl:"group,optional"`
+}
+
 // StatResourceConfig holds stat resource specific attributes
 type StatResourceConfig struct {
 	Path   string `hcl:"path"`
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/z0mbix/hostcfg/internal/secrets"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// configureSecrets configures the secret store backends from the secrets
+// blocks, which can reference variables. Sensitive variables are unmarked,
+// as backend options such as tokens are never shown.
+func (p *Parser) configureSecrets(blocks []*SecretsBlock) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	ctx := p.buildEvalContext(nil)
+	ctx.Variables["var"], _ = ctx.Variables["var"].UnmarkDeep()
+	for _, b := range blocks {
+		diags = append(diags, p.secrets.Configure(b.Backend, b.Body, ctx, b.Body.MissingItemRange())...)
+	}
+	return diags
+}
+
+// makeSecretFunc creates the secret function, which reads a value from a
+// secret store as secret("backend:path#key"). The value is sensitive.
+func makeSecretFunc(store *secrets.Store, baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "ref", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			value, err := store.Get(args[0].AsString(), baseDir)
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+			return cty.StringVal(value).Mark(SensitiveMark), nil
+		},
+	})
+}
+
+// makeSecretMapFunc creates the secret_map function, which reads every key
+// of a secret as a map of strings, as secret_map("backend:path"). The map is
+// sensitive.
+func makeSecretMapFunc(store *secrets.Store, baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "ref", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.Map(cty.String)),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			fields, err := store.GetFields(args[0].AsString(), baseDir)
+			if err != nil {
+				return cty.UnknownVal(retType), err
+			}
+			if len(fields) == 0 {
+				return cty.MapValEmpty(cty.String).Mark(SensitiveMark), nil
+			}
+			values := make(map[string]cty.Value, len(fields))
+			for k, v := range fields {
+				values[k] = cty.StringVal(v)
+			}
+			return cty.MapVal(values).Mark(SensitiveMark), nil
+		},
+	})
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// SensitiveMark is the cty mark carried by values from sensitive variables
+// and the sensitive() function
+const SensitiveMark = "sensitive"
+
+// SensitiveValue is shown in place of a sensitive value
+const SensitiveValue = "(sensitive value)"
+
+// IsSensitiveValue reports whether a value, or any value inside it, is sensitive
+func IsSensitiveValue(val cty.Value) bool {
+	return val.HasMarkDeep(SensitiveMark)
+}
+
+// SensitiveStrings returns the strings inside a value that are sensitive,
+// so they can be removed from messages
+func SensitiveStrings(val cty.Value) []string {
+	var result []string
+	collectSensitiveStrings(val, false, &result)
+	return result
+}
+
+func collectSensitiveStrings(val cty.Value, sensitive bool, result *[]string) {
+	val, marks := val.Unmark()
+	if _, ok := marks[SensitiveMark]; ok {
+		sensitive = true
+	}
+	if val.IsNull() || !val.IsKnown() {
+		return
+	}
+
+	switch {
+	case val.Type() == cty.String:
+		if sensitive && val.AsString() != "" {
+			*result = append(*result, val.AsString())
+		}
+	case val.CanIterateElements():
+		for it := val.ElementIterator(); it.Next(); {
+			_, elem := it.Element()
+			collectSensitiveStrings(elem, sensitive, result)
+		}
+	}
+}
+
+// sensitiveFunc marks a value as sensitive, so it is hidden in plans,
+// messages and the state
+var sensitiveFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:             "value",
+			Type:             cty.DynamicPseudoType,
+			AllowUnknown:     true,
+			AllowNull:        true,
+			AllowMarked:      true,
+			AllowDynamicType: true,
+		},
+	},
+	Type: func(args []cty.Value) (cty.Type, error) {
+		return args[0].Type(), nil
+	},
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return args[0].Mark(SensitiveMark), nil
+	},
+})
+
+// nonsensitiveFunc removes the sensitive mark from a value, for values
+// derived from a secret that are safe to show, such as its length
+var nonsensitiveFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:             "value",
+			Type:             cty.DynamicPseudoType,
+			AllowUnknown:     true,
+			AllowNull:        true,
+			AllowMarked:      true,
+			AllowDynamicType: true,
+		},
+	},
+	Type: func(args []cty.Value) (cty.Type, error) {
+		return args[0].Type(), nil
+	},
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		val, marks := args[0].Unmark()
+		delete(marks, SensitiveMark)
+		return val.WithMarks(marks), nil
+	},
+})
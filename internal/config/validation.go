@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// CheckVariable checks a variable's value against its nullable setting and
+// validation rules. A null value for a variable that is not nullable is
+// replaced by the default, if it has one. source describes where the value
+// came from, such as a var file or the -e flag, for the error messages, and
+// is empty if the variable has no value at all.
+func CheckVariable(v *Variable, val cty.Value, source string) (cty.Value, hcl.Diagnostics) {
+	if source == "" && val.IsNull() && (v.Nullable == nil || *v.Nullable) {
+		return val, nil // Nothing to validate
+	}
+
+	if val.IsNull() && v.Nullable != nil && !*v.Nullable {
+		if HasExpression(v.Default) {
+			def, diags := v.Default.Value(nil)
+			if diags.HasErrors() {
+				return cty.NilVal, diags
+			}
+			val = def
+		}
+		if val.IsNull() {
+			detail := fmt.Sprintf("Variable %q is not nullable, but %s set it to null.", v.Name, source)
+			if source == "" {
+				detail = fmt.Sprintf("Variable %q is not nullable and has no value.", v.Name)
+			}
+			return cty.NilVal, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("Invalid value for variable %q", v.Name),
+				Detail:   detail,
+			}}
+		}
+	}
+
+	// Conditions see the value without marks, such as the sensitive mark of
+	// a value passed from a sensitive variable
+	unmarked, _ := val.UnmarkDeep()
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{v.Name: unmarked}),
+		},
+		Functions: standardFunctions(),
+	}
+
+	var diags hcl.Diagnostics
+	for _, rule := range v.Validations {
+		result, ruleDiags := rule.Condition.Value(ctx)
+		if ruleDiags.HasErrors() {
+			diags = append(diags, ruleDiags...)
+			continue
+		}
+		if result.IsNull() || !result.IsKnown() || result.Type() != cty.Bool {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid validation condition",
+				Detail:   fmt.Sprintf("The condition for variable %q must be true or false.", v.Name),
+				Subject:  rule.Condition.Range().Ptr(),
+			})
+			continue
+		}
+		if result.False() {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("Invalid value for variable %q", v.Name),
+				Detail:   fmt.Sprintf("%s\n\nThe value was set by %s.", rule.ErrorMessage, source),
+				Subject:  rule.Condition.Range().Ptr(),
+			})
+		}
+	}
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+	return val, nil
+}
+
+// HasExpression reports whether an optional expression attribute was set.
+// gohcl decodes a missing one as a static expression with an empty range.
+func HasExpression(expr hcl.Expression) bool {
+	if expr == nil {
+		return false
+	}
+	r := expr.Range()
+	return r.Start.Line != r.End.Line || r.Start.Column != r.End.Column
+}
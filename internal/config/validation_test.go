@@ -0,0 +1,100 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParser_VariableValidation(t *testing.T) {
+	content := `
+variable "port" {
+  type    = number
+  default = 8080
+
+  validation {
+    condition     = var.port > 0 && var.port < 65536
+    error_message = "The port must be between 1 and 65535."
+  }
+}
+
+variable "env" {
+  default  = "dev"
+  nullable = false
+
+  validation {
+    condition     = contains(["dev", "prod"], var.env)
+    error_message = "The env must be dev or prod."
+  }
+}
+
+variable "token" {
+  sensitive = true
+}
+`
+
+	tests := []struct {
+		name    string
+		vars    map[string]cty.Value
+		sources map[string]string
+		wantErr string
+		wantEnv string
+	}{
+		{name: "defaults", wantEnv: "dev"},
+		{
+			name:    "null uses the default when not nullable",
+			vars:    map[string]cty.Value{"env": cty.NullVal(cty.String)},
+			sources: map[string]string{"env": "the var file at prod.vars.hcl:1,1-11"},
+			wantEnv: "dev",
+		},
+		{
+			name:    "invalid var file value",
+			vars:    map[string]cty.Value{"port": cty.NumberIntVal(70000)},
+			sources: map[string]string{"port": "the var file at prod.vars.hcl:2,1-13"},
+			wantErr: "The port must be between 1 and 65535.\n\nThe value was set by the var file at prod.vars.hcl:2,1-13.",
+		},
+		{
+			name:    "invalid cli value",
+			vars:    map[string]cty.Value{"env": cty.StringVal("staging")},
+			sources: map[string]string{"env": "the -e env flag"},
+			wantErr: "The value was set by the -e env flag.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hclPath := filepath.Join(t.TempDir(), "test.hcl")
+			if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+
+			p := NewParser()
+			for name, val := range tt.vars {
+				p.SetVariableValue(name, val)
+			}
+			for name, source := range tt.sources {
+				p.SetVariableSource(name, source)
+			}
+
+			_, diags := p.ParseFile(hclPath)
+			if tt.wantErr != "" {
+				if !diags.HasErrors() || !strings.Contains(diags.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got: %v", tt.wantErr, diags)
+				}
+				return
+			}
+			if diags.HasErrors() {
+				t.Fatalf("unexpected error: %s", diags.Error())
+			}
+			if got := p.variables["env"].AsString(); got != tt.wantEnv {
+				t.Errorf("expected env %q, got %q", tt.wantEnv, got)
+			}
+			if !p.IsSensitive("token") || p.IsSensitive("env") {
+				t.Error("expected only token to be sensitive")
+			}
+		})
+	}
+}
@@ -14,13 +14,15 @@ import (
 // VarFileLoader handles loading variable files
 type VarFileLoader struct {
 	parser          *hclparse.Parser
-	typeConstraints map[string]cty.Type // optional type constraints for validation
+	typeConstraints map[string]cty.Type  // optional type constraints for validation
+	sources         map[string]hcl.Range // where each loaded variable was set
 }
 
 // NewVarFileLoader creates a new variable file loader
 func NewVarFileLoader() *VarFileLoader {
 	return &VarFileLoader{
-		parser: hclparse.NewParser(),
+		parser:  hclparse.NewParser(),
+		sources: make(map[string]hcl.Range),
 	}
 }
 
@@ -76,11 +78,18 @@ func (l *VarFileLoader) LoadVarFile(path string) (map[string]cty.Value, hcl.Diag
 		}
 
 		result[name] = val
+		l.sources[name] = attr.Range
 	}
 
 	return result, diags
 }
 
+// Sources returns where each loaded variable was set. When several files set
+// a variable, the last one loaded wins, as it does for the value.
+func (l *VarFileLoader) Sources() map[string]hcl.Range {
+	return l.sources
+}
+
 // FindAutoLoadFiles finds all auto-load variable files in a directory
 // Returns files in load order: hostcfg.vars.hcl, hostcfg.vars.hcl.local, *.auto.vars.hcl
 func (l *VarFileLoader) FindAutoLoadFiles(dir string) ([]string, error) {
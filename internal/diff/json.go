@@ -0,0 +1,130 @@
+package diff
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/resource"
+)
+
+// JSONFormatVersion is the version of the JSON plan format. It is bumped
+// whenever a field is removed or changes meaning.
+const JSONFormatVersion = 1
+
+// JSONPlan is the machine-readable representation of a plan
+type JSONPlan struct {
+	FormatVersion int            `json:"format_version"`
+	Resources     []JSONResource `json:"resources"`
+	Summary       JSONSummary    `json:"summary"`
+}
+
+// JSONResource is the planned action for a single resource
+type JSONResource struct {
+	ID          string       `json:"id"`
+	Type        string       `json:"type"`
+	Name        string       `json:"name"`
+	Action      string       `json:"action"`
+	Description string       `json:"description,omitempty"`
+	SkipReason  string       `json:"skip_reason,omitempty"`
+	Deferred    bool         `json:"deferred,omitempty"` // Changes are known after apply
+	DependsOn   []string     `json:"depends_on"`
+	Changes     []JSONChange `json:"changes"`
+
+	// NotifyAction and NotifiedBy are set when changes to other resources
+	// will notify this one
+	NotifyAction string   `json:"notify_action,omitempty"`
+	NotifiedBy   []string `json:"notified_by,omitempty"`
+}
+
+// JSONChange is a single attribute change. The values of sensitive changes
+// are replaced by "(sensitive value)".
+type JSONChange struct {
+	Attribute string      `json:"attribute"`
+	Old       interface{} `json:"old"`
+	New       interface{} `json:"new"`
+	Sensitive bool        `json:"sensitive,omitempty"`
+}
+
+// JSONSummary counts the planned actions
+type JSONSummary struct {
+	Add        int  `json:"add"`
+	Change     int  `json:"change"`
+	Destroy    int  `json:"destroy"`
+	Skip       int  `json:"skip"`
+	HasChanges bool `json:"has_changes"`
+}
+
+// NewJSONPlan builds the JSON representation of the plans for the given
+// resources, in the order they are given
+func NewJSONPlan(resources []resource.Resource, plans map[string]*resource.Plan) *JSONPlan {
+	out := &JSONPlan{
+		FormatVersion: JSONFormatVersion,
+		Resources:     make([]JSONResource, 0, len(resources)),
+	}
+
+	for _, r := range resources {
+		id := resource.ID(r)
+		plan := plans[id]
+		if plan == nil {
+			continue
+		}
+
+		deps := r.Dependencies()
+		if deps == nil {
+			deps = []string{}
+		}
+
+		jr := JSONResource{
+			ID:          id,
+			Type:        r.Type(),
+			Name:        r.Name(),
+			Action:      plan.Action.String(),
+			Description: r.Description(),
+			SkipReason:  plan.SkipReason,
+			Deferred:    plan.Deferred,
+			DependsOn:   deps,
+			Changes:     make([]JSONChange, 0, len(plan.Changes)),
+		}
+		for _, c := range plan.Changes {
+			change := JSONChange{
+				Attribute: c.Attribute,
+				Old:       c.Old,
+				New:       c.New,
+			}
+			if plan.Sensitive[c.Attribute] {
+				if change.Old != nil {
+					change.Old = config.SensitiveValue
+				}
+				if change.New != nil {
+					change.New = config.SensitiveValue
+				}
+				change.Sensitive = true
+			}
+			jr.Changes = append(jr.Changes, change)
+		}
+		out.Resources = append(out.Resources, jr)
+
+		switch plan.Action {
+		case resource.ActionCreate:
+			out.Summary.Add++
+		case resource.ActionUpdate:
+			out.Summary.Change++
+		case resource.ActionDelete:
+			out.Summary.Destroy++
+		case resource.ActionSkip:
+			out.Summary.Skip++
+		}
+	}
+
+	out.Summary.HasChanges = out.Summary.Add+out.Summary.Change+out.Summary.Destroy > 0
+
+	return out
+}
+
+// WriteJSON writes the plan as indented JSON
+func (p *JSONPlan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
@@ -1,12 +1,13 @@
 package diff
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
 
 	"github.com/fatih/color"
-	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/z0mbix/hostcfg/internal/config"
 	"github.com/z0mbix/hostcfg/internal/resource"
 )
 
@@ -48,7 +49,11 @@ func (p *Printer) PrintPlan(r resource.Resource, plan *resource.Plan) {
 	case resource.ActionCreate:
 		p.printHeader("+", resource.ID(r), color.FgGreen)
 	case resource.ActionUpdate:
-		p.printHeader("~", resource.ID(r), color.FgYellow)
+		if plan.Deferred {
+			p.printHeader("~", resource.ID(r)+" (known after apply)", color.FgYellow)
+		} else {
+			p.printHeader("~", resource.ID(r), color.FgYellow)
+		}
 	case resource.ActionDelete:
 		p.printHeader("-", resource.ID(r), color.FgRed)
 	}
@@ -60,6 +65,14 @@ func (p *Printer) PrintPlan(r resource.Resource, plan *resource.Plan) {
 
 	// Print each change
 	for _, change := range plan.Changes {
+		if plan.Deferred {
+			p.printUnknown(change)
+			continue
+		}
+		if plan.Sensitive[change.Attribute] {
+			p.printSensitive(plan.Action, change)
+			continue
+		}
 		p.printChange(plan.Action, change)
 	}
 
@@ -99,16 +112,62 @@ func (p *Printer) printHeader(symbol, resourceID string, c color.Attribute) {
 }
 
 func (p *Printer) printChange(action resource.Action, change resource.Change) {
+	// Text content is shown as a unified diff, whatever the action
+	if change.Attribute == "content" {
+		oldStr, oldOk := change.Old.(string)
+		newStr, newOk := change.New.(string)
+		if oldOk && newOk {
+			p.printContentChange(change.Attribute, oldStr, newStr)
+			return
+		}
+	}
+
 	switch action {
 	case resource.ActionCreate:
 		p.printAddition(change)
 	case resource.ActionUpdate:
-		p.printModification(change)
+		// An update can add or remove parts of a resource, such as the
+		// files of a directory
+		switch {
+		case change.Old == nil:
+			p.printAddition(change)
+		case change.New == nil:
+			p.printDeletion(change)
+		default:
+			p.printModification(change)
+		}
 	case resource.ActionDelete:
 		p.printDeletion(change)
 	}
 }
 
+// printUnknown prints an attribute whose value is known after apply
+func (p *Printer) printUnknown(change resource.Change) {
+	yellow := color.New(color.FgYellow)
+	if p.useColors {
+		_, _ = yellow.Fprintf(p.out, "    ~ %s = %s\n", change.Attribute, change.New)
+	} else {
+		_, _ = fmt.Fprintf(p.out, "    ~ %s = %s\n", change.Attribute, change.New)
+	}
+}
+
+// printSensitive prints a change to an attribute set from a sensitive value,
+// without its values
+func (p *Printer) printSensitive(action resource.Action, change resource.Change) {
+	symbol, c := "~", color.FgYellow
+	switch action {
+	case resource.ActionCreate:
+		symbol, c = "+", color.FgGreen
+	case resource.ActionDelete:
+		symbol, c = "-", color.FgRed
+	}
+	if p.useColors {
+		_, _ = color.New(c).Fprintf(p.out, "    %s %s = %s\n", symbol, change.Attribute, config.SensitiveValue)
+	} else {
+		_, _ = fmt.Fprintf(p.out, "    %s %s = %s\n", symbol, change.Attribute, config.SensitiveValue)
+	}
+}
+
 func (p *Printer) printAddition(change resource.Change) {
 	green := color.New(color.FgGreen)
 	if p.useColors {
@@ -130,21 +189,6 @@ func (p *Printer) printDeletion(change resource.Change) {
 func (p *Printer) printModification(change resource.Change) {
 	yellow := color.New(color.FgYellow)
 
-	// Check if this is content that should show a text diff
-	if change.Attribute == "content" {
-		oldStr, oldOk := change.Old.(string)
-		newStr, newOk := change.New.(string)
-		if oldOk && newOk {
-			if p.useColors {
-				_, _ = yellow.Fprintf(p.out, "    ~ %s: (changed)\n", change.Attribute)
-			} else {
-				_, _ = fmt.Fprintf(p.out, "    ~ %s: (changed)\n", change.Attribute)
-			}
-			p.printTextDiff(oldStr, newStr)
-			return
-		}
-	}
-
 	// Regular attribute change
 	if p.useColors {
 		_, _ = yellow.Fprintf(p.out, "    ~ %s: %s => %s\n",
@@ -159,52 +203,43 @@ func (p *Printer) printModification(change resource.Change) {
 	}
 }
 
-func (p *Printer) printTextDiff(old, new string) {
-	dmp := diffmatchpatch.New()
-	diffs := dmp.DiffMain(old, new, false)
+// printContentChange prints a change to text content as a unified diff
+func (p *Printer) printContentChange(attribute, old, new string) {
+	yellow := color.New(color.FgYellow)
+	if p.useColors {
+		_, _ = yellow.Fprintf(p.out, "    ~ %s: (changed)\n", attribute)
+	} else {
+		_, _ = fmt.Fprintf(p.out, "    ~ %s: (changed)\n", attribute)
+	}
+	p.printTextDiff(old, new)
+}
 
+// printTextDiff prints the hunks of a unified diff from old to new
+func (p *Printer) printTextDiff(old, new string) {
+	cyan := color.New(color.FgCyan)
 	red := color.New(color.FgRed)
 	green := color.New(color.FgGreen)
 
-	// Print line-by-line diff
-	oldLines := strings.Split(old, "\n")
-	newLines := strings.Split(new, "\n")
-
-	// Use unified diff style
-	for i := 0; i < len(oldLines) || i < len(newLines); i++ {
-		var oldLine, newLine string
-		hasOld := i < len(oldLines)
-		hasNew := i < len(newLines)
-
-		if hasOld {
-			oldLine = oldLines[i]
-		}
-		if hasNew {
-			newLine = newLines[i]
+	for _, h := range unifiedHunks(old, new, contextLines) {
+		if p.useColors {
+			_, _ = cyan.Fprintf(p.out, "      %s\n", h.header())
+		} else {
+			_, _ = fmt.Fprintf(p.out, "      %s\n", h.header())
 		}
 
-		if hasOld && hasNew && oldLine == newLine {
-			// Unchanged line
-			_, _ = fmt.Fprintf(p.out, "        %s\n", oldLine)
-		} else {
-			if hasOld && oldLine != "" {
-				if p.useColors {
-					_, _ = red.Fprintf(p.out, "      - %s\n", oldLine)
-				} else {
-					_, _ = fmt.Fprintf(p.out, "      - %s\n", oldLine)
-				}
-			}
-			if hasNew && newLine != "" {
-				if p.useColors {
-					_, _ = green.Fprintf(p.out, "      + %s\n", newLine)
-				} else {
-					_, _ = fmt.Fprintf(p.out, "      + %s\n", newLine)
-				}
+		for _, line := range h.lines {
+			switch {
+			case line.kind == ' ':
+				_, _ = fmt.Fprintf(p.out, "        %s\n", line.text)
+			case p.useColors && line.kind == '-':
+				_, _ = red.Fprintf(p.out, "      - %s\n", line.text)
+			case p.useColors:
+				_, _ = green.Fprintf(p.out, "      + %s\n", line.text)
+			default:
+				_, _ = fmt.Fprintf(p.out, "      %c %s\n", line.kind, line.text)
 			}
 		}
 	}
-
-	_ = diffs // suppress unused warning, we may use this for more detailed diffs later
 }
 
 func (p *Printer) formatValue(v interface{}) string {
@@ -216,11 +251,29 @@ func (p *Printer) formatValue(v interface{}) string {
 		return fmt.Sprintf("%q", val)
 	case bool:
 		return fmt.Sprintf("%t", val)
+	case []interface{}, map[string]interface{}:
+		// Values of settings in structured files are shown as JSON
+		if src, err := json.Marshal(val); err == nil {
+			return string(src)
+		}
+		return fmt.Sprintf("%v", val)
 	default:
 		return fmt.Sprintf("%v", val)
 	}
 }
 
+// PrintNotification prints a notify action that will run because other
+// resources are changing
+func (p *Printer) PrintNotification(resourceID, action string, notifiedBy []string) {
+	if p.useColors {
+		cyan := color.New(color.FgCyan, color.Bold)
+		_, _ = cyan.Fprintf(p.out, "! %s", resourceID)
+		_, _ = fmt.Fprintf(p.out, " will %s (notified by %s)\n\n", action, strings.Join(notifiedBy, ", "))
+	} else {
+		_, _ = fmt.Fprintf(p.out, "! %s will %s (notified by %s)\n\n", resourceID, action, strings.Join(notifiedBy, ", "))
+	}
+}
+
 // PrintSummary prints the plan summary
 func (p *Printer) PrintSummary(toAdd, toChange, toDestroy, toSkip int) {
 	if toSkip > 0 {
@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// contextLines is the number of unchanged lines shown around each change
+const contextLines = 3
+
+// diffLine is a line of a unified diff: ' ' when unchanged, '-' when removed
+// and '+' when added
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// hunk is a group of nearby changes with the unchanged lines around them
+type hunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	lines              []diffLine
+}
+
+// header returns the hunk's "@@ -a,b +c,d @@" line
+func (h hunk) header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.oldStart, h.oldCount, h.newStart, h.newCount)
+}
+
+// diffLines compares old and new line by line
+func diffLines(old, new string) []diffLine {
+	dmp := diffmatchpatch.New()
+	oldChars, newChars, lineArray := dmp.DiffLinesToChars(old, new)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(oldChars, newChars, false), lineArray)
+
+	var lines []diffLine
+	for _, d := range diffs {
+		kind := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			kind = '-'
+		case diffmatchpatch.DiffInsert:
+			kind = '+'
+		}
+		for _, text := range strings.SplitAfter(d.Text, "\n") {
+			if text != "" {
+				lines = append(lines, diffLine{kind: kind, text: strings.TrimSuffix(text, "\n")})
+			}
+		}
+	}
+	return lines
+}
+
+// unifiedHunks returns the hunks of a unified diff from old to new, with up
+// to context unchanged lines around each change
+func unifiedHunks(old, new string, context int) []hunk {
+	lines := diffLines(old, new)
+
+	// Line numbers in old and new before each line of the diff
+	oldBefore := make([]int, len(lines)+1)
+	newBefore := make([]int, len(lines)+1)
+	for i, l := range lines {
+		oldBefore[i+1], newBefore[i+1] = oldBefore[i], newBefore[i]
+		if l.kind != '+' {
+			oldBefore[i+1]++
+		}
+		if l.kind != '-' {
+			newBefore[i+1]++
+		}
+	}
+
+	var hunks []hunk
+	for i := 0; i < len(lines); {
+		if lines[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := max(0, i-context)
+		end := i
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			// Changes separated by more than twice the context start a new hunk
+			next := end
+			for next < len(lines) && lines[next].kind == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				end = min(end+context, next)
+				break
+			}
+			end = next
+		}
+
+		h := hunk{
+			oldStart: oldBefore[start] + 1,
+			oldCount: oldBefore[end] - oldBefore[start],
+			newStart: newBefore[start] + 1,
+			newCount: newBefore[end] - newBefore[start],
+			lines:    lines[start:end],
+		}
+		// An empty range starts at the line before it
+		if h.oldCount == 0 {
+			h.oldStart--
+		}
+		if h.newCount == 0 {
+			h.newStart--
+		}
+		hunks = append(hunks, h)
+		i = end
+	}
+	return hunks
+}
@@ -0,0 +1,71 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/z0mbix/hostcfg/internal/resource"
+)
+
+// numbered returns lines "line 1" to "line n"
+func numbered(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	return lines
+}
+
+func TestUnifiedHunks(t *testing.T) {
+	lines := numbered(20)
+	old := strings.Join(lines, "\n") + "\n"
+
+	changed := append([]string(nil), lines...)
+	changed[1] = "line two"
+	changed[17] = "line eighteen"
+	new := strings.Join(changed, "\n") + "\n"
+
+	hunks := unifiedHunks(old, new, 3)
+	if len(hunks) != 2 {
+		t.Fatalf("expected 2 hunks, got %d", len(hunks))
+	}
+	if got := hunks[0].header(); got != "@@ -1,5 +1,5 @@" {
+		t.Errorf("unexpected first header %q", got)
+	}
+	if got := hunks[1].header(); got != "@@ -15,6 +15,6 @@" {
+		t.Errorf("unexpected second header %q", got)
+	}
+
+	// Nearby changes share a hunk
+	changed[5] = "line six"
+	hunks = unifiedHunks(old, strings.Join(changed, "\n")+"\n", 3)
+	if len(hunks) != 2 || hunks[0].header() != "@@ -1,9 +1,9 @@" {
+		t.Errorf("expected the first two changes in one hunk, got %+v", hunks)
+	}
+
+	// Lines added to an empty file
+	hunks = unifiedHunks("", "a\nb\n", 3)
+	if len(hunks) != 1 || hunks[0].header() != "@@ -0,0 +1,2 @@" {
+		t.Errorf("unexpected hunks for an empty file: %+v", hunks)
+	}
+}
+
+func TestPrinter_ContentDiff(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewPrinter(&buf, false)
+	p.printChange(resource.ActionCreate, resource.Change{
+		Attribute: "content",
+		Old:       "127.0.0.1 localhost\n",
+		New:       "127.0.0.1 localhost\n10.0.0.1 db\n",
+	})
+
+	want := "    ~ content: (changed)\n" +
+		"      @@ -1,1 +1,2 @@\n" +
+		"        127.0.0.1 localhost\n" +
+		"      + 10.0.0.1 db\n"
+	if buf.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/z0mbix/hostcfg/internal/config"
+)
+
+// blockExpressions returns every expression in a resource block, including
+// those in nested blocks
+func blockExpressions(block *config.ResourceBlock) []hcl.Expression {
+	exprs := []hcl.Expression{block.Description, block.ForEach, block.Count, block.When}
+	exprs = append(exprs, bodyExpressions(block.Body)...)
+
+	result := exprs[:0]
+	for _, expr := range exprs {
+		if expr != nil {
+			result = append(result, expr)
+		}
+	}
+	return result
+}
+
+// bodyExpressions returns the expressions of the attributes in a body and
+// its nested blocks
+func bodyExpressions(body hcl.Body) []hcl.Expression {
+	var exprs []hcl.Expression
+	if syntaxBody, ok := body.(*hclsyntax.Body); ok {
+		for _, attr := range syntaxBody.Attributes {
+			exprs = append(exprs, attr.Expr)
+		}
+		for _, block := range syntaxBody.Blocks {
+			exprs = append(exprs, bodyExpressions(block.Body)...)
+		}
+		return exprs
+	}
+
+	attrs, _ := body.JustAttributes()
+	for _, attr := range attrs {
+		exprs = append(exprs, attr.Expr)
+	}
+	return exprs
+}
+
+// nestedBlocks returns the nested blocks of a resource body. Dynamic blocks
+// are returned as they are, as they are only expanded when the resource is
+// created.
+func nestedBlocks(body hcl.Body) []*hclsyntax.Block {
+	if syntaxBody, ok := body.(*hclsyntax.Body); ok {
+		return syntaxBody.Blocks
+	}
+	return nil
+}
+
+// nestedBlockName returns the type of the blocks a nested block creates,
+// such as "key" for both key { ... } and dynamic "key" { ... }
+func nestedBlockName(block *hclsyntax.Block) string {
+	if block.Type == "dynamic" && len(block.Labels) > 0 {
+		return block.Labels[0]
+	}
+	return block.Type
+}
@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/resource"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// deferredResource stands in for a resource whose configuration references
+// values that are known after apply. The real resource is created once those
+// values are known: while planning, if the resources it references have
+// nothing to apply, or otherwise just before it is applied.
+type deferredResource struct {
+	block    *config.ResourceBlock
+	deps     []string
+	resolved resource.Resource
+}
+
+func (d *deferredResource) Type() string { return d.block.Type }
+func (d *deferredResource) Name() string { return d.block.Name }
+
+func (d *deferredResource) Description() string {
+	if d.resolved != nil {
+		return d.resolved.Description()
+	}
+	return ""
+}
+
+func (d *deferredResource) Dependencies() []string {
+	return d.deps
+}
+
+func (d *deferredResource) Validate() error {
+	if d.resolved != nil {
+		return d.resolved.Validate()
+	}
+	return nil
+}
+
+func (d *deferredResource) Read(ctx context.Context) (*resource.State, error) {
+	if d.resolved == nil {
+		return nil, d.unresolvedError()
+	}
+	return d.resolved.Read(ctx)
+}
+
+func (d *deferredResource) Diff(ctx context.Context, current *resource.State) (*resource.Plan, error) {
+	if d.resolved == nil {
+		return nil, d.unresolvedError()
+	}
+	return d.resolved.Diff(ctx, current)
+}
+
+func (d *deferredResource) Apply(ctx context.Context, plan *resource.Plan, apply bool) error {
+	if d.resolved == nil {
+		return d.unresolvedError()
+	}
+	return d.resolved.Apply(ctx, plan, apply)
+}
+
+func (d *deferredResource) unresolvedError() error {
+	return fmt.Errorf("%s.%s references values that are not known yet", d.block.Type, d.block.Name)
+}
+
+// unwrapResource returns the resource a deferred resource was resolved to,
+// or r itself
+func unwrapResource(r resource.Resource) resource.Resource {
+	if d, ok := r.(*deferredResource); ok && d.resolved != nil {
+		return d.resolved
+	}
+	return r
+}
+
+// unknownReferences returns the names of the attributes of a resource block,
+// including its when condition, that reference values known after apply
+func unknownReferences(block *config.ResourceBlock, ctx *hcl.EvalContext) []string {
+	isUnknown := func(expr hcl.Expression) bool {
+		if expr == nil {
+			return false
+		}
+		for _, traversal := range expr.Variables() {
+			val, diags := traversal.TraverseAbs(ctx)
+			if !diags.HasErrors() && !val.IsWhollyKnown() {
+				return true
+			}
+		}
+		return false
+	}
+
+	var names []string
+	attrs, _ := block.Body.JustAttributes()
+	for name, attr := range attrs {
+		if isUnknown(attr.Expr) {
+			names = append(names, name)
+		}
+	}
+	for _, nested := range nestedBlocks(block.Body) {
+		for _, expr := range bodyExpressions(nested.Body) {
+			if isUnknown(expr) {
+				names = append(names, nestedBlockName(nested))
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	if isUnknown(block.When) {
+		names = append(names, "when")
+	}
+	return names
+}
+
+// resourceEvalContext returns the context a resource block is evaluated in,
+// with each.key and each.value set for expanded for_each resources, and
+// count.index for expanded count resources
+func (e *Executor) resourceEvalContext(block *config.ResourceBlock) *hcl.EvalContext {
+	if block.CountIndex != nil {
+		return e.parser.BuildEvalContextWithCount(*block.CountIndex)
+	}
+	if block.ForEachKey == "" {
+		return e.parser.GetEvalContext()
+	}
+	eachKey := cty.StringVal(block.ForEachKey)
+	eachValue := e.forEachValues[block.Type+"."+block.Name]
+	if eachValue.IsNull() {
+		eachValue = eachKey // Fallback for sets where key == value
+	}
+	return e.parser.BuildEvalContextWithEach(eachKey, eachValue)
+}
+
+// exportAttributes publishes the attributes a resource exports, so that the
+// resources referencing it can be evaluated. While pending is set the
+// resource has changes to apply and its KnownAfterApply attributes are
+// unknown; otherwise any that are still unknown are null.
+func (e *Executor) exportAttributes(ctx context.Context, r resource.Resource, pending bool) error {
+	exporter, ok := unwrapResource(r).(resource.Exporter)
+	if !ok {
+		return nil
+	}
+
+	attrs, err := resource.ExportValues(ctx, exporter)
+	if err != nil {
+		return err
+	}
+	for name, attr := range exporter.AttributeSchema() {
+		if !attr.KnownAfterApply {
+			continue
+		}
+		if pending {
+			attrs[name] = cty.UnknownVal(attr.Type)
+		} else if !attrs[name].IsKnown() {
+			attrs[name] = cty.NullVal(attr.Type)
+		}
+	}
+
+	e.markSensitive(resource.ID(r), attrs)
+
+	e.evalMu.Lock()
+	defer e.evalMu.Unlock()
+	e.parser.SetResourceAttributes(r.Type(), r.Name(), attrs)
+	if e.valuesReady {
+		_, err := e.refreshValues(true)
+		return err
+	}
+	return nil
+}
+
+// resolveDeferred creates the real resource behind a deferred one, once the
+// values it references are known. It returns false if some are still unknown.
+func (e *Executor) resolveDeferred(d *deferredResource) (bool, error) {
+	e.evalMu.Lock()
+	defer e.evalMu.Unlock()
+
+	if d.block.RoleBaseDir != "" {
+		e.parser.SetRoleContext(d.block.RoleBaseDir)
+		defer e.parser.ClearRoleContext()
+	}
+
+	ctx := e.resourceEvalContext(d.block)
+	if len(unknownReferences(d.block, ctx)) > 0 {
+		return false, nil
+	}
+	e.recordSensitive(d.block, ctx)
+
+	r, err := resource.CreateWithDeps(d.block, d.deps, ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to create resource %s.%s: %w", d.block.Type, d.block.Name, err)
+	}
+	if err := r.Validate(); err != nil {
+		return false, err
+	}
+	d.resolved = r
+	return true, nil
+}
+
+// deferredPlan is the plan of a resource that cannot be worked out until the
+// values it references are known, listing the attributes that reference them
+func (e *Executor) deferredPlan(d *deferredResource) *resource.Plan {
+	e.evalMu.Lock()
+	ctx := e.resourceEvalContext(d.block)
+	names := unknownReferences(d.block, ctx)
+	e.evalMu.Unlock()
+
+	plan := &resource.Plan{
+		Action:   resource.ActionUpdate,
+		Before:   resource.NewState(),
+		After:    resource.NewState(),
+		Deferred: true,
+	}
+	for _, name := range names {
+		plan.Changes = append(plan.Changes, resource.Change{Attribute: name, New: resource.Unknown})
+	}
+	return plan
+}
@@ -2,40 +2,28 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/user"
-	"strconv"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
-	"syscall"
+	"sync"
+	"text/tabwriter"
 
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
 	"github.com/z0mbix/hostcfg/internal/diff"
 	"github.com/z0mbix/hostcfg/internal/facts"
 	"github.com/z0mbix/hostcfg/internal/resource"
 	"github.com/z0mbix/hostcfg/internal/role"
+	"github.com/z0mbix/hostcfg/internal/source"
+	"github.com/z0mbix/hostcfg/internal/state"
 	"github.com/zclconf/go-cty/cty"
 )
 
-// knownResourceTypes lists all resource types that can be referenced
-var knownResourceTypes = map[string]bool{
-	"file":      true,
-	"directory": true,
-	"exec":      true,
-	"hostname":  true,
-	"cron":      true,
-	"package":   true,
-	"service":   true,
-	"user":      true,
-	"group":     true,
-	"link":      true,
-	"download":  true,
-	"stat":      true,
-}
-
 // Executor runs the configuration management process
 type Executor struct {
 	parser    *config.Parser
@@ -44,15 +32,63 @@ type Executor struct {
 	out       io.Writer
 	useColors bool
 	roles     map[string]*role.Role
+	roleOrder []string // role names in the order they were loaded
 	cliVars   map[string]cty.Value
+	outputs   []*config.Output
+
+	// localScopes holds the locals of each role and of the main config
+	localScopes []*localScope
+
+	// valuesReady is set once every resource has been exported, so locals
+	// and role outputs are evaluated again whenever an export changes
+	valuesReady bool
+
+	// warnings are problems found while loading the configuration
+	warnings []string
 
 	// for_each tracking
 	forEachValues        map[string]cty.Value // resourceID -> each.value
 	forEachOriginalNames map[string][]string  // originalID -> []expandedIDs
+	countIndexes         map[string]int       // resourceID -> count.index
+
+	// notify tracking
+	subscriptions map[string][]string // resourceID -> resources whose changes notify it
 
 	// when tracking
 	whenExpressions  map[string]hcl.Expression // resourceID -> when expression
 	skippedResources map[string]string         // resourceID -> skip reason
+	failedResources  map[string]bool           // resourceIDs that failed to apply
+	skippedMu        sync.Mutex                // guards skippedResources and failedResources
+
+	// sensitive tracking
+	sensitiveAttrs map[string]map[string]bool // resourceID -> attributes set from sensitive values
+	secrets        map[string]bool            // sensitive strings, removed from messages
+	secretList     []string                   // secrets, longest first
+	sensitiveMu    sync.Mutex                 // guards sensitiveAttrs and secrets
+
+	// evalMu guards the parser while resources are planned and applied, as
+	// exported attributes are updated and deferred resources resolved
+	evalMu sync.Mutex
+
+	// parallelism is the maximum number of resources planned or applied at once
+	parallelism int
+
+	// rollbackOnFailure reverts applied resources when an apply fails
+	rollbackOnFailure bool
+
+	// keepGoing continues a sequential apply after a failure, skipping only
+	// the resources that depend on the failed one
+	keepGoing bool
+
+	// stateStore records managed resources between runs (nil disables state)
+	stateStore *state.Store
+	configPath string
+
+	// configSource is the remote source the configuration was fetched from
+	configSource string
+
+	// fetcher fetches remote role sources
+	fetcher *source.Fetcher
 }
 
 // NewExecutor creates a new executor
@@ -74,17 +110,67 @@ func NewExecutor(out io.Writer, useColors bool) *Executor {
 		cliVars:              make(map[string]cty.Value),
 		forEachValues:        make(map[string]cty.Value),
 		forEachOriginalNames: make(map[string][]string),
+		countIndexes:         make(map[string]int),
+		subscriptions:        make(map[string][]string),
 		whenExpressions:      make(map[string]hcl.Expression),
 		skippedResources:     make(map[string]string),
+		failedResources:      make(map[string]bool),
+		sensitiveAttrs:       make(map[string]map[string]bool),
+		secrets:              make(map[string]bool),
+		parallelism:          1,
+		fetcher:              source.NewFetcher(source.DefaultCacheDir()),
 	}
 }
 
+// SetParallelism sets the maximum number of independent resources that are
+// planned and applied concurrently. Values below 1 are treated as 1.
+func (e *Executor) SetParallelism(n int) {
+	if n < 1 {
+		n = 1
+	}
+	e.parallelism = n
+}
+
+// SetRollbackOnFailure enables reverting every applied resource, in reverse
+// order, when any resource fails to apply
+func (e *Executor) SetRollbackOnFailure(enabled bool) {
+	e.rollbackOnFailure = enabled
+}
+
+// SetKeepGoing makes apply continue after a resource fails, skipping only
+// the resources that depend on it, and print a summary of every resource
+func (e *Executor) SetKeepGoing(enabled bool) {
+	e.keepGoing = enabled
+}
+
+// SetSourceFetcher sets the fetcher used for remote role sources
+func (e *Executor) SetSourceFetcher(f *source.Fetcher) {
+	e.fetcher = f
+}
+
+// SetConfigSource records the remote source the configuration was fetched
+// from, so saved plans and the state refer to it rather than the cache
+func (e *Executor) SetConfigSource(src string) {
+	e.configSource = src
+}
+
+// SetStateStore sets the store used to record managed resources after apply
+func (e *Executor) SetStateStore(store *state.Store) {
+	e.stateStore = store
+}
+
 // SetVariable sets a variable for use during execution
 func (e *Executor) SetVariable(name, value string) {
 	e.parser.SetVariable(name, value)
 	e.cliVars[name] = cty.StringVal(value)
 }
 
+// SetVariableSource records where a variable's value was set, such as a var
+// file or the -e flag, for validation errors
+func (e *Executor) SetVariableSource(name, source string) {
+	e.parser.SetVariableSource(name, source)
+}
+
 // SetVariableValue sets a variable with a cty.Value directly (for non-string types from var files)
 func (e *Executor) SetVariableValue(name string, value cty.Value) {
 	e.parser.SetVariableValue(name, value)
@@ -93,40 +179,56 @@ func (e *Executor) SetVariableValue(name string, value cty.Value) {
 
 // LoadFile loads and parses an HCL configuration file
 func (e *Executor) LoadFile(filename string) error {
+	e.configPath = filename
 	cfg, diags := e.parser.ParseFile(filename)
 	if diags.HasErrors() {
 		return fmt.Errorf("failed to parse file: %s", diags.Error())
 	}
 
-	return e.loadConfig(cfg)
+	return e.redactError(e.loadConfig(cfg))
 }
 
 // LoadDirectory loads and parses all HCL files in a directory
 func (e *Executor) LoadDirectory(dir string) error {
+	e.configPath = dir
 	cfg, diags := e.parser.ParseDirectory(dir)
 	if diags.HasErrors() {
 		return fmt.Errorf("failed to parse directory: %s", diags.Error())
 	}
 
-	return e.loadConfig(cfg)
+	return e.redactError(e.loadConfig(cfg))
 }
 
 func (e *Executor) loadConfig(cfg *config.Config) error {
 	// Phase 0: Load all roles
 	if len(cfg.Roles) > 0 {
 		roleLoader := role.NewLoader(e.parser, e.parser.GetBaseDir(), e.cliVars)
+		roleLoader.SetFetcher(e.fetcher)
 		for _, roleBlock := range cfg.Roles {
 			r, err := roleLoader.LoadRole(roleBlock)
 			if err != nil {
 				return fmt.Errorf("failed to load role %s: %w", roleBlock.Name, err)
 			}
 			e.roles[roleBlock.Name] = r
+			e.roleOrder = append(e.roleOrder, roleBlock.Name)
 
 			// Append role resources to main resource list
 			cfg.Resources = append(cfg.Resources, r.Resources...)
 		}
 	}
 
+	// Locals can be used anywhere, including for_each
+	if err := e.loadLocals(cfg); err != nil {
+		return err
+	}
+	if err := e.checkLocalReferences(cfg); err != nil {
+		return err
+	}
+	pending, err := e.refreshValues(false)
+	if err != nil {
+		return err
+	}
+
 	// Phase 0.5: Expand for_each resources
 	expandedResources, err := e.expandForEachResources(cfg.Resources)
 	if err != nil {
@@ -134,21 +236,36 @@ func (e *Executor) loadConfig(cfg *config.Config) error {
 	}
 	cfg.Resources = expandedResources
 
-	// First pass: extract resource attributes so they can be referenced
-	// by other resources. We decode each resource to get its attribute values.
-	for _, block := range cfg.Resources {
-		// Set role context if this is a role resource
-		if block.RoleBaseDir != "" {
-			e.parser.SetRoleContext(block.RoleBaseDir)
+	// First pass: export resource attributes so they can be referenced
+	// by other resources
+	if err := e.exportBlocks(cfg.Resources); err != nil {
+		return err
+	}
+
+	// Locals and role outputs can reference resources. Export again if some
+	// could not be evaluated before, as resources may reference them.
+	if pending {
+		if _, err := e.refreshValues(false); err != nil {
+			return err
 		}
-		attrs := e.extractResourceAttributes(block)
-		if len(attrs) > 0 {
-			e.parser.SetResourceAttributes(block.Type, block.Name, attrs)
+		if err := e.exportBlocks(cfg.Resources); err != nil {
+			return err
 		}
-		// Clear role context
-		if block.RoleBaseDir != "" {
-			e.parser.ClearRoleContext()
+	}
+	if _, err := e.refreshValues(true); err != nil {
+		return err
+	}
+	e.valuesReady = true
+
+	// Collect notify and subscribe relationships. A notified resource depends
+	// on the resources that notify it, so it is applied after their changes.
+	for _, block := range cfg.Resources {
+		resourceID := block.Type + "." + block.Name
+		for _, target := range e.expandForEachDependencies(e.expandRoleDependencies(block.Notify)) {
+			e.subscriptions[target] = e.mergeDependencies(e.subscriptions[target], []string{resourceID})
 		}
+		subscribed := e.expandForEachDependencies(e.expandRoleDependencies(block.Subscribe))
+		e.subscriptions[resourceID] = e.mergeDependencies(e.subscriptions[resourceID], subscribed)
 	}
 
 	// Second pass: create resources with full context (including resource references)
@@ -159,17 +276,7 @@ func (e *Executor) loadConfig(cfg *config.Config) error {
 		}
 
 		// Build context with each.key/each.value if this is an expanded for_each resource
-		var ctx *hcl.EvalContext
-		if block.ForEachKey != "" {
-			eachKey := cty.StringVal(block.ForEachKey)
-			eachValue := e.forEachValues[block.Type+"."+block.Name]
-			if eachValue.IsNull() {
-				eachValue = eachKey // Fallback for sets where key == value
-			}
-			ctx = e.parser.BuildEvalContextWithEach(eachKey, eachValue)
-		} else {
-			ctx = e.parser.GetEvalContext()
-		}
+		ctx := e.resourceEvalContext(block)
 
 		// Extract implicit dependencies from resource references in expressions
 		implicitDeps := e.extractImplicitDependencies(block)
@@ -183,7 +290,20 @@ func (e *Executor) loadConfig(cfg *config.Config) error {
 		// Expand for_each dependencies (reference to base name -> all expanded instances)
 		allDeps = e.expandForEachDependencies(allDeps)
 
-		r, err := resource.CreateWithDeps(block, allDeps, ctx)
+		// Depend on every resource that notifies this one
+		allDeps = e.mergeDependencies(allDeps, e.subscriptions[block.Type+"."+block.Name])
+
+		e.recordSensitive(block, ctx)
+
+		// Resources referencing values known after apply are created later
+		var r resource.Resource
+		var err error
+		deferred := len(unknownReferences(block, ctx)) > 0
+		if deferred {
+			r = &deferredResource{block: block, deps: allDeps}
+		} else {
+			r, err = resource.CreateWithDeps(block, allDeps, ctx)
+		}
 
 		// Clear role context
 		if block.RoleBaseDir != "" {
@@ -208,11 +328,41 @@ func (e *Executor) loadConfig(cfg *config.Config) error {
 		e.graph.Add(r)
 	}
 
+	// Validate that notified resources exist and can be notified
+	if err := e.validateSubscriptions(); err != nil {
+		return err
+	}
+
 	// Validate the dependency graph
 	if err := e.graph.Validate(); err != nil {
 		return err
 	}
 
+	return e.checkOutputs(cfg.Outputs)
+}
+
+// validateSubscriptions checks that every notified resource exists and
+// supports being notified
+func (e *Executor) validateSubscriptions() error {
+	receivers := make([]string, 0, len(e.subscriptions))
+	for id, notifiers := range e.subscriptions {
+		if len(notifiers) > 0 {
+			receivers = append(receivers, id)
+		}
+	}
+	sort.Strings(receivers)
+
+	for _, id := range receivers {
+		notifier := e.subscriptions[id][0]
+		r, ok := e.graph.Get(id)
+		if !ok {
+			return fmt.Errorf("resource %s notifies unknown resource: %s", notifier, id)
+		}
+		if _, ok := r.(resource.Notifiable); !ok {
+			return fmt.Errorf("resource %s notifies %s, but %s resources cannot be notified",
+				notifier, id, r.Type())
+		}
+	}
 	return nil
 }
 
@@ -232,59 +382,90 @@ func (e *Executor) expandRoleDependencies(deps []string) []string {
 	return result
 }
 
-// expandForEachResources expands any resource with for_each into multiple resources
+// expandForEachResources expands any resource with for_each or count into
+// multiple resources
 func (e *Executor) expandForEachResources(resources []*config.ResourceBlock) ([]*config.ResourceBlock, error) {
 	var result []*config.ResourceBlock
 
 	for _, block := range resources {
-		// Evaluate the for_each expression (returns nil if not present or null)
+		// Role resources see the role's locals
+		if block.RoleBaseDir != "" {
+			e.parser.SetRoleContext(block.RoleBaseDir)
+		}
+		// Evaluate the for_each and count expressions (nil and -1 if not present or null)
 		iterations, diags := e.parser.EvaluateForEach(block.ForEach)
+		count := -1
+		if !diags.HasErrors() {
+			count, diags = e.parser.EvaluateCount(block.Count)
+		}
+		if block.RoleBaseDir != "" {
+			e.parser.ClearRoleContext()
+		}
 		if diags.HasErrors() {
-			return nil, fmt.Errorf("failed to evaluate for_each for %s.%s: %s",
+			return nil, fmt.Errorf("failed to evaluate for_each or count for %s.%s: %s",
 				block.Type, block.Name, diags.Error())
 		}
 
-		// If no for_each or it evaluates to null, keep the resource as-is
-		if iterations == nil {
-			result = append(result, block)
-			continue
+		originalID := block.Type + "." + block.Name
+		if iterations != nil && count >= 0 {
+			return nil, fmt.Errorf("%s: for_each and count cannot both be set", originalID)
 		}
 
-		if len(iterations) == 0 {
-			// Empty for_each - no resources created
+		// If no for_each or count, keep the resource as-is
+		if iterations == nil && count < 0 {
+			result = append(result, block)
 			continue
 		}
 
-		originalID := block.Type + "." + block.Name
-		var expandedIDs []string
+		// An empty for_each or a count of 0 creates no resources, and
+		// dependencies on the resource have nothing to wait for
+		expandedIDs := []string{}
 
 		// Create an expanded resource for each iteration
 		for key, value := range iterations {
-			expandedName := fmt.Sprintf("%s[\"%s\"]", block.Name, key)
-			expanded := &config.ResourceBlock{
-				Type:        block.Type,
-				Name:        expandedName,
-				Description: block.Description, // Preserve description
-				DependsOn:   block.DependsOn,   // Will be expanded in second pass
-				Body:        block.Body,        // Same body, will be decoded with each context
-				RoleBaseDir: block.RoleBaseDir,
-				ForEachKey:  key,
-				When:        block.When, // Preserve when expression
-			}
+			expanded := expandBlock(block, fmt.Sprintf("%s[\"%s\"]", block.Name, key))
+			expanded.ForEachKey = key
 
-			expandedID := block.Type + "." + expandedName
+			expandedID := block.Type + "." + expanded.Name
 			e.forEachValues[expandedID] = value
 			expandedIDs = append(expandedIDs, expandedID)
 
 			result = append(result, expanded)
 		}
 
+		// Create an expanded resource for each index
+		for i := 0; i < count; i++ {
+			expanded := expandBlock(block, fmt.Sprintf("%s[%d]", block.Name, i))
+			index := i
+			expanded.CountIndex = &index
+
+			expandedID := block.Type + "." + expanded.Name
+			e.countIndexes[expandedID] = index
+			expandedIDs = append(expandedIDs, expandedID)
+			result = append(result, expanded)
+		}
+
 		e.forEachOriginalNames[originalID] = expandedIDs
 	}
 
 	return result, nil
 }
 
+// expandBlock copies a for_each or count resource block for one instance
+func expandBlock(block *config.ResourceBlock, name string) *config.ResourceBlock {
+	return &config.ResourceBlock{
+		Type:        block.Type,
+		Name:        name,
+		Description: block.Description, // Preserve description
+		DependsOn:   block.DependsOn,   // Will be expanded in second pass
+		Body:        block.Body,        // Same body, will be decoded with each or count context
+		RoleBaseDir: block.RoleBaseDir,
+		When:        block.When, // Preserve when expression
+		Notify:      block.Notify,
+		Subscribe:   block.Subscribe,
+	}
+}
+
 // expandForEachDependencies expands references to for_each base names to all their instances
 func (e *Executor) expandForEachDependencies(deps []string) []string {
 	var result []string
@@ -321,231 +502,47 @@ func (e *Executor) mergeDependencies(explicit, implicit []string) []string {
 	return result
 }
 
-// extractResourceAttributes extracts attribute values from a resource block
-// that can be referenced by other resources
-func (e *Executor) extractResourceAttributes(block *config.ResourceBlock) map[string]cty.Value {
-	// Build context with each.key/each.value if this is an expanded for_each resource
-	var ctx *hcl.EvalContext
-	if block.ForEachKey != "" {
-		eachKey := cty.StringVal(block.ForEachKey)
-		eachValue := e.forEachValues[block.Type+"."+block.Name]
-		if eachValue.IsNull() {
-			eachValue = eachKey // Fallback for sets where key == value
-		}
-		ctx = e.parser.BuildEvalContextWithEach(eachKey, eachValue)
-	} else {
-		ctx = e.parser.GetEvalContext()
-	}
-
-	attrs := make(map[string]cty.Value)
-
-	switch block.Type {
-	case "file":
-		var cfg config.FileResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["path"] = cty.StringVal(cfg.Path)
-			if cfg.Content != nil {
-				attrs["content"] = cty.StringVal(*cfg.Content)
-			}
-			if cfg.Mode != nil {
-				attrs["mode"] = cty.StringVal(*cfg.Mode)
-			}
-			if cfg.Owner != nil {
-				attrs["owner"] = cty.StringVal(*cfg.Owner)
-			}
-			if cfg.Group != nil {
-				attrs["group"] = cty.StringVal(*cfg.Group)
-			}
-		}
-
-	case "directory":
-		var cfg config.DirectoryResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["path"] = cty.StringVal(cfg.Path)
-			if cfg.Mode != nil {
-				attrs["mode"] = cty.StringVal(*cfg.Mode)
-			}
-			if cfg.Owner != nil {
-				attrs["owner"] = cty.StringVal(*cfg.Owner)
-			}
-			if cfg.Group != nil {
-				attrs["group"] = cty.StringVal(*cfg.Group)
-			}
-		}
-
-	case "exec":
-		var cfg config.ExecResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["command"] = cty.StringVal(cfg.Command)
-			if cfg.Creates != nil {
-				attrs["creates"] = cty.StringVal(*cfg.Creates)
-			}
-			if cfg.Dir != nil {
-				attrs["dir"] = cty.StringVal(*cfg.Dir)
-			}
-		}
-
-	case "hostname":
-		var cfg config.HostnameResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["name"] = cty.StringVal(cfg.Name)
-		}
-
-	case "cron":
-		var cfg config.CronResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["command"] = cty.StringVal(cfg.Command)
-			attrs["schedule"] = cty.StringVal(cfg.Schedule)
-			if cfg.User != nil {
-				attrs["user"] = cty.StringVal(*cfg.User)
-			}
-		}
-
-	case "package":
-		var cfg config.PackageResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["name"] = cty.StringVal(cfg.Name)
-			if cfg.Version != nil {
-				attrs["version"] = cty.StringVal(*cfg.Version)
-			}
-		}
-
-	case "service":
-		var cfg config.ServiceResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["name"] = cty.StringVal(cfg.Name)
-		}
-
-	case "user":
-		var cfg config.UserResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["name"] = cty.StringVal(cfg.Name)
-			if cfg.UID != nil {
-				attrs["uid"] = cty.StringVal(*cfg.UID)
-			}
-			if cfg.GID != nil {
-				attrs["gid"] = cty.StringVal(*cfg.GID)
-			}
-			if cfg.Home != nil {
-				attrs["home"] = cty.StringVal(*cfg.Home)
-			}
-			if cfg.Shell != nil {
-				attrs["shell"] = cty.StringVal(*cfg.Shell)
-			}
-		}
-
-	case "group":
-		var cfg config.GroupResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["name"] = cty.StringVal(cfg.Name)
-			if cfg.GID != nil {
-				attrs["gid"] = cty.StringVal(*cfg.GID)
-			}
+// exportBlock exports the attributes of a resource block before any resource
+// has been planned. A resource that references values known after apply
+// exports an unknown value, so the resources referencing it are deferred too.
+func (e *Executor) exportBlocks(blocks []*config.ResourceBlock) error {
+	for _, block := range blocks {
+		// Set role context if this is a role resource
+		if block.RoleBaseDir != "" {
+			e.parser.SetRoleContext(block.RoleBaseDir)
 		}
-
-	case "link":
-		var cfg config.LinkResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["path"] = cty.StringVal(cfg.Path)
-			attrs["target"] = cty.StringVal(cfg.Target)
+		err := e.exportBlock(block)
+		// Clear role context
+		if block.RoleBaseDir != "" {
+			e.parser.ClearRoleContext()
 		}
-
-	case "download":
-		var cfg config.DownloadResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["url"] = cty.StringVal(cfg.URL)
-			attrs["dest"] = cty.StringVal(cfg.Dest)
-			if cfg.Checksum != nil {
-				attrs["checksum"] = cty.StringVal(*cfg.Checksum)
-			}
-			if cfg.Mode != nil {
-				attrs["mode"] = cty.StringVal(*cfg.Mode)
-			}
-			if cfg.Owner != nil {
-				attrs["owner"] = cty.StringVal(*cfg.Owner)
-			}
-			if cfg.Group != nil {
-				attrs["group"] = cty.StringVal(*cfg.Group)
-			}
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-	case "stat":
-		var cfg config.StatResourceConfig
-		if diags := gohcl.DecodeBody(block.Body, ctx, &cfg); !diags.HasErrors() {
-			attrs["path"] = cty.StringVal(cfg.Path)
-
-			// Perform stat operation to populate runtime attributes
-			follow := true
-			if cfg.Follow != nil {
-				follow = *cfg.Follow
-			}
-
-			var info os.FileInfo
-			var statErr error
-			if follow {
-				info, statErr = os.Stat(cfg.Path)
-			} else {
-				info, statErr = os.Lstat(cfg.Path)
-			}
-
-			if os.IsNotExist(statErr) {
-				attrs["exists"] = cty.BoolVal(false)
-				attrs["isdir"] = cty.BoolVal(false)
-				attrs["isfile"] = cty.BoolVal(false)
-				attrs["islink"] = cty.BoolVal(false)
-				attrs["size"] = cty.NumberIntVal(0)
-				attrs["mode"] = cty.StringVal("")
-				attrs["owner"] = cty.StringVal("")
-				attrs["group"] = cty.StringVal("")
-				attrs["uid"] = cty.NumberIntVal(-1)
-				attrs["gid"] = cty.NumberIntVal(-1)
-				attrs["mtime"] = cty.NumberIntVal(0)
-				attrs["atime"] = cty.NumberIntVal(0)
-			} else if statErr == nil {
-				attrs["exists"] = cty.BoolVal(true)
-				attrs["isdir"] = cty.BoolVal(info.IsDir())
-				attrs["isfile"] = cty.BoolVal(info.Mode().IsRegular())
-				attrs["size"] = cty.NumberIntVal(info.Size())
-				attrs["mode"] = cty.StringVal(fmt.Sprintf("%04o", info.Mode().Perm()))
-				attrs["mtime"] = cty.NumberIntVal(info.ModTime().Unix())
-
-				// Check if it's a symlink
-				linfo, lerr := os.Lstat(cfg.Path)
-				if lerr == nil {
-					attrs["islink"] = cty.BoolVal(linfo.Mode()&os.ModeSymlink != 0)
-				} else {
-					attrs["islink"] = cty.BoolVal(false)
-				}
-
-				// Get owner/group info
-				if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-					attrs["uid"] = cty.NumberIntVal(int64(stat.Uid))
-					attrs["gid"] = cty.NumberIntVal(int64(stat.Gid))
-					attrs["atime"] = cty.NumberIntVal(getAtime(stat))
-
-					if u, err := user.LookupId(strconv.Itoa(int(stat.Uid))); err == nil {
-						attrs["owner"] = cty.StringVal(u.Username)
-					} else {
-						attrs["owner"] = cty.StringVal(strconv.Itoa(int(stat.Uid)))
-					}
-					if g, err := user.LookupGroupId(strconv.Itoa(int(stat.Gid))); err == nil {
-						attrs["group"] = cty.StringVal(g.Name)
-					} else {
-						attrs["group"] = cty.StringVal(strconv.Itoa(int(stat.Gid)))
-					}
-				}
-			}
-		}
+func (e *Executor) exportBlock(block *config.ResourceBlock) error {
+	ctx := e.resourceEvalContext(block)
+	e.recordSensitive(block, ctx)
+	if len(unknownReferences(block, ctx)) > 0 {
+		e.parser.SetResourceValue(block.Type, block.Name, cty.DynamicVal)
+		return nil
 	}
 
-	return attrs
+	// Errors are reported when the resource is created in the second pass
+	r, err := resource.Create(block, ctx)
+	if err != nil {
+		return nil
+	}
+	return e.exportAttributes(context.Background(), r, true)
 }
 
 // extractImplicitDependencies analyzes HCL expressions in a resource block
 // to find references to other resources, returning them as implicit dependencies
 func (e *Executor) extractImplicitDependencies(block *config.ResourceBlock) []string {
 	deps := make(map[string]bool)
-	selfRef := block.Type + "." + block.Name
 
 	// Helper function to extract resource references from an expression
 	extractFromExpr := func(expr hcl.Expression) {
@@ -553,21 +550,11 @@ func (e *Executor) extractImplicitDependencies(block *config.ResourceBlock) []st
 			return
 		}
 		for _, traversal := range expr.Variables() {
-			if len(traversal) >= 2 {
-				// Check if the root is a known resource type
-				rootName := traversal.RootName()
-				if knownResourceTypes[rootName] {
-					// This is a resource reference like directory.web_root_dir.path
-					// Extract the resource type and name
-					if nameStep, ok := traversal[1].(hcl.TraverseAttr); ok {
-						resourceRef := rootName + "." + nameStep.Name
-						// Don't add self-references
-						if resourceRef != selfRef {
-							deps[resourceRef] = true
-						}
-					}
-				}
-			}
+			addReference(traversal, deps)
+		}
+		// A local depends on whatever its value references
+		for _, name := range config.LocalReferences(expr) {
+			e.localDependencies(block.RoleBaseDir, name, deps)
 		}
 	}
 
@@ -583,9 +570,19 @@ func (e *Executor) extractImplicitDependencies(block *config.ResourceBlock) []st
 		extractFromExpr(attr.Expr)
 	}
 
+	// And from nested blocks, including dynamic blocks
+	for _, nested := range nestedBlocks(block.Body) {
+		for _, expr := range bodyExpressions(nested.Body) {
+			extractFromExpr(expr)
+		}
+	}
+
 	// Also extract dependencies from the when expression
 	extractFromExpr(block.When)
 
+	// Don't add self-references
+	delete(deps, block.Type+"."+block.Name)
+
 	// Convert map to slice
 	result := make([]string, 0, len(deps))
 	for dep := range deps {
@@ -594,8 +591,33 @@ func (e *Executor) extractImplicitDependencies(block *config.ResourceBlock) []st
 	return result
 }
 
+// addReference adds the resource or role a traversal references, such as
+// directory.web_root_dir for directory.web_root_dir.path, to deps
+func addReference(traversal hcl.Traversal, deps map[string]bool) {
+	if len(traversal) < 2 {
+		return
+	}
+	nameStep, ok := traversal[1].(hcl.TraverseAttr)
+	if !ok {
+		return
+	}
+
+	rootName := traversal.RootName()
+	if rootName == "role" {
+		// A role output depends on all of the role's resources
+		deps["role."+nameStep.Name] = true
+		return
+	}
+	// Check if the root is a known resource type
+	if resource.DefaultRegistry.Has(rootName) {
+		deps[rootName+"."+nameStep.Name] = true
+	}
+}
+
 // Plan generates and prints the execution plan
-func (e *Executor) Plan(ctx context.Context) (*PlanResult, error) {
+func (e *Executor) Plan(ctx context.Context) (_ *PlanResult, err error) {
+	defer func() { err = e.redactError(err) }()
+
 	result := &PlanResult{
 		Plans: make(map[string]*resource.Plan),
 	}
@@ -609,61 +631,63 @@ func (e *Executor) Plan(ctx context.Context) (*PlanResult, error) {
 		return nil, err
 	}
 
-	for _, r := range resources {
+	var mu sync.Mutex
+	errs := make(map[string]error)
+
+	e.graph.Walk(resources, e.parallelism, func(r resource.Resource) {
 		resourceID := resource.ID(r)
 
-		// Check if any dependency was skipped (cascade skip)
-		skipReason := e.checkDependencySkipped(r)
-		if skipReason != "" {
-			plan := &resource.Plan{
-				Action:     resource.ActionSkip,
-				SkipReason: skipReason,
-			}
-			result.Plans[resourceID] = plan
-			result.Resources = append(result.Resources, r)
-			result.ToSkip++
-			e.skippedResources[resourceID] = skipReason
-			continue
+		// Stop planning once any resource has failed
+		mu.Lock()
+		failed := len(errs) > 0
+		mu.Unlock()
+		if failed {
+			return
 		}
 
-		// Check when condition
-		if whenExpr, ok := e.whenExpressions[resourceID]; ok {
-			evalCtx := e.buildWhenEvalContext(r)
-			shouldExecute, failedCondition, err := e.parser.EvaluateWhen(whenExpr, evalCtx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to evaluate when condition for %s: %w", resourceID, err)
-			}
-			if !shouldExecute {
-				skipReason := "when condition false"
-				if failedCondition != "" {
-					skipReason = fmt.Sprintf("when %s", failedCondition)
-				}
-				plan := &resource.Plan{
-					Action:     resource.ActionSkip,
-					SkipReason: skipReason,
-				}
-				result.Plans[resourceID] = plan
-				result.Resources = append(result.Resources, r)
-				result.ToSkip++
-				e.skippedResources[resourceID] = skipReason
-				continue
+		plan, err := e.planResource(ctx, r)
+		if err == nil {
+			// Later resources see the planned values, or unknown values
+			// for attributes that change
+			if exportErr := e.exportAttributes(ctx, r, plan.HasChanges()); exportErr != nil {
+				err = fmt.Errorf("failed to export attributes of %s: %w", resourceID, exportErr)
 			}
 		}
 
-		current, err := r.Read(ctx)
+		mu.Lock()
+		defer mu.Unlock()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read %s: %w", resourceID, err)
+			errs[resourceID] = err
+			return
 		}
+		result.Plans[resourceID] = plan
+	})
 
-		plan, err := r.Diff(ctx, current)
-		if err != nil {
-			return nil, fmt.Errorf("failed to diff %s: %w", resourceID, err)
+	// Report the first failure in dependency order so errors are deterministic
+	for _, r := range resources {
+		if err, ok := errs[resource.ID(r)]; ok {
+			return nil, err
 		}
+	}
 
-		result.Plans[resourceID] = plan
+	// Work out which resources will be notified by changes to others
+	result.Notifications = e.plannedNotifications(resources, result.Plans)
+
+	// Plan deletion of resources that were removed from the configuration
+	orphans, err := e.planOrphans(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+	resources = append(resources, orphans...)
+
+	for _, r := range resources {
+		plan := result.Plans[resource.ID(r)]
 		result.Resources = append(result.Resources, r)
 
-		if plan.HasChanges() {
+		switch {
+		case plan.Action == resource.ActionSkip:
+			result.ToSkip++
+		case plan.HasChanges():
 			switch plan.Action {
 			case resource.ActionCreate:
 				result.ToAdd++
@@ -678,18 +702,190 @@ func (e *Executor) Plan(ctx context.Context) (*PlanResult, error) {
 	return result, nil
 }
 
+// plannedNotifications returns, for each notified resource, the resources
+// that notify it and are planned to change, in dependency order
+func (e *Executor) plannedNotifications(resources []resource.Resource, plans map[string]*resource.Plan) map[string][]string {
+	notifications := make(map[string][]string)
+	for id, notifiers := range e.subscriptions {
+		if plan, ok := plans[id]; !ok || plan.Action == resource.ActionSkip {
+			continue
+		}
+		for _, r := range resources {
+			notifier := resource.ID(r)
+			if !slices.Contains(notifiers, notifier) {
+				continue
+			}
+			if plan := plans[notifier]; plan.Action != resource.ActionSkip && plan.HasChanges() {
+				notifications[id] = append(notifications[id], notifier)
+			}
+		}
+	}
+	return notifications
+}
+
+// planResource works out the plan for a single resource. It is called once
+// all of the resource's dependencies have been planned.
+func (e *Executor) planResource(ctx context.Context, r resource.Resource) (*resource.Plan, error) {
+	resourceID := resource.ID(r)
+
+	// Check if any dependency was skipped (cascade skip)
+	if skipReason := e.checkDependencySkipped(r); skipReason != "" {
+		e.markSkipped(resourceID, skipReason)
+		return &resource.Plan{
+			Action:     resource.ActionSkip,
+			SkipReason: skipReason,
+		}, nil
+	}
+
+	// Resolve resources that reference values known after apply, once
+	// the resources they reference have been planned
+	if d, ok := r.(*deferredResource); ok && d.resolved == nil {
+		resolved, err := e.resolveDeferred(d)
+		if err != nil {
+			return nil, err
+		}
+		if !resolved {
+			return e.deferredPlan(d), nil
+		}
+	}
+
+	// Check when condition
+	if whenExpr, ok := e.whenExpressions[resourceID]; ok {
+		e.evalMu.Lock()
+		evalCtx := e.buildWhenEvalContext(r)
+		shouldExecute, failedCondition, err := e.parser.EvaluateWhen(whenExpr, evalCtx)
+		e.evalMu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate when condition for %s: %w", resourceID, err)
+		}
+		if !shouldExecute {
+			skipReason := "when condition false"
+			if failedCondition != "" {
+				skipReason = fmt.Sprintf("when %s", failedCondition)
+			}
+			e.markSkipped(resourceID, skipReason)
+			return &resource.Plan{
+				Action:     resource.ActionSkip,
+				SkipReason: skipReason,
+			}, nil
+		}
+	}
+
+	current, err := r.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", resourceID, err)
+	}
+
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s: %w", resourceID, err)
+	}
+	plan.Sensitive = e.planSensitive(r, plan)
+
+	return plan, nil
+}
+
+// orphanDescription is shown in the plan for resources removed from the configuration
+const orphanDescription = "removed from configuration"
+
+// planOrphans finds resources recorded in the state that are no longer in the
+// configuration and plans their removal. Orphans are deleted in reverse
+// dependency order, so a file is removed before the directory holding it.
+func (e *Executor) planOrphans(ctx context.Context, result *PlanResult) ([]resource.Resource, error) {
+	result.Orphans = make(map[string]bool)
+	if e.stateStore == nil {
+		return nil, nil
+	}
+
+	st, err := e.stateStore.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphanIDs []string
+	for _, id := range st.IDs() {
+		if _, ok := e.graph.Get(id); !ok {
+			result.Orphans[id] = true
+			orphanIDs = append(orphanIDs, id)
+		}
+	}
+
+	// Reverse the recorded dependencies between orphans
+	dependents := make(map[string][]string)
+	for _, id := range orphanIDs {
+		rs, _ := st.Get(id)
+		for _, dep := range rs.DependsOn {
+			if result.Orphans[dep] {
+				dependents[dep] = append(dependents[dep], id)
+			}
+		}
+	}
+
+	orphanGraph := NewGraph()
+	for _, id := range orphanIDs {
+		rs, _ := st.Get(id)
+		if !resource.DefaultRegistry.IsPrunable(rs.Type) {
+			continue
+		}
+		r, err := resource.DefaultRegistry.CreateOrphan(rs.Type, rs.Name, rs.Attributes, dependents[id], orphanDescription)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load orphaned resource %s: %w", id, err)
+		}
+		orphanGraph.Add(r)
+	}
+
+	sorted, err := orphanGraph.TopologicalSort()
+	if err != nil {
+		return nil, fmt.Errorf("failed to order orphaned resources: %w", err)
+	}
+
+	for _, r := range sorted {
+		resourceID := resource.ID(r)
+		current, err := r.Read(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", resourceID, err)
+		}
+		plan, err := r.Diff(ctx, current)
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff %s: %w", resourceID, err)
+		}
+		result.Plans[resourceID] = plan
+	}
+
+	return sorted, nil
+}
+
+// markSkipped records a skipped resource so its dependents are skipped too
+func (e *Executor) markSkipped(resourceID, reason string) {
+	e.skippedMu.Lock()
+	defer e.skippedMu.Unlock()
+	e.skippedResources[resourceID] = reason
+}
+
+// markFailed records that a resource failed to apply, so that the resources
+// depending on it are skipped
+func (e *Executor) markFailed(resourceID string) {
+	e.skippedMu.Lock()
+	defer e.skippedMu.Unlock()
+	e.skippedResources[resourceID] = "apply failed"
+	e.failedResources[resourceID] = true
+}
+
 // checkDependencySkipped checks if any dependency of the resource was skipped
-// Returns the skip reason if a dependency was skipped, empty string otherwise
+// or failed to apply. Returns the skip reason if so, empty string otherwise
 func (e *Executor) checkDependencySkipped(r resource.Resource) string {
+	e.skippedMu.Lock()
+	defer e.skippedMu.Unlock()
+
 	for _, depID := range r.Dependencies() {
-		if _, skipped := e.skippedResources[depID]; skipped {
-			return fmt.Sprintf("dependency %s skipped", depID)
+		if reason := e.dependencySkipReason(depID); reason != "" {
+			return reason
 		}
 		// Also check expanded for_each dependencies
 		if expanded, ok := e.forEachOriginalNames[depID]; ok {
 			for _, expandedID := range expanded {
-				if _, skipped := e.skippedResources[expandedID]; skipped {
-					return fmt.Sprintf("dependency %s skipped", expandedID)
+				if reason := e.dependencySkipReason(expandedID); reason != "" {
+					return reason
 				}
 			}
 		}
@@ -697,12 +893,29 @@ func (e *Executor) checkDependencySkipped(r resource.Resource) string {
 	return ""
 }
 
+// dependencySkipReason returns why a resource depending on depID is skipped.
+// The caller must hold skippedMu.
+func (e *Executor) dependencySkipReason(depID string) string {
+	if e.failedResources[depID] {
+		return fmt.Sprintf("dependency %s failed", depID)
+	}
+	if _, skipped := e.skippedResources[depID]; skipped {
+		return fmt.Sprintf("dependency %s skipped", depID)
+	}
+	return ""
+}
+
 // buildWhenEvalContext builds the evaluation context for when expressions
 // This includes the current state of resources that this resource depends on
 func (e *Executor) buildWhenEvalContext(r resource.Resource) *hcl.EvalContext {
 	// Get the resource ID to check for for_each context
 	resourceID := resource.ID(r)
 
+	// Check if this is a count expanded resource
+	if index, ok := e.countIndexes[resourceID]; ok {
+		return e.parser.BuildEvalContextWithCount(index)
+	}
+
 	// Check if this is a for_each expanded resource
 	if eachValue, ok := e.forEachValues[resourceID]; ok {
 		// Extract the key from the resource name (e.g., `configs["app"]` -> "app")
@@ -739,29 +952,404 @@ func (e *Executor) PrintPlan(result *PlanResult) {
 		return
 	}
 
+	for _, r := range result.Resources {
+		resourceID := resource.ID(r)
+		if notifiers := result.Notifications[resourceID]; len(notifiers) > 0 {
+			e.printer.PrintNotification(resourceID, r.(resource.Notifiable).NotifyAction(), notifiers)
+		}
+	}
+
 	e.printer.PrintSummary(result.ToAdd, result.ToChange, result.ToDestroy, result.ToSkip)
 }
 
-// Apply applies the changes
-func (e *Executor) Apply(ctx context.Context, result *PlanResult, dryRun bool) error {
+// Apply applies the changes. Independent resources are applied concurrently
+// when parallelism is greater than 1, with each resource's output kept
+// together and printed in plan order. Sequential runs stop at the first
+// failure unless keep going is set; parallel runs only skip the resources
+// that depend on it.
+func (e *Executor) Apply(ctx context.Context, result *PlanResult, dryRun bool) (err error) {
+	defer func() { err = e.redactError(err) }()
+
+	var st *state.State
+	if e.stateStore != nil && !dryRun {
+		loaded, err := e.stateStore.Load()
+		if err != nil {
+			return err
+		}
+		st = loaded
+	}
+
+	output := newOrderedOutput(e.out, result.Resources)
+
+	// Failures are tracked alongside plan-time skips so dependents cascade
+	e.failedResources = make(map[string]bool)
+
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	outcomes := make(map[string]applyOutcome)     // resourceID -> result for the summary
+	final := make(map[string]*resource.State)     // resourceID -> state after apply
+	changed := make(map[string]bool)              // resourceIDs successfully changed
+	snapshots := make(map[string]*resource.State) // resourceID -> state read just before apply
+	var started []string                          // resourceIDs in the order their apply started
+
+	e.graph.Walk(result.Resources, e.parallelism, func(r resource.Resource) {
+		resourceID := resource.ID(r)
+		out := output.Writer(resourceID)
+		defer output.Finish(resourceID)
+
+		mu.Lock()
+		plan := result.Plans[resourceID]
+		abort := e.parallelism == 1 && !e.keepGoing && len(errs) > 0
+		// Only notify for changes that were actually made
+		var notifiedBy []string
+		for _, id := range result.Notifications[resourceID] {
+			if dryRun || changed[id] {
+				notifiedBy = append(notifiedBy, id)
+			}
+		}
+		mu.Unlock()
+
+		if abort {
+			return
+		}
+		if plan.Action == resource.ActionSkip {
+			mu.Lock()
+			outcomes[resourceID] = applyOutcome{status: "skipped", detail: plan.SkipReason}
+			mu.Unlock()
+			return
+		}
+
+		// Skip resources that depend on one that failed
+		cause := e.checkDependencySkipped(r)
+		if cause != "" {
+			e.markSkipped(resourceID, cause)
+		}
+
+		// Work out the changes of resources that reference values known
+		// after apply, now that the resources they reference are applied
+		if plan.Deferred && !dryRun && cause == "" {
+			resolved, err := e.planResource(ctx, r)
+			if err == nil && resolved.Deferred {
+				err = fmt.Errorf("%s references values that are still unknown", resourceID)
+			}
+			if err != nil {
+				mu.Lock()
+				errs[resourceID] = err
+				outcomes[resourceID] = applyOutcome{status: "failed", detail: err.Error()}
+				mu.Unlock()
+				e.markFailed(resourceID)
+				return
+			}
+			plan = resolved
+			mu.Lock()
+			result.Plans[resourceID] = plan
+			mu.Unlock()
+
+			if plan.Action == resource.ActionSkip {
+				_, _ = fmt.Fprintf(out, "Skipping %s (%s)\n", resourceID, plan.SkipReason)
+				mu.Lock()
+				outcomes[resourceID] = applyOutcome{status: "skipped", detail: plan.SkipReason}
+				mu.Unlock()
+				return
+			}
+		}
+
+		if !plan.HasChanges() && len(notifiedBy) == 0 {
+			if err := e.exportAttributes(ctx, r, false); err != nil {
+				mu.Lock()
+				errs[resourceID] = fmt.Errorf("failed to export attributes of %s: %w", resourceID, err)
+				outcomes[resourceID] = applyOutcome{status: "failed", detail: err.Error()}
+				mu.Unlock()
+				e.markFailed(resourceID)
+				return
+			}
+			mu.Lock()
+			final[resourceID] = plan.Before
+			mu.Unlock()
+			return
+		}
+
+		if dryRun {
+			if plan.Deferred {
+				_, _ = fmt.Fprintf(out, "Would %s %s (known after apply)\n", plan.Action, resourceID)
+			} else if plan.HasChanges() {
+				_, _ = fmt.Fprintf(out, "Would %s %s\n", plan.Action, resourceID)
+			}
+			if len(notifiedBy) > 0 {
+				_, _ = fmt.Fprintf(out, "Would %s %s (notified by %s)\n",
+					r.(resource.Notifiable).NotifyAction(), resourceID, strings.Join(notifiedBy, ", "))
+			}
+			return
+		}
+
+		if cause != "" {
+			_, _ = fmt.Fprintf(out, "Skipping %s (%s)\n", resourceID, cause)
+			mu.Lock()
+			outcomes[resourceID] = applyOutcome{status: "skipped", detail: cause}
+			mu.Unlock()
+			return
+		}
+
+		if plan.HasChanges() {
+			if e.rollbackOnFailure {
+				before, err := r.Read(ctx)
+				mu.Lock()
+				if err != nil {
+					errs[resourceID] = fmt.Errorf("failed to snapshot %s before apply: %w", resourceID, err)
+					outcomes[resourceID] = applyOutcome{status: "failed", detail: err.Error()}
+					mu.Unlock()
+					e.markFailed(resourceID)
+					return
+				}
+				snapshots[resourceID] = before
+				started = append(started, resourceID)
+				mu.Unlock()
+			}
+
+			_, _ = fmt.Fprintf(out, "Applying %s...\n", resourceID)
+			if err := r.Apply(ctx, plan, true); err != nil {
+				var skip *resource.SkipError
+				if errors.As(err, &skip) {
+					_, _ = fmt.Fprintf(out, "  Warning: skipped, %s\n", skip.Reason)
+					e.markSkipped(resourceID, skip.Reason)
+					mu.Lock()
+					final[resourceID] = plan.Before
+					outcomes[resourceID] = applyOutcome{status: "skipped", detail: skip.Reason}
+					mu.Unlock()
+					return
+				}
+				mu.Lock()
+				errs[resourceID] = fmt.Errorf("failed to apply %s: %w", resourceID, err)
+				outcomes[resourceID] = applyOutcome{status: "failed", detail: err.Error()}
+				mu.Unlock()
+				e.markFailed(resourceID)
+				return
+			}
+			_, _ = fmt.Fprintf(out, "  Done.\n")
+
+			after := plan.After
+			if st != nil {
+				// Re-read so the state records what is actually on the host
+				if current, err := r.Read(ctx); err == nil {
+					after = current
+				}
+			}
+			mu.Lock()
+			final[resourceID] = after
+			changed[resourceID] = true
+			outcomes[resourceID] = applyOutcome{status: "succeeded", detail: plan.Action.String()}
+			mu.Unlock()
+		} else {
+			mu.Lock()
+			final[resourceID] = plan.Before
+			mu.Unlock()
+		}
+
+		// Resources referencing this one see the values it was applied with
+		if err := e.exportAttributes(ctx, r, false); err != nil {
+			mu.Lock()
+			errs[resourceID] = fmt.Errorf("failed to export attributes of %s: %w", resourceID, err)
+			outcomes[resourceID] = applyOutcome{status: "failed", detail: err.Error()}
+			mu.Unlock()
+			e.markFailed(resourceID)
+			return
+		}
+
+		// Notified once, after every resource that notifies it was applied
+		if len(notifiedBy) > 0 {
+			n := r.(resource.Notifiable)
+			_, _ = fmt.Fprintf(out, "Running %s on %s (notified by %s)...\n",
+				n.NotifyAction(), resourceID, strings.Join(notifiedBy, ", "))
+			if err := n.Notify(ctx); err != nil {
+				mu.Lock()
+				errs[resourceID] = fmt.Errorf("failed to %s %s: %w", n.NotifyAction(), resourceID, err)
+				outcomes[resourceID] = applyOutcome{status: "failed", detail: err.Error()}
+				mu.Unlock()
+				e.markFailed(resourceID)
+				return
+			}
+			_, _ = fmt.Fprintf(out, "  Done.\n")
+
+			mu.Lock()
+			detail := n.NotifyAction()
+			if plan.HasChanges() {
+				detail = plan.Action.String() + ", " + detail
+			}
+			outcomes[resourceID] = applyOutcome{status: "succeeded", detail: detail}
+			mu.Unlock()
+		}
+	})
+
+	var applyErrs []error
+	for _, r := range result.Resources {
+		if err, ok := errs[resource.ID(r)]; ok {
+			applyErrs = append(applyErrs, err)
+		}
+	}
+
+	if e.keepGoing && !dryRun {
+		e.printApplySummary(result.Resources, outcomes)
+	}
+
+	if e.rollbackOnFailure && len(applyErrs) > 0 && len(started) > 0 {
+		if err := e.rollback(ctx, result, started, snapshots, final); err != nil {
+			applyErrs = append(applyErrs, err)
+		}
+	}
+
+	if st != nil {
+		values, err := e.Outputs()
+		if err == nil {
+			err = e.recordOutputs(st, values)
+		}
+		if err != nil {
+			applyErrs = append(applyErrs, err)
+		}
+		if err := e.saveState(st, result.Resources, result.Orphans, final); err != nil {
+			applyErrs = append(applyErrs, err)
+		}
+	}
+
+	return errors.Join(applyErrs...)
+}
+
+// applyOutcome is what happened to a resource during apply
+type applyOutcome struct {
+	status string // "succeeded", "failed" or "skipped"
+	detail string // action taken, error or skip reason
+}
+
+// printApplySummary prints a table of every resource that was applied,
+// failed or skipped, followed by the totals
+func (e *Executor) printApplySummary(resources []resource.Resource, outcomes map[string]applyOutcome) {
+	counts := make(map[string]int)
+
+	_, _ = fmt.Fprintf(e.out, "\nApply summary:\n")
+	tw := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "  RESOURCE\tSTATUS\tDETAIL\n")
+	for _, r := range resources {
+		resourceID := resource.ID(r)
+		outcome, ok := outcomes[resourceID]
+		if !ok {
+			continue
+		}
+		counts[outcome.status]++
+		// Keep multi-line errors on one row
+		detail := strings.Join(strings.Fields(e.redact(outcome.detail)), " ")
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\t%s\n", resourceID, outcome.status, detail)
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintf(e.out, "\n%d succeeded, %d failed, %d skipped.\n",
+		counts["succeeded"], counts["failed"], counts["skipped"])
+}
+
+// rollback reverts resources in the reverse order they were applied, using
+// the state read just before each apply, and reports what was reverted.
+// Reverted resources have their final state replaced by the snapshot.
+func (e *Executor) rollback(ctx context.Context, result *PlanResult, started []string, snapshots, final map[string]*resource.State) error {
+	byID := make(map[string]resource.Resource, len(result.Resources))
 	for _, r := range result.Resources {
-		plan := result.Plans[resource.ID(r)]
-		if !plan.HasChanges() {
+		byID[resource.ID(r)] = r
+	}
+
+	_, _ = fmt.Fprintf(e.out, "\nRolling back %d resources...\n", len(started))
+
+	var reverted, notReverted int
+	for i := len(started) - 1; i >= 0; i-- {
+		resourceID := started[i]
+		r, ok := unwrapResource(byID[resourceID]).(resource.Reversible)
+		if !ok {
+			_, _ = fmt.Fprintf(e.out, "  Not reverted %s (%s resources cannot be rolled back)\n",
+				resourceID, byID[resourceID].Type())
+			notReverted++
 			continue
 		}
 
-		if dryRun {
-			_, _ = fmt.Fprintf(e.out, "Would %s %s\n", plan.Action, resource.ID(r))
+		if err := r.Revert(ctx, snapshots[resourceID]); err != nil {
+			_, _ = fmt.Fprintf(e.out, "  Failed to revert %s: %s\n", resourceID, e.redact(err.Error()))
+			notReverted++
+			continue
+		}
+
+		_, _ = fmt.Fprintf(e.out, "  Reverted %s\n", resourceID)
+		final[resourceID] = snapshots[resourceID]
+		reverted++
+	}
+
+	_, _ = fmt.Fprintf(e.out, "\nRollback complete: %d reverted, %d not reverted.\n", reverted, notReverted)
+
+	if notReverted > 0 {
+		return fmt.Errorf("rollback incomplete: %d resources could not be reverted", notReverted)
+	}
+	return nil
+}
+
+// SaveState records the state of every resource and the outputs, for runs
+// that have nothing to apply. Without it, resources later removed from the
+// configuration of a host that is already up to date could not be pruned.
+// As with Apply, the caller holds the state store's lock.
+func (e *Executor) SaveState(result *PlanResult) error {
+	if e.stateStore == nil {
+		return nil
+	}
+
+	values, err := e.Outputs()
+	if err != nil {
+		return err
+	}
+
+	st, err := e.stateStore.Load()
+	if err != nil {
+		return err
+	}
+	if err := e.recordOutputs(st, values); err != nil {
+		return err
+	}
+
+	final := make(map[string]*resource.State, len(result.Plans))
+	for id, plan := range result.Plans {
+		if plan.Action != resource.ActionSkip && !plan.HasChanges() {
+			final[id] = plan.Before
+		}
+	}
+	return e.saveState(st, result.Resources, result.Orphans, final)
+}
+
+// saveState records the final state of every resource that was applied or
+// already up to date. Resources that no longer exist on the host are
+// forgotten; skipped or failed resources keep their previous record.
+func (e *Executor) saveState(st *state.State, resources []resource.Resource, orphans map[string]bool, final map[string]*resource.State) error {
+	for _, r := range resources {
+		resourceID := resource.ID(r)
+		current, ok := final[resourceID]
+		if !ok {
+			continue
+		}
+		if current == nil || !current.Exists {
+			st.Remove(resourceID)
 			continue
 		}
+		st.Set(r.Type(), r.Name(), e.maskAttributes(resourceID, current.Attributes), r.Dependencies())
+	}
 
-		_, _ = fmt.Fprintf(e.out, "Applying %s...\n", resource.ID(r))
-		if err := r.Apply(ctx, plan, true); err != nil {
-			return fmt.Errorf("failed to apply %s: %w", resource.ID(r), err)
+	// Orphans of types that cannot be pruned have nothing left to manage
+	for id := range orphans {
+		if _, planned := final[id]; planned {
+			continue
+		}
+		if rs, ok := st.Get(id); ok && !resource.DefaultRegistry.IsPrunable(rs.Type) {
+			st.Remove(id)
 		}
-		_, _ = fmt.Fprintf(e.out, "  Done.\n")
 	}
 
+	if location, err := e.configLocation(); err == nil && location != "" {
+		st.Config = location
+	}
+
+	if err := e.stateStore.Save(st); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
 	return nil
 }
 
@@ -769,7 +1357,7 @@ func (e *Executor) Apply(ctx context.Context, result *PlanResult, dryRun bool) e
 func (e *Executor) Validate() error {
 	for _, r := range e.graph.All() {
 		if err := r.Validate(); err != nil {
-			return err
+			return e.redactError(err)
 		}
 	}
 	return e.graph.Validate()
@@ -783,6 +1371,56 @@ type PlanResult struct {
 	ToChange  int
 	ToDestroy int
 	ToSkip    int
+
+	// Orphans holds the IDs of resources recorded in the state that are no
+	// longer in the configuration. Prunable ones are planned for deletion.
+	Orphans map[string]bool
+
+	// Notifications maps notified resources to the resources that notify
+	// them and are planned to change
+	Notifications map[string][]string
+}
+
+// SkipOrphans turns the planned deletion of orphaned resources into skips,
+// for applies that should leave removed resources in place
+func (r *PlanResult) SkipOrphans(reason string) {
+	for id := range r.Orphans {
+		plan, ok := r.Plans[id]
+		if !ok || plan.Action != resource.ActionDelete {
+			continue
+		}
+		r.Plans[id] = &resource.Plan{
+			Action:     resource.ActionSkip,
+			Before:     plan.Before,
+			SkipReason: reason,
+		}
+		r.ToDestroy--
+		r.ToSkip++
+	}
+}
+
+// WriteJSON writes the plan for every resource as machine-readable JSON
+func (r *PlanResult) WriteJSON(w io.Writer) error {
+	return r.jsonPlan().WriteJSON(w)
+}
+
+// jsonPlan builds the JSON representation of the plan, including notifications
+func (r *PlanResult) jsonPlan() *diff.JSONPlan {
+	plan := diff.NewJSONPlan(r.Resources, r.Plans)
+	for _, res := range r.Resources {
+		n, ok := res.(resource.Notifiable)
+		notifiers := r.Notifications[resource.ID(res)]
+		if !ok || len(notifiers) == 0 {
+			continue
+		}
+		for i := range plan.Resources {
+			if plan.Resources[i].ID == resource.ID(res) {
+				plan.Resources[i].NotifyAction = n.NotifyAction()
+				plan.Resources[i].NotifiedBy = notifiers
+			}
+		}
+	}
+	return plan
 }
 
 // HasChanges returns true if there are any changes in the plan
@@ -790,6 +1428,18 @@ func (r *PlanResult) HasChanges() bool {
 	return r.ToAdd > 0 || r.ToChange > 0 || r.ToDestroy > 0
 }
 
+// configLocation returns where the configuration came from: its remote
+// source, or the absolute path of the loaded file or directory
+func (e *Executor) configLocation() (string, error) {
+	if e.configSource != "" {
+		return e.configSource, nil
+	}
+	if e.configPath == "" {
+		return "", nil
+	}
+	return filepath.Abs(e.configPath)
+}
+
 // FindConfigFile looks for configuration in the following order:
 // 1. Specified path (file or directory)
 // 2. Current directory (all *.hcl files)
@@ -3,12 +3,22 @@ package engine
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/diff"
+	"github.com/z0mbix/hostcfg/internal/resource"
 	"github.com/z0mbix/hostcfg/internal/role"
+	"github.com/z0mbix/hostcfg/internal/state"
 )
 
 func TestNewExecutor(t *testing.T) {
@@ -1058,6 +1068,109 @@ resource "exec" "finalize" {
 	}
 }
 
+func TestExecutor_Count(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+
+	content := `
+variable "enabled" {
+  default = false
+}
+
+resource "file" "worker" {
+  count   = 3
+  path    = "` + tmpDir + `/worker-${count.index}.conf"
+  content = "worker ${count.index}"
+  when    = [count.index != 1]
+}
+
+resource "file" "optional" {
+  count   = var.enabled ? 1 : 0
+  path    = "` + tmpDir + `/optional"
+  content = "optional"
+}
+
+resource "exec" "finalize" {
+  command    = "true"
+  depends_on = ["file.worker", "file.optional"]
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// 3 workers, no optional file and the exec
+	if all := e.graph.All(); len(all) != 4 {
+		t.Errorf("expected 4 resources, got %d", len(all))
+	}
+	finalize, ok := e.graph.Get("exec.finalize")
+	if !ok {
+		t.Fatal("expected exec.finalize")
+	}
+	if got := strings.Join(finalize.Dependencies(), ","); got != "file.worker[0],file.worker[1],file.worker[2]" {
+		t.Errorf("unexpected dependencies: %s", got)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if plan := result.Plans["file.worker[1]"]; plan.Action != resource.ActionSkip {
+		t.Errorf("expected file.worker[1] to be skipped, got %s", plan.Action)
+	}
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v\n%s", err, buf.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "worker-2.conf"))
+	if err != nil || string(data) != "worker 2" {
+		t.Errorf("unexpected worker-2.conf %q: %v", data, err)
+	}
+}
+
+func TestExecutor_Count_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr string
+	}{
+		{"negative", `count = -1`, "count must be a whole number of zero or more, got -1"},
+		{"fractional", `count = 1.5`, "count must be a whole number of zero or more, got 1.5"},
+		{"not a number", `count = "many"`, "count must be a number"},
+		{"with for_each", "count = 1\n  for_each = toset([\"a\"])", "for_each and count cannot both be set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hclPath := filepath.Join(t.TempDir(), "test.hcl")
+			content := `
+resource "file" "test" {
+  ` + tt.args + `
+  path    = "/tmp/test"
+  content = "test"
+}
+`
+			if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+
+			var buf bytes.Buffer
+			e := NewExecutor(&buf, false)
+			err := e.LoadFile(hclPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
 func TestExecutor_expandForEachDependencies(t *testing.T) {
 	var buf bytes.Buffer
 	e := NewExecutor(&buf, false)
@@ -1497,3 +1610,1599 @@ resource "file" "configs" {
 		t.Errorf("expected 2 to skip (for_each with when=false), got %d", result.ToSkip)
 	}
 }
+
+func TestExecutor_Apply_ParallelFailureStopsDependents(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	brokenPath := filepath.Join(tmpDir, "missing", "broken.txt")
+	childPath := filepath.Join(tmpDir, "child.txt")
+	otherPath := filepath.Join(tmpDir, "other.txt")
+
+	content := `
+resource "file" "broken" {
+  path    = "` + brokenPath + `"
+  content = "cannot be written, parent directory is missing"
+}
+
+resource "file" "child" {
+  path       = "` + childPath + `"
+  content    = "child content"
+  depends_on = ["file.broken"]
+}
+
+resource "file" "other" {
+  path    = "` + otherPath + `"
+  content = "other content"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetParallelism(4)
+
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	err = e.Apply(ctx, result, false)
+	if err == nil {
+		t.Fatal("expected apply to fail")
+	}
+	if !strings.Contains(err.Error(), "file.broken") {
+		t.Errorf("expected error to mention file.broken, got: %v", err)
+	}
+
+	if _, err := os.Stat(childPath); !os.IsNotExist(err) {
+		t.Error("dependent resource should not have been applied")
+	}
+	if _, err := os.Stat(otherPath); err != nil {
+		t.Errorf("independent resource should have been applied: %v", err)
+	}
+
+	// Output is printed in plan order regardless of completion order
+	want := "Applying file.broken...\n" +
+		"Skipping file.child (dependency file.broken failed)\n" +
+		"Applying file.other...\n  Done.\n"
+	if buf.String() != want {
+		t.Errorf("unexpected output:\ngot:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestExecutor_Apply_SequentialStopsOnFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	brokenPath := filepath.Join(tmpDir, "missing", "broken.txt")
+	otherPath := filepath.Join(tmpDir, "other.txt")
+
+	content := `
+resource "file" "a_broken" {
+  path    = "` + brokenPath + `"
+  content = "cannot be written"
+}
+
+resource "file" "b_other" {
+  path    = "` + otherPath + `"
+  content = "other content"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if err := e.Apply(ctx, result, false); err == nil {
+		t.Fatal("expected apply to fail")
+	}
+	if _, err := os.Stat(otherPath); !os.IsNotExist(err) {
+		t.Error("sequential apply should stop at the first failure")
+	}
+}
+
+func TestExecutor_Apply_KeepGoing(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	brokenPath := filepath.Join(tmpDir, "missing", "broken.txt")
+	childPath := filepath.Join(tmpDir, "child.txt")
+	grandchildPath := filepath.Join(tmpDir, "grandchild.txt")
+	otherPath := filepath.Join(tmpDir, "other.txt")
+
+	content := `
+resource "file" "a_broken" {
+  path    = "` + brokenPath + `"
+  content = "cannot be written"
+}
+
+resource "file" "b_child" {
+  path       = "` + childPath + `"
+  content    = "child content"
+  depends_on = ["file.a_broken"]
+}
+
+resource "file" "c_grandchild" {
+  path       = "` + grandchildPath + `"
+  content    = "grandchild content"
+  depends_on = ["file.b_child"]
+}
+
+resource "file" "d_other" {
+  path    = "` + otherPath + `"
+  content = "other content"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetKeepGoing(true)
+
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	err = e.Apply(ctx, result, false)
+	if err == nil || !strings.Contains(err.Error(), "file.a_broken") {
+		t.Fatalf("expected apply to fail on file.a_broken, got: %v", err)
+	}
+
+	for _, path := range []string{childPath, grandchildPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("dependent %s should not have been applied", path)
+		}
+	}
+	if _, err := os.Stat(otherPath); err != nil {
+		t.Errorf("independent resource should have been applied: %v", err)
+	}
+
+	output := buf.String()
+	for _, want := range []string{
+		"Skipping file.b_child (dependency file.a_broken failed)",
+		"Skipping file.c_grandchild (dependency file.b_child skipped)",
+		"Apply summary:",
+		"1 succeeded, 1 failed, 2 skipped.",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+
+	summary := output[strings.Index(output, "Apply summary:"):]
+	for _, row := range [][]string{
+		{"file.a_broken", "failed", "no such file or directory"},
+		{"file.b_child", "skipped", "dependency file.a_broken failed"},
+		{"file.d_other", "succeeded", "create"},
+	} {
+		found := false
+		for _, line := range strings.Split(summary, "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == row[0] {
+				found = strings.Contains(line, row[1]) && strings.Contains(line, row[2])
+			}
+		}
+		if !found {
+			t.Errorf("expected summary row for %s with %s and %q, got:\n%s", row[0], row[1], row[2], summary)
+		}
+	}
+}
+
+func TestExecutor_Apply_RecordsState(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	filePath := filepath.Join(tmpDir, "managed.txt")
+	statePath := filepath.Join(tmpDir, "state", "state.json")
+
+	content := `
+resource "file" "managed" {
+  path    = "` + filePath + `"
+  content = "managed content"
+  mode    = "0640"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetStateStore(state.NewStore(statePath))
+
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	st, err := state.NewStore(statePath).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+
+	rs, ok := st.Get("file.managed")
+	if !ok {
+		t.Fatalf("file.managed not recorded in state, got %v", st.IDs())
+	}
+	if rs.Type != "file" {
+		t.Errorf("expected type file, got %s", rs.Type)
+	}
+	if rs.Attributes["path"] != filePath {
+		t.Errorf("expected path %s, got %v", filePath, rs.Attributes["path"])
+	}
+	if rs.Attributes["mode"] != "0640" {
+		t.Errorf("expected mode 0640, got %v", rs.Attributes["mode"])
+	}
+	if st.Config != hclPath {
+		t.Errorf("expected config %s, got %s", hclPath, st.Config)
+	}
+}
+
+func TestExecutor_Apply_DryRunDoesNotWriteState(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	statePath := filepath.Join(tmpDir, "state.json")
+
+	content := `
+resource "file" "managed" {
+  path    = "` + filepath.Join(tmpDir, "managed.txt") + `"
+  content = "managed content"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetStateStore(state.NewStore(statePath))
+
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if err := e.Apply(ctx, result, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Error("dry run should not write a state file")
+	}
+}
+
+// applyConfig loads, plans and applies an HCL config with the given state store
+func applyConfig(t *testing.T, hclPath, content, statePath string, prune bool) *PlanResult {
+	t.Helper()
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetStateStore(state.NewStore(statePath))
+
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if !prune {
+		result.SkipOrphans("removed from configuration")
+	}
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	return result
+}
+
+func TestExecutor_Prune_Orphans(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	statePath := filepath.Join(tmpDir, "state.json")
+	keepPath := filepath.Join(tmpDir, "keep.txt")
+	orphanDir := filepath.Join(tmpDir, "old")
+	orphanPath := filepath.Join(orphanDir, "motd")
+
+	keep := `
+resource "file" "keep" {
+  path    = "` + keepPath + `"
+  content = "keep"
+}
+`
+	full := keep + `
+resource "directory" "old" {
+  path = "` + orphanDir + `"
+}
+
+resource "file" "old_motd" {
+  path    = "${directory.old.path}/motd"
+  content = "old motd"
+}
+`
+	applyConfig(t, hclPath, full, statePath, false)
+	if _, err := os.Stat(orphanPath); err != nil {
+		t.Fatalf("expected file to be created: %v", err)
+	}
+
+	// Remove the blocks: plan shows deletes, apply without prune leaves them
+	result := applyConfig(t, hclPath, keep, statePath, false)
+	if !result.Orphans["file.old_motd"] || !result.Orphans["directory.old"] {
+		t.Fatalf("expected orphans to be detected, got %v", result.Orphans)
+	}
+	if plan := result.Plans["file.old_motd"]; plan.Action != resource.ActionSkip {
+		t.Errorf("expected orphan to be skipped without prune, got %v", plan.Action)
+	}
+	if _, err := os.Stat(orphanPath); err != nil {
+		t.Fatalf("orphan should be left in place without prune: %v", err)
+	}
+
+	// Plan again without skipping: orphans are planned for deletion, file first
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetStateStore(state.NewStore(statePath))
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if result.ToDestroy != 2 {
+		t.Errorf("expected 2 to destroy, got %d", result.ToDestroy)
+	}
+	if plan := result.Plans["file.old_motd"]; plan.Action != resource.ActionDelete {
+		t.Errorf("expected ActionDelete for orphan, got %v", plan.Action)
+	}
+	var order []string
+	for _, r := range result.Resources {
+		order = append(order, resource.ID(r))
+	}
+	want := []string{"file.keep", "file.old_motd", "directory.old"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected order %v, want %v", order, want)
+	}
+
+	e.PrintPlan(result)
+	if !strings.Contains(buf.String(), "- file.old_motd") {
+		t.Errorf("expected plan output to show orphan deletion, got:\n%s", buf.String())
+	}
+
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if _, err := os.Stat(orphanDir); !os.IsNotExist(err) {
+		t.Error("expected orphaned directory to be pruned")
+	}
+
+	st, err := state.NewStore(statePath).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	ids := st.IDs()
+	if len(ids) != 1 || ids[0] != "file.keep" {
+		t.Errorf("expected only file.keep in state, got %v", ids)
+	}
+}
+
+func TestExecutor_Prune_NonEmptyDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	statePath := filepath.Join(tmpDir, "state.json")
+	orphanDir := filepath.Join(tmpDir, "old")
+	keepPath := filepath.Join(tmpDir, "keep.txt")
+
+	keep := `
+resource "file" "keep" {
+  path    = "` + keepPath + `"
+  content = "keep"
+}
+`
+	applyConfig(t, hclPath, keep+`
+resource "directory" "old" {
+  path = "`+orphanDir+`"
+}
+`, statePath, false)
+
+	// A file hostcfg doesn't manage keeps the directory from being removed
+	unmanaged := filepath.Join(orphanDir, "notes.txt")
+	if err := os.WriteFile(unmanaged, []byte("notes"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	result := applyConfig(t, hclPath, keep, statePath, true)
+	if plan := result.Plans["directory.old"]; plan.Action != resource.ActionDelete {
+		t.Fatalf("expected the orphaned directory to be planned for deletion, got %v", plan.Action)
+	}
+	if _, err := os.Stat(unmanaged); err != nil {
+		t.Errorf("expected the directory to be left in place: %v", err)
+	}
+
+	// It stays in state, so it is pruned once it is empty
+	st, err := state.NewStore(statePath).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if _, ok := st.Get("directory.old"); !ok {
+		t.Errorf("expected directory.old to stay in state, got %v", st.IDs())
+	}
+
+	if err := os.Remove(unmanaged); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	applyConfig(t, hclPath, keep, statePath, true)
+	if _, err := os.Stat(orphanDir); !os.IsNotExist(err) {
+		t.Error("expected the empty directory to be pruned")
+	}
+}
+
+func TestExecutor_Prune_FileLine(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	statePath := filepath.Join(tmpDir, "state.json")
+	envPath := filepath.Join(tmpDir, "app.env")
+	if err := os.WriteFile(envPath, []byte("a=1\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	applyConfig(t, hclPath, `
+resource "file_line" "env" {
+  path = "`+envPath+`"
+  line = "b=2"
+}
+`, statePath, false)
+
+	// Removing the block removes only the managed line
+	result := applyConfig(t, hclPath, "", statePath, true)
+	if plan := result.Plans["file_line.env"]; plan.Action != resource.ActionDelete {
+		t.Fatalf("expected the orphaned line to be planned for deletion, got %v", plan.Action)
+	}
+	if data, _ := os.ReadFile(envPath); string(data) != "a=1\n" {
+		t.Errorf("expected the line to be removed, got %q", data)
+	}
+
+	st, err := state.NewStore(statePath).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if ids := st.IDs(); len(ids) != 0 {
+		t.Errorf("expected the pruned line to be dropped from state, got %v", ids)
+	}
+}
+
+func TestExecutor_StateHasNoFileContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	statePath := filepath.Join(tmpDir, "state.json")
+	envPath := filepath.Join(tmpDir, "app.env")
+	if err := os.WriteFile(envPath, []byte("a=1\nSECRET_TOKEN=abc123\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	content := `
+resource "file" "key" {
+  path    = "` + filepath.Join(tmpDir, "key.pem") + `"
+  content = "PRIVATE KEY xyz789\n"
+}
+
+resource "file_line" "env" {
+  path = "` + envPath + `"
+  line = "b=2"
+}
+`
+	result := applyConfig(t, hclPath, content, statePath, false)
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("failed to read state: %v", err)
+	}
+	for _, secret := range []string{"xyz789", "abc123"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected state to hold no file content, found %q in:\n%s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), "content_hash") {
+		t.Errorf("expected state to record content hashes, got:\n%s", data)
+	}
+
+	// Saved plans record the states by hash too
+	e := NewExecutor(&bytes.Buffer{}, false)
+	for id, plan := range result.Plans {
+		for _, st := range []*resource.State{e.maskState(id, plan.Before), e.maskState(id, plan.After)} {
+			if st == nil {
+				continue
+			}
+			if _, ok := st.Attributes["content"]; ok {
+				t.Errorf("expected %s to be saved without content, got %v", id, st.Attributes)
+			}
+		}
+	}
+}
+
+func TestPlanResult_WriteJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	dirPath := filepath.Join(tmpDir, "app")
+
+	content := `
+resource "directory" "app" {
+  path = "` + dirPath + `"
+}
+
+resource "file" "config" {
+  description = "App config"
+  path        = "${directory.app.path}/app.conf"
+  content     = "key=value"
+}
+
+resource "file" "skipped" {
+  path    = "` + filepath.Join(tmpDir, "skipped") + `"
+  content = "never"
+  when    = false
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	result, err := e.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := result.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+
+	var plan diff.JSONPlan
+	if err := json.Unmarshal(out.Bytes(), &plan); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+
+	if plan.FormatVersion != diff.JSONFormatVersion {
+		t.Errorf("unexpected format version %d", plan.FormatVersion)
+	}
+	if len(plan.Resources) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(plan.Resources))
+	}
+	if plan.Summary.Add != 2 || plan.Summary.Skip != 1 || !plan.Summary.HasChanges {
+		t.Errorf("unexpected summary: %+v", plan.Summary)
+	}
+
+	byID := make(map[string]diff.JSONResource)
+	for _, r := range plan.Resources {
+		byID[r.ID] = r
+	}
+
+	cfg := byID["file.config"]
+	if cfg.Action != "create" {
+		t.Errorf("expected create, got %q", cfg.Action)
+	}
+	if cfg.Description != "App config" {
+		t.Errorf("unexpected description %q", cfg.Description)
+	}
+	if len(cfg.DependsOn) != 1 || cfg.DependsOn[0] != "directory.app" {
+		t.Errorf("unexpected dependencies %v", cfg.DependsOn)
+	}
+	var found bool
+	for _, c := range cfg.Changes {
+		if c.Attribute == "path" {
+			found = true
+			if c.Old != nil || c.New != filepath.Join(dirPath, "app.conf") {
+				t.Errorf("unexpected path change %v => %v", c.Old, c.New)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected path change, got %+v", cfg.Changes)
+	}
+
+	skipped := byID["file.skipped"]
+	if skipped.Action != "skip" || skipped.SkipReason == "" {
+		t.Errorf("expected skip with reason, got %+v", skipped)
+	}
+}
+
+// notifiedResource is a test resource that counts how often it is notified
+type notifiedResource struct {
+	name string
+	deps []string
+}
+
+var (
+	registerNotifiedOnce sync.Once
+	notifyCounts         = make(map[string]int)
+	notifyCountsMu       sync.Mutex
+)
+
+func registerNotifiedResource() {
+	registerNotifiedOnce.Do(func() {
+		resource.Register("test_notified", func(name string, body hcl.Body, dependsOn []string, description string, ctx *hcl.EvalContext) (resource.Resource, error) {
+			return &notifiedResource{name: name, deps: dependsOn}, nil
+		})
+	})
+}
+
+func (r *notifiedResource) Type() string        { return "test_notified" }
+func (r *notifiedResource) Name() string        { return r.name }
+func (r *notifiedResource) Description() string { return "" }
+func (r *notifiedResource) Read(ctx context.Context) (*resource.State, error) {
+	return &resource.State{Exists: true, Attributes: map[string]interface{}{}}, nil
+}
+func (r *notifiedResource) Diff(ctx context.Context, current *resource.State) (*resource.Plan, error) {
+	return &resource.Plan{Action: resource.ActionNoop, Before: current, After: current}, nil
+}
+func (r *notifiedResource) Apply(ctx context.Context, plan *resource.Plan, apply bool) error {
+	return nil
+}
+func (r *notifiedResource) Validate() error        { return nil }
+func (r *notifiedResource) Dependencies() []string { return r.deps }
+func (r *notifiedResource) NotifyAction() string   { return "restart" }
+func (r *notifiedResource) Notify(ctx context.Context) error {
+	notifyCountsMu.Lock()
+	defer notifyCountsMu.Unlock()
+	notifyCounts[r.name]++
+	return nil
+}
+
+func TestExecutor_Notify(t *testing.T) {
+	registerNotifiedResource()
+
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	confPath := filepath.Join(tmpDir, "app.conf")
+	extraPath := filepath.Join(tmpDir, "extra.conf")
+
+	config := func(confContent string) string {
+		return `
+resource "file" "conf" {
+  path    = "` + confPath + `"
+  content = "` + confContent + `"
+}
+
+resource "file" "extra" {
+  path    = "` + extraPath + `"
+  content = "extra"
+  notify  = ["test_notified.app"]
+}
+
+resource "test_notified" "app" {
+  subscribe = ["file.conf"]
+}
+`
+	}
+
+	run := func(content string) (*PlanResult, string) {
+		t.Helper()
+		if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		var buf bytes.Buffer
+		e := NewExecutor(&buf, false)
+		if err := e.LoadFile(hclPath); err != nil {
+			t.Fatalf("LoadFile failed: %v", err)
+		}
+		ctx := context.Background()
+		result, err := e.Plan(ctx)
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		e.PrintPlan(result)
+		if err := e.Apply(ctx, result, false); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		return result, buf.String()
+	}
+
+	// Both notifiers change: a single notification, after both are applied
+	result, out := run(config("v1"))
+	if result.Plans["test_notified.app"] == nil {
+		t.Fatal("expected a plan for test_notified.app")
+	}
+	if got := result.Notifications["test_notified.app"]; strings.Join(got, ",") != "file.conf,file.extra" {
+		t.Errorf("unexpected notifications: %v", got)
+	}
+	if !strings.Contains(out, "! test_notified.app will restart (notified by file.conf, file.extra)") {
+		t.Errorf("expected plan to show notification, got:\n%s", out)
+	}
+	if !strings.Contains(out, "Running restart on test_notified.app (notified by file.conf, file.extra)...") {
+		t.Errorf("expected notify output, got:\n%s", out)
+	}
+	if strings.Index(out, "Applying file.extra") > strings.Index(out, "Running restart") {
+		t.Errorf("expected notification after all changes, got:\n%s", out)
+	}
+	if notifyCounts["app"] != 1 {
+		t.Errorf("expected 1 notification, got %d", notifyCounts["app"])
+	}
+
+	// Nothing changes: no notification
+	result, _ = run(config("v1"))
+	if len(result.Notifications) != 0 {
+		t.Errorf("expected no notifications, got %v", result.Notifications)
+	}
+	if notifyCounts["app"] != 1 {
+		t.Errorf("expected no further notification, got %d", notifyCounts["app"])
+	}
+
+	// Only the subscribed file changes
+	_, out = run(config("v2"))
+	if !strings.Contains(out, "(notified by file.conf)") {
+		t.Errorf("expected notification from file.conf, got:\n%s", out)
+	}
+	if notifyCounts["app"] != 2 {
+		t.Errorf("expected 2 notifications, got %d", notifyCounts["app"])
+	}
+}
+
+func TestExecutor_Notify_Invalid(t *testing.T) {
+	registerNotifiedResource()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "unknown resource",
+			content: `
+resource "directory" "conf" {
+  path   = "/tmp/hostcfg-notify-test"
+  notify = ["service.missing"]
+}
+`,
+			wantErr: "resource directory.conf notifies unknown resource: service.missing",
+		},
+		{
+			name: "not notifiable",
+			content: `
+resource "directory" "a" {
+  path   = "/tmp/hostcfg-notify-a"
+  notify = ["directory.b"]
+}
+
+resource "directory" "b" {
+  path = "/tmp/hostcfg-notify-b"
+}
+`,
+			wantErr: "directory resources cannot be notified",
+		},
+		{
+			name: "unknown subscription",
+			content: `
+resource "test_notified" "app" {
+  subscribe = ["file.missing"]
+}
+`,
+			wantErr: "depends on unknown resource: file.missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hclPath := filepath.Join(t.TempDir(), "test.hcl")
+			if err := os.WriteFile(hclPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+			var buf bytes.Buffer
+			e := NewExecutor(&buf, false)
+			err := e.LoadFile(hclPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestExecutor_Apply_RollbackOnFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	existingPath := filepath.Join(tmpDir, "existing.txt")
+	newPath := filepath.Join(tmpDir, "new.txt")
+	brokenPath := filepath.Join(tmpDir, "missing", "broken.txt")
+	statePath := filepath.Join(tmpDir, "state.json")
+
+	if err := os.WriteFile(existingPath, []byte("original"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	content := `
+resource "file" "existing" {
+  path    = "` + existingPath + `"
+  content = "changed"
+  mode    = "0644"
+}
+
+resource "file" "new" {
+  path       = "` + newPath + `"
+  content    = "new"
+  depends_on = ["file.existing"]
+}
+
+resource "exec" "hook" {
+  command    = "true"
+  depends_on = ["file.new"]
+}
+
+resource "file" "broken" {
+  path       = "` + brokenPath + `"
+  content    = "cannot be written, parent directory is missing"
+  depends_on = ["exec.hook"]
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetRollbackOnFailure(true)
+	e.SetStateStore(state.NewStore(statePath))
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	err = e.Apply(ctx, result, false)
+	if err == nil {
+		t.Fatal("expected apply to fail")
+	}
+	if !strings.Contains(err.Error(), "rollback incomplete: 1 resources could not be reverted") {
+		t.Errorf("expected incomplete rollback error, got: %v", err)
+	}
+
+	data, err := os.ReadFile(existingPath)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "original" {
+		t.Errorf("expected content to be restored, got %q", data)
+	}
+	info, _ := os.Stat(existingPath)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode to be restored to 0600, got %o", info.Mode().Perm())
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Error("expected created file to be removed")
+	}
+
+	out := buf.String()
+	want := "Rolling back 4 resources...\n" +
+		"  Reverted file.broken\n" +
+		"  Not reverted exec.hook (exec resources cannot be rolled back)\n" +
+		"  Reverted file.new\n" +
+		"  Reverted file.existing\n" +
+		"\nRollback complete: 3 reverted, 1 not reverted.\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("unexpected rollback report, got:\n%s", out)
+	}
+
+	// Reverted resources are recorded as they were before the apply
+	st, err := state.NewStore(statePath).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if _, ok := st.Get("file.new"); ok {
+		t.Error("expected file.new not to be recorded in state")
+	}
+	original := sha256.Sum256([]byte("original"))
+	if rs, ok := st.Get("file.existing"); !ok || rs.Attributes["content_hash"] != hex.EncodeToString(original[:]) {
+		t.Errorf("expected file.existing to be recorded with original content, got %+v", rs)
+	}
+}
+
+func TestExecutor_ResourceReferences(t *testing.T) {
+	tmpDir := t.TempDir()
+	downloaded := filepath.Join(tmpDir, "tool.tar.gz")
+	if err := os.WriteFile(downloaded, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		resources string
+		content   string
+		want      string
+		wantErr   string
+	}{
+		{
+			name: "cron schedule",
+			resources: `resource "cron" "backup" {
+  command  = "/usr/local/bin/backup"
+  schedule = "0 2 * * *"
+}`,
+			content: "${cron.backup.schedule}",
+			want:    "0 2 * * *",
+		},
+		{
+			name: "exec attribute not exported before",
+			resources: `resource "exec" "migrate" {
+  command = "true"
+  unless  = "test -f /tmp/migrated"
+}`,
+			content: "${exec.migrate.unless}",
+			want:    "test -f /tmp/migrated",
+		},
+		{
+			name: "unset optional attribute is null",
+			resources: `resource "directory" "app" {
+  path = "/opt/app"
+}`,
+			content: `${directory.app.mode == null ? "unset" : "set"}`,
+			want:    "unset",
+		},
+		{
+			name: "list attribute",
+			resources: `resource "group" "ops" {
+  name    = "ops"
+  members = ["alice", "bob"]
+}`,
+			content: `${join(",", group.ops.members)}`,
+			want:    "alice,bob",
+		},
+		{
+			name: "computed download checksum",
+			resources: `resource "download" "tool" {
+  url  = "https://example.com/tool.tar.gz"
+  dest = "` + downloaded + `"
+}`,
+			content: "${download.tool.sha256}",
+			want:    "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name: "unknown attribute",
+			resources: `resource "exec" "migrate" {
+  command = "true"
+}`,
+			content: "${exec.migrate.anything}",
+			wantErr: "Unsupported attribute",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			hclPath := filepath.Join(dir, "test.hcl")
+			outPath := filepath.Join(dir, "out.txt")
+			content := tt.resources + `
+
+resource "file" "out" {
+  path    = "` + outPath + `"
+  content = "` + tt.content + `"
+}
+`
+			if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+
+			e := NewExecutor(&bytes.Buffer{}, false)
+			err := e.LoadFile(hclPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFile failed: %v", err)
+			}
+
+			// Only file.out is applied, the referenced resources are not run
+			ctx := context.Background()
+			result, err := e.Plan(ctx)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			r, _ := e.graph.Get("file.out")
+			if err := r.Apply(ctx, result.Plans["file.out"], true); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			got, err := os.ReadFile(outPath)
+			if err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected content %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExecutor_KnownAfterApply(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	tokenPath := filepath.Join(tmpDir, "token.txt")
+	copyPath := filepath.Join(tmpDir, "copy.txt")
+	skippedPath := filepath.Join(tmpDir, "skipped.txt")
+
+	content := `
+resource "exec" "token" {
+  command = "printf secret-123"
+}
+
+resource "file" "token" {
+  path    = "` + tokenPath + `"
+  content = exec.token.stdout
+}
+
+# Deferred in turn, as file.token's attributes are not known until it is resolved
+resource "file" "copy" {
+  path    = "` + copyPath + `"
+  content = "copy of ${file.token.path}"
+}
+
+resource "file" "skipped" {
+  when    = [exec.token.stdout == "something else"]
+  path    = "` + skippedPath + `"
+  content = "skipped"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	for _, id := range []string{"file.token", "file.copy", "file.skipped"} {
+		if plan := result.Plans[id]; !plan.Deferred {
+			t.Errorf("expected %s to be deferred, got %+v", id, plan)
+		}
+	}
+
+	e.PrintPlan(result)
+	output := buf.String()
+	for _, want := range []string{"~ file.token (known after apply)", "~ content = (known after apply)", "~ when = (known after apply)"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected plan output to contain %q, got:\n%s", want, output)
+		}
+	}
+
+	buf.Reset()
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v\n%s", err, buf.String())
+	}
+
+	if data, err := os.ReadFile(tokenPath); err != nil || string(data) != "secret-123" {
+		t.Errorf("expected token file to contain the command output, got %q (%v)", data, err)
+	}
+	if data, err := os.ReadFile(copyPath); err != nil || string(data) != "copy of "+tokenPath {
+		t.Errorf("unexpected copy content %q (%v)", data, err)
+	}
+	if _, err := os.Stat(skippedPath); !os.IsNotExist(err) {
+		t.Errorf("expected file.skipped not to be created, got %v", err)
+	}
+	if plan := result.Plans["file.skipped"]; plan.Action != resource.ActionSkip {
+		t.Errorf("expected file.skipped to be skipped at apply, got %v", plan.Action)
+	}
+}
+
+func TestExecutor_Outputs(t *testing.T) {
+	tmpDir := t.TempDir()
+	roleDir := filepath.Join(tmpDir, "roles", "redis")
+	if err := os.MkdirAll(roleDir, 0755); err != nil {
+		t.Fatalf("failed to create role dir: %v", err)
+	}
+	redisPath := filepath.Join(tmpDir, "redis.conf")
+	statePath := filepath.Join(tmpDir, "state.json")
+
+	roleHCL := `
+resource "file" "config" {
+  path    = "` + redisPath + `"
+  content = "port ${var.port}"
+}
+
+output "config_path" {
+  value = file.redis_config.path
+}
+
+output "port" {
+  value = var.port
+}
+`
+	if err := os.WriteFile(filepath.Join(roleDir, "resources.hcl"), []byte(roleHCL), 0644); err != nil {
+		t.Fatalf("failed to write role resources: %v", err)
+	}
+
+	mainHCL := `
+variable "name" {
+  default = "cache"
+}
+
+role "redis" {
+  source = "./roles/redis"
+
+  variables = {
+    port = 6380
+  }
+}
+
+resource "exec" "token" {
+  command = "printf secret-123"
+}
+
+resource "file" "motd" {
+  path    = "` + filepath.Join(tmpDir, "motd") + `"
+  content = "redis on port ${role.redis.port}"
+}
+
+output "summary" {
+  value       = "${var.name} on ${fact.os.name}: ${role.redis.config_path}"
+  description = "What this host runs"
+}
+
+output "token" {
+  value = exec.token.stdout
+}
+`
+	mainPath := filepath.Join(tmpDir, "main.hcl")
+	if err := os.WriteFile(mainPath, []byte(mainHCL), 0644); err != nil {
+		t.Fatalf("failed to write main.hcl: %v", err)
+	}
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetStateStore(state.NewStore(statePath))
+	if err := e.LoadFile(mainPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// Role outputs make the main config depend on the role's resources
+	motd, ok := e.graph.Get("file.motd")
+	if !ok {
+		t.Fatal("expected file.motd")
+	}
+	deps := strings.Join(motd.Dependencies(), ",")
+	if !strings.Contains(deps, "file.redis_config") {
+		t.Errorf("expected file.motd to depend on file.redis_config, got %s", deps)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	values, err := e.Outputs()
+	if err != nil {
+		t.Fatalf("Outputs failed: %v", err)
+	}
+	if got := values["summary"].AsString(); !strings.HasPrefix(got, "cache on ") || !strings.HasSuffix(got, redisPath) {
+		t.Errorf("unexpected summary %q", got)
+	}
+	if values["token"].IsKnown() {
+		t.Errorf("expected token to be known after apply, got %#v", values["token"])
+	}
+
+	buf.Reset()
+	e.PrintOutputs(values)
+	if !strings.Contains(buf.String(), "token = (known after apply)") {
+		t.Errorf("expected unknown token in outputs, got:\n%s", buf.String())
+	}
+
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v\n%s", err, buf.String())
+	}
+
+	st, err := state.NewStore(statePath).Load()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	recorded, err := StateOutputs(st)
+	if err != nil {
+		t.Fatalf("StateOutputs failed: %v", err)
+	}
+	if got := recorded["token"]; !got.IsKnown() || got.AsString() != "secret-123" {
+		t.Errorf("expected token secret-123 in state, got %#v", got)
+	}
+	if st.Outputs["summary"].Description != "What this host runs" {
+		t.Errorf("expected summary description in state, got %+v", st.Outputs["summary"])
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "motd"))
+	if err != nil || string(data) != "redis on port 6380" {
+		t.Errorf("unexpected motd %q: %v", data, err)
+	}
+}
+
+func TestExecutor_Outputs_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "duplicate",
+			content: `
+output "a" {
+  value = 1
+}
+
+output "a" {
+  value = 2
+}
+`,
+			wantErr: `duplicate output "a"`,
+		},
+		{
+			name: "unknown reference",
+			content: `
+output "a" {
+  value = var.missing
+}
+`,
+			wantErr: `failed to evaluate output "a"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hclPath := filepath.Join(t.TempDir(), "test.hcl")
+			if err := os.WriteFile(hclPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+
+			var buf bytes.Buffer
+			e := NewExecutor(&buf, false)
+			err := e.LoadFile(hclPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestExecutor_Locals(t *testing.T) {
+	tmpDir := t.TempDir()
+	roleDir := filepath.Join(tmpDir, "roles", "app")
+	if err := os.MkdirAll(roleDir, 0755); err != nil {
+		t.Fatalf("failed to create role dir: %v", err)
+	}
+
+	// The role has its own local named prefix
+	roleHCL := `
+locals {
+  prefix = "role-${var.name}"
+  unused = true
+}
+
+resource "file" "config" {
+  path    = "` + filepath.Join(tmpDir, "app.conf") + `"
+  content = local.prefix
+}
+
+output "prefix" {
+  value = local.prefix
+}
+`
+	if err := os.WriteFile(filepath.Join(roleDir, "resources.hcl"), []byte(roleHCL), 0644); err != nil {
+		t.Fatalf("failed to write role resources: %v", err)
+	}
+
+	mainHCL := `
+locals {
+  prefix  = "main"
+  dirs    = toset(["a", "b"])
+  base    = directory.base.path
+  message = "${local.prefix} ${role.app.prefix} in ${local.base}"
+}
+
+role "app" {
+  source = "./roles/app"
+
+  variables = {
+    name = "web"
+  }
+}
+
+resource "directory" "base" {
+  path = "` + tmpDir + `"
+}
+
+resource "file" "motd" {
+  path    = "${local.base}/motd"
+  content = local.message
+}
+
+resource "directory" "sub" {
+  for_each = local.dirs
+  path     = "${local.base}/${each.key}"
+}
+`
+	mainPath := filepath.Join(tmpDir, "main.hcl")
+	if err := os.WriteFile(mainPath, []byte(mainHCL), 0644); err != nil {
+		t.Fatalf("failed to write main.hcl: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	if err := e.LoadFile(mainPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// for_each over a local
+	if _, ok := e.graph.Get("directory.sub[\"b\"]"); !ok {
+		t.Error("expected directory.sub[\"b\"] from for_each over a local")
+	}
+
+	// References through locals are dependencies
+	motd, _ := e.graph.Get("file.motd")
+	deps := strings.Join(motd.Dependencies(), ",")
+	for _, want := range []string{"directory.base", "file.app_config"} {
+		if !strings.Contains(deps, want) {
+			t.Errorf("expected file.motd to depend on %s, got %s", want, deps)
+		}
+	}
+
+	warnings := strings.Join(e.Warnings(), "\n")
+	if !strings.Contains(warnings, "local.unused of role app is defined but never used") {
+		t.Errorf("expected unused local warning, got %q", warnings)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v\n%s", err, buf.String())
+	}
+
+	for path, want := range map[string]string{
+		filepath.Join(tmpDir, "app.conf"): "role-web",
+		filepath.Join(tmpDir, "motd"):     "main role-web in " + tmpDir,
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", path, want, data, err)
+		}
+	}
+}
+
+func TestExecutor_Locals_Undefined(t *testing.T) {
+	hclPath := filepath.Join(t.TempDir(), "test.hcl")
+	content := `
+locals {
+  name = "app"
+}
+
+resource "file" "test" {
+  path    = "/tmp/${local.nmae}"
+  content = "test"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	err := e.LoadFile(hclPath)
+	if err == nil || !strings.Contains(err.Error(), "reference to undefined local.nmae") {
+		t.Errorf("expected undefined local error, got: %v", err)
+	}
+}
+
+func TestExecutor_DynamicBlocks(t *testing.T) {
+	hclPath := filepath.Join(t.TempDir(), "test.hcl")
+	content := `
+resource "exec" "key" {
+  command = "printf 'ssh-ed25519 AAAA ci'"
+}
+
+resource "user" "deploy" {
+  name = "deploy"
+
+  dynamic "key" {
+    for_each = [exec.key.stdout]
+    content {
+      public_key = key.value
+    }
+  }
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// References in dynamic blocks are dependencies
+	user, _ := e.graph.Get("user.deploy")
+	if deps := user.Dependencies(); len(deps) != 1 || deps[0] != "exec.key" {
+		t.Errorf("expected user.deploy to depend on exec.key, got %v", deps)
+	}
+
+	result, err := e.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	plan := result.Plans["user.deploy"]
+	if !plan.Deferred || len(plan.Changes) != 1 || plan.Changes[0].Attribute != "key" {
+		t.Errorf("expected the key block to be known after apply, got %+v", plan)
+	}
+}
+
+func TestExecutor_Sensitive(t *testing.T) {
+	tmpDir := t.TempDir()
+	credsPath := filepath.Join(tmpDir, "creds")
+	tokenPath := filepath.Join(tmpDir, "token")
+	appPath := filepath.Join(tmpDir, "app.conf")
+	statePath := filepath.Join(tmpDir, "state.json")
+
+	content := `
+variable "password" {
+  sensitive = true
+}
+
+resource "file_line" "app_password" {
+  path  = "` + appPath + `"
+  line  = "password = ${var.password}"
+  match = "^password ="
+}
+
+resource "file" "creds" {
+  path    = "` + credsPath + `"
+  content = "password=${var.password}\n"
+  mode    = "0600"
+}
+
+resource "file" "token" {
+  path    = "` + tokenPath + `"
+  content = sensitive("token-abcdef")
+}
+
+output "password" {
+  value = var.password
+}
+
+output "password_set" {
+  value = nonsensitive(var.password != "")
+}
+`
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(credsPath, []byte("password=old-password\n"), 0600); err != nil {
+		t.Fatalf("failed to write creds: %v", err)
+	}
+	if err := os.WriteFile(appPath, []byte("user = app\npassword = old-app-password\n"), 0600); err != nil {
+		t.Fatalf("failed to write app config: %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	e.SetStateStore(state.NewStore(statePath))
+	e.SetVariable("password", "hunter2-secret")
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	secrets := []string{"hunter2-secret", "old-password", "old-app-password", "token-abcdef"}
+	checkHidden := func(what, text string) {
+		t.Helper()
+		for _, secret := range secrets {
+			if strings.Contains(text, secret) {
+				t.Errorf("%s contains %q:\n%s", what, secret, text)
+			}
+		}
+	}
+
+	e.PrintPlan(result)
+	checkHidden("plan", buf.String())
+	if !strings.Contains(buf.String(), "~ content = (sensitive value)") {
+		t.Errorf("expected sensitive content in plan, got:\n%s", buf.String())
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := result.WriteJSON(&jsonBuf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	checkHidden("JSON plan", jsonBuf.String())
+	if !strings.Contains(jsonBuf.String(), `"sensitive": true`) {
+		t.Errorf("expected sensitive changes in JSON plan, got:\n%s", jsonBuf.String())
+	}
+
+	if err := e.redactError(errors.New("failed to log in with hunter2-secret")); err.Error() != "failed to log in with (sensitive value)" {
+		t.Errorf("expected redacted error, got %q", err)
+	}
+
+	buf.Reset()
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	data, err := os.ReadFile(credsPath)
+	if err != nil || string(data) != "password=hunter2-secret\n" {
+		t.Errorf("unexpected creds %q: %v", data, err)
+	}
+	data, err = os.ReadFile(appPath)
+	if err != nil || string(data) != "user = app\npassword = hunter2-secret\n" {
+		t.Errorf("unexpected app config %q: %v", data, err)
+	}
+
+	values, err := e.Outputs()
+	if err != nil {
+		t.Fatalf("Outputs failed: %v", err)
+	}
+	e.PrintOutputs(values)
+	checkHidden("apply output", buf.String())
+	for _, want := range []string{"password = (sensitive value)", "password_set = true"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in outputs, got:\n%s", want, buf.String())
+		}
+	}
+
+	stateData, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("failed to read state: %v", err)
+	}
+	checkHidden("state", string(stateData))
+}
@@ -213,3 +213,67 @@ func (g *Graph) Validate() error {
 	}
 	return nil
 }
+
+// WalkFunc is called by Walk for each resource once all of its dependencies
+// have been visited
+type WalkFunc func(r resource.Resource)
+
+// Walk visits the given resources in dependency order, running up to
+// parallelism visits concurrently. A resource is only visited after fn has
+// returned for every dependency in the list. Resources that become ready at
+// the same time are started in the order they appear in the list, so a list
+// from TopologicalSort with parallelism 1 is visited in exactly that order.
+func (g *Graph) Walk(resources []resource.Resource, parallelism int, fn WalkFunc) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	index := make(map[string]int, len(resources))
+	for i, r := range resources {
+		index[resource.ID(r)] = i
+	}
+
+	// pending counts unvisited dependencies, dependents is the reverse edge list
+	pending := make([]int, len(resources))
+	dependents := make([][]int, len(resources))
+	for i, r := range resources {
+		for _, dep := range r.Dependencies() {
+			if j, ok := index[dep]; ok {
+				pending[i]++
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+	}
+
+	var ready []int
+	for i := range resources {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	done := make(chan int)
+	running := 0
+	for len(ready) > 0 || running > 0 {
+		for running < parallelism && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func(i int) {
+				fn(resources[i])
+				done <- i
+			}(i)
+		}
+
+		i := <-done
+		running--
+		for _, j := range dependents[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+		// Keep list order among ready resources for deterministic scheduling
+		sort.Ints(ready)
+	}
+}
@@ -2,7 +2,9 @@ package engine
 
 import (
 	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/z0mbix/hostcfg/internal/resource"
 )
@@ -243,3 +245,75 @@ func TestGraph_Deterministic(t *testing.T) {
 		}
 	}
 }
+
+func TestGraph_Walk_DependencyOrder(t *testing.T) {
+	g := NewGraph()
+	g.Add(newMockResource("file", "c", []string{"file.b"}))
+	g.Add(newMockResource("file", "b", []string{"file.a"}))
+	g.Add(newMockResource("file", "a", nil))
+
+	sorted, err := g.TopologicalSort()
+	if err != nil {
+		t.Fatalf("TopologicalSort failed: %v", err)
+	}
+
+	for _, parallelism := range []int{1, 4} {
+		var mu sync.Mutex
+		var visited []string
+		g.Walk(sorted, parallelism, func(r resource.Resource) {
+			mu.Lock()
+			defer mu.Unlock()
+			visited = append(visited, resource.ID(r))
+		})
+
+		want := []string{"file.a", "file.b", "file.c"}
+		if len(visited) != len(want) {
+			t.Fatalf("parallelism %d: visited %v, want %v", parallelism, visited, want)
+		}
+		for i := range want {
+			if visited[i] != want[i] {
+				t.Errorf("parallelism %d: visited[%d] = %s, want %s", parallelism, i, visited[i], want[i])
+			}
+		}
+	}
+}
+
+func TestGraph_Walk_Parallelism(t *testing.T) {
+	g := NewGraph()
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		g.Add(newMockResource("file", name, nil))
+	}
+
+	sorted, err := g.TopologicalSort()
+	if err != nil {
+		t.Fatalf("TopologicalSort failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	running, maxRunning, visited := 0, 0, 0
+	g.Walk(sorted, 3, func(r resource.Resource) {
+		mu.Lock()
+		running++
+		visited++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	if visited != 6 {
+		t.Errorf("expected 6 resources visited, got %d", visited)
+	}
+	if maxRunning > 3 {
+		t.Errorf("expected at most 3 concurrent visits, got %d", maxRunning)
+	}
+	if maxRunning < 2 {
+		t.Errorf("expected independent resources to run concurrently, max was %d", maxRunning)
+	}
+}
@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/role"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// localScope holds the locals of the main config or of a role. Each scope
+// only sees its own locals.
+type localScope struct {
+	dir   string     // role directory, empty for the main config
+	role  *role.Role // nil for the main config
+	attrs hcl.Attributes
+	order []string // names in evaluation order
+}
+
+// describe names the scope in messages
+func (s *localScope) describe() string {
+	if s.role == nil {
+		return ""
+	}
+	return " of role " + s.role.Name
+}
+
+// loadLocals checks the locals of the main config and of every role for
+// duplicates, cycles and references to undefined locals. Roles come first,
+// as role outputs can reference role locals and main locals role outputs.
+func (e *Executor) loadLocals(cfg *config.Config) error {
+	seen := make(map[string]bool)
+	for _, name := range e.roleOrder {
+		r := e.roles[name]
+		if seen[r.BaseDir] {
+			continue // Roles from the same directory share their locals
+		}
+		seen[r.BaseDir] = true
+		if err := e.addLocalScope(r.BaseDir, r, r.Locals); err != nil {
+			return err
+		}
+	}
+	return e.addLocalScope("", nil, cfg.Locals)
+}
+
+func (e *Executor) addLocalScope(dir string, r *role.Role, blocks []*config.Locals) error {
+	scope := &localScope{dir: dir, role: r}
+	attrs, diags := config.LocalAttributes(blocks)
+	if !diags.HasErrors() {
+		scope.order, diags = config.SortLocals(attrs)
+	}
+	if diags.HasErrors() {
+		return fmt.Errorf("invalid locals%s: %s", scope.describe(), diags.Error())
+	}
+	scope.attrs = attrs
+	e.localScopes = append(e.localScopes, scope)
+	return nil
+}
+
+// refreshValues evaluates the locals and role outputs, in the order they can
+// reference each other. Until final is set, values that cannot be evaluated
+// yet, such as those referencing resources that have not been exported, are
+// left unknown and true is returned.
+func (e *Executor) refreshValues(final bool) (bool, error) {
+	pending := false
+	for _, scope := range e.localScopes {
+		if scope.role == nil {
+			// The main config's locals can reference role outputs
+			unresolved, err := e.refreshRoleOutputs(final)
+			if err != nil {
+				return false, err
+			}
+			pending = pending || unresolved
+		}
+		unresolved, err := e.refreshLocals(scope, final)
+		if err != nil {
+			return false, err
+		}
+		pending = pending || unresolved
+	}
+	return pending, nil
+}
+
+// refreshLocals evaluates the locals of a scope in dependency order
+func (e *Executor) refreshLocals(scope *localScope, final bool) (bool, error) {
+	if len(scope.attrs) == 0 {
+		return false, nil
+	}
+
+	var ctx *hcl.EvalContext
+	if scope.role != nil {
+		ctx = e.roleEvalContext(scope.role)
+	} else {
+		ctx = e.parser.GetEvalContext()
+	}
+
+	pending := false
+	values := make(map[string]cty.Value, len(scope.order))
+	for _, name := range scope.order {
+		ctx.Variables["local"] = cty.ObjectVal(values)
+		val, diags := scope.attrs[name].Expr.Value(ctx)
+		if diags.HasErrors() {
+			if final {
+				return false, fmt.Errorf("failed to evaluate local.%s%s: %s", name, scope.describe(), diags.Error())
+			}
+			val = cty.DynamicVal
+			pending = true
+		}
+		values[name] = val
+	}
+	e.parser.SetLocals(scope.dir, values)
+	return pending, nil
+}
+
+// localScope returns the scope of a role directory, or of the main config
+func (e *Executor) localScope(dir string) *localScope {
+	for _, scope := range e.localScopes {
+		if scope.dir == dir {
+			return scope
+		}
+	}
+	return nil
+}
+
+// localDependencies adds the resources and roles a local references,
+// directly or through other locals, to deps
+func (e *Executor) localDependencies(dir, name string, deps map[string]bool) {
+	scope := e.localScope(dir)
+	if scope == nil {
+		return
+	}
+	attr, ok := scope.attrs[name]
+	if !ok {
+		return
+	}
+	for _, traversal := range attr.Expr.Variables() {
+		addReference(traversal, deps)
+	}
+	for _, ref := range config.LocalReferences(attr.Expr) {
+		e.localDependencies(dir, ref, deps)
+	}
+}
+
+// checkLocalReferences reports references to undefined locals, and records
+// a warning for each local that is never used
+func (e *Executor) checkLocalReferences(cfg *config.Config) error {
+	used := make(map[string]map[string]bool) // scope dir -> used local names
+	var errs []string
+
+	check := func(dir string, expr hcl.Expression) {
+		scope := e.localScope(dir)
+		for _, name := range config.LocalReferences(expr) {
+			if scope == nil {
+				continue
+			}
+			if _, ok := scope.attrs[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: reference to undefined local.%s%s",
+					expr.Range(), name, scope.describe()))
+				continue
+			}
+			if used[dir] == nil {
+				used[dir] = make(map[string]bool)
+			}
+			used[dir][name] = true
+		}
+	}
+
+	for _, block := range cfg.Resources {
+		for _, expr := range blockExpressions(block) {
+			check(block.RoleBaseDir, expr)
+		}
+	}
+	for _, o := range cfg.Outputs {
+		check("", o.Value)
+	}
+	for _, name := range e.roleOrder {
+		r := e.roles[name]
+		for _, o := range r.Outputs {
+			check(r.BaseDir, o.Value)
+		}
+	}
+	for _, scope := range e.localScopes {
+		for _, attr := range scope.attrs {
+			check(scope.dir, attr.Expr)
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", errs[0])
+	}
+
+	for _, scope := range e.localScopes {
+		for _, name := range scope.order {
+			if !used[scope.dir][name] {
+				e.warnings = append(e.warnings, fmt.Sprintf("local.%s%s is defined but never used", name, scope.describe()))
+			}
+		}
+	}
+	return nil
+}
+
+// Warnings returns problems found while loading the configuration that do
+// not stop it from being applied
+func (e *Executor) Warnings() []string {
+	return e.warnings
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/z0mbix/hostcfg/internal/resource"
+)
+
+// orderedOutput groups output per resource and writes it in list order.
+// The first unfinished resource in the list writes straight through, so a
+// sequential run streams its output exactly as before; output from resources
+// running ahead of it is buffered until they become first in line.
+type orderedOutput struct {
+	mu      sync.Mutex
+	out     io.Writer
+	order   []string
+	buffers map[string]*bytes.Buffer
+	done    map[string]bool
+	next    int
+}
+
+// newOrderedOutput creates an ordered writer for the given resources
+func newOrderedOutput(out io.Writer, resources []resource.Resource) *orderedOutput {
+	o := &orderedOutput{
+		out:     out,
+		order:   make([]string, 0, len(resources)),
+		buffers: make(map[string]*bytes.Buffer, len(resources)),
+		done:    make(map[string]bool, len(resources)),
+	}
+	for _, r := range resources {
+		id := resource.ID(r)
+		o.order = append(o.order, id)
+		o.buffers[id] = &bytes.Buffer{}
+	}
+	return o
+}
+
+// Writer returns the writer to use for a resource's output
+func (o *orderedOutput) Writer(id string) io.Writer {
+	return &resourceWriter{output: o, id: id}
+}
+
+// Finish marks a resource as complete and flushes any buffered output that
+// is now first in line
+func (o *orderedOutput) Finish(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.done[id] = true
+	for o.next < len(o.order) && o.done[o.order[o.next]] {
+		o.next++
+		if o.next < len(o.order) {
+			head := o.buffers[o.order[o.next]]
+			_, _ = o.out.Write(head.Bytes())
+			head.Reset()
+		}
+	}
+}
+
+// resourceWriter writes output for a single resource
+type resourceWriter struct {
+	output *orderedOutput
+	id     string
+}
+
+func (w *resourceWriter) Write(p []byte) (int, error) {
+	o := w.output
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.next < len(o.order) && o.order[o.next] == w.id {
+		return o.out.Write(p)
+	}
+	return o.buffers[w.id].Write(p)
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/z0mbix/hostcfg/internal/resource"
+)
+
+func TestOrderedOutput_BuffersUntilFirstInLine(t *testing.T) {
+	resources := []resource.Resource{
+		newMockResource("file", "a", nil),
+		newMockResource("file", "b", nil),
+		newMockResource("file", "c", nil),
+	}
+
+	var buf bytes.Buffer
+	o := newOrderedOutput(&buf, resources)
+
+	// c and b finish before a; their output must wait
+	_, _ = fmt.Fprint(o.Writer("file.c"), "c1\n")
+	o.Finish("file.c")
+	_, _ = fmt.Fprint(o.Writer("file.b"), "b1\n")
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output before file.a, got %q", buf.String())
+	}
+
+	// a is first in line, so it writes straight through
+	_, _ = fmt.Fprint(o.Writer("file.a"), "a1\n")
+	if buf.String() != "a1\n" {
+		t.Fatalf("expected a1 to be written immediately, got %q", buf.String())
+	}
+
+	o.Finish("file.a")
+	_, _ = fmt.Fprint(o.Writer("file.b"), "b2\n")
+	o.Finish("file.b")
+
+	want := "a1\nb1\nb2\nc1\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/role"
+	"github.com/z0mbix/hostcfg/internal/state"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Outputs evaluates the output blocks of the configuration. Values that
+// depend on resources that have not been applied yet are unknown.
+func (e *Executor) Outputs() (map[string]cty.Value, error) {
+	e.evalMu.Lock()
+	defer e.evalMu.Unlock()
+
+	values := make(map[string]cty.Value, len(e.outputs))
+	ctx := e.parser.GetEvalContext()
+	for _, o := range e.outputs {
+		val, diags := o.Value.Value(ctx)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("failed to evaluate output %q: %s", o.Name, diags.Error())
+		}
+		values[o.Name] = val
+	}
+	return values, nil
+}
+
+// refreshRoleOutputs evaluates the outputs of every role, in the order the
+// roles were loaded, so the configuration can reference them as
+// role.<name>.<output>. Until final is set, outputs that cannot be evaluated
+// yet are left unknown and true is returned.
+func (e *Executor) refreshRoleOutputs(final bool) (bool, error) {
+	pending := false
+	for _, name := range e.roleOrder {
+		r := e.roles[name]
+		if len(r.Outputs) == 0 {
+			continue
+		}
+
+		ctx := e.roleEvalContext(r)
+		outputs := make(map[string]cty.Value, len(r.Outputs))
+		for _, o := range r.Outputs {
+			val, diags := o.Value.Value(ctx)
+			if diags.HasErrors() {
+				if final {
+					return false, fmt.Errorf("failed to evaluate output %q of role %s: %s", o.Name, name, diags.Error())
+				}
+				val = cty.DynamicVal
+				pending = true
+			}
+			outputs[o.Name] = val
+		}
+		e.parser.SetRoleOutputs(name, outputs)
+	}
+	return pending, nil
+}
+
+// roleEvalContext returns the context role locals and outputs are evaluated
+// in, which sees the role's own variables, locals and files
+func (e *Executor) roleEvalContext(r *role.Role) *hcl.EvalContext {
+	e.parser.SetRoleContext(r.BaseDir)
+	ctx := e.parser.GetEvalContext()
+	e.parser.ClearRoleContext()
+
+	vars := ctx.Variables["var"].AsValueMap()
+	if vars == nil {
+		vars = make(map[string]cty.Value)
+	}
+	for k, v := range r.BuildVariableScope(e.cliVars) {
+		if e.parser.IsSensitive(k) {
+			v = v.Mark(config.SensitiveMark)
+		}
+		vars[k] = v
+	}
+	ctx.Variables["var"] = cty.ObjectVal(vars)
+	return ctx
+}
+
+// PrintOutputs prints output values in name order. Unknown values are shown
+// as known after apply.
+func (e *Executor) PrintOutputs(values map[string]cty.Value) {
+	if len(values) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(e.out, "\nOutputs:\n\n")
+	WriteOutputs(e.out, values)
+}
+
+// WriteOutputs writes output values as "name = value" lines in name order
+func WriteOutputs(w io.Writer, values map[string]cty.Value) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, _ = fmt.Fprintf(w, "%s = %s\n", name, FormatValue(values[name]))
+	}
+}
+
+// FormatValue formats a value in HCL syntax. Sensitive values are hidden.
+func FormatValue(val cty.Value) string {
+	if config.IsSensitiveValue(val) {
+		return config.SensitiveValue
+	}
+	if !val.IsWhollyKnown() {
+		return "(known after apply)"
+	}
+	src := hclwrite.Format(hclwrite.TokensForValue(val).Bytes())
+	return strings.TrimSpace(string(src))
+}
+
+// recordOutputs replaces the outputs recorded in the state with the known
+// output values. Only the type of sensitive values is recorded.
+func (e *Executor) recordOutputs(st *state.State, values map[string]cty.Value) error {
+	descriptions := make(map[string]string, len(e.outputs))
+	for _, o := range e.outputs {
+		descriptions[o.Name] = o.Description
+	}
+
+	st.Outputs = make(map[string]*state.Output, len(values))
+	for name, val := range values {
+		if !val.IsWhollyKnown() {
+			continue
+		}
+		ty, err := ctyjson.MarshalType(val.Type())
+		if err != nil {
+			return fmt.Errorf("failed to encode output %q: %w", name, err)
+		}
+		sensitive := config.IsSensitiveValue(val)
+		encoded := json.RawMessage("null")
+		if !sensitive {
+			encoded, err = ctyjson.Marshal(val, val.Type())
+			if err != nil {
+				return fmt.Errorf("failed to encode output %q: %w", name, err)
+			}
+		}
+		st.Outputs[name] = &state.Output{
+			Value:       encoded,
+			Type:        ty,
+			Description: descriptions[name],
+			Sensitive:   sensitive,
+		}
+	}
+	return nil
+}
+
+// StateOutputs decodes the outputs recorded in a state. Sensitive outputs
+// are null values marked as sensitive.
+func StateOutputs(st *state.State) (map[string]cty.Value, error) {
+	values := make(map[string]cty.Value, len(st.Outputs))
+	for name, o := range st.Outputs {
+		var ty cty.Type
+		if err := json.Unmarshal(o.Type, &ty); err != nil {
+			return nil, fmt.Errorf("invalid type for output %q: %w", name, err)
+		}
+		if o.Sensitive {
+			values[name] = cty.NullVal(ty).Mark(config.SensitiveMark)
+			continue
+		}
+		val, err := ctyjson.Unmarshal(o.Value, ty)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for output %q: %w", name, err)
+		}
+		values[name] = val
+	}
+	return values, nil
+}
+
+// checkOutputs evaluates the outputs once the configuration is loaded, so
+// mistakes are reported by validate and plan
+func (e *Executor) checkOutputs(outputs []*config.Output) error {
+	seen := make(map[string]hcl.Range)
+	for _, o := range outputs {
+		if prev, ok := seen[o.Name]; ok {
+			return fmt.Errorf("duplicate output %q, already defined at %s", o.Name, prev)
+		}
+		seen[o.Name] = o.Value.Range()
+	}
+	e.outputs = outputs
+
+	_, err := e.Outputs()
+	return err
+}
@@ -0,0 +1,355 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/z0mbix/hostcfg/internal/diff"
+	"github.com/z0mbix/hostcfg/internal/resource"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// PlanFileVersion is the version of the saved plan file format
+const PlanFileVersion = 2
+
+// SavedPlan is a plan written by 'hostcfg plan --out' so it can be applied
+// later exactly as it was reviewed
+type SavedPlan struct {
+	FormatVersion int                        `json:"format_version"`
+	Version       string                     `json:"hostcfg_version"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	Config        string                     `json:"config"`
+	ConfigHash    string                     `json:"config_hash"`
+	Variables     map[string]savedVariable   `json:"variables"`
+	States        map[string]*resource.State `json:"states"`
+	Desired       map[string]*resource.State `json:"desired"` // planned states, which pin content such as file hashes
+	Plan          *diff.JSONPlan             `json:"plan"`
+	Prune         bool                       `json:"prune,omitempty"` // whether resources removed from the configuration are deleted
+}
+
+// savedVariable keeps the cty type alongside the value so it can be restored.
+// Sensitive values are not saved, only a hash to check the value given when
+// the plan is applied.
+type savedVariable struct {
+	Type      json.RawMessage `json:"type"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Sensitive bool            `json:"sensitive,omitempty"`
+	Salt      string          `json:"salt,omitempty"`
+	Hash      string          `json:"hash,omitempty"`
+}
+
+// SavePlan writes the plan, the variables it was made with and a hash of the
+// configuration to path. prune records whether the plan deletes resources
+// removed from the configuration, so applying it plans orphans the same way.
+func (e *Executor) SavePlan(path string, result *PlanResult, version string, prune bool) error {
+	hash, err := e.ConfigHash()
+	if err != nil {
+		return err
+	}
+
+	configPath, err := e.configLocation()
+	if err != nil {
+		return err
+	}
+
+	saved := &SavedPlan{
+		FormatVersion: PlanFileVersion,
+		Version:       version,
+		CreatedAt:     time.Now().UTC(),
+		Config:        configPath,
+		ConfigHash:    hash,
+		Variables:     make(map[string]savedVariable),
+		States:        make(map[string]*resource.State),
+		Desired:       make(map[string]*resource.State),
+		Plan:          result.jsonPlan(),
+		Prune:         prune,
+	}
+
+	for name, value := range e.cliVars {
+		ty, err := ctyjson.MarshalType(value.Type())
+		if err != nil {
+			return fmt.Errorf("failed to save variable %s: %w", name, err)
+		}
+		val, err := ctyjson.Marshal(value, value.Type())
+		if err != nil {
+			return fmt.Errorf("failed to save variable %s: %w", name, err)
+		}
+		if e.parser.IsSensitive(name) {
+			salt := make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				return fmt.Errorf("failed to save variable %s: %w", name, err)
+			}
+			saltHex := hex.EncodeToString(salt)
+			saved.Variables[name] = savedVariable{
+				Type:      ty,
+				Sensitive: true,
+				Salt:      saltHex,
+				Hash:      hashSecret(saltHex, val),
+			}
+			continue
+		}
+		saved.Variables[name] = savedVariable{Type: ty, Value: val}
+	}
+
+	for id, plan := range result.Plans {
+		if plan.Before != nil {
+			saved.States[id] = e.maskState(id, plan.Before)
+		}
+		if plan.After != nil {
+			saved.Desired[id] = e.maskState(id, plan.After)
+		}
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+
+	// The plan may contain file contents and variable values
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+	return nil
+}
+
+// ReadPlanFile reads a plan written by SavePlan
+func ReadPlanFile(path string) (*SavedPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %w", err)
+	}
+
+	var saved SavedPlan
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file %s: %w", path, err)
+	}
+	if saved.FormatVersion != PlanFileVersion || saved.Plan == nil {
+		return nil, fmt.Errorf("plan file %s has unsupported format version %d", path, saved.FormatVersion)
+	}
+
+	return &saved, nil
+}
+
+// VariableValues returns the variables the plan was created with, except
+// the sensitive ones, which must be given again
+func (p *SavedPlan) VariableValues() (map[string]cty.Value, error) {
+	values := make(map[string]cty.Value, len(p.Variables))
+	for name, v := range p.Variables {
+		if v.Sensitive {
+			continue
+		}
+		ty, err := ctyjson.UnmarshalType(v.Type)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type for variable %s in plan file: %w", name, err)
+		}
+		val, err := ctyjson.Unmarshal(v.Value, ty)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for variable %s in plan file: %w", name, err)
+		}
+		values[name] = val
+	}
+	return values, nil
+}
+
+// SensitiveVariables returns the names of the sensitive variables the plan
+// was created with, whose values are not saved
+func (p *SavedPlan) SensitiveVariables() []string {
+	var names []string
+	for name, v := range p.Variables {
+		if v.Sensitive {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VerifyPlan checks that a fresh plan of the loaded configuration matches a
+// saved plan: the configuration and sensitive variables are unchanged, every
+// resource reads back the same state and the same changes are planned, with
+// the same desired state, so content read from files outside the
+// configuration is also pinned
+func (e *Executor) VerifyPlan(saved *SavedPlan, result *PlanResult) error {
+	hash, err := e.ConfigHash()
+	if err != nil {
+		return err
+	}
+	if hash != saved.ConfigHash {
+		return errors.New("saved plan is stale: the configuration has changed since the plan was created")
+	}
+
+	for _, name := range saved.SensitiveVariables() {
+		value, ok := e.cliVars[name]
+		if !ok {
+			return fmt.Errorf("sensitive variable %s is not saved in the plan file, set it again with -e or --var-file", name)
+		}
+		v := saved.Variables[name]
+		val, err := ctyjson.Marshal(value, value.Type())
+		if err != nil || hashSecret(v.Salt, val) != v.Hash {
+			return fmt.Errorf("saved plan is stale: sensitive variable %s differs from the value the plan was created with", name)
+		}
+	}
+
+	savedResources := make(map[string]diff.JSONResource, len(saved.Plan.Resources))
+	for _, r := range saved.Plan.Resources {
+		savedResources[r.ID] = r
+	}
+
+	current := result.jsonPlan()
+	seen := make(map[string]bool, len(current.Resources))
+
+	var stale []string
+	for _, r := range current.Resources {
+		seen[r.ID] = true
+
+		old, ok := savedResources[r.ID]
+		if !ok {
+			stale = append(stale, r.ID+" (not in saved plan)")
+			continue
+		}
+
+		sameState, err := sameJSON(saved.States[r.ID], e.maskState(r.ID, result.Plans[r.ID].Before))
+		if err != nil {
+			return err
+		}
+		if !sameState {
+			stale = append(stale, r.ID+" (state changed)")
+			continue
+		}
+
+		samePlan, err := sameJSON(old, r)
+		if err != nil {
+			return err
+		}
+		if !samePlan {
+			stale = append(stale, r.ID+" (planned changes differ)")
+			continue
+		}
+
+		sameDesired, err := sameJSON(saved.Desired[r.ID], e.maskState(r.ID, result.Plans[r.ID].After))
+		if err != nil {
+			return err
+		}
+		if !sameDesired {
+			stale = append(stale, r.ID+" (desired content changed)")
+		}
+	}
+
+	for _, r := range saved.Plan.Resources {
+		if !seen[r.ID] {
+			stale = append(stale, r.ID+" (no longer planned)")
+		}
+	}
+
+	if len(stale) > 0 {
+		return fmt.Errorf("saved plan is stale, run plan again:\n  %s", strings.Join(stale, "\n  "))
+	}
+	return nil
+}
+
+// ConfigHash returns a SHA-256 hash of the loaded configuration files,
+// including the HCL files of any roles
+func (e *Executor) ConfigHash() (string, error) {
+	var files []string
+
+	info, err := os.Stat(e.configPath)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(e.configPath, "*.hcl"))
+		if err != nil {
+			return "", err
+		}
+		files = append(files, matches...)
+	} else {
+		files = append(files, e.configPath)
+	}
+
+	for _, r := range e.roles {
+		matches, err := filepath.Glob(filepath.Join(r.BaseDir, "*.hcl"))
+		if err != nil {
+			return "", err
+		}
+		files = append(files, matches...)
+	}
+
+	for i, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		files[i] = abs
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	var last string
+	for _, f := range files {
+		if f == last {
+			continue
+		}
+		last = f
+
+		fh, err := os.Open(f)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00", f)
+		_, err = io.Copy(h, fh)
+		_ = fh.Close()
+		if err != nil {
+			return "", err
+		}
+		_, _ = h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashSecret hashes a sensitive value with a salt, so a saved plan can check
+// the value it is applied with without recording it
+func hashSecret(salt string, val []byte) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte(salt))
+	_, _ = h.Write(val)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sameJSON compares two values by their JSON encoding, after round-tripping
+// both so that values decoded from a plan file compare equal to the originals
+func sameJSON(a, b interface{}) (bool, error) {
+	ca, err := canonicalJSON(a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := canonicalJSON(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ca, cb), nil
+}
+
+func canonicalJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func planConfig(t *testing.T, hclPath string, vars map[string]string) (*Executor, *PlanResult) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	e := NewExecutor(&buf, false)
+	for k, v := range vars {
+		e.SetVariable(k, v)
+	}
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	result, err := e.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	return e, result
+}
+
+func TestSavedPlan_RoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	planPath := filepath.Join(tmpDir, "host.plan")
+	filePath := filepath.Join(tmpDir, "motd")
+
+	content := `
+variable "msg" {
+  default = "hello"
+}
+
+resource "file" "motd" {
+  path    = "` + filePath + `"
+  content = var.msg
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filePath, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	e, result := planConfig(t, hclPath, map[string]string{"msg": "from cli"})
+	if err := e.SavePlan(planPath, result, "1.2.3", false); err != nil {
+		t.Fatalf("SavePlan failed: %v", err)
+	}
+
+	info, err := os.Stat(planPath)
+	if err != nil {
+		t.Fatalf("plan file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected plan file mode 0600, got %o", info.Mode().Perm())
+	}
+
+	saved, err := ReadPlanFile(planPath)
+	if err != nil {
+		t.Fatalf("ReadPlanFile failed: %v", err)
+	}
+	if saved.Version != "1.2.3" {
+		t.Errorf("unexpected version %q", saved.Version)
+	}
+	if saved.Config != hclPath {
+		t.Errorf("unexpected config path %q", saved.Config)
+	}
+
+	values, err := saved.VariableValues()
+	if err != nil {
+		t.Fatalf("VariableValues failed: %v", err)
+	}
+	if v, ok := values["msg"]; !ok || v.AsString() != "from cli" {
+		t.Errorf("expected msg variable to be saved, got %#v", values)
+	}
+
+	// A fresh plan with the saved variables matches
+	fresh, freshResult := planConfig(t, hclPath, map[string]string{"msg": "from cli"})
+	if err := fresh.VerifyPlan(saved, freshResult); err != nil {
+		t.Errorf("expected plan to verify, got: %v", err)
+	}
+
+	// Different variables plan different changes
+	other, otherResult := planConfig(t, hclPath, map[string]string{"msg": "other"})
+	err = other.VerifyPlan(saved, otherResult)
+	if err == nil || !strings.Contains(err.Error(), "file.motd (planned changes differ)") {
+		t.Errorf("expected planned changes to differ, got: %v", err)
+	}
+
+	// The file changing on the host makes the plan stale
+	if err := os.WriteFile(filePath, []byte("changed"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	drifted, driftedResult := planConfig(t, hclPath, map[string]string{"msg": "from cli"})
+	err = drifted.VerifyPlan(saved, driftedResult)
+	if err == nil || !strings.Contains(err.Error(), "file.motd (state changed)") {
+		t.Errorf("expected state change to be detected, got: %v", err)
+	}
+
+	// So does changing the configuration
+	if err := os.WriteFile(hclPath, []byte(content+"\n# edited\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	edited, editedResult := planConfig(t, hclPath, map[string]string{"msg": "from cli"})
+	err = edited.VerifyPlan(saved, editedResult)
+	if err == nil || !strings.Contains(err.Error(), "configuration has changed") {
+		t.Errorf("expected configuration change to be detected, got: %v", err)
+	}
+}
+
+func TestSavedPlan_PinsFileContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	planPath := filepath.Join(tmpDir, "host.plan")
+	srcPath := filepath.Join(tmpDir, "motd.txt")
+
+	// A new file's content is not one of its planned changes, so only the
+	// desired state pins it
+	content := `
+resource "file" "motd" {
+  path    = "` + filepath.Join(tmpDir, "motd") + `"
+  content = file("` + srcPath + `")
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(srcPath, []byte("v1"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	e, result := planConfig(t, hclPath, nil)
+	if err := e.SavePlan(planPath, result, "1.2.3", false); err != nil {
+		t.Fatalf("SavePlan failed: %v", err)
+	}
+	saved, err := ReadPlanFile(planPath)
+	if err != nil {
+		t.Fatalf("ReadPlanFile failed: %v", err)
+	}
+
+	if err := os.WriteFile(srcPath, []byte("v2"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	fresh, freshResult := planConfig(t, hclPath, nil)
+	err = fresh.VerifyPlan(saved, freshResult)
+	if err == nil || !strings.Contains(err.Error(), "file.motd (desired content changed)") {
+		t.Errorf("expected the content change to be detected, got: %v", err)
+	}
+}
+
+func TestReadPlanFile_Invalid(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"not json", "not a plan"},
+		{"unsupported version", `{"format_version": 99, "plan": {}}`},
+		{"missing plan", `{"format_version": 2}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, strings.ReplaceAll(tt.name, " ", "_"))
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("failed to write plan: %v", err)
+			}
+			if _, err := ReadPlanFile(path); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestSavedPlan_SensitiveVariables(t *testing.T) {
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	planPath := filepath.Join(tmpDir, "host.plan")
+	filePath := filepath.Join(tmpDir, "creds")
+
+	content := `
+variable "password" {
+  default   = "changeme"
+  sensitive = true
+}
+
+resource "file" "creds" {
+  path    = "` + filePath + `"
+  content = "password=${var.password}"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filePath, []byte("password=old-password"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	e, result := planConfig(t, hclPath, map[string]string{"password": "hunter2-secret"})
+	if err := e.SavePlan(planPath, result, "1.2.3", false); err != nil {
+		t.Fatalf("SavePlan failed: %v", err)
+	}
+
+	data, err := os.ReadFile(planPath)
+	if err != nil {
+		t.Fatalf("failed to read plan file: %v", err)
+	}
+	for _, secret := range []string{"hunter2-secret", "old-password"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("plan file contains %q:\n%s", secret, data)
+		}
+	}
+
+	saved, err := ReadPlanFile(planPath)
+	if err != nil {
+		t.Fatalf("ReadPlanFile failed: %v", err)
+	}
+	if got := saved.SensitiveVariables(); len(got) != 1 || got[0] != "password" {
+		t.Errorf("expected password to be sensitive, got %v", got)
+	}
+	values, err := saved.VariableValues()
+	if err != nil {
+		t.Fatalf("VariableValues failed: %v", err)
+	}
+	if _, ok := values["password"]; ok {
+		t.Error("expected the sensitive variable to be left out")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{name: "same value", password: "hunter2-secret"},
+		{name: "different value", password: "other-secret", wantErr: "sensitive variable password differs"},
+		{name: "missing", wantErr: "sensitive variable password is not saved in the plan file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{}
+			if tt.password != "" {
+				vars["password"] = tt.password
+			}
+			var buf bytes.Buffer
+			e := NewExecutor(&buf, false)
+			for k, v := range vars {
+				e.SetVariable(k, v)
+			}
+			if err := e.LoadFile(hclPath); err != nil {
+				t.Fatalf("LoadFile failed: %v", err)
+			}
+			result, err := e.Plan(context.Background())
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+
+			err = e.VerifyPlan(saved, result)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("VerifyPlan failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/resource"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// minSecretLength is the length below which sensitive strings are not
+// removed from messages, as they would hide unrelated text
+const minSecretLength = 4
+
+// recordSensitive records the attributes of a resource block that are set
+// from sensitive values, and the sensitive strings they contain. Nested
+// blocks are recorded by their type, such as "key".
+func (e *Executor) recordSensitive(block *config.ResourceBlock, ctx *hcl.EvalContext) {
+	var secrets []string
+	isSensitive := func(expr hcl.Expression) bool {
+		sensitive := false
+		if val, diags := expr.Value(ctx); !diags.HasErrors() && config.IsSensitiveValue(val) {
+			secrets = append(secrets, config.SensitiveStrings(val)...)
+			sensitive = true
+		}
+		// Expressions in dynamic blocks reference their iterator, so the
+		// values they reference are checked instead
+		for _, traversal := range expr.Variables() {
+			if val, diags := traversal.TraverseAbs(ctx); !diags.HasErrors() && config.IsSensitiveValue(val) {
+				secrets = append(secrets, config.SensitiveStrings(val)...)
+				sensitive = true
+			}
+		}
+		return sensitive
+	}
+
+	attrs := make(map[string]bool)
+	bodyAttrs, _ := block.Body.JustAttributes()
+	for name, attr := range bodyAttrs {
+		if isSensitive(attr.Expr) {
+			attrs[name] = true
+		}
+	}
+	for _, nested := range nestedBlocks(block.Body) {
+		for _, expr := range bodyExpressions(nested.Body) {
+			if isSensitive(expr) {
+				attrs[nestedBlockName(nested)] = true
+			}
+		}
+	}
+
+	// Sensitive variables are removed from messages even if no resource
+	// uses them directly
+	secrets = append(secrets, config.SensitiveStrings(ctx.Variables["var"])...)
+
+	e.sensitiveMu.Lock()
+	defer e.sensitiveMu.Unlock()
+	resourceID := block.Type + "." + block.Name
+	if len(attrs) > 0 {
+		e.sensitiveAttrs[resourceID] = attrs
+	} else {
+		delete(e.sensitiveAttrs, resourceID)
+	}
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength && !e.secrets[secret] {
+			e.secrets[secret] = true
+			e.secretList = append(e.secretList, secret)
+		}
+	}
+	// Longest first, so a secret containing another is removed whole
+	sort.Slice(e.secretList, func(i, j int) bool {
+		return len(e.secretList[i]) > len(e.secretList[j])
+	})
+}
+
+// sensitiveAttributes returns the attributes of a resource that are set from
+// sensitive values
+func (e *Executor) sensitiveAttributes(resourceID string) map[string]bool {
+	e.sensitiveMu.Lock()
+	defer e.sensitiveMu.Unlock()
+	return e.sensitiveAttrs[resourceID]
+}
+
+// planSensitive returns the attributes whose changes a plan hides: those set
+// from sensitive values, and those the resource works out from its
+// configuration when any of it is sensitive, such as the content a file_line
+// edits
+func (e *Executor) planSensitive(r resource.Resource, plan *resource.Plan) map[string]bool {
+	sensitive := e.sensitiveAttributes(resource.ID(r))
+	exporter, ok := r.(resource.Exporter)
+	if len(sensitive) == 0 || !ok {
+		return sensitive
+	}
+
+	schema := exporter.AttributeSchema()
+	result := make(map[string]bool, len(sensitive))
+	for name := range sensitive {
+		result[name] = true
+	}
+	for _, change := range plan.Changes {
+		if _, configured := schema[change.Attribute]; !configured {
+			result[change.Attribute] = true
+		}
+	}
+	return result
+}
+
+// markSensitive marks the exported attributes of a resource that are set
+// from sensitive values, so the resources referencing them are sensitive too
+func (e *Executor) markSensitive(resourceID string, attrs map[string]cty.Value) {
+	for name := range e.sensitiveAttributes(resourceID) {
+		if val, ok := attrs[name]; ok {
+			attrs[name] = val.Mark(config.SensitiveMark)
+		}
+	}
+}
+
+// redact replaces the sensitive strings in a message with (sensitive value)
+func (e *Executor) redact(s string) string {
+	e.sensitiveMu.Lock()
+	defer e.sensitiveMu.Unlock()
+	for _, secret := range e.secretList {
+		s = strings.ReplaceAll(s, secret, config.SensitiveValue)
+	}
+	return s
+}
+
+// redactError removes sensitive strings from an error's message
+func (e *Executor) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := e.redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// maskAttributes returns a copy of a resource's state attributes to write to
+// disk, with the attributes set from sensitive values replaced by
+// (sensitive value) and sensitive strings removed from the others. File
+// content is replaced by its hash, so files are not copied into the state.
+func (e *Executor) maskAttributes(resourceID string, attrs map[string]interface{}) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+	sensitive := e.sensitiveAttributes(resourceID)
+	masked := make(map[string]interface{}, len(attrs))
+	for name, val := range attrs {
+		if content, ok := val.(string); ok && name == "content" {
+			if _, hashed := attrs["content_hash"]; !hashed {
+				sum := sha256.Sum256([]byte(content))
+				masked["content_hash"] = hex.EncodeToString(sum[:])
+			}
+			continue
+		}
+		if sensitive[name] && val != nil {
+			masked[name] = config.SensitiveValue
+			continue
+		}
+		masked[name] = e.maskValue(val)
+	}
+	return masked
+}
+
+// maskState returns a copy of a state with its attributes masked, for saved
+// plans
+func (e *Executor) maskState(resourceID string, st *resource.State) *resource.State {
+	if st == nil {
+		return nil
+	}
+	return &resource.State{Exists: st.Exists, Attributes: e.maskAttributes(resourceID, st.Attributes)}
+}
+
+func (e *Executor) maskValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return e.redact(v)
+	case []string:
+		masked := make([]string, len(v))
+		for i, s := range v {
+			masked[i] = e.redact(s)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = e.maskValue(item)
+		}
+		return masked
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			masked[k] = e.maskValue(item)
+		}
+		return masked
+	default:
+		return val
+	}
+}
@@ -0,0 +1,199 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the version of the provider protocol spoken by hostcfg
+const ProtocolVersion = 1
+
+// closeTimeout is how long a provider has to exit after its input is closed
+const closeTimeout = 5 * time.Second
+
+// callTimeout is how long a provider has to answer a request when the
+// context has no deadline of its own
+const callTimeout = 10 * time.Minute
+
+// request is a JSON-RPC 2.0 request
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// response is a JSON-RPC 2.0 response
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Client talks to a provider process over its stdin and stdout, one
+// JSON-RPC message per line. Calls are serialized.
+type Client struct {
+	name string
+	path string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	nextID int
+}
+
+// Start starts the provider binary at path
+func Start(name, path string) (*Client, error) {
+	cmd := exec.Command(path)
+	// Provider logs go straight to the user
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start provider %s: %w", name, err)
+	}
+
+	return &Client{
+		name:   name,
+		path:   path,
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// Name returns the provider name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Call sends a request and decodes the result into result. A provider that
+// does not answer before ctx is done, or within callTimeout if ctx has no
+// deadline, is killed.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cmd == nil {
+		return fmt.Errorf("provider %s is not running", c.name)
+	}
+
+	c.nextID++
+	req := request{JSONRPC: "2.0", ID: c.nextID, Method: method, Params: params}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("provider %s: failed to encode %s request: %w", c.name, method, err)
+	}
+	line, err := c.exchange(ctx, method, data)
+	if err != nil {
+		return err
+	}
+
+	var resp response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("provider %s: invalid response to %s: %w", c.name, method, err)
+	}
+	if resp.ID != req.ID {
+		return fmt.Errorf("provider %s: response id %d does not match request id %d", c.name, resp.ID, req.ID)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s", resp.Error.Message)
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("provider %s: invalid %s result: %w", c.name, method, err)
+	}
+	return nil
+}
+
+// exchange sends a request and reads the response line, killing the provider
+// if ctx is done first, as its answer could no longer be told apart from the
+// answer to the next request
+func (c *Client) exchange(ctx context.Context, method string, data []byte) ([]byte, error) {
+	type reply struct {
+		line []byte
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		if _, err := c.stdin.Write(append(data, '\n')); err != nil {
+			replies <- reply{err: fmt.Errorf("provider %s: failed to send %s request: %w", c.name, method, err)}
+			return
+		}
+		line, err := c.stdout.ReadBytes('\n')
+		if err != nil {
+			err = fmt.Errorf("provider %s: no response to %s: %w", c.name, method, err)
+		}
+		replies <- reply{line: line, err: err}
+	}()
+
+	select {
+	case r := <-replies:
+		return r.line, r.err
+	case <-ctx.Done():
+		cmd := c.cmd
+		c.cmd = nil
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		<-replies
+		return nil, fmt.Errorf("provider %s: no response to %s: %w", c.name, method, ctx.Err())
+	}
+}
+
+// Close stops the provider by closing its input, killing it if it does not
+// exit in time
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cmd == nil {
+		return nil
+	}
+	cmd := c.cmd
+	c.cmd = nil
+
+	_ = c.stdin.Close()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("provider %s exited with error: %w", c.name, err)
+		}
+		return nil
+	case <-time.After(closeTimeout):
+		_ = cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("provider %s did not exit, killed it", c.name)
+	}
+}
@@ -0,0 +1,175 @@
+// Package provider runs external resource providers. A provider is an
+// executable named hostcfg-provider-<name> that implements one or more
+// resource types, talking JSON-RPC 2.0 over its stdin and stdout.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/z0mbix/hostcfg/internal/resource"
+)
+
+// BinaryPrefix is the file name prefix of provider executables
+const BinaryPrefix = "hostcfg-provider-"
+
+// SystemDir is the system-wide provider directory
+const SystemDir = "/usr/local/lib/hostcfg/providers"
+
+// DefaultDirs returns the directories searched for providers before $PATH:
+// the user's provider directory (for non-root users) and the system one
+func DefaultDirs() []string {
+	var dirs []string
+	if os.Geteuid() != 0 {
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			dirs = append(dirs, filepath.Join(dir, "hostcfg", "providers"))
+		} else if home, err := os.UserHomeDir(); err == nil {
+			dirs = append(dirs, filepath.Join(home, ".local", "share", "hostcfg", "providers"))
+		}
+	}
+	return append(dirs, SystemDir)
+}
+
+// Discover finds provider executables in dirs and then in $PATH, returning
+// provider names mapped to their paths. The first provider found with a
+// given name wins.
+func Discover(dirs []string) map[string]string {
+	found := make(map[string]string)
+
+	search := append([]string{}, dirs...)
+	search = append(search, filepath.SplitList(os.Getenv("PATH"))...)
+
+	for _, dir := range search {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), BinaryPrefix)
+			if !ok || name == "" {
+				continue
+			}
+			if _, seen := found[name]; seen {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			found[name] = path
+		}
+	}
+
+	return found
+}
+
+// Manager starts providers when their resource types are first used, and
+// holds the running providers and the resource types they registered
+type Manager struct {
+	registry  *resource.Registry
+	providers map[string]string // discovered providers that are not started
+
+	mu      sync.Mutex
+	clients []*Client
+	types   []string
+	errs    map[string]error // providers that failed to start
+}
+
+// Load discovers providers and sets up registry to start a provider the first
+// time one of its resource types is looked up. A resource type belongs to the
+// provider it is named after, so providers the configuration doesn't use are
+// never started.
+func Load(registry *resource.Registry, dirs []string) *Manager {
+	m := &Manager{
+		registry:  registry,
+		providers: Discover(dirs),
+		errs:      make(map[string]error),
+	}
+	registry.SetResolver(m.resolve)
+	return m
+}
+
+// resolve starts the providers that may implement resourceType: those named
+// after it or after a prefix of it ending before an underscore
+func (m *Manager) resolve(resourceType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, name := range sortedKeys(m.providers) {
+		if resourceType != name && !strings.HasPrefix(resourceType, name+"_") {
+			continue
+		}
+		if err, failed := m.errs[name]; failed {
+			return err
+		}
+		if err := m.start(name, m.providers[name]); err != nil {
+			m.errs[name] = err
+			return err
+		}
+		delete(m.providers, name)
+	}
+	return nil
+}
+
+// start starts a provider, checks its schema and registers its resource types
+func (m *Manager) start(name, path string) error {
+	client, err := Start(name, path)
+	if err != nil {
+		return err
+	}
+
+	var schema Schema
+	if err := client.Call(context.Background(), "schema", struct{}{}, &schema); err != nil {
+		_ = client.Close()
+		return fmt.Errorf("provider %s: failed to get schema: %w", name, err)
+	}
+	if err := schema.check(name); err != nil {
+		_ = client.Close()
+		return err
+	}
+	for _, typeName := range sortedKeys(schema.ResourceTypes) {
+		if m.registry.Registered(typeName) {
+			_ = client.Close()
+			return fmt.Errorf("provider %s: resource type %s is already defined", name, typeName)
+		}
+	}
+
+	m.clients = append(m.clients, client)
+	for _, typeName := range sortedKeys(schema.ResourceTypes) {
+		m.registry.Register(typeName, factory(client, typeName, schema.ResourceTypes[typeName]))
+		m.types = append(m.types, typeName)
+	}
+	return nil
+}
+
+// Close unregisters the providers' resource types and stops every provider
+// that was started
+func (m *Manager) Close() error {
+	m.registry.SetResolver(nil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, typeName := range m.types {
+		m.registry.Unregister(typeName)
+	}
+	m.types = nil
+
+	var errs []error
+	for _, c := range m.clients {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	m.clients = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,472 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z0mbix/hostcfg/internal/engine"
+	"github.com/z0mbix/hostcfg/internal/resource"
+)
+
+// The test binary doubles as a provider when HOSTCFG_TEST_PROVIDER is set
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("HOSTCFG_TEST_PROVIDER"); mode != "" {
+		serveTestProvider(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// writeProvider installs hostcfg-provider-<name> in dir, running this test
+// binary as a provider in the given mode
+func writeProvider(t *testing.T, dir, name, mode string) {
+	t.Helper()
+
+	script := fmt.Sprintf("#!/bin/sh\nHOSTCFG_TEST_PROVIDER=%s exec %q\n", mode, os.Args[0])
+	if err := os.WriteFile(filepath.Join(dir, BinaryPrefix+name), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write provider: %v", err)
+	}
+}
+
+// serveTestProvider implements an "acme" provider whose acme_license
+// resource manages a file's content
+func serveTestProvider(mode string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req struct {
+			ID     int             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(1)
+		}
+
+		var params struct {
+			Config struct {
+				Path    string `json:"path"`
+				Content string `json:"content"`
+			} `json:"config"`
+			Current *resource.State `json:"current"`
+		}
+		_ = json.Unmarshal(req.Params, &params)
+		cfg := params.Config
+
+		var result interface{} = struct{}{}
+		var rpcErr *rpcError
+
+		if mode == "hang" {
+			// Never answer
+			continue
+		}
+
+		switch req.Method {
+		case "schema":
+			typeName := "acme_license"
+			if mode == "misnamed" {
+				typeName = "license"
+			}
+			result = map[string]interface{}{
+				"protocol_version": ProtocolVersion,
+				"resource_types": map[string]interface{}{
+					typeName: map[string]interface{}{
+						"attributes": map[string]interface{}{
+							"path":    map[string]interface{}{"type": "string", "required": true},
+							"content": map[string]interface{}{"type": "string", "required": true},
+							"tags":    map[string]interface{}{"type": "map(string)"},
+							"serial":  map[string]interface{}{"type": "string", "computed": true},
+						},
+					},
+				},
+			}
+		case "validate":
+			if !filepath.IsAbs(cfg.Path) {
+				rpcErr = &rpcError{Code: 1, Message: "path must be absolute"}
+			}
+		case "read":
+			state := map[string]interface{}{"exists": false, "attributes": map[string]interface{}{}}
+			if data, err := os.ReadFile(cfg.Path); err == nil {
+				state["exists"] = true
+				state["attributes"] = map[string]interface{}{"content": string(data), "serial": "S-" + string(data)}
+			}
+			result = state
+		case "diff":
+			plan := map[string]interface{}{"action": "noop"}
+			switch {
+			case !params.Current.Exists:
+				plan["action"] = "create"
+				plan["changes"] = []map[string]interface{}{{"attribute": "content", "old": nil, "new": cfg.Content}}
+			case params.Current.Attributes["content"] != cfg.Content:
+				plan["action"] = "update"
+				plan["changes"] = []map[string]interface{}{
+					{"attribute": "content", "old": params.Current.Attributes["content"], "new": cfg.Content},
+				}
+			}
+			result = plan
+		case "apply":
+			if err := os.WriteFile(cfg.Path, []byte(cfg.Content), 0644); err != nil {
+				rpcErr = &rpcError{Code: 1, Message: err.Error()}
+			}
+		default:
+			rpcErr = &rpcError{Code: -32601, Message: "method not found: " + req.Method}
+		}
+
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if rpcErr != nil {
+			resp["error"] = rpcErr
+		} else {
+			resp["result"] = result
+		}
+		data, _ := json.Marshal(resp)
+		fmt.Println(string(data))
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+
+	writeProvider(t, first, "acme", "ok")
+	writeProvider(t, second, "acme", "ok")
+	writeProvider(t, second, "other", "ok")
+	// Not executable, a directory and a bare prefix are ignored
+	if err := os.WriteFile(filepath.Join(second, BinaryPrefix+"noexec"), []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(second, BinaryPrefix+"dir"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	writeProvider(t, second, "", "ok")
+	t.Setenv("PATH", "")
+
+	found := Discover([]string{first, second})
+	if len(found) != 2 {
+		t.Fatalf("expected 2 providers, got %v", found)
+	}
+	if found["acme"] != filepath.Join(first, BinaryPrefix+"acme") {
+		t.Errorf("expected the first acme provider to win, got %s", found["acme"])
+	}
+	if found["other"] != filepath.Join(second, BinaryPrefix+"other") {
+		t.Errorf("unexpected path for other: %s", found["other"])
+	}
+}
+
+func TestLoad_PlanAndApply(t *testing.T) {
+	providerDir := t.TempDir()
+	writeProvider(t, providerDir, "acme", "ok")
+	t.Setenv("PATH", "")
+
+	registry := resource.DefaultRegistry
+	m := Load(registry, []string{providerDir})
+	defer func() {
+		if err := m.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if registry.Has("acme_license") {
+			t.Error("expected acme_license to be unregistered on Close")
+		}
+	}()
+
+	if !registry.Has("acme_license") {
+		t.Fatal("expected acme_license to be registered")
+	}
+
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	licensePath := filepath.Join(tmpDir, "license.key")
+	content := `
+resource "acme_license" "main" {
+  path    = "` + licensePath + `"
+  content = "KEY-123"
+  tags    = { team = "infra" }
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	ctx := context.Background()
+	var buf bytes.Buffer
+	e := engine.NewExecutor(&buf, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	plan := result.Plans["acme_license.main"]
+	if plan == nil || plan.Action != resource.ActionCreate {
+		t.Fatalf("expected acme_license.main to be created, got %+v", plan)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].New != "KEY-123" {
+		t.Errorf("unexpected changes: %+v", plan.Changes)
+	}
+
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	data, err := os.ReadFile(licensePath)
+	if err != nil || string(data) != "KEY-123" {
+		t.Fatalf("expected provider to write the license, got %q (%v)", data, err)
+	}
+
+	// A second plan has nothing to do
+	e = engine.NewExecutor(&buf, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	result, err = e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if result.HasChanges() {
+		t.Errorf("expected no changes after apply, got %+v", result.Plans["acme_license.main"])
+	}
+}
+
+func TestLoad_ExportedAttributes(t *testing.T) {
+	providerDir := t.TempDir()
+	writeProvider(t, providerDir, "acme", "ok")
+	t.Setenv("PATH", "")
+
+	m := Load(resource.DefaultRegistry, []string{providerDir})
+	defer func() { _ = m.Close() }()
+
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	licensePath := filepath.Join(tmpDir, "license.key")
+	outPath := filepath.Join(tmpDir, "serial.txt")
+	if err := os.WriteFile(licensePath, []byte("KEY-123"), 0644); err != nil {
+		t.Fatalf("failed to write license: %v", err)
+	}
+	content := `
+resource "acme_license" "main" {
+  path    = "` + licensePath + `"
+  content = "KEY-123"
+}
+
+resource "file" "serial" {
+  path    = "` + outPath + `"
+  content = "${acme_license.main.serial} ${acme_license.main.tags == null}"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	ctx := context.Background()
+	e := engine.NewExecutor(&bytes.Buffer{}, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	result, err := e.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	var deps []string
+	for _, r := range result.Resources {
+		if resource.ID(r) == "file.serial" {
+			deps = r.Dependencies()
+		}
+	}
+	if len(deps) != 1 || deps[0] != "acme_license.main" {
+		t.Errorf("expected file.serial to depend on acme_license.main, got %v", deps)
+	}
+	if err := e.Apply(ctx, result, false); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil || string(data) != "S-KEY-123 true" {
+		t.Errorf("expected the computed serial to be exported, got %q (%v)", data, err)
+	}
+}
+
+func TestLoad_ConfigErrors(t *testing.T) {
+	providerDir := t.TempDir()
+	writeProvider(t, providerDir, "acme", "ok")
+	t.Setenv("PATH", "")
+
+	m := Load(resource.DefaultRegistry, []string{providerDir})
+	defer func() { _ = m.Close() }()
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"missing required attribute", `path = "/tmp/license"`, `"content" is required`},
+		{"unknown attribute", `
+  path    = "/tmp/license"
+  content = "x"
+  owner   = "root"`, `"owner" is not expected`},
+		{"computed attribute", `
+  path    = "/tmp/license"
+  content = "x"
+  serial  = "S-1"`, `"serial" is not expected`},
+		{"wrong type", `
+  path    = "/tmp/license"
+  content = "x"
+  tags    = "infra"`, "map of string required"},
+		{"provider validation", `
+  path    = "relative"
+  content = "x"`, "path must be absolute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hclPath := filepath.Join(t.TempDir(), "test.hcl")
+			content := "resource \"acme_license\" \"main\" {\n" + tt.body + "\n}\n"
+			if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+
+			e := engine.NewExecutor(&bytes.Buffer{}, false)
+			err := e.LoadFile(hclPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_InvalidSchema(t *testing.T) {
+	providerDir := t.TempDir()
+	writeProvider(t, providerDir, "acme", "misnamed")
+	t.Setenv("PATH", "")
+
+	m := Load(resource.DefaultRegistry, []string{providerDir})
+	defer func() { _ = m.Close() }()
+
+	hclPath := filepath.Join(t.TempDir(), "test.hcl")
+	content := "resource \"acme_license\" \"main\" {\n}\n"
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	e := engine.NewExecutor(&bytes.Buffer{}, false)
+	err := e.LoadFile(hclPath)
+	if err == nil || !strings.Contains(err.Error(), "must be named acme or start with acme_") {
+		t.Errorf("expected schema error, got: %v", err)
+	}
+	if resource.DefaultRegistry.Has("license") {
+		t.Error("types from an invalid provider should not be registered")
+	}
+}
+
+func TestLoad_StartsUsedProvidersOnly(t *testing.T) {
+	providerDir := t.TempDir()
+	writeProvider(t, providerDir, "acme", "ok")
+	// Starting this provider would fail, so it must be left alone
+	writeProvider(t, providerDir, "broken", "misnamed")
+	t.Setenv("PATH", "")
+
+	m := Load(resource.DefaultRegistry, []string{providerDir})
+	defer func() { _ = m.Close() }()
+
+	tmpDir := t.TempDir()
+	hclPath := filepath.Join(tmpDir, "test.hcl")
+	content := `
+resource "acme_license" "main" {
+  path    = "` + filepath.Join(tmpDir, "license.key") + `"
+  content = "KEY-123"
+}
+`
+	if err := os.WriteFile(hclPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	e := engine.NewExecutor(&bytes.Buffer{}, false)
+	if err := e.LoadFile(hclPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	if len(m.clients) != 1 || m.clients[0].Name() != "acme" {
+		t.Errorf("expected only the acme provider to be started, got %d providers", len(m.clients))
+	}
+}
+
+func TestClient_CallTimeout(t *testing.T) {
+	providerDir := t.TempDir()
+	writeProvider(t, providerDir, "acme", "hang")
+
+	client, err := Start("acme", filepath.Join(providerDir, BinaryPrefix+"acme"))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.Call(ctx, "read", struct{}{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("expected the call to time out, got: %v", err)
+	}
+
+	// The provider is stopped, as a late answer would be taken for the next one
+	err = client.Call(context.Background(), "read", struct{}{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "is not running") {
+		t.Errorf("expected the provider to be stopped, got: %v", err)
+	}
+}
+
+func TestSchema_Check(t *testing.T) {
+	attrs := func(name, ty string) map[string]*AttributeSchema {
+		return map[string]*AttributeSchema{name: {Type: ty}}
+	}
+
+	tests := []struct {
+		name    string
+		schema  Schema
+		wantErr string
+	}{
+		{"valid", Schema{ProtocolVersion: ProtocolVersion, ResourceTypes: map[string]*ResourceSchema{
+			"acme":         {Attributes: attrs("name", "string")},
+			"acme_license": {Attributes: attrs("tags", "list(object({ key = string }))")},
+		}}, ""},
+		{"protocol version", Schema{ProtocolVersion: 99, ResourceTypes: map[string]*ResourceSchema{
+			"acme": {Attributes: attrs("name", "string")},
+		}}, "protocol version 99"},
+		{"no types", Schema{ProtocolVersion: ProtocolVersion}, "no resource types"},
+		{"misnamed type", Schema{ProtocolVersion: ProtocolVersion, ResourceTypes: map[string]*ResourceSchema{
+			"acmelicense": {Attributes: attrs("name", "string")},
+		}}, "must be named acme"},
+		{"reserved attribute", Schema{ProtocolVersion: ProtocolVersion, ResourceTypes: map[string]*ResourceSchema{
+			"acme": {Attributes: attrs("depends_on", "string")},
+		}}, "reserved"},
+		{"invalid attribute name", Schema{ProtocolVersion: ProtocolVersion, ResourceTypes: map[string]*ResourceSchema{
+			"acme": {Attributes: attrs("not-valid!", "string")},
+		}}, "invalid attribute name"},
+		{"invalid type", Schema{ProtocolVersion: ProtocolVersion, ResourceTypes: map[string]*ResourceSchema{
+			"acme": {Attributes: attrs("name", "strin")},
+		}}, "invalid type"},
+		{"required and computed", Schema{ProtocolVersion: ProtocolVersion, ResourceTypes: map[string]*ResourceSchema{
+			"acme": {Attributes: map[string]*AttributeSchema{"id": {Type: "string", Required: true, Computed: true}}},
+		}}, "cannot be both required and computed"},
+		{"missing type", Schema{ProtocolVersion: ProtocolVersion, ResourceTypes: map[string]*ResourceSchema{
+			"acme": {Attributes: attrs("name", "")},
+		}}, "type is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schema.check("acme")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
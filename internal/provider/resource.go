@@ -0,0 +1,223 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	"github.com/z0mbix/hostcfg/internal/resource"
+)
+
+// Resource is a resource implemented by an external provider. Each method is
+// forwarded to the provider process.
+type Resource struct {
+	client       *Client
+	resourceType string
+	name         string
+	description  string
+	schema       *ResourceSchema
+	values       cty.Value
+	config       json.RawMessage
+	dependsOn    []string
+}
+
+// resourceParams identifies a resource in every request
+type resourceParams struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+}
+
+type diffParams struct {
+	resourceParams
+	Current *resource.State `json:"current"`
+}
+
+type applyParams struct {
+	resourceParams
+	Plan *wirePlan `json:"plan"`
+}
+
+// wirePlan is a plan as sent to and from providers
+type wirePlan struct {
+	Action  string          `json:"action"`
+	Changes []wireChange    `json:"changes"`
+	Before  *resource.State `json:"before,omitempty"`
+	After   *resource.State `json:"after,omitempty"`
+}
+
+type wireChange struct {
+	Attribute string      `json:"attribute"`
+	Old       interface{} `json:"old"`
+	New       interface{} `json:"new"`
+}
+
+// factory returns a resource factory for a provider resource type. The
+// configuration is decoded and type checked against the provider's schema
+// before it is sent to the provider.
+func factory(client *Client, resourceType string, rs *ResourceSchema) resource.Factory {
+	spec := rs.spec()
+	return func(name string, body hcl.Body, dependsOn []string, description string, ctx *hcl.EvalContext) (resource.Resource, error) {
+		val, diags := hcldec.Decode(body, spec, ctx)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("failed to decode %s resource: %s", resourceType, diags.Error())
+		}
+
+		config, err := ctyjson.Marshal(val, val.Type())
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: failed to encode configuration: %w", resourceType, name, err)
+		}
+
+		return &Resource{
+			client:       client,
+			resourceType: resourceType,
+			name:         name,
+			description:  description,
+			schema:       rs,
+			values:       val,
+			config:       config,
+			dependsOn:    dependsOn,
+		}, nil
+	}
+}
+
+func (r *Resource) Type() string        { return r.resourceType }
+func (r *Resource) Name() string        { return r.name }
+func (r *Resource) Description() string { return r.description }
+
+func (r *Resource) Dependencies() []string {
+	return r.dependsOn
+}
+
+func (r *Resource) AttributeSchema() map[string]resource.Attribute {
+	attrs := make(map[string]resource.Attribute, len(r.schema.Attributes))
+	for name, attr := range r.schema.Attributes {
+		attrs[name] = resource.Attribute{Type: attr.ty, Computed: attr.Computed, KnownAfterApply: attr.Computed}
+	}
+	return attrs
+}
+
+// ExportedAttributes exports the configuration and, when the resource type has
+// computed attributes, their values from the provider's read
+func (r *Resource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	values := r.values.AsValueMap()
+	if values == nil {
+		values = make(map[string]cty.Value)
+	}
+
+	var computed []string
+	for _, name := range sortedKeys(r.schema.Attributes) {
+		if r.schema.Attributes[name].Computed {
+			computed = append(computed, name)
+		}
+	}
+	if len(computed) == 0 {
+		return values, nil
+	}
+
+	state, err := r.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range computed {
+		v, ok := state.Attributes[name]
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %s.%s: attribute %s: %w", r.client.Name(), r.resourceType, r.name, name, err)
+		}
+		val, err := ctyjson.Unmarshal(data, r.schema.Attributes[name].ty)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %s.%s: attribute %s: %w", r.client.Name(), r.resourceType, r.name, name, err)
+		}
+		values[name] = val
+	}
+	return values, nil
+}
+
+func (r *Resource) params() resourceParams {
+	return resourceParams{Type: r.resourceType, Name: r.name, Config: r.config}
+}
+
+func (r *Resource) Validate() error {
+	if err := r.client.Call(context.Background(), "validate", r.params(), nil); err != nil {
+		return fmt.Errorf("%s.%s: %w", r.resourceType, r.name, err)
+	}
+	return nil
+}
+
+func (r *Resource) Read(ctx context.Context) (*resource.State, error) {
+	state := resource.NewState()
+	if err := r.client.Call(ctx, "read", r.params(), state); err != nil {
+		return nil, fmt.Errorf("failed to read %s.%s: %w", r.resourceType, r.name, err)
+	}
+	if state.Attributes == nil {
+		state.Attributes = make(map[string]interface{})
+	}
+	return state, nil
+}
+
+func (r *Resource) Diff(ctx context.Context, current *resource.State) (*resource.Plan, error) {
+	var wp wirePlan
+	if err := r.client.Call(ctx, "diff", diffParams{resourceParams: r.params(), Current: current}, &wp); err != nil {
+		return nil, fmt.Errorf("failed to diff %s.%s: %w", r.resourceType, r.name, err)
+	}
+
+	action, err := parseAction(wp.Action)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %s.%s: %w", r.client.Name(), r.resourceType, r.name, err)
+	}
+
+	plan := &resource.Plan{
+		Action: action,
+		Before: current,
+		After:  wp.After,
+	}
+	if plan.After == nil {
+		plan.After = resource.NewState()
+	}
+	for _, c := range wp.Changes {
+		plan.Changes = append(plan.Changes, resource.Change{Attribute: c.Attribute, Old: c.Old, New: c.New})
+	}
+	return plan, nil
+}
+
+func (r *Resource) Apply(ctx context.Context, plan *resource.Plan, apply bool) error {
+	if !apply || !plan.HasChanges() {
+		return nil
+	}
+
+	wp := &wirePlan{
+		Action: plan.Action.String(),
+		Before: plan.Before,
+		After:  plan.After,
+	}
+	for _, c := range plan.Changes {
+		wp.Changes = append(wp.Changes, wireChange{Attribute: c.Attribute, Old: c.Old, New: c.New})
+	}
+
+	return r.client.Call(ctx, "apply", applyParams{resourceParams: r.params(), Plan: wp}, nil)
+}
+
+// parseAction converts a plan action from the protocol
+func parseAction(s string) (resource.Action, error) {
+	switch s {
+	case "", "noop":
+		return resource.ActionNoop, nil
+	case "create":
+		return resource.ActionCreate, nil
+	case "update":
+		return resource.ActionUpdate, nil
+	case "delete":
+		return resource.ActionDelete, nil
+	default:
+		return resource.ActionNoop, fmt.Errorf("unknown plan action %q", s)
+	}
+}
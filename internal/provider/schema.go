@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// metaArguments are resource block arguments handled by hostcfg itself
+var metaArguments = map[string]bool{
+	"depends_on":  true,
+	"description": true,
+	"for_each":    true,
+	"when":        true,
+	"notify":      true,
+	"subscribe":   true,
+}
+
+// Schema is a provider's answer to the "schema" method
+type Schema struct {
+	ProtocolVersion int                        `json:"protocol_version"`
+	ResourceTypes   map[string]*ResourceSchema `json:"resource_types"`
+}
+
+// ResourceSchema describes the attributes of one resource type
+type ResourceSchema struct {
+	Description string                      `json:"description,omitempty"`
+	Attributes  map[string]*AttributeSchema `json:"attributes"`
+}
+
+// AttributeSchema describes one attribute of a resource type
+type AttributeSchema struct {
+	Type        string `json:"type"` // HCL type constraint, e.g. "string" or "list(string)"
+	Required    bool   `json:"required,omitempty"`
+	Computed    bool   `json:"computed,omitempty"` // Set by the provider, read from the state
+	Description string `json:"description,omitempty"`
+
+	ty cty.Type
+}
+
+// check verifies that a provider's schema is usable: it speaks this protocol
+// version, its resource types are named after the provider, and every
+// attribute has a valid name and type
+func (s *Schema) check(providerName string) error {
+	if s.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("provider %s speaks protocol version %d, hostcfg supports %d",
+			providerName, s.ProtocolVersion, ProtocolVersion)
+	}
+	if len(s.ResourceTypes) == 0 {
+		return fmt.Errorf("provider %s has no resource types", providerName)
+	}
+
+	for _, typeName := range sortedKeys(s.ResourceTypes) {
+		rs := s.ResourceTypes[typeName]
+		if typeName != providerName && !strings.HasPrefix(typeName, providerName+"_") {
+			return fmt.Errorf("provider %s: resource type %s must be named %s or start with %s_",
+				providerName, typeName, providerName, providerName)
+		}
+		if rs == nil {
+			return fmt.Errorf("provider %s: resource type %s has no schema", providerName, typeName)
+		}
+
+		for _, attrName := range sortedKeys(rs.Attributes) {
+			attr := rs.Attributes[attrName]
+			if !hclsyntax.ValidIdentifier(attrName) {
+				return fmt.Errorf("provider %s: %s has invalid attribute name %q", providerName, typeName, attrName)
+			}
+			if metaArguments[attrName] {
+				return fmt.Errorf("provider %s: %s cannot define attribute %q, it is reserved by hostcfg",
+					providerName, typeName, attrName)
+			}
+			if attr == nil {
+				return fmt.Errorf("provider %s: %s.%s has no schema", providerName, typeName, attrName)
+			}
+			if attr.Required && attr.Computed {
+				return fmt.Errorf("provider %s: %s.%s cannot be both required and computed", providerName, typeName, attrName)
+			}
+
+			ty, err := parseType(attr.Type)
+			if err != nil {
+				return fmt.Errorf("provider %s: %s.%s has invalid type %q: %w", providerName, typeName, attrName, attr.Type, err)
+			}
+			attr.ty = ty
+		}
+	}
+	return nil
+}
+
+// spec returns the HCL decoder spec for a resource type. Computed attributes
+// cannot be set in the configuration.
+func (rs *ResourceSchema) spec() hcldec.Spec {
+	spec := make(hcldec.ObjectSpec, len(rs.Attributes))
+	for name, attr := range rs.Attributes {
+		if attr.Computed {
+			continue
+		}
+		spec[name] = &hcldec.AttrSpec{
+			Name:     name,
+			Type:     attr.ty,
+			Required: attr.Required,
+		}
+	}
+	return spec
+}
+
+// parseType parses an HCL type constraint such as "map(string)"
+func parseType(s string) (cty.Type, error) {
+	if s == "" {
+		return cty.DynamicPseudoType, fmt.Errorf("type is required")
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(s), "type", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return cty.DynamicPseudoType, diags
+	}
+	ty, diags := typeexpr.TypeConstraint(expr)
+	if diags.HasErrors() {
+		return cty.DynamicPseudoType, diags
+	}
+	return ty, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
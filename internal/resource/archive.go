@@ -0,0 +1,723 @@
+package resource
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func init() {
+	Register("archive", NewArchiveResource)
+}
+
+// archiveMarkerPrefix names the file in dest that records the checksum of
+// the archive extracted there and a hash of the settings it was extracted
+// with, followed by the resource name
+const archiveMarkerPrefix = ".hostcfg-archive-"
+
+// ArchiveResource extracts a tar or zip archive into a directory
+type ArchiveResource struct {
+	name        string
+	description string
+	config      config.ArchiveResourceConfig
+	dependsOn   []string
+}
+
+// NewArchiveResource creates a new archive resource from HCL
+func NewArchiveResource(name string, body hcl.Body, dependsOn []string, description string, ctx *hcl.EvalContext) (Resource, error) {
+	var cfg config.ArchiveResourceConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to decode archive resource: %s", diags.Error())
+	}
+
+	return &ArchiveResource{
+		name:        name,
+		description: description,
+		config:      cfg,
+		dependsOn:   dependsOn,
+	}, nil
+}
+
+func (r *ArchiveResource) Type() string        { return "archive" }
+func (r *ArchiveResource) Name() string        { return r.name }
+func (r *ArchiveResource) Description() string { return r.description }
+
+func (r *ArchiveResource) Validate() error {
+	if r.config.Source == "" {
+		return fmt.Errorf("archive.%s: source is required", r.name)
+	}
+	if r.config.Dest == "" {
+		return fmt.Errorf("archive.%s: dest is required", r.name)
+	}
+	if _, err := r.format(); err != nil {
+		return fmt.Errorf("archive.%s: %w", r.name, err)
+	}
+	if r.config.StripComponents != nil && *r.config.StripComponents < 0 {
+		return fmt.Errorf("archive.%s: strip_components must not be negative", r.name)
+	}
+	for _, pattern := range append(append([]string(nil), r.config.Include...), r.config.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("archive.%s: invalid glob %q", r.name, pattern)
+		}
+	}
+	return nil
+}
+
+func (r *ArchiveResource) Dependencies() []string {
+	return r.dependsOn
+}
+
+func (r *ArchiveResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *ArchiveResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
+// format returns the configured format, or the one implied by the source's
+// extension
+func (r *ArchiveResource) format() (string, error) {
+	if r.config.Format != nil {
+		switch *r.config.Format {
+		case "tar", "tar.gz", "tar.bz2", "tar.xz", "zip":
+			return *r.config.Format, nil
+		}
+		return "", fmt.Errorf("unsupported format %q", *r.config.Format)
+	}
+
+	source := strings.ToLower(r.config.Source)
+	switch {
+	case strings.HasSuffix(source, ".tar.gz"), strings.HasSuffix(source, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(source, ".tar.bz2"), strings.HasSuffix(source, ".tbz2"):
+		return "tar.bz2", nil
+	case strings.HasSuffix(source, ".tar.xz"), strings.HasSuffix(source, ".txz"):
+		return "tar.xz", nil
+	case strings.HasSuffix(source, ".tar"):
+		return "tar", nil
+	case strings.HasSuffix(source, ".zip"):
+		return "zip", nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s from its name, set format", r.config.Source)
+}
+
+func (r *ArchiveResource) markerPath() string {
+	return filepath.Join(r.config.Dest, archiveMarkerPrefix+r.name)
+}
+
+// settingsHash returns a hash of the settings that decide what extracting the
+// archive writes, so changing them extracts it again
+func (r *ArchiveResource) settingsHash() (string, error) {
+	format, err := r.format()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(struct {
+		Format          string   `json:"format"`
+		StripComponents *int     `json:"strip_components"`
+		Include         []string `json:"include"`
+		Exclude         []string `json:"exclude"`
+		Owner           *string  `json:"owner"`
+		Group           *string  `json:"group"`
+	}{format, r.config.StripComponents, r.config.Include, r.config.Exclude, r.config.Owner, r.config.Group})
+	if err != nil {
+		return "", err
+	}
+	return contentHash(data), nil
+}
+
+// Read returns the checksum of the archive last extracted into dest, and the
+// hash of the settings it was extracted with. The resource exists once it
+// has been extracted.
+func (r *ArchiveResource) Read(ctx context.Context) (*State, error) {
+	state := NewState()
+	state.Attributes["source"] = r.config.Source
+	state.Attributes["dest"] = r.config.Dest
+
+	data, err := os.ReadFile(r.markerPath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", r.markerPath(), err)
+	}
+	state.Exists = true
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	state.Attributes["checksum"] = strings.TrimSpace(lines[0])
+	if len(lines) > 1 {
+		state.Attributes["settings"] = strings.TrimSpace(lines[1])
+	}
+	return state, nil
+}
+
+func (r *ArchiveResource) Diff(ctx context.Context, current *State) (*Plan, error) {
+	plan := &Plan{
+		Before: current,
+		After:  NewState(),
+	}
+	for k, v := range current.Attributes {
+		plan.After.Attributes[k] = v
+	}
+	plan.After.Exists = current.Exists
+
+	action := ActionCreate
+	if current.Exists {
+		action = ActionUpdate
+	}
+	var recorded, recordedSettings interface{}
+	if checksum, ok := current.Attributes["checksum"].(string); ok {
+		recorded = checksum
+	}
+	if settings, ok := current.Attributes["settings"].(string); ok {
+		recordedSettings = settings
+	}
+	settings, err := r.settingsHash()
+	if err != nil {
+		return nil, fmt.Errorf("archive.%s: %w", r.name, err)
+	}
+
+	sum, err := computeFileChecksum(r.config.Source, "sha256")
+	if os.IsNotExist(err) {
+		// The archive may be downloaded by another resource in this run
+		plan.Action = action
+		plan.After.Exists = true
+		plan.Changes = append(plan.Changes, Change{Attribute: "checksum", Old: recorded, New: Unknown})
+		return plan, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("archive.%s: failed to read source: %w", r.name, err)
+	}
+	checksum := "sha256:" + sum
+	if recorded == checksum && recordedSettings == settings {
+		return plan, nil
+	}
+
+	added, replaced, err := r.pendingFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("archive.%s: %w", r.name, err)
+	}
+
+	plan.Action = action
+	plan.After.Exists = true
+	plan.After.Attributes["checksum"] = checksum
+	plan.After.Attributes["settings"] = settings
+	if recorded != checksum {
+		plan.Changes = append(plan.Changes, Change{Attribute: "checksum", Old: recorded, New: checksum})
+	}
+	if recordedSettings != settings {
+		plan.Changes = append(plan.Changes, Change{Attribute: "settings", Old: recordedSettings, New: settings})
+	}
+	if len(added) > 0 {
+		plan.Changes = append(plan.Changes, Change{Attribute: "add", Old: nil, New: added})
+	}
+	if len(replaced) > 0 {
+		plan.Changes = append(plan.Changes, Change{Attribute: "replace", Old: nil, New: replaced})
+	}
+	return plan, nil
+}
+
+// pendingFiles returns the paths, relative to dest, of the files that
+// extracting the archive would add and those it would replace
+func (r *ArchiveResource) pendingFiles(ctx context.Context) (added, replaced []interface{}, err error) {
+	root, err := os.OpenRoot(r.config.Dest)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if root != nil {
+		defer func() { _ = root.Close() }()
+	}
+
+	err = r.walk(ctx, func(e *archiveEntry, rel string) error {
+		if e.kind == archiveDir {
+			return nil
+		}
+		if root == nil {
+			added = append(added, rel)
+			return nil
+		}
+		name := filepath.FromSlash(rel)
+		info, err := root.Lstat(name)
+		if os.IsNotExist(err) {
+			added = append(added, rel)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		same := false
+		switch e.kind {
+		case archiveFile:
+			if info.Mode().IsRegular() && info.Size() == e.size {
+				same, err = sameContent(root, name, e.open())
+			}
+		case archiveSymlink:
+			if info.Mode()&os.ModeSymlink != 0 {
+				link, _ := root.Readlink(name)
+				same = link == e.linkname
+			}
+		case archiveHardlink:
+			same = info.Mode().IsRegular()
+		}
+		if err != nil {
+			return err
+		}
+		if !same {
+			replaced = append(replaced, rel)
+		}
+		return nil
+	})
+	return added, replaced, err
+}
+
+func (r *ArchiveResource) Apply(ctx context.Context, plan *Plan, apply bool) error {
+	if !apply || !plan.HasChanges() {
+		return nil
+	}
+
+	sum, err := computeFileChecksum(r.config.Source, "sha256")
+	if err != nil {
+		return fmt.Errorf("failed to read source: %w", err)
+	}
+	settings, err := r.settingsHash()
+	if err != nil {
+		return err
+	}
+
+	uid, gid := -1, -1
+	if r.config.Owner != nil {
+		u, err := user.Lookup(*r.config.Owner)
+		if err != nil {
+			return fmt.Errorf("unknown user: %s", *r.config.Owner)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if r.config.Group != nil {
+		g, err := user.LookupGroup(*r.config.Group)
+		if err != nil {
+			return fmt.Errorf("unknown group: %s", *r.config.Group)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	if err := os.MkdirAll(r.config.Dest, 0755); err != nil {
+		return fmt.Errorf("failed to create dest directory: %w", err)
+	}
+
+	// Entries are written through a root, so links extracted by earlier
+	// entries cannot lead later ones outside dest
+	root, err := os.OpenRoot(r.config.Dest)
+	if err != nil {
+		return fmt.Errorf("failed to open dest directory: %w", err)
+	}
+	defer func() { _ = root.Close() }()
+
+	err = r.walk(ctx, func(e *archiveEntry, rel string) error {
+		name := filepath.FromSlash(rel)
+		if err := extractEntry(root, e, name); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", rel, err)
+		}
+		if uid != -1 || gid != -1 {
+			if err := root.Lchown(name, uid, gid); err != nil {
+				return fmt.Errorf("failed to set ownership of %s: %w", rel, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	marker := "sha256:" + sum + "\n" + settings + "\n"
+	if err := root.WriteFile(archiveMarkerPrefix+r.name, []byte(marker), 0644); err != nil {
+		return fmt.Errorf("failed to record checksum: %w", err)
+	}
+	return nil
+}
+
+// walk calls fn for each entry of the archive that is extracted, with its
+// path relative to dest
+func (r *ArchiveResource) walk(ctx context.Context, fn func(e *archiveEntry, rel string) error) error {
+	format, err := r.format()
+	if err != nil {
+		return err
+	}
+	strip := 0
+	if r.config.StripComponents != nil {
+		strip = *r.config.StripComponents
+	}
+
+	return walkArchive(ctx, r.config.Source, format, func(e *archiveEntry) error {
+		rel, ok, err := r.relPath(e.name, strip)
+		if err != nil || !ok {
+			return err
+		}
+		switch e.kind {
+		case archiveSymlink:
+			if path.IsAbs(e.linkname) || escapesDest(path.Join(path.Dir(rel), e.linkname)) {
+				return fmt.Errorf("symlink %s points outside dest", e.name)
+			}
+		case archiveHardlink:
+			// Hard links name another entry of the archive
+			target, ok, err := r.relPath(e.linkname, strip)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("hard link %s points to %s, which is not extracted", e.name, e.linkname)
+			}
+			e.linkname = target
+		}
+		return fn(e, rel)
+	})
+}
+
+// relPath maps a path in the archive to one relative to dest, reporting
+// false for entries that are stripped, not included or excluded
+func (r *ArchiveResource) relPath(name string, strip int) (string, bool, error) {
+	name = strings.TrimPrefix(name, "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false, fmt.Errorf("unsafe path %s in archive", name)
+		}
+	}
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", false, nil
+	}
+
+	parts := strings.Split(clean, "/")
+	if len(parts) <= strip {
+		return "", false, nil
+	}
+	rel := strings.Join(parts[strip:], "/")
+
+	if len(r.config.Include) > 0 && !matchGlobs(r.config.Include, rel) {
+		return "", false, nil
+	}
+	if matchGlobs(r.config.Exclude, rel) {
+		return "", false, nil
+	}
+	return rel, true, nil
+}
+
+// matchGlobs reports whether rel, or one of the directories it is
+// in, matches one of the patterns
+func matchGlobs(patterns []string, rel string) bool {
+	parts := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		for i := len(parts); i > 0; i-- {
+			if ok, _ := path.Match(pattern, strings.Join(parts[:i], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// escapesDest reports whether a cleaned relative path is outside dest
+func escapesDest(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, "../")
+}
+
+// sameContent reports whether the file name in root holds the content of r
+func sameContent(root *os.Root, name string, r io.Reader) (bool, error) {
+	want := sha256.New()
+	if _, err := io.Copy(want, r); err != nil {
+		return false, err
+	}
+	f, err := root.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+	got := sha256.New()
+	if _, err := io.Copy(got, f); err != nil {
+		return false, err
+	}
+	return bytes.Equal(want.Sum(nil), got.Sum(nil)), nil
+}
+
+// extractEntry writes an entry to name in root, replacing what was there
+func extractEntry(root *os.Root, e *archiveEntry, name string) error {
+	if e.kind == archiveDir {
+		mode := e.mode
+		if mode == 0 {
+			mode = 0755
+		}
+		if err := root.MkdirAll(name, 0755); err != nil {
+			return err
+		}
+		return root.Chmod(name, mode)
+	}
+
+	dir := filepath.Dir(name)
+	if err := root.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	switch e.kind {
+	case archiveSymlink:
+		if err := removeExisting(root, name); err != nil {
+			return err
+		}
+		return root.Symlink(e.linkname, name)
+
+	case archiveHardlink:
+		if err := removeExisting(root, name); err != nil {
+			return err
+		}
+		return root.Link(filepath.FromSlash(e.linkname), name)
+	}
+
+	// Files are written next to the target and renamed over it
+	tmpFile, tmpName, err := createTempIn(root, dir, ".hostcfg-extract-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmpName != "" {
+			_ = root.Remove(tmpName)
+		}
+	}()
+
+	_, err = io.Copy(tmpFile, e.open())
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	mode := e.mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := root.Chmod(tmpName, mode); err != nil {
+		return err
+	}
+	if info, err := root.Lstat(name); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory", name)
+	}
+	if err := root.Rename(tmpName, name); err != nil {
+		return err
+	}
+	tmpName = ""
+	return nil
+}
+
+// createTempIn creates a new file in dir of root, with a random name that
+// starts with prefix, and returns it with its name
+func createTempIn(root *os.Root, dir, prefix string) (*os.File, string, error) {
+	for {
+		suffix := make([]byte, 8)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, "", err
+		}
+		name := filepath.Join(dir, prefix+hex.EncodeToString(suffix))
+		f, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, name, err
+	}
+}
+
+// removeExisting removes a file or symlink at name in root, but not a
+// directory
+func removeExisting(root *os.Root, name string) error {
+	info, err := root.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", name)
+	}
+	return root.Remove(name)
+}
+
+const (
+	archiveFile = iota
+	archiveDir
+	archiveSymlink
+	archiveHardlink
+)
+
+// archiveEntry is a file, directory or link in an archive
+type archiveEntry struct {
+	name     string
+	kind     int
+	mode     os.FileMode
+	size     int64
+	linkname string
+
+	// open returns the content of a file. It may only be read during the
+	// call that is passed the entry.
+	open func() io.Reader
+}
+
+// walkArchive calls fn for each file, directory and link in the archive at
+// source. Other entries, such as devices, are skipped.
+func walkArchive(ctx context.Context, source, format string, fn func(e *archiveEntry) error) error {
+	if format == "zip" {
+		return walkZip(source, fn)
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	var stream io.Reader = f
+	switch format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gz.Close() }()
+		stream = gz
+	case "tar.bz2":
+		stream = bzip2.NewReader(f)
+	case "tar.xz":
+		// There is no xz decoder in the standard library
+		cmd := exec.CommandContext(ctx, "xz", "--decompress", "--stdout")
+		cmd.Stdin = f
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("tar.xz archives need the xz command: %w", err)
+		}
+		err = walkTar(out, fn)
+		_, _ = io.Copy(io.Discard, out)
+		if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+			err = fmt.Errorf("xz failed: %w: %s", waitErr, strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return walkTar(stream, fn)
+}
+
+func walkTar(stream io.Reader, fn func(e *archiveEntry) error) error {
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		e := &archiveEntry{
+			name:     hdr.Name,
+			mode:     os.FileMode(hdr.Mode).Perm(),
+			size:     hdr.Size,
+			linkname: hdr.Linkname,
+			open:     func() io.Reader { return tr },
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			e.kind = archiveFile
+		case tar.TypeDir:
+			e.kind = archiveDir
+		case tar.TypeSymlink:
+			e.kind = archiveSymlink
+		case tar.TypeLink:
+			e.kind = archiveHardlink
+		default:
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(source string, fn func(e *archiveEntry) error) error {
+	zr, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = zr.Close() }()
+
+	for _, f := range zr.File {
+		e := &archiveEntry{
+			name: f.Name,
+			mode: f.Mode().Perm(),
+			size: int64(f.UncompressedSize64),
+		}
+
+		var rc io.ReadCloser
+		open := func() io.Reader {
+			if rc == nil {
+				var err error
+				if rc, err = f.Open(); err != nil {
+					return errReader{err}
+				}
+			}
+			return rc
+		}
+		switch {
+		case f.Mode().IsDir():
+			e.kind = archiveDir
+		case f.Mode()&os.ModeSymlink != 0:
+			// A symlink's target is its content
+			target, err := io.ReadAll(open())
+			if err != nil {
+				return err
+			}
+			e.kind = archiveSymlink
+			e.linkname = string(target)
+		case f.Mode().IsRegular():
+			e.kind = archiveFile
+			e.open = open
+		default:
+			continue
+		}
+
+		err := fn(e)
+		if rc != nil {
+			_ = rc.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errReader is a reader that fails with err
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,383 @@
+package resource
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// archiveFiles are the entries of the test archives, under a top level
+// directory as in release tarballs
+var archiveFiles = []struct {
+	name    string
+	content string
+}{
+	{"app-1.0/bin/app", "#!/bin/sh\necho app\n"},
+	{"app-1.0/README", "readme\n"},
+	{"app-1.0/docs/guide.txt", "guide\n"},
+}
+
+func writeTestTarGz(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, file := range archiveFiles {
+		hdr := &tar.Header{Name: file.name, Mode: 0755, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(file.content)); err != nil {
+			t.Fatalf("failed to write content: %v", err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "app-1.0/bin/app-link", Linkname: "app", Typeflag: tar.TypeSymlink}); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+}
+
+func writeTestZip(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	zw := zip.NewWriter(f)
+	for _, file := range archiveFiles {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			t.Fatalf("failed to add file: %v", err)
+		}
+		if _, err := w.Write([]byte(file.content)); err != nil {
+			t.Fatalf("failed to write content: %v", err)
+		}
+	}
+	_ = zw.Close()
+}
+
+func TestArchiveResource_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hcl     string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			hcl: `
+				source = "/tmp/app.tar.gz"
+				dest   = "/opt/app"
+			`,
+		},
+		{
+			name: "format from attribute",
+			hcl: `
+				source = "/tmp/app.bin"
+				dest   = "/opt/app"
+				format = "zip"
+			`,
+		},
+		{
+			name: "unknown extension",
+			hcl: `
+				source = "/tmp/app.bin"
+				dest   = "/opt/app"
+			`,
+			wantErr: true,
+		},
+		{
+			name: "invalid glob",
+			hcl: `
+				source  = "/tmp/app.tar.gz"
+				dest    = "/opt/app"
+				exclude = ["[docs"]
+			`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewArchiveResource("test", parseFileHCL(t, tt.hcl), nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			err = r.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestArchiveResource_Extract(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		write  func(*testing.T, string)
+		hcl    string
+		want   []string
+	}{
+		{
+			name:   "tar.gz with strip_components",
+			source: "app.tar.gz",
+			write:  writeTestTarGz,
+			hcl:    `strip_components = 1`,
+			want:   []string{"bin/app", "README", "docs/guide.txt", "bin/app-link"},
+		},
+		{
+			name:   "zip with exclude",
+			source: "app.zip",
+			write:  writeTestZip,
+			hcl: `
+				strip_components = 1
+				exclude          = ["docs"]
+			`,
+			want: []string{"bin/app", "README"},
+		},
+		{
+			name:   "include",
+			source: "app.tar.gz",
+			write:  writeTestTarGz,
+			hcl:    `include = ["*/bin/*"]`,
+			want:   []string{"app-1.0/bin/app", "app-1.0/bin/app-link"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			source := filepath.Join(tmpDir, tt.source)
+			dest := filepath.Join(tmpDir, "dest")
+			tt.write(t, source)
+
+			r, err := NewArchiveResource("app", parseFileHCL(t, `
+				source = "`+source+`"
+				dest   = "`+dest+`"
+			`+tt.hcl), nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			if err := r.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+
+			ctx := context.Background()
+			current, err := r.Read(ctx)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			plan, err := r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.Action != ActionCreate {
+				t.Errorf("expected create, got %s", plan.Action)
+			}
+			var added []string
+			for _, c := range plan.Changes {
+				if c.Attribute == "add" {
+					for _, p := range c.New.([]interface{}) {
+						added = append(added, p.(string))
+					}
+				}
+			}
+			if !reflect.DeepEqual(added, tt.want) {
+				t.Errorf("expected to add %v, got %v", tt.want, added)
+			}
+
+			if err := r.Apply(ctx, plan, true); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			for _, rel := range tt.want {
+				if _, err := os.Lstat(filepath.Join(dest, rel)); err != nil {
+					t.Errorf("expected %s to be extracted: %v", rel, err)
+				}
+			}
+
+			current, _ = r.Read(ctx)
+			plan, err = r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.HasChanges() {
+				t.Errorf("expected no changes on the second run, got %+v", plan.Changes)
+			}
+		})
+	}
+}
+
+func TestArchiveResource_Replace(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "app.tar.gz")
+	dest := filepath.Join(tmpDir, "dest")
+	writeTestTarGz(t, source)
+
+	if err := os.MkdirAll(filepath.Join(dest, "bin"), 0755); err != nil {
+		t.Fatalf("failed to create dest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "README"), []byte("readme\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "bin", "app"), []byte("old\n"), 0755); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	r, _ := NewArchiveResource("app", parseFileHCL(t, `
+		source           = "`+source+`"
+		dest             = "`+dest+`"
+		strip_components = 1
+	`), nil, "", nil)
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	for _, c := range plan.Changes {
+		if c.Attribute == "replace" && !reflect.DeepEqual(c.New, []interface{}{"bin/app"}) {
+			t.Errorf("expected to replace only bin/app, got %v", c.New)
+		}
+	}
+
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "bin", "app")); string(data) != archiveFiles[0].content {
+		t.Errorf("expected bin/app to be replaced, got %q", data)
+	}
+}
+
+func TestArchiveResource_SettingsChanged(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "app.tar.gz")
+	dest := filepath.Join(tmpDir, "dest")
+	writeTestTarGz(t, source)
+
+	ctx := context.Background()
+	r, _ := NewArchiveResource("app", parseFileHCL(t, `
+		source = "`+source+`"
+		dest   = "`+dest+`"
+	`), nil, "", nil)
+	current, _ := r.Read(ctx)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	// The archive is unchanged, but it is now extracted differently
+	r, _ = NewArchiveResource("app", parseFileHCL(t, `
+		source           = "`+source+`"
+		dest             = "`+dest+`"
+		strip_components = 1
+	`), nil, "", nil)
+	current, _ = r.Read(ctx)
+	plan, err = r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if !plan.HasChanges() {
+		t.Fatal("expected changing strip_components to extract the archive again")
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "bin", "app")); err != nil {
+		t.Errorf("expected bin/app to be extracted: %v", err)
+	}
+
+	current, _ = r.Read(ctx)
+	plan, err = r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes on the second run, got %+v", plan.Changes)
+	}
+}
+
+func TestArchiveResource_MissingSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := NewArchiveResource("app", parseFileHCL(t, `
+		source = "`+filepath.Join(tmpDir, "app.tar.gz")+`"
+		dest   = "`+filepath.Join(tmpDir, "dest")+`"
+	`), nil, "", nil)
+
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if plan.Action != ActionCreate || len(plan.Changes) != 1 || plan.Changes[0].New != Unknown {
+		t.Errorf("expected the checksum to be known after apply, got %s %+v", plan.Action, plan.Changes)
+	}
+}
+
+func TestArchiveResource_UnsafePath(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "evil.tar")
+	f, _ := os.Create(source)
+	tw := tar.NewWriter(f)
+	_ = tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	_, _ = tw.Write([]byte("x"))
+	_ = tw.Close()
+	_ = f.Close()
+
+	r, _ := NewArchiveResource("evil", parseFileHCL(t, `
+		source = "`+source+`"
+		dest   = "`+filepath.Join(tmpDir, "dest")+`"
+	`), nil, "", nil)
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	if _, err := r.Diff(ctx, current); err == nil {
+		t.Error("expected error for a path outside dest")
+	}
+}
+
+func TestArchiveResource_SymlinkChain(t *testing.T) {
+	// Each link is inside dest on its own, but e/ resolves to the parent of
+	// dest through the links extracted before it
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "chain.tar")
+	dest := filepath.Join(tmpDir, "dest")
+	f, _ := os.Create(source)
+	tw := tar.NewWriter(f)
+	_ = tw.WriteHeader(&tar.Header{Name: "d", Linkname: ".", Typeflag: tar.TypeSymlink})
+	_ = tw.WriteHeader(&tar.Header{Name: "d/e", Linkname: "..", Typeflag: tar.TypeSymlink})
+	_ = tw.WriteHeader(&tar.Header{Name: "e/pwned.txt", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	_, _ = tw.Write([]byte("x"))
+	_ = tw.Close()
+	_ = f.Close()
+
+	r, _ := NewArchiveResource("chain", parseFileHCL(t, `
+		source = "`+source+`"
+		dest   = "`+dest+`"
+	`), nil, "", nil)
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if err := r.Apply(ctx, plan, true); err == nil {
+		t.Error("expected error for a path outside dest")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "pwned.txt")); !os.IsNotExist(err) {
+		t.Error("expected nothing to be written outside dest")
+	}
+}
@@ -12,10 +12,12 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
 	Register("cron", NewCronResource)
+	RegisterPrunable("cron", "command", "schedule", "user")
 }
 
 // CronResource manages cron job entries
@@ -60,6 +62,14 @@ func (r *CronResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *CronResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *CronResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 // cronEntry returns the formatted cron entry line
 func (r *CronResource) cronEntry() string {
 	return fmt.Sprintf("%s %s # hostcfg: %s", r.config.Schedule, r.config.Command, r.name)
@@ -234,6 +244,45 @@ func (r *CronResource) Apply(ctx context.Context, plan *Plan, apply bool) error
 	return nil
 }
 
+// Revert removes the cron entry if it was added, or restores its previous
+// schedule and command
+func (r *CronResource) Revert(ctx context.Context, before *State) error {
+	cronUser := r.getUser()
+	marker := r.marker()
+
+	cmd := exec.CommandContext(ctx, "crontab", "-u", cronUser, "-l")
+	output, _ := cmd.Output()
+
+	var entry string
+	if before.Exists {
+		schedule, _ := before.Attributes["schedule"].(string)
+		command, _ := before.Attributes["command"].(string)
+		entry = fmt.Sprintf("%s %s %s", schedule, command, marker)
+	}
+
+	var lines []string
+	if existing := strings.TrimSuffix(string(output), "\n"); existing != "" {
+		lines = strings.Split(existing, "\n")
+	}
+
+	var newLines []string
+	for _, line := range lines {
+		if !strings.Contains(line, marker) {
+			newLines = append(newLines, line)
+			continue
+		}
+		if entry != "" {
+			newLines = append(newLines, entry)
+			entry = ""
+		}
+	}
+	if entry != "" {
+		newLines = append(newLines, entry)
+	}
+
+	return r.writeCrontab(ctx, cronUser, strings.Join(newLines, "\n")+"\n")
+}
+
 func (r *CronResource) writeCrontab(ctx context.Context, cronUser, content string) error {
 	// Write to a temp file
 	tmpfile, err := os.CreateTemp("", "crontab")
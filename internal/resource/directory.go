@@ -2,9 +2,11 @@ package resource
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
 	"strconv"
 	"syscall"
@@ -12,10 +14,12 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
 	Register("directory", NewDirectoryResource)
+	RegisterPrunable("directory", "path")
 }
 
 // DirectoryResource manages directory resources
@@ -24,6 +28,7 @@ type DirectoryResource struct {
 	description string
 	config      config.DirectoryResourceConfig
 	dependsOn   []string
+	ctx         *hcl.EvalContext
 }
 
 // NewDirectoryResource creates a new directory resource from HCL
@@ -39,6 +44,7 @@ func NewDirectoryResource(name string, body hcl.Body, dependsOn []string, descri
 		description: description,
 		config:      cfg,
 		dependsOn:   dependsOn,
+		ctx:         ctx,
 	}, nil
 }
 
@@ -50,6 +56,14 @@ func (r *DirectoryResource) Validate() error {
 	if r.config.Path == "" {
 		return fmt.Errorf("directory.%s: path is required", r.name)
 	}
+	if r.config.Source == nil && (r.templates() || r.purge() || len(r.config.Ignore) > 0) {
+		return fmt.Errorf("directory.%s: templates, purge and ignore require source", r.name)
+	}
+	for _, pattern := range r.config.Ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("directory.%s: invalid glob %q", r.name, pattern)
+		}
+	}
 	return nil
 }
 
@@ -57,6 +71,14 @@ func (r *DirectoryResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *DirectoryResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *DirectoryResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 func (r *DirectoryResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -95,6 +117,25 @@ func (r *DirectoryResource) Read(ctx context.Context) (*State, error) {
 }
 
 func (r *DirectoryResource) Diff(ctx context.Context, current *State) (*Plan, error) {
+	plan := r.diffDirectory(current)
+	if r.config.Source == nil || (r.config.Ensure != nil && *r.config.Ensure == "absent") {
+		return plan, nil
+	}
+
+	// Add a change for each file the source tree creates, updates or purges
+	changes, err := r.syncChanges()
+	if err != nil {
+		return nil, fmt.Errorf("directory.%s: %w", r.name, err)
+	}
+	plan.Changes = append(plan.Changes, changes...)
+	if len(changes) > 0 && plan.Action == ActionNoop {
+		plan.Action = ActionUpdate
+	}
+	return plan, nil
+}
+
+// diffDirectory compares the directory itself, without its contents
+func (r *DirectoryResource) diffDirectory(current *State) *Plan {
 	plan := &Plan{
 		Before: current,
 		After:  NewState(),
@@ -115,7 +156,7 @@ func (r *DirectoryResource) Diff(ctx context.Context, current *State) (*Plan, er
 				New:       nil,
 			})
 		}
-		return plan, nil
+		return plan
 	}
 
 	// Directory doesn't exist - create it
@@ -151,7 +192,7 @@ func (r *DirectoryResource) Diff(ctx context.Context, current *State) (*Plan, er
 			Old:       nil,
 			New:       mode,
 		})
-		return plan, nil
+		return plan
 	}
 
 	// Directory exists - check for changes
@@ -201,7 +242,7 @@ func (r *DirectoryResource) Diff(ctx context.Context, current *State) (*Plan, er
 		plan.Action = ActionUpdate
 	}
 
-	return plan, nil
+	return plan
 }
 
 func (r *DirectoryResource) Apply(ctx context.Context, plan *Plan, apply bool) error {
@@ -218,7 +259,15 @@ func (r *DirectoryResource) Apply(ctx context.Context, plan *Plan, apply bool) e
 		if recursive {
 			return os.RemoveAll(r.config.Path)
 		}
-		return os.Remove(r.config.Path)
+		// A directory that is not empty is left alone, as when it is pruned
+		// with files that are not managed by hostcfg in it
+		if err := os.Remove(r.config.Path); err != nil {
+			if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+				return &SkipError{Reason: "directory is not empty"}
+			}
+			return err
+		}
+		return nil
 
 	case ActionCreate:
 		mode := os.FileMode(0755)
@@ -245,15 +294,43 @@ func (r *DirectoryResource) Apply(ctx context.Context, plan *Plan, apply bool) e
 			}
 		}
 
-		return r.applyOwnershipAndMode()
+		if err := r.applyOwnershipAndMode(); err != nil {
+			return err
+		}
+		return r.sync()
 
 	case ActionUpdate:
-		return r.applyOwnershipAndMode()
+		if err := r.applyOwnershipAndMode(); err != nil {
+			return err
+		}
+		return r.sync()
 	}
 
 	return nil
 }
 
+// Revert removes the directory if it was created, along with anything synced
+// into it, or restores its previous mode and ownership. A deleted directory is
+// recreated empty, and synced files are not restored.
+func (r *DirectoryResource) Revert(ctx context.Context, before *State) error {
+	if !before.Exists {
+		remove := os.Remove
+		if r.config.Source != nil {
+			remove = os.RemoveAll
+		}
+		if err := remove(r.config.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove directory: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(r.config.Path, 0755); err != nil {
+		return fmt.Errorf("failed to recreate directory: %w", err)
+	}
+
+	return restorePathAttributes(r.config.Path, before)
+}
+
 func (r *DirectoryResource) applyOwnershipAndMode() error {
 	// Set ownership
 	if r.config.Owner != nil || r.config.Group != nil {
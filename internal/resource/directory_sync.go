@@ -0,0 +1,359 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// syncEntry is a file or directory of the source tree, as it is copied into
+// the managed directory
+type syncEntry struct {
+	rel     string // slash separated path relative to the directory
+	dir     bool
+	content []byte
+	mode    os.FileMode
+}
+
+func (r *DirectoryResource) templates() bool {
+	return r.config.Templates != nil && *r.config.Templates
+}
+
+func (r *DirectoryResource) purge() bool {
+	return r.config.Purge != nil && *r.config.Purge
+}
+
+// sourceTree returns the entries of the source tree that are not ignored,
+// parents before their children. Templates are rendered, and lose their
+// .tpl extension.
+func (r *DirectoryResource) sourceTree() ([]*syncEntry, error) {
+	source := *r.config.Source
+	var entries []*syncEntry
+	seen := make(map[string]bool)
+
+	err := filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matchGlobs(r.config.Ignore, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Symlinks in the source are followed
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		e := &syncEntry{rel: rel, mode: info.Mode().Perm()}
+		switch {
+		case info.IsDir():
+			e.dir = true
+		case r.templates() && strings.HasSuffix(rel, ".tpl"):
+			e.rel = strings.TrimSuffix(rel, ".tpl")
+			if e.content, err = r.renderTemplate(p); err != nil {
+				return err
+			}
+		default:
+			if e.content, err = os.ReadFile(p); err != nil {
+				return err
+			}
+		}
+
+		if seen[e.rel] {
+			return fmt.Errorf("more than one file in %s becomes %s", source, e.rel)
+		}
+		seen[e.rel] = true
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source: %w", err)
+	}
+	return entries, nil
+}
+
+// renderTemplate renders a template in the source tree with the template()
+// function of the configuration, so it sees the same variables
+func (r *DirectoryResource) renderTemplate(path string) ([]byte, error) {
+	if r.ctx == nil {
+		return nil, fmt.Errorf("cannot render %s: templates are not available here", path)
+	}
+	fn, ok := r.ctx.Functions["template"]
+	if !ok {
+		return nil, fmt.Errorf("cannot render %s: templates are not available here", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	val, err := fn.Call([]cty.Value{cty.StringVal(abs)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", path, err)
+	}
+	val, _ = val.Unmark()
+	return []byte(val.AsString()), nil
+}
+
+// syncValue describes a file by a short hash of its content, so that plans
+// show which files change without their content
+func syncValue(e *syncEntry) string {
+	if e.dir {
+		return "(directory)"
+	}
+	return contentHash(e.content)
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// syncChanges returns a change for each file that copying the source tree
+// would create or update, including its mode and ownership, and for each file
+// purge would delete. The attribute of each change is the file's path.
+func (r *DirectoryResource) syncChanges() ([]Change, error) {
+	entries, err := r.sourceTree()
+	if err != nil {
+		return nil, err
+	}
+	uid, gid, err := lookupOwnership(r.config.Owner, r.config.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, e := range entries {
+		target := filepath.Join(r.config.Path, filepath.FromSlash(e.rel))
+		info, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			changes = append(changes, Change{Attribute: target, Old: nil, New: syncValue(e)})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case e.dir && !info.IsDir():
+			return nil, fmt.Errorf("%s is not a directory", target)
+		case !e.dir && !info.Mode().IsRegular():
+			return nil, fmt.Errorf("%s is not a regular file", target)
+		}
+
+		if !e.dir {
+			current, err := os.ReadFile(target)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(current, e.content) {
+				changes = append(changes, Change{Attribute: target, Old: contentHash(current), New: syncValue(e)})
+				continue
+			}
+		}
+		if info.Mode().Perm() != e.mode {
+			changes = append(changes, Change{
+				Attribute: target,
+				Old:       fmt.Sprintf("%04o", info.Mode().Perm()),
+				New:       fmt.Sprintf("%04o", e.mode),
+			})
+		} else if old, new, drift := ownershipDrift(info, uid, gid); drift {
+			changes = append(changes, Change{Attribute: target, Old: old, New: new})
+		}
+	}
+
+	if r.purge() {
+		unmanaged, err := r.unmanagedPaths(entries)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range unmanaged {
+			old := "(directory)"
+			if content, err := os.ReadFile(target); err == nil {
+				old = contentHash(content)
+			}
+			changes = append(changes, Change{Attribute: target, Old: old, New: nil})
+		}
+	}
+	return changes, nil
+}
+
+// unmanagedPaths returns the files and directories in the directory that are
+// neither in the source tree nor ignored. Directories are returned without
+// their contents.
+func (r *DirectoryResource) unmanagedPaths(entries []*syncEntry) ([]string, error) {
+	managed := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		managed[e.rel] = true
+	}
+
+	var unmanaged []string
+	err := filepath.WalkDir(r.config.Path, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == r.config.Path {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(r.config.Path, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if managed[rel] {
+			return nil
+		}
+		if !matchGlobs(r.config.Ignore, rel) {
+			unmanaged = append(unmanaged, p)
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return unmanaged, err
+}
+
+// sync copies the source tree into the directory, leaving files that are
+// already up to date alone, and purges unmanaged files if enabled
+func (r *DirectoryResource) sync() error {
+	if r.config.Source == nil {
+		return nil
+	}
+	entries, err := r.sourceTree()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := lookupOwnership(r.config.Owner, r.config.Group)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		target := filepath.Join(r.config.Path, filepath.FromSlash(e.rel))
+		if e.dir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			if err := os.Chmod(target, e.mode); err != nil {
+				return fmt.Errorf("failed to set mode: %w", err)
+			}
+		} else if err := syncFile(target, e); err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+		if uid != -1 || gid != -1 {
+			if err := os.Lchown(target, uid, gid); err != nil {
+				return fmt.Errorf("failed to set ownership: %w", err)
+			}
+		}
+	}
+
+	if !r.purge() {
+		return nil
+	}
+	unmanaged, err := r.unmanagedPaths(entries)
+	if err != nil {
+		return err
+	}
+	for _, target := range unmanaged {
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to purge %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// syncFile writes a file of the source tree to target, through a temporary
+// file renamed over it, unless target already has its content and mode
+func syncFile(target string, e *syncEntry) error {
+	if info, err := os.Lstat(target); err == nil && info.Mode().IsRegular() && info.Mode().Perm() == e.mode {
+		if current, err := os.ReadFile(target); err == nil && bytes.Equal(current, e.content) {
+			return nil
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(target), ".hostcfg-sync-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		if tmpPath != "" {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	_, err = tmpFile.Write(e.content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, e.mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		return err
+	}
+	tmpPath = ""
+	return nil
+}
+
+// ownershipDrift returns the current and desired ownership of a file as
+// "uid:gid", and whether they differ. A uid or gid of -1 is not checked.
+func ownershipDrift(info os.FileInfo, uid, gid int) (string, string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || (uid == -1 && gid == -1) {
+		return "", "", false
+	}
+	wantUID, wantGID := int(stat.Uid), int(stat.Gid)
+	if uid != -1 {
+		wantUID = uid
+	}
+	if gid != -1 {
+		wantGID = gid
+	}
+	if wantUID == int(stat.Uid) && wantGID == int(stat.Gid) {
+		return "", "", false
+	}
+	return fmt.Sprintf("%d:%d", stat.Uid, stat.Gid), fmt.Sprintf("%d:%d", wantUID, wantGID), true
+}
+
+// lookupOwnership returns the uid and gid of owner and group, -1 for those
+// that are not set
+func lookupOwnership(owner, group *string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != nil {
+		u, err := user.Lookup(*owner)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown user: %s", *owner)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if group != nil {
+		g, err := user.LookupGroup(*group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown group: %s", *group)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
@@ -2,12 +2,17 @@ package resource
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func parseDirHCL(t *testing.T, src string) hcl.Body {
@@ -61,6 +66,33 @@ func TestDirectoryResource_Validate(t *testing.T) {
 			hcl:     `mode = "0755"`,
 			wantErr: true,
 		},
+		{
+			name: "valid with source",
+			hcl: `
+				path   = "/tmp/test"
+				source = "files/conf.d"
+				purge  = true
+				ignore = ["*.local"]
+			`,
+			wantErr: false,
+		},
+		{
+			name: "purge without source",
+			hcl: `
+				path  = "/tmp/test"
+				purge = true
+			`,
+			wantErr: true,
+		},
+		{
+			name: "invalid ignore glob",
+			hcl: `
+				path   = "/tmp/test"
+				source = "files/conf.d"
+				ignore = ["[conf"]
+			`,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -417,6 +449,42 @@ func TestDirectoryResource_Apply_Delete(t *testing.T) {
 	}
 }
 
+func TestDirectoryResource_Apply_DeleteNotEmpty(t *testing.T) {
+	tmpDir := t.TempDir()
+	dirPath := filepath.Join(tmpDir, "deleted")
+	filePath := filepath.Join(dirPath, "file.txt")
+
+	if err := os.Mkdir(dirPath, 0755); err != nil {
+		t.Fatalf("failed to create test directory: %v", err)
+	}
+	if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	body := parseDirHCL(t, `
+		path   = "`+dirPath+`"
+		ensure = "absent"
+	`)
+
+	r, err := NewDirectoryResource("test", body, nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, _ := r.Diff(ctx, current)
+
+	err = r.Apply(ctx, plan, true)
+	var skip *SkipError
+	if !errors.As(err, &skip) {
+		t.Fatalf("expected the delete to be skipped, got: %v", err)
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Errorf("expected the directory to be left in place: %v", err)
+	}
+}
+
 func TestDirectoryResource_Apply_DeleteRecursive(t *testing.T) {
 	tmpDir := t.TempDir()
 	dirPath := filepath.Join(tmpDir, "deleted")
@@ -553,3 +621,210 @@ func TestDirectoryResource_Idempotent(t *testing.T) {
 		t.Errorf("second run should be noop, got %v", plan2.Action)
 	}
 }
+
+func TestDirectoryResource_Revert(t *testing.T) {
+	tmpDir := t.TempDir()
+	dirPath := filepath.Join(tmpDir, "created")
+
+	body := parseDirHCL(t, `
+		path = "`+dirPath+`"
+	`)
+	r, err := NewDirectoryResource("test", body, nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+
+	ctx := context.Background()
+	before, err := r.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	plan, err := r.Diff(ctx, before)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	if err := r.(Reversible).Revert(ctx, before); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if _, err := os.Stat(dirPath); !os.IsNotExist(err) {
+		t.Error("expected created directory to be removed")
+	}
+}
+
+// writeTree writes files, given by slash separated paths, under dir
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+}
+
+// syncChangeSet maps the path of each file change in a plan, relative to
+// dir, to its kind of change
+func syncChangeSet(t *testing.T, plan *Plan, dir string) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	for _, c := range plan.Changes {
+		rel, err := filepath.Rel(dir, c.Attribute)
+		if err != nil || !filepath.IsAbs(c.Attribute) || rel == "." {
+			continue
+		}
+		switch {
+		case c.Old == nil:
+			got[filepath.ToSlash(rel)] = "create"
+		case c.New == nil:
+			got[filepath.ToSlash(rel)] = "delete"
+		default:
+			got[filepath.ToSlash(rel)] = "update"
+		}
+	}
+	return got
+}
+
+func TestDirectoryResource_Sync(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "conf.d")
+	dirPath := filepath.Join(tmpDir, "dest")
+	writeTree(t, source, map[string]string{
+		"app.conf":             "port = 80\n",
+		"vhosts/site.conf.tpl": "server_name {{ .var.domain }};\n",
+	})
+
+	p := config.NewParser()
+	p.SetVariableValue("domain", cty.StringVal("example.com"))
+	r, err := NewDirectoryResource("conf", parseDirHCL(t, `
+		path      = "`+dirPath+`"
+		source    = "`+source+`"
+		templates = true
+	`), nil, "", p.GetEvalContext())
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	want := map[string]string{"app.conf": "create", "vhosts": "create", "vhosts/site.conf": "create"}
+	if plan.Action != ActionCreate || !reflect.DeepEqual(syncChangeSet(t, plan, dirPath), want) {
+		t.Errorf("expected to create %v, got %s %+v", want, plan.Action, plan.Changes)
+	}
+
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dirPath, "vhosts", "site.conf")); string(data) != "server_name example.com;\n" {
+		t.Errorf("expected the template to be rendered, got %q", data)
+	}
+
+	current, _ = r.Read(ctx)
+	plan, _ = r.Diff(ctx, current)
+	if plan.HasChanges() {
+		t.Errorf("expected no changes on the second run, got %+v", plan.Changes)
+	}
+
+	writeTree(t, source, map[string]string{"app.conf": "port = 8080\n"})
+	current, _ = r.Read(ctx)
+	plan, _ = r.Diff(ctx, current)
+	want = map[string]string{"app.conf": "update"}
+	if plan.Action != ActionUpdate || !reflect.DeepEqual(syncChangeSet(t, plan, dirPath), want) {
+		t.Errorf("expected to update %v, got %s %+v", want, plan.Action, plan.Changes)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dirPath, "app.conf")); string(data) != "port = 8080\n" {
+		t.Errorf("expected app.conf to be updated, got %q", data)
+	}
+
+	// A subdirectory whose mode drifted shows in the plan
+	vhosts := filepath.Join(dirPath, "vhosts")
+	info, _ := os.Stat(filepath.Join(source, "vhosts"))
+	if err := os.Chmod(vhosts, 0700); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	current, _ = r.Read(ctx)
+	plan, _ = r.Diff(ctx, current)
+	wantChange := Change{Attribute: vhosts, Old: "0700", New: fmt.Sprintf("%04o", info.Mode().Perm())}
+	if plan.Action != ActionUpdate || len(plan.Changes) != 1 || plan.Changes[0] != wantChange {
+		t.Errorf("expected the mode of vhosts to change, got %s %+v", plan.Action, plan.Changes)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if info, _ := os.Stat(vhosts); fmt.Sprintf("%04o", info.Mode().Perm()) != wantChange.New {
+		t.Errorf("expected vhosts to have mode %s, got %04o", wantChange.New, info.Mode().Perm())
+	}
+}
+
+func TestDirectoryResource_SyncPurge(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "conf.d")
+	dirPath := filepath.Join(tmpDir, "dest")
+	writeTree(t, source, map[string]string{"app.conf": "port = 80\n"})
+	writeTree(t, dirPath, map[string]string{
+		"app.conf":      "port = 80\n",
+		"old.conf":      "stale\n",
+		"old/site.conf": "stale\n",
+		"site.local":    "kept\n",
+	})
+
+	r, _ := NewDirectoryResource("conf", parseDirHCL(t, `
+		path   = "`+dirPath+`"
+		source = "`+source+`"
+		purge  = true
+		ignore = ["*.local"]
+	`), nil, "", nil)
+
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	want := map[string]string{"old.conf": "delete", "old": "delete"}
+	if plan.Action != ActionUpdate || !reflect.DeepEqual(syncChangeSet(t, plan, dirPath), want) {
+		t.Errorf("expected to delete %v, got %s %+v", want, plan.Action, plan.Changes)
+	}
+
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	for _, rel := range []string{"old.conf", "old"} {
+		if _, err := os.Stat(filepath.Join(dirPath, rel)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be purged", rel)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dirPath, "site.local")); err != nil {
+		t.Errorf("expected the ignored file to be kept: %v", err)
+	}
+}
+
+func TestDirectoryResource_SyncTemplatesWithoutContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "conf.d")
+	writeTree(t, source, map[string]string{"app.conf.tpl": "{{ .var.port }}\n"})
+
+	r, _ := NewDirectoryResource("conf", parseDirHCL(t, `
+		path      = "`+filepath.Join(tmpDir, "dest")+`"
+		source    = "`+source+`"
+		templates = true
+	`), nil, "", nil)
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	if _, err := r.Diff(ctx, current); err == nil {
+		t.Error("expected error rendering templates without an evaluation context")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
@@ -75,6 +76,25 @@ func (r *DownloadResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *DownloadResource) AttributeSchema() map[string]Attribute {
+	return mergeAttributes(configAttributes(r.config), map[string]Attribute{
+		"sha256": {Type: cty.String, Computed: true, KnownAfterApply: true},
+	})
+}
+
+// ExportedAttributes exports the configuration and the sha256 of the
+// downloaded file, which is null until it has been downloaded
+func (r *DownloadResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	values := configValues(r.config)
+	sum, err := computeFileChecksum(r.config.Dest, "sha256")
+	if err == nil {
+		values["sha256"] = cty.StringVal(sum)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("download.%s: %w", r.name, err)
+	}
+	return values, nil
+}
+
 func (r *DownloadResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -1,17 +1,21 @@
 package resource
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
 	"strconv"
+	"sync"
 	"syscall"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
@@ -24,6 +28,8 @@ type ExecResource struct {
 	description string
 	config      config.ExecResourceConfig
 	dependsOn   []string
+
+	stdout *string // output of the command once it has run
 }
 
 // NewExecResource creates a new exec resource from HCL
@@ -57,6 +63,24 @@ func (r *ExecResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *ExecResource) AttributeSchema() map[string]Attribute {
+	return mergeAttributes(configAttributes(r.config), map[string]Attribute{
+		"stdout": {Type: cty.String, Computed: true, KnownAfterApply: true},
+	})
+}
+
+// ExportedAttributes exports the configuration and the command's stdout,
+// which is null unless the command ran during this apply. Output of earlier
+// runs is not kept, so an exec that creates, only_if or unless stops from
+// running exports null.
+func (r *ExecResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	values := configValues(r.config)
+	if r.stdout != nil {
+		values["stdout"] = cty.StringVal(*r.stdout)
+	}
+	return values, nil
+}
+
 func (r *ExecResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -171,10 +195,36 @@ func (r *ExecResource) Apply(ctx context.Context, plan *Plan, apply bool) error
 		}
 	}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("command failed: %w\nOutput: %s", err, string(output))
+	// Errors show stdout and stderr interleaved as they were written, while
+	// only stdout is exported
+	var stdout bytes.Buffer
+	var combined outputBuffer
+	cmd.Stdout = io.MultiWriter(&stdout, &combined)
+	cmd.Stderr = &combined
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command failed: %w\nOutput: %s", err, combined.String())
 	}
 
+	output := stdout.String()
+	r.stdout = &output
 	return nil
 }
+
+// outputBuffer is a buffer that a command's stdout and stderr can write to at
+// the same time
+type outputBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
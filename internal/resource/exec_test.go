@@ -0,0 +1,80 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// runExec plans and applies an exec resource, returning its exported stdout
+// and the error from applying it
+func runExec(t *testing.T, src string) (cty.Value, error) {
+	t.Helper()
+	r, err := NewExecResource("test", parseFileHCL(t, src), nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	ctx := context.Background()
+	current, err := r.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	applyErr := r.Apply(ctx, plan, true)
+
+	attrs, err := ExportValues(ctx, r.(Exporter))
+	if err != nil {
+		t.Fatalf("ExportValues failed: %v", err)
+	}
+	return attrs["stdout"], applyErr
+}
+
+func TestExecResource_ExportsStdout(t *testing.T) {
+	stdout, err := runExec(t, `command = "echo out; echo err >&2"`)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !stdout.RawEquals(cty.StringVal("out\n")) {
+		t.Errorf("expected only stdout to be exported, got %#v", stdout)
+	}
+}
+
+func TestExecResource_NotRunExportsNull(t *testing.T) {
+	creates := filepath.Join(t.TempDir(), "done")
+	if err := os.WriteFile(creates, nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	stdout, err := runExec(t, `
+		command = "echo out"
+		creates = "`+creates+`"
+	`)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !stdout.IsNull() {
+		t.Errorf("expected stdout to be null when the command did not run, got %#v", stdout)
+	}
+}
+
+func TestExecResource_FailureShowsOutputInOrder(t *testing.T) {
+	// The pauses let each write reach the output before the next one
+	_, err := runExec(t, `command = "echo out1; sleep 0.1; echo err1 >&2; sleep 0.1; echo out2; exit 3"`)
+	if err == nil {
+		t.Fatal("expected the command to fail")
+	}
+	if !strings.Contains(err.Error(), "out1\nerr1\nout2\n") {
+		t.Errorf("expected stdout and stderr in the order they were written, got %q", err)
+	}
+}
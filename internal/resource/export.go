@@ -0,0 +1,154 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// Attribute describes an attribute a resource exports to other resources
+type Attribute struct {
+	Type cty.Type
+
+	// Computed attributes are set by the resource rather than its
+	// configuration, such as a downloaded file's checksum
+	Computed bool
+
+	// KnownAfterApply attributes are unknown while the resource has changes
+	// to apply, such as the output of a command that has not run yet
+	KnownAfterApply bool
+}
+
+// Exporter is implemented by resources whose attributes can be referenced
+// by other resources, such as ${file.config.path}
+type Exporter interface {
+	Resource
+
+	// AttributeSchema describes every attribute the resource exports
+	AttributeSchema() map[string]Attribute
+
+	// ExportedAttributes returns the values of the exported attributes.
+	// Attributes missing from the result are null.
+	// KnownAfterApply attributes are only used once the resource has nothing
+	// left to apply.
+	ExportedAttributes(ctx context.Context) (map[string]cty.Value, error)
+}
+
+// ExportValues returns every attribute in an exporter's schema, converted to
+// its declared type, with null for attributes that have no value
+func ExportValues(ctx context.Context, e Exporter) (map[string]cty.Value, error) {
+	schema := e.AttributeSchema()
+	values, err := e.ExportedAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range values {
+		if _, ok := schema[name]; !ok {
+			return nil, fmt.Errorf("%s: exported attribute %q is not in the schema", ID(e), name)
+		}
+	}
+
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make(map[string]cty.Value, len(schema))
+	for _, name := range names {
+		ty := schema[name].Type
+		val, ok := values[name]
+		if !ok || val.IsNull() {
+			attrs[name] = cty.NullVal(ty)
+			continue
+		}
+		converted, err := convert.Convert(val, ty)
+		if err != nil {
+			return nil, fmt.Errorf("%s: exported attribute %q: %w", ID(e), name, err)
+		}
+		attrs[name] = converted
+	}
+	return attrs, nil
+}
+
+// configAttributes returns the schema of the attributes in a gohcl-tagged
+// configuration struct
+func configAttributes(cfg interface{}) map[string]Attribute {
+	attrs := make(map[string]Attribute)
+	t := reflect.TypeOf(cfg)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := configAttributeName(field)
+		if !ok {
+			continue
+		}
+		ty, err := gocty.ImpliedType(reflect.Zero(field.Type).Interface())
+		if err != nil {
+			panic(fmt.Sprintf("config field %s: %s", field.Name, err))
+		}
+		attrs[name] = Attribute{Type: ty}
+	}
+	return attrs
+}
+
+// configValues returns the values of the attributes set in a gohcl-tagged
+// configuration struct. Optional attributes that are not set are omitted.
+func configValues(cfg interface{}) map[string]cty.Value {
+	values := make(map[string]cty.Value)
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := configAttributeName(field)
+		if !ok {
+			continue
+		}
+		fv := v.Field(i)
+		if (fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Slice) && fv.IsNil() {
+			continue
+		}
+		ty, err := gocty.ImpliedType(fv.Interface())
+		if err != nil {
+			panic(fmt.Sprintf("config field %s: %s", field.Name, err))
+		}
+		val, err := gocty.ToCtyValue(fv.Interface(), ty)
+		if err != nil {
+			panic(fmt.Sprintf("config field %s: %s", field.Name, err))
+		}
+		values[name] = val
+	}
+	return values
+}
+
+// configAttributeName returns the HCL attribute name of a struct field, and
+// false for fields that are not attributes (labels, blocks and the remain body)
+func configAttributeName(field reflect.StructField) (string, bool) {
+	tag, ok := field.Tag.Lookup("hcl")
+	if !ok {
+		return "", false
+	}
+	name, kind, _ := strings.Cut(tag, ",")
+	if name == "" || (kind != "" && kind != "optional") {
+		return "", false
+	}
+	return name, true
+}
+
+// mergeAttributes returns a new map holding the entries of every map, later
+// maps taking precedence
+func mergeAttributes[V any](maps ...map[string]V) map[string]V {
+	merged := make(map[string]V)
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
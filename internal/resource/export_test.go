@@ -0,0 +1,105 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestExportValues_Config(t *testing.T) {
+	body := parseStatHCL(t, `
+		name    = "ops"
+		members = ["alice", "bob"]
+	`)
+	r, err := NewGroupResource("ops", body, nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+
+	attrs, err := ExportValues(context.Background(), r.(Exporter))
+	if err != nil {
+		t.Fatalf("ExportValues failed: %v", err)
+	}
+
+	want := map[string]cty.Value{
+		"name":    cty.StringVal("ops"),
+		"members": cty.ListVal([]cty.Value{cty.StringVal("alice"), cty.StringVal("bob")}),
+		"gid":     cty.NullVal(cty.String),
+		"system":  cty.NullVal(cty.Bool),
+		"ensure":  cty.NullVal(cty.String),
+	}
+	if len(attrs) != len(want) {
+		t.Errorf("expected %d attributes, got %d: %#v", len(want), len(attrs), attrs)
+	}
+	for name, w := range want {
+		if got, ok := attrs[name]; !ok || !got.RawEquals(w) {
+			t.Errorf("attribute %s: expected %#v, got %#v", name, w, got)
+		}
+	}
+}
+
+func TestExportValues_StatComputed(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "file.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	for _, tt := range []struct {
+		path       string
+		wantExists bool
+		wantSize   int64
+		wantUID    bool
+	}{
+		{path, true, 5, true},
+		{filepath.Join(tmpDir, "missing"), false, 0, false},
+	} {
+		r, err := NewStatResource("test", parseStatHCL(t, `path = "`+tt.path+`"`), nil, "", nil)
+		if err != nil {
+			t.Fatalf("failed to create resource: %v", err)
+		}
+		e := r.(Exporter)
+		if !e.AttributeSchema()["size"].Computed {
+			t.Error("expected size to be computed")
+		}
+
+		attrs, err := ExportValues(context.Background(), e)
+		if err != nil {
+			t.Fatalf("ExportValues failed: %v", err)
+		}
+		if attrs["exists"].True() != tt.wantExists {
+			t.Errorf("%s: expected exists=%v", tt.path, tt.wantExists)
+		}
+		if !attrs["size"].RawEquals(cty.NumberIntVal(tt.wantSize)) {
+			t.Errorf("%s: expected size %d, got %#v", tt.path, tt.wantSize, attrs["size"])
+		}
+		if (attrs["uid"].AsBigFloat().Sign() >= 0) != tt.wantUID {
+			t.Errorf("%s: unexpected uid %#v", tt.path, attrs["uid"])
+		}
+		if !attrs["follow"].IsNull() {
+			t.Errorf("%s: expected unset follow to be null, got %#v", tt.path, attrs["follow"])
+		}
+	}
+}
+
+// badExporter exports an attribute missing from its schema
+type badExporter struct{ testResource }
+
+func (r *badExporter) AttributeSchema() map[string]Attribute {
+	return map[string]Attribute{"name": {Type: cty.String}}
+}
+
+func (r *badExporter) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return map[string]cty.Value{"nmae": cty.StringVal("x")}, nil
+}
+
+func TestExportValues_UnknownAttribute(t *testing.T) {
+	_, err := ExportValues(context.Background(), &badExporter{testResource{typ: "test", name: "bad"}})
+	if err == nil || !strings.Contains(err.Error(), `"nmae" is not in the schema`) {
+		t.Errorf("expected schema error, got: %v", err)
+	}
+}
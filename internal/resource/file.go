@@ -1,22 +1,29 @@
 package resource
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/user"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
 	Register("file", NewFileResource)
+	RegisterPrunable("file", "path")
 }
 
 // FileResource manages file resources
@@ -63,6 +70,9 @@ func (r *FileResource) Validate() error {
 	if r.config.Content != nil && r.config.Source != nil {
 		return fmt.Errorf("file.%s: cannot specify both content and source", r.name)
 	}
+	if r.config.ValidateCmd != nil && !strings.Contains(*r.config.ValidateCmd, "%s") {
+		return fmt.Errorf("file.%s: validate_cmd must contain %%s for the path of the file to validate", r.name)
+	}
 	return nil
 }
 
@@ -70,6 +80,14 @@ func (r *FileResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *FileResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *FileResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 func (r *FileResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -142,11 +160,17 @@ func (r *FileResource) Diff(ctx context.Context, current *State) (*Plan, error)
 		return nil, err
 	}
 
+	// The desired content is only recorded as a hash, which pins it in
+	// saved plans
+	desiredHash := sha256.Sum256([]byte(desiredContent))
+	desiredHashStr := hex.EncodeToString(desiredHash[:])
+
 	// File doesn't exist - create it
 	if !current.Exists {
 		plan.Action = ActionCreate
 		plan.After.Exists = true
 		plan.After.Attributes["path"] = r.config.Path
+		plan.After.Attributes["content_hash"] = desiredHashStr
 		plan.Changes = append(plan.Changes, Change{
 			Attribute: "path",
 			Old:       nil,
@@ -186,8 +210,7 @@ func (r *FileResource) Diff(ctx context.Context, current *State) (*Plan, error)
 	}
 
 	// Check content
-	desiredHash := sha256.Sum256([]byte(desiredContent))
-	desiredHashStr := hex.EncodeToString(desiredHash[:])
+	plan.After.Attributes["content_hash"] = desiredHashStr
 	if currentHash, ok := current.Attributes["content_hash"].(string); ok {
 		if currentHash != desiredHashStr {
 			plan.Changes = append(plan.Changes, Change{
@@ -251,63 +274,198 @@ func (r *FileResource) Apply(ctx context.Context, plan *Plan, apply bool) error
 		return os.Remove(r.config.Path)
 
 	case ActionCreate, ActionUpdate:
-		// Write content
 		content, err := r.getDesiredContent()
 		if err != nil {
 			return err
 		}
+		return r.writeFile(ctx, []byte(content))
+	}
 
-		mode := os.FileMode(0644)
-		if r.config.Mode != nil {
-			parsed, err := strconv.ParseUint(*r.config.Mode, 8, 32)
-			if err != nil {
-				return fmt.Errorf("invalid mode: %w", err)
-			}
-			mode = os.FileMode(parsed)
+	return nil
+}
+
+// writeFile writes content to a temporary file next to the target, sets its
+// mode and ownership, validates it and renames it over the target, so the
+// target is never left partly written or replaced by invalid content
+func (r *FileResource) writeFile(ctx context.Context, content []byte) error {
+	mode := os.FileMode(0644)
+	uid, gid := -1, -1
+
+	// A symlink is written through: the file it points to is replaced, next
+	// to where it is
+	path := r.config.Path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	// Keep the mode and ownership of an existing file unless they are set
+	existing, err := os.Stat(path)
+	if err == nil {
+		mode = existing.Mode().Perm()
+		if stat, ok := existing.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
 		}
+	}
 
-		if err := os.WriteFile(r.config.Path, []byte(content), mode); err != nil {
-			return fmt.Errorf("failed to write file: %w", err)
+	if r.config.Mode != nil {
+		parsed, err := strconv.ParseUint(*r.config.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode: %w", err)
 		}
+		mode = os.FileMode(parsed)
+	}
+	if r.config.Owner != nil {
+		u, err := user.Lookup(*r.config.Owner)
+		if err != nil {
+			return fmt.Errorf("unknown user: %s", *r.config.Owner)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if r.config.Group != nil {
+		g, err := user.LookupGroup(*r.config.Group)
+		if err != nil {
+			return fmt.Errorf("unknown group: %s", *r.config.Group)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
 
-		// Set ownership
-		if r.config.Owner != nil || r.config.Group != nil {
-			uid := -1
-			gid := -1
-
-			if r.config.Owner != nil {
-				u, err := user.Lookup(*r.config.Owner)
-				if err != nil {
-					return fmt.Errorf("unknown user: %s", *r.config.Owner)
-				}
-				uid, _ = strconv.Atoi(u.Uid)
-			}
-
-			if r.config.Group != nil {
-				g, err := user.LookupGroup(*r.config.Group)
-				if err != nil {
-					return fmt.Errorf("unknown group: %s", *r.config.Group)
-				}
-				gid, _ = strconv.Atoi(g.Gid)
-			}
-
-			if err := os.Chown(r.config.Path, uid, gid); err != nil {
-				return fmt.Errorf("failed to set ownership: %w", err)
-			}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".hostcfg-*")
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		// Clean up temp file on error
+		if tmpPath != "" {
+			_ = os.Remove(tmpPath)
 		}
+	}()
 
-		// Set mode (again, in case it was overwritten by umask during create)
-		if r.config.Mode != nil {
-			parsed, _ := strconv.ParseUint(*r.config.Mode, 8, 32)
-			if err := os.Chmod(r.config.Path, os.FileMode(parsed)); err != nil {
-				return fmt.Errorf("failed to set mode: %w", err)
-			}
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return fmt.Errorf("failed to set mode: %w", err)
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(tmpPath, uid, gid); err != nil && (r.config.Owner != nil || r.config.Group != nil) {
+			return fmt.Errorf("failed to set ownership: %w", err)
 		}
 	}
 
+	if r.config.ValidateCmd != nil {
+		if err := validateFile(ctx, *r.config.ValidateCmd, tmpPath); err != nil {
+			return err
+		}
+	}
+
+	if existing != nil && r.config.Backup != nil && *r.config.Backup {
+		if err := backupFile(path, content); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	// Clear tmpPath so deferred cleanup doesn't remove the target
+	tmpPath = ""
+
 	return nil
 }
 
+// validateFile runs a validate_cmd against path, where %s in the command is
+// replaced by the quoted path
+func validateFile(ctx context.Context, command, path string) error {
+	quoted := "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	cmd := exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(command, "%s", quoted))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("validate_cmd failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// backupFile keeps the current content of path as path.<timestamp>.bak, or
+// path.<timestamp>.<n>.bak if that is taken, unless it is the same as the
+// content replacing it
+func backupFile(path string, replacement []byte) error {
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	if bytes.Equal(current, replacement) {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to back up file: %w", err)
+	}
+
+	stamp := path + "." + time.Now().Format("20060102150405")
+	for n := 0; ; n++ {
+		backup := stamp + ".bak"
+		if n > 0 {
+			backup = fmt.Sprintf("%s.%d.bak", stamp, n)
+		}
+
+		// A hard link keeps the replaced file's mode and ownership as they are
+		err := os.Link(path, backup)
+		if err == nil {
+			return nil
+		}
+		if os.IsExist(err) {
+			continue
+		}
+
+		f, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to back up file: %w", err)
+		}
+		_, err = f.Write(current)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to back up file: %w", err)
+		}
+		return nil
+	}
+}
+
+// Revert restores the file's previous content, mode and ownership, or removes
+// it if it did not exist
+func (r *FileResource) Revert(ctx context.Context, before *State) error {
+	if !before.Exists {
+		if err := os.Remove(r.config.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		return nil
+	}
+
+	content, ok := before.Attributes["content"].(string)
+	if !ok {
+		return fmt.Errorf("no backup of %s to restore", r.config.Path)
+	}
+	if err := os.WriteFile(r.config.Path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to restore file: %w", err)
+	}
+
+	return restorePathAttributes(r.config.Path, before)
+}
+
 func (r *FileResource) getDesiredContent() (string, error) {
 	if r.config.Content != nil {
 		return *r.config.Content, nil
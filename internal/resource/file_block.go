@@ -0,0 +1,238 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func init() {
+	Register("file_block", NewFileBlockResource)
+	RegisterPrunable("file_block", "path", "marker")
+}
+
+// FileBlockResource manages a block of lines in a file between BEGIN and END
+// marker lines, leaving the rest of the file alone
+type FileBlockResource struct {
+	name        string
+	description string
+	config      config.FileBlockResourceConfig
+	dependsOn   []string
+	undo        *fileEditUndo // what Apply replaced, for Revert
+}
+
+// NewFileBlockResource creates a new file_block resource from HCL
+func NewFileBlockResource(name string, body hcl.Body, dependsOn []string, description string, ctx *hcl.EvalContext) (Resource, error) {
+	var cfg config.FileBlockResourceConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to decode file_block resource: %s", diags.Error())
+	}
+
+	return &FileBlockResource{
+		name:        name,
+		description: description,
+		config:      cfg,
+		dependsOn:   dependsOn,
+	}, nil
+}
+
+func (r *FileBlockResource) Type() string        { return "file_block" }
+func (r *FileBlockResource) Name() string        { return r.name }
+func (r *FileBlockResource) Description() string { return r.description }
+
+func (r *FileBlockResource) Validate() error {
+	if r.config.Path == "" {
+		return fmt.Errorf("file_block.%s: path is required", r.name)
+	}
+	ensure := r.ensure()
+	if ensure != "present" && ensure != "absent" {
+		return fmt.Errorf("file_block.%s: ensure must be \"present\" or \"absent\"", r.name)
+	}
+	if ensure == "present" && r.config.Block == nil {
+		return fmt.Errorf("file_block.%s: block is required", r.name)
+	}
+	if !strings.Contains(r.marker(), "{mark}") {
+		return fmt.Errorf("file_block.%s: marker must contain {mark}", r.name)
+	}
+	if strings.Contains(r.marker(), "\n") {
+		return fmt.Errorf("file_block.%s: marker must be a single line", r.name)
+	}
+	return validateInsertPosition("file_block."+r.name, r.config.InsertAfter, r.config.InsertBefore)
+}
+
+func (r *FileBlockResource) Dependencies() []string {
+	return r.dependsOn
+}
+
+func (r *FileBlockResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *FileBlockResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
+func (r *FileBlockResource) ensure() string {
+	if r.config.Ensure != nil {
+		return *r.config.Ensure
+	}
+	return "present"
+}
+
+// marker returns the marker line template, which defaults to
+// "# {mark} hostcfg <name>" so several blocks can share a file
+func (r *FileBlockResource) marker() string {
+	if r.config.Marker != nil {
+		return *r.config.Marker
+	}
+	return "# {mark} hostcfg " + r.name
+}
+
+// findBlock returns the indexes of the BEGIN and END marker lines, or -1 if
+// the block is not in the file
+func (r *FileBlockResource) findBlock(lines []string) (int, int, error) {
+	begin := strings.ReplaceAll(r.marker(), "{mark}", "BEGIN")
+	end := strings.ReplaceAll(r.marker(), "{mark}", "END")
+
+	for i, line := range lines {
+		if strings.TrimRight(line, " \t") != begin {
+			continue
+		}
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimRight(lines[j], " \t") == end {
+				return i, j, nil
+			}
+		}
+		return -1, -1, fmt.Errorf("file_block.%s: %s has %q without %q", r.name, r.config.Path, begin, end)
+	}
+	return -1, -1, nil
+}
+
+// Read reports the block as existing when the file has its marker lines
+func (r *FileBlockResource) Read(ctx context.Context) (*State, error) {
+	state := NewState()
+
+	content, exists, err := readFileContent(r.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	state.Attributes["path"] = r.config.Path
+	state.Attributes["marker"] = r.marker()
+	if !exists {
+		return state, nil
+	}
+	state.Attributes["file_exists"] = true
+	state.Attributes["content_hash"] = fileContentHash(content)
+
+	lines, _ := splitLines(content)
+	start, _, err := r.findBlock(lines)
+	if err != nil {
+		return nil, err
+	}
+	state.Exists = start >= 0
+	return state, nil
+}
+
+func (r *FileBlockResource) Diff(ctx context.Context, current *State) (*Plan, error) {
+	content, fileExists, err := readEditedFile(current, r.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	present := r.ensure() == "present"
+
+	if !fileExists {
+		if !present {
+			return &Plan{Before: current, After: NewState()}, nil
+		}
+		if r.config.Create == nil || !*r.config.Create {
+			return nil, fmt.Errorf("file_block.%s: %s does not exist, set create = true to create it", r.name, r.config.Path)
+		}
+	}
+
+	desired, err := r.edit(content)
+	if err != nil {
+		return nil, err
+	}
+	return planFileEdit(current, r.config.Path, content, desired, present), nil
+}
+
+// edit returns content with the block added, updated or removed
+func (r *FileBlockResource) edit(content string) (string, error) {
+	lines, trailing := splitLines(content)
+	start, end, err := r.findBlock(lines)
+	if err != nil {
+		return "", err
+	}
+
+	if r.ensure() == "absent" {
+		if start < 0 {
+			return content, nil
+		}
+		return joinLines(append(lines[:start:start], lines[end+1:]...), trailing), nil
+	}
+
+	block := []string{strings.ReplaceAll(r.marker(), "{mark}", "BEGIN")}
+	if text := strings.TrimSuffix(*r.config.Block, "\n"); text != "" {
+		block = append(block, strings.Split(text, "\n")...)
+	}
+	block = append(block, strings.ReplaceAll(r.marker(), "{mark}", "END"))
+
+	if start >= 0 {
+		lines = append(lines[:start:start], append(block, lines[end+1:]...)...)
+		return joinLines(lines, trailing), nil
+	}
+
+	index, err := insertIndex(lines, r.config.InsertAfter, r.config.InsertBefore)
+	if err != nil {
+		return "", fmt.Errorf("file_block.%s: %w", r.name, err)
+	}
+	return joinLines(insertLines(lines, index, block...), trailing), nil
+}
+
+// Apply edits the file as it is when applied, so changes made to it by
+// resources applied earlier are kept
+func (r *FileBlockResource) Apply(ctx context.Context, plan *Plan, apply bool) error {
+	if !apply || !plan.HasChanges() {
+		return nil
+	}
+
+	unlock := lockFileEdit(r.config.Path)
+	defer unlock()
+
+	content, exists, err := readFileContent(r.config.Path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if r.ensure() == "absent" {
+			return nil
+		}
+		if r.config.Create == nil || !*r.config.Create {
+			return fmt.Errorf("%s does not exist", r.config.Path)
+		}
+	}
+	desired, err := r.edit(content)
+	if err != nil {
+		return err
+	}
+	if exists && desired == content {
+		return nil
+	}
+	if err := writeFileContent(r.config.Path, desired); err != nil {
+		return err
+	}
+	r.undo = &fileEditUndo{content: content, existed: exists}
+	return nil
+}
+
+// Revert restores the content Apply replaced, or removes the file if Apply
+// created it
+func (r *FileBlockResource) Revert(ctx context.Context, before *State) error {
+	return revertFileContent(r.config.Path, r.undo)
+}
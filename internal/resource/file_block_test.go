@@ -0,0 +1,206 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileBlockResource_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hcl     string
+		wantErr bool
+	}{
+		{
+			name: "valid block",
+			hcl: `
+				path  = "/etc/hosts"
+				block = "10.0.0.1 db\n"
+			`,
+		},
+		{
+			name: "absent without block",
+			hcl: `
+				path   = "/etc/hosts"
+				ensure = "absent"
+			`,
+		},
+		{
+			name: "missing block",
+			hcl: `
+				path = "/etc/hosts"
+			`,
+			wantErr: true,
+		},
+		{
+			name: "marker without mark",
+			hcl: `
+				path   = "/etc/hosts"
+				block  = "10.0.0.1 db\n"
+				marker = "# hostcfg"
+			`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewFileBlockResource("test", parseFileHCL(t, tt.hcl), nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			err = r.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileBlockResource_Edit(t *testing.T) {
+	const hosts = "127.0.0.1 localhost\n::1 localhost\n"
+	const managed = "127.0.0.1 localhost\n# BEGIN hostcfg hosts\n10.0.0.1 db\n# END hostcfg hosts\n::1 localhost\n"
+
+	tests := []struct {
+		name    string
+		hcl     string
+		content string
+		want    string
+		action  Action
+	}{
+		{
+			name:    "append",
+			hcl:     `block = "10.0.0.1 db\n"`,
+			content: hosts,
+			want:    hosts + "# BEGIN hostcfg hosts\n10.0.0.1 db\n# END hostcfg hosts\n",
+			action:  ActionCreate,
+		},
+		{
+			name: "insert after",
+			hcl: `
+				block        = "10.0.0.1 db"
+				insert_after = "^127"
+			`,
+			content: hosts,
+			want:    managed,
+			action:  ActionCreate,
+		},
+		{
+			name:    "unchanged",
+			hcl:     `block = "10.0.0.1 db\n"`,
+			content: managed,
+			want:    managed,
+			action:  ActionNoop,
+		},
+		{
+			name:    "update",
+			hcl:     `block = "10.0.0.1 db\n10.0.0.2 cache\n"`,
+			content: managed,
+			want:    "127.0.0.1 localhost\n# BEGIN hostcfg hosts\n10.0.0.1 db\n10.0.0.2 cache\n# END hostcfg hosts\n::1 localhost\n",
+			action:  ActionUpdate,
+		},
+		{
+			name:    "absent",
+			hcl:     `ensure = "absent"`,
+			content: managed,
+			want:    hosts,
+			action:  ActionDelete,
+		},
+		{
+			name: "custom marker",
+			hcl: `
+				block  = "10.0.0.1 db"
+				marker = "; {mark} db hosts"
+			`,
+			content: hosts,
+			want:    hosts + "; BEGIN db hosts\n10.0.0.1 db\n; END db hosts\n",
+			action:  ActionCreate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hosts")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			r, err := NewFileBlockResource("hosts", parseFileHCL(t, `path = "`+path+`"`+"\n"+tt.hcl), nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			if err := r.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+
+			ctx := context.Background()
+			current, err := r.Read(ctx)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			plan, err := r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.Action != tt.action {
+				t.Errorf("expected action %s, got %s", tt.action, plan.Action)
+			}
+
+			if err := r.Apply(ctx, plan, true); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, data)
+			}
+
+			current, _ = r.Read(ctx)
+			plan, err = r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.HasChanges() {
+				t.Errorf("expected no changes on the second run, got %+v", plan.Changes)
+			}
+		})
+	}
+}
+
+func TestFileBlockResource_MissingEndMarker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("# BEGIN hostcfg hosts\n10.0.0.1 db\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	r, _ := NewFileBlockResource("hosts", parseFileHCL(t, `
+		path  = "`+path+`"
+		block = "10.0.0.1 db"
+	`), nil, "", nil)
+	if _, err := r.Read(context.Background()); err == nil {
+		t.Error("expected error for a block without its END marker")
+	}
+}
+
+func TestFileBlockResource_Revert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	r, _ := NewFileBlockResource("hosts", parseFileHCL(t, `
+		path  = "`+path+`"
+		block = "10.0.0.1 db"
+	`), nil, "", nil)
+
+	ctx := context.Background()
+	before, _ := r.Read(ctx)
+	plan, _ := r.Diff(ctx, before)
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if err := r.(Reversible).Revert(ctx, before); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "127.0.0.1 localhost\n" {
+		t.Errorf("expected the original content, got %q", data)
+	}
+}
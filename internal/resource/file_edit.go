@@ -0,0 +1,281 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Helpers for file_line and file_block, which edit part of a file in place
+// rather than owning its whole content
+
+// readFileContent returns the content of a file and whether it exists
+func readFileContent(path string) (string, bool, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read file: %w", err)
+	}
+	return string(content), true, nil
+}
+
+// readEditedFile reads the file Read recorded as current, so the text to edit
+// is not kept in the state. A file that did not exist is read as empty.
+func readEditedFile(current *State, path string) (string, bool, error) {
+	if fileExists, _ := current.Attributes["file_exists"].(bool); !fileExists {
+		return "", false, nil
+	}
+	content, _, err := readFileContent(path)
+	if err != nil {
+		return "", false, err
+	}
+	return content, true, nil
+}
+
+// fileContentHash returns the SHA-256 hash of content, which the state of an
+// edited file records in place of the content
+func fileContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitLines splits content into lines, reporting whether it ends with a
+// newline. Empty content has no lines and ends with a newline, so lines
+// added to a new file are terminated.
+func splitLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, true
+	}
+	trailing := strings.HasSuffix(content, "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), trailing
+}
+
+// joinLines is the inverse of splitLines
+func joinLines(lines []string, trailing bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	content := strings.Join(lines, "\n")
+	if trailing {
+		content += "\n"
+	}
+	return content
+}
+
+// validateInsertPosition checks the insert_after and insert_before
+// attributes, which are regular expressions or "EOF" and "BOF"
+func validateInsertPosition(id string, insertAfter, insertBefore *string) error {
+	if insertAfter != nil && insertBefore != nil {
+		return fmt.Errorf("%s: cannot specify both insert_after and insert_before", id)
+	}
+	if insertAfter != nil && *insertAfter != "EOF" {
+		if _, err := regexp.Compile(*insertAfter); err != nil {
+			return fmt.Errorf("%s: invalid insert_after: %w", id, err)
+		}
+	}
+	if insertBefore != nil && *insertBefore != "BOF" {
+		if _, err := regexp.Compile(*insertBefore); err != nil {
+			return fmt.Errorf("%s: invalid insert_before: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// insertIndex returns where new lines are inserted: before the first line
+// matching insertBefore, after the last line matching insertAfter, or at
+// the end of the file when neither is set or matches
+func insertIndex(lines []string, insertAfter, insertBefore *string) (int, error) {
+	if insertBefore != nil {
+		if *insertBefore == "BOF" {
+			return 0, nil
+		}
+		re, err := regexp.Compile(*insertBefore)
+		if err != nil {
+			return 0, fmt.Errorf("invalid insert_before: %w", err)
+		}
+		for i, line := range lines {
+			if re.MatchString(line) {
+				return i, nil
+			}
+		}
+		return len(lines), nil
+	}
+
+	if insertAfter != nil && *insertAfter != "EOF" {
+		re, err := regexp.Compile(*insertAfter)
+		if err != nil {
+			return 0, fmt.Errorf("invalid insert_after: %w", err)
+		}
+		for i := len(lines) - 1; i >= 0; i-- {
+			if re.MatchString(lines[i]) {
+				return i + 1, nil
+			}
+		}
+	}
+	return len(lines), nil
+}
+
+// insertLines returns lines with extra inserted at index
+func insertLines(lines []string, index int, extra ...string) []string {
+	result := make([]string, 0, len(lines)+len(extra))
+	result = append(result, lines[:index]...)
+	result = append(result, extra...)
+	return append(result, lines[index:]...)
+}
+
+// planFileEdit plans changing a file's content from old to desired.
+// current.Exists reports whether the managed part of the file is there, so
+// adding it is a create and removing it is a delete.
+func planFileEdit(current *State, path, old, desired string, present bool) *Plan {
+	plan := &Plan{
+		Before: current,
+		After:  NewState(),
+	}
+	for k, v := range current.Attributes {
+		plan.After.Attributes[k] = v
+	}
+
+	fileExists, _ := current.Attributes["file_exists"].(bool)
+	if fileExists && old == desired {
+		plan.After.Exists = current.Exists
+		return plan
+	}
+
+	plan.After.Exists = present
+	plan.After.Attributes["file_exists"] = true
+	plan.After.Attributes["content_hash"] = fileContentHash(desired)
+	switch {
+	case !present:
+		plan.Action = ActionDelete
+	case current.Exists:
+		plan.Action = ActionUpdate
+	default:
+		plan.Action = ActionCreate
+	}
+
+	if !fileExists {
+		plan.Changes = append(plan.Changes, Change{
+			Attribute: "path",
+			Old:       nil,
+			New:       path,
+		})
+	}
+	var oldContent interface{}
+	if fileExists {
+		oldContent = old
+	}
+	plan.Changes = append(plan.Changes, Change{
+		Attribute: "content",
+		Old:       oldContent,
+		New:       desired,
+	})
+	return plan
+}
+
+// fileEditLocks holds a mutex per file, so that resources editing the same
+// file in parallel do not overwrite each other's changes
+var fileEditLocks sync.Map
+
+// lockFileEdit locks a file for reading, editing and writing it back, and
+// returns the function that unlocks it
+func lockFileEdit(path string) func() {
+	key := path
+	if abs, err := filepath.Abs(path); err == nil {
+		key = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(key); err == nil {
+		key = resolved
+	}
+	mu, _ := fileEditLocks.LoadOrStore(key, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// writeFileContent writes edited content to a file, keeping the mode and
+// ownership of an existing file and creating a new one with mode 0644. The
+// content is written to a temporary file renamed over the file, so a crash
+// never leaves it truncated. A symlink is written through, not replaced.
+func writeFileContent(path, content string) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	mode := os.FileMode(0644)
+	uid, gid := -1, -1
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".hostcfg-*")
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		if tmpPath != "" {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	_, err = tmpFile.WriteString(content)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return fmt.Errorf("failed to set mode: %w", err)
+	}
+	if uid != -1 || gid != -1 {
+		// Only root can give the file back to another owner
+		_ = os.Chown(tmpPath, uid, gid)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	tmpPath = ""
+	return nil
+}
+
+// fileEditUndo is the content an edit replaced, kept in memory so that the
+// edit can be reverted without the state holding the file's content
+type fileEditUndo struct {
+	content string
+	existed bool
+}
+
+// revertFileContent restores the content an edit replaced, or removes the
+// file if the edit created it. Nothing is done if the file was not edited.
+func revertFileContent(path string, undo *fileEditUndo) error {
+	if undo == nil {
+		return nil
+	}
+	unlock := lockFileEdit(path)
+	defer unlock()
+
+	if !undo.existed {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		return nil
+	}
+	if err := writeFileContent(path, undo.content); err != nil {
+		return fmt.Errorf("failed to restore file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func init() {
+	Register("file_line", NewFileLineResource)
+	RegisterPrunable("file_line", "path", "line")
+}
+
+// FileLineResource manages a single line in a file, leaving the rest of the
+// file alone
+type FileLineResource struct {
+	name        string
+	description string
+	config      config.FileLineResourceConfig
+	dependsOn   []string
+	undo        *fileEditUndo // what Apply replaced, for Revert
+}
+
+// NewFileLineResource creates a new file_line resource from HCL
+func NewFileLineResource(name string, body hcl.Body, dependsOn []string, description string, ctx *hcl.EvalContext) (Resource, error) {
+	var cfg config.FileLineResourceConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to decode file_line resource: %s", diags.Error())
+	}
+
+	return &FileLineResource{
+		name:        name,
+		description: description,
+		config:      cfg,
+		dependsOn:   dependsOn,
+	}, nil
+}
+
+func (r *FileLineResource) Type() string        { return "file_line" }
+func (r *FileLineResource) Name() string        { return r.name }
+func (r *FileLineResource) Description() string { return r.description }
+
+func (r *FileLineResource) Validate() error {
+	if r.config.Path == "" {
+		return fmt.Errorf("file_line.%s: path is required", r.name)
+	}
+	ensure := r.ensure()
+	if ensure != "present" && ensure != "absent" {
+		return fmt.Errorf("file_line.%s: ensure must be \"present\" or \"absent\"", r.name)
+	}
+	if ensure == "present" && r.config.Line == nil {
+		return fmt.Errorf("file_line.%s: line is required", r.name)
+	}
+	if ensure == "absent" && r.config.Line == nil && r.config.Match == nil {
+		return fmt.Errorf("file_line.%s: either line or match is required", r.name)
+	}
+	if r.config.Match != nil {
+		if _, err := regexp.Compile(*r.config.Match); err != nil {
+			return fmt.Errorf("file_line.%s: invalid match: %w", r.name, err)
+		}
+	}
+	return validateInsertPosition("file_line."+r.name, r.config.InsertAfter, r.config.InsertBefore)
+}
+
+func (r *FileLineResource) Dependencies() []string {
+	return r.dependsOn
+}
+
+func (r *FileLineResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *FileLineResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
+func (r *FileLineResource) ensure() string {
+	if r.config.Ensure != nil {
+		return *r.config.Ensure
+	}
+	return "present"
+}
+
+// matcher returns the compiled match expression, or nil if it is not set
+func (r *FileLineResource) matcher() (*regexp.Regexp, error) {
+	if r.config.Match == nil {
+		return nil, nil
+	}
+	re, err := regexp.Compile(*r.config.Match)
+	if err != nil {
+		return nil, fmt.Errorf("file_line.%s: invalid match: %w", r.name, err)
+	}
+	return re, nil
+}
+
+// Read reports the line as existing when the file has the line, or a line
+// matching match
+func (r *FileLineResource) Read(ctx context.Context) (*State, error) {
+	state := NewState()
+
+	content, exists, err := readFileContent(r.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	state.Attributes["path"] = r.config.Path
+	if !exists {
+		return state, nil
+	}
+	state.Attributes["file_exists"] = true
+	state.Attributes["content_hash"] = fileContentHash(content)
+
+	re, err := r.matcher()
+	if err != nil {
+		return nil, err
+	}
+	lines, _ := splitLines(content)
+	for _, line := range lines {
+		if (r.config.Line != nil && line == *r.config.Line) || (re != nil && re.MatchString(line)) {
+			state.Exists = true
+			break
+		}
+	}
+	if r.config.Line != nil {
+		state.Attributes["line"] = *r.config.Line
+	}
+	return state, nil
+}
+
+func (r *FileLineResource) Diff(ctx context.Context, current *State) (*Plan, error) {
+	content, fileExists, err := readEditedFile(current, r.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	present := r.ensure() == "present"
+
+	if !fileExists {
+		if !present {
+			return &Plan{Before: current, After: NewState()}, nil
+		}
+		if r.config.Create == nil || !*r.config.Create {
+			return nil, fmt.Errorf("file_line.%s: %s does not exist, set create = true to create it", r.name, r.config.Path)
+		}
+	}
+
+	desired, err := r.edit(content)
+	if err != nil {
+		return nil, err
+	}
+	return planFileEdit(current, r.config.Path, content, desired, present), nil
+}
+
+// edit returns content with the line added, replaced or removed
+func (r *FileLineResource) edit(content string) (string, error) {
+	re, err := r.matcher()
+	if err != nil {
+		return "", err
+	}
+	lines, trailing := splitLines(content)
+
+	if r.ensure() == "absent" {
+		kept := make([]string, 0, len(lines))
+		for _, line := range lines {
+			if re != nil && re.MatchString(line) {
+				continue
+			}
+			if re == nil && line == *r.config.Line {
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return joinLines(kept, trailing), nil
+	}
+
+	want := *r.config.Line
+
+	// Replace the last line matching match
+	if re != nil {
+		for i := len(lines) - 1; i >= 0; i-- {
+			if re.MatchString(lines[i]) {
+				lines[i] = want
+				return joinLines(lines, trailing), nil
+			}
+		}
+	}
+
+	for _, line := range lines {
+		if line == want {
+			return content, nil
+		}
+	}
+
+	index, err := insertIndex(lines, r.config.InsertAfter, r.config.InsertBefore)
+	if err != nil {
+		return "", fmt.Errorf("file_line.%s: %w", r.name, err)
+	}
+	return joinLines(insertLines(lines, index, want), trailing), nil
+}
+
+// Apply edits the file as it is when applied, so changes made to it by
+// resources applied earlier are kept
+func (r *FileLineResource) Apply(ctx context.Context, plan *Plan, apply bool) error {
+	if !apply || !plan.HasChanges() {
+		return nil
+	}
+
+	unlock := lockFileEdit(r.config.Path)
+	defer unlock()
+
+	content, exists, err := readFileContent(r.config.Path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if r.ensure() == "absent" {
+			return nil
+		}
+		if r.config.Create == nil || !*r.config.Create {
+			return fmt.Errorf("%s does not exist", r.config.Path)
+		}
+	}
+	desired, err := r.edit(content)
+	if err != nil {
+		return err
+	}
+	if exists && desired == content {
+		return nil
+	}
+	if err := writeFileContent(r.config.Path, desired); err != nil {
+		return err
+	}
+	r.undo = &fileEditUndo{content: content, existed: exists}
+	return nil
+}
+
+// Revert restores the content Apply replaced, or removes the file if Apply
+// created it
+func (r *FileLineResource) Revert(ctx context.Context, before *State) error {
+	return revertFileContent(r.config.Path, r.undo)
+}
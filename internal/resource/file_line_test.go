@@ -0,0 +1,352 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestFileLineResource_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hcl     string
+		wantErr bool
+	}{
+		{
+			name: "valid line",
+			hcl: `
+				path = "/etc/hosts"
+				line = "127.0.0.1 myhost"
+			`,
+		},
+		{
+			name: "absent by match",
+			hcl: `
+				path   = "/etc/hosts"
+				match  = "myhost$"
+				ensure = "absent"
+			`,
+		},
+		{
+			name: "missing line",
+			hcl: `
+				path  = "/etc/hosts"
+				match = "myhost$"
+			`,
+			wantErr: true,
+		},
+		{
+			name: "invalid match",
+			hcl: `
+				path  = "/etc/hosts"
+				line  = "127.0.0.1 myhost"
+				match = "("
+			`,
+			wantErr: true,
+		},
+		{
+			name: "both insert positions",
+			hcl: `
+				path          = "/etc/hosts"
+				line          = "127.0.0.1 myhost"
+				insert_after  = "^127"
+				insert_before = "^::1"
+			`,
+			wantErr: true,
+		},
+		{
+			name: "invalid ensure",
+			hcl: `
+				path   = "/etc/hosts"
+				line   = "127.0.0.1 myhost"
+				ensure = "latest"
+			`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewFileLineResource("test", parseFileHCL(t, tt.hcl), nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			err = r.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileLineResource_Edit(t *testing.T) {
+	const sshd = "Port 22\n#PermitRootLogin yes\nPasswordAuthentication yes\n"
+
+	tests := []struct {
+		name    string
+		hcl     string
+		content string
+		want    string
+		action  Action
+	}{
+		{
+			name:    "append",
+			hcl:     `line = "UseDNS no"`,
+			content: sshd,
+			want:    sshd + "UseDNS no\n",
+			action:  ActionCreate,
+		},
+		{
+			name:    "already present",
+			hcl:     `line = "Port 22"`,
+			content: sshd,
+			want:    sshd,
+			action:  ActionNoop,
+		},
+		{
+			name: "replace match",
+			hcl: `
+				line  = "PermitRootLogin no"
+				match = "^#?PermitRootLogin"
+			`,
+			content: sshd,
+			want:    "Port 22\nPermitRootLogin no\nPasswordAuthentication yes\n",
+			action:  ActionUpdate,
+		},
+		{
+			name: "insert after",
+			hcl: `
+				line         = "ListenAddress 0.0.0.0"
+				insert_after = "^Port"
+			`,
+			content: sshd,
+			want:    "Port 22\nListenAddress 0.0.0.0\n#PermitRootLogin yes\nPasswordAuthentication yes\n",
+			action:  ActionCreate,
+		},
+		{
+			name: "insert before BOF",
+			hcl: `
+				line          = "# Managed by hostcfg"
+				insert_before = "BOF"
+			`,
+			content: sshd,
+			want:    "# Managed by hostcfg\n" + sshd,
+			action:  ActionCreate,
+		},
+		{
+			name: "insert after without a match appends",
+			hcl: `
+				line         = "UseDNS no"
+				insert_after = "^Missing"
+			`,
+			content: "Port 22",
+			want:    "Port 22\nUseDNS no",
+			action:  ActionCreate,
+		},
+		{
+			name: "absent line",
+			hcl: `
+				line   = "PasswordAuthentication yes"
+				ensure = "absent"
+			`,
+			content: sshd,
+			want:    "Port 22\n#PermitRootLogin yes\n",
+			action:  ActionDelete,
+		},
+		{
+			name: "absent match",
+			hcl: `
+				match  = "PermitRootLogin|PasswordAuthentication"
+				ensure = "absent"
+			`,
+			content: sshd,
+			want:    "Port 22\n",
+			action:  ActionDelete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sshd_config")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			r, err := NewFileLineResource("test", parseFileHCL(t, `path = "`+path+`"`+"\n"+tt.hcl), nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			if err := r.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+
+			ctx := context.Background()
+			current, err := r.Read(ctx)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			plan, err := r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.Action != tt.action {
+				t.Errorf("expected action %s, got %s", tt.action, plan.Action)
+			}
+
+			if err := r.Apply(ctx, plan, true); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, data)
+			}
+			if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+				t.Errorf("expected mode 0600 to be kept, got %o", info.Mode().Perm())
+			}
+
+			// A second run has nothing to do
+			current, _ = r.Read(ctx)
+			plan, err = r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.HasChanges() {
+				t.Errorf("expected no changes on the second run, got %+v", plan.Changes)
+			}
+		})
+	}
+}
+
+func TestFileLineResource_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sysctl.conf")
+	ctx := context.Background()
+
+	r, _ := NewFileLineResource("test", parseFileHCL(t, `
+		path = "`+path+`"
+		line = "vm.swappiness = 10"
+	`), nil, "", nil)
+	current, err := r.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if _, err := r.Diff(ctx, current); err == nil {
+		t.Error("expected error for a missing file without create")
+	}
+
+	r, _ = NewFileLineResource("test", parseFileHCL(t, `
+		path   = "`+path+`"
+		line   = "vm.swappiness = 10"
+		create = true
+	`), nil, "", nil)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if plan.Action != ActionCreate {
+		t.Errorf("expected create, got %s", plan.Action)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "vm.swappiness = 10\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+
+	// Reverting removes the file it created
+	if err := r.(Reversible).Revert(ctx, current); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected file to be removed, got %v", err)
+	}
+}
+
+func TestFileLineResource_ParallelEdits(t *testing.T) {
+	tmpDir := t.TempDir()
+	real := filepath.Join(tmpDir, "hosts")
+	if err := os.WriteFile(real, []byte("127.0.0.1 localhost\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	// Edits go through a symlink to the file, which is kept
+	path := filepath.Join(tmpDir, "hosts.link")
+	if err := os.Symlink(real, path); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	const count = 200
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, _ := NewFileLineResource("test", parseFileHCL(t, `
+				path = "`+path+`"
+				line = "10.0.0.`+strconv.Itoa(i)+` host`+strconv.Itoa(i)+`"
+			`), nil, "", nil)
+			current, err := r.Read(ctx)
+			if err == nil {
+				var plan *Plan
+				if plan, err = r.Diff(ctx, current); err == nil {
+					err = r.Apply(ctx, plan, true)
+				}
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("edit failed: %v", err)
+		}
+	}
+
+	data, _ := os.ReadFile(real)
+	if lines, _ := splitLines(string(data)); len(lines) != count+1 {
+		t.Errorf("expected %d lines, got %d", count+1, len(lines))
+	}
+	if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Error("expected the symlink to be kept")
+	}
+	if info, _ := os.Stat(real); info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 to be kept, got %04o", info.Mode().Perm())
+	}
+}
+
+func TestFileLineResource_StateHasNoContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	if err := os.WriteFile(path, []byte("a=1\nSECRET_TOKEN=abc123\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	r, _ := NewFileLineResource("test", parseFileHCL(t, `
+		path = "`+path+`"
+		line = "b=2"
+	`), nil, "", nil)
+
+	ctx := context.Background()
+	current, err := r.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+
+	// The file is read again to plan the edit, so states only hold its hash
+	for _, st := range []*State{current, plan.After} {
+		if _, ok := st.Attributes["content"]; ok {
+			t.Errorf("expected no content in state, got %v", st.Attributes)
+		}
+		if _, ok := st.Attributes["content_hash"].(string); !ok {
+			t.Errorf("expected a content hash in state, got %v", st.Attributes)
+		}
+	}
+	if plan.Action != ActionCreate || len(plan.Changes) != 1 || plan.Changes[0].New != "a=1\nSECRET_TOKEN=abc123\nb=2\n" {
+		t.Errorf("unexpected plan %s %+v", plan.Action, plan.Changes)
+	}
+}
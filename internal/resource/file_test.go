@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/hcl/v2"
@@ -104,6 +105,15 @@ func TestFileResource_Validate(t *testing.T) {
 			`,
 			wantErr: false,
 		},
+		{
+			name: "validate_cmd without path",
+			hcl: `
+				path         = "/tmp/test"
+				content      = "hello"
+				validate_cmd = "visudo -c"
+			`,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -444,6 +454,176 @@ func TestFileResource_Apply_Update(t *testing.T) {
 	}
 }
 
+func TestFileResource_Apply_ValidateCmd(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "sudoers")
+	if err := os.WriteFile(filePath, []byte("valid\n"), 0440); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "invalid content is not written", content: "broken\n", want: "valid\n", wantErr: true},
+		{name: "valid content is written", content: "valid again\n", want: "valid again\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := parseFileHCL(t, `
+				path         = "`+filePath+`"
+				content      = "`+strings.TrimSuffix(tt.content, "\n")+`\n"
+				validate_cmd = "grep -q valid %s"
+			`)
+			r, err := NewFileResource("test", body, nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+
+			ctx := context.Background()
+			current, _ := r.Read(ctx)
+			plan, err := r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			err = r.Apply(ctx, plan, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			data, _ := os.ReadFile(filePath)
+			if string(data) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, data)
+			}
+			info, _ := os.Stat(filePath)
+			if info.Mode().Perm() != 0440 {
+				t.Errorf("expected mode 0440 to be kept, got %04o", info.Mode().Perm())
+			}
+			entries, _ := os.ReadDir(tmpDir)
+			if len(entries) != 1 {
+				t.Errorf("expected no temp files to be left, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestFileResource_Apply_Backup(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "nginx.conf")
+	if err := os.WriteFile(filePath, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	body := parseFileHCL(t, `
+		path    = "`+filePath+`"
+		content = "new"
+		backup  = true
+	`)
+	r, err := NewFileResource("test", body, nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, _ := r.Diff(ctx, current)
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	backups, _ := filepath.Glob(filePath + ".*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "old" {
+		t.Errorf("expected the backup to hold the old content, got %q", data)
+	}
+	if data, _ := os.ReadFile(filePath); string(data) != "new" {
+		t.Errorf("expected the new content, got %q", data)
+	}
+}
+func TestFileResource_Apply_BackupSameSecond(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "nginx.conf")
+	if err := os.WriteFile(filePath, []byte("first"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	// Two writes within the same second each keep their own backup
+	ctx := context.Background()
+	for _, content := range []string{"second", "third"} {
+		body := parseFileHCL(t, `
+			path    = "`+filePath+`"
+			content = "`+content+`"
+			backup  = true
+		`)
+		r, err := NewFileResource("test", body, nil, "", nil)
+		if err != nil {
+			t.Fatalf("failed to create resource: %v", err)
+		}
+		current, _ := r.Read(ctx)
+		plan, _ := r.Diff(ctx, current)
+		if err := r.Apply(ctx, plan, true); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+
+	backups, _ := filepath.Glob(filePath + ".*.bak")
+	if len(backups) != 2 {
+		t.Fatalf("expected two backups, got %v", backups)
+	}
+	kept := make(map[string]bool)
+	for _, backup := range backups {
+		data, _ := os.ReadFile(backup)
+		kept[string(data)] = true
+	}
+	if !kept["first"] || !kept["second"] {
+		t.Errorf("expected backups of both replaced contents, got %v", kept)
+	}
+}
+
+func TestFileResource_Apply_Symlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "real.conf")
+	link := filepath.Join(tmpDir, "link.conf")
+	if err := os.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	body := parseFileHCL(t, `
+		path    = "`+link+`"
+		content = "new"
+	`)
+	r, err := NewFileResource("test", body, nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+
+	ctx := context.Background()
+	current, _ := r.Read(ctx)
+	plan, _ := r.Diff(ctx, current)
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected %s to still be a symlink", link)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("expected the target to hold the new content, got %q", data)
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 to be kept, got %04o", info.Mode().Perm())
+	}
+}
+
 func TestFileResource_Apply_Delete(t *testing.T) {
 	tmpDir := t.TempDir()
 	filePath := filepath.Join(tmpDir, "deleted.txt")
@@ -586,3 +766,57 @@ func TestFileResource_Idempotent(t *testing.T) {
 		t.Errorf("second run should be noop, got %v", plan2.Action)
 	}
 }
+
+func TestFileResource_Revert(t *testing.T) {
+	tmpDir := t.TempDir()
+	existingPath := filepath.Join(tmpDir, "existing.txt")
+	newPath := filepath.Join(tmpDir, "new.txt")
+
+	if err := os.WriteFile(existingPath, []byte("old"), 0600); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, path := range []string{existingPath, newPath} {
+		body := parseFileHCL(t, `
+			path    = "`+path+`"
+			content = "updated"
+			mode    = "0644"
+		`)
+		r, err := NewFileResource("test", body, nil, "", nil)
+		if err != nil {
+			t.Fatalf("failed to create resource: %v", err)
+		}
+
+		before, err := r.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		plan, err := r.Diff(ctx, before)
+		if err != nil {
+			t.Fatalf("Diff failed: %v", err)
+		}
+		if err := r.Apply(ctx, plan, true); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+
+		if err := r.(Reversible).Revert(ctx, before); err != nil {
+			t.Fatalf("Revert failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(existingPath)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "old" {
+		t.Errorf("expected content to be restored, got %q", string(data))
+	}
+	info, _ := os.Stat(existingPath)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 to be restored, got %o", info.Mode().Perm())
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Error("expected created file to be removed")
+	}
+}
@@ -11,10 +11,12 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
 	Register("group", NewGroupResource)
+	RegisterPrunable("group", "name")
 }
 
 // GroupResource manages system groups
@@ -56,6 +58,14 @@ func (r *GroupResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *GroupResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *GroupResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 func (r *GroupResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -211,6 +221,40 @@ func (r *GroupResource) Apply(ctx context.Context, plan *Plan, apply bool) error
 	return nil
 }
 
+// Revert deletes the group if it was created, or restores its previous
+// members. A deleted group is recreated with its old GID.
+func (r *GroupResource) Revert(ctx context.Context, before *State) error {
+	installed, err := r.Read(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !before.Exists {
+		if !installed.Exists {
+			return nil
+		}
+		cmd := exec.CommandContext(ctx, "groupdel", r.config.Name)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to delete group: %w\nOutput: %s", err, string(output))
+		}
+		return nil
+	}
+
+	if !installed.Exists {
+		args := []string{r.config.Name}
+		if gid, ok := before.Attributes["gid"].(string); ok {
+			args = []string{"-g", gid, r.config.Name}
+		}
+		cmd := exec.CommandContext(ctx, "groupadd", args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to recreate group: %w\nOutput: %s", err, string(output))
+		}
+	}
+
+	members, _ := before.Attributes["members"].([]string)
+	return r.setGroupMembers(ctx, members)
+}
+
 func (r *GroupResource) setGroupMembers(ctx context.Context, members []string) error {
 	// Use gpasswd to set group members
 	// First remove all members, then add the specified ones
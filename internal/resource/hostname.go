@@ -10,6 +10,7 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
@@ -55,6 +56,14 @@ func (r *HostnameResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *HostnameResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *HostnameResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 func (r *HostnameResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -9,10 +9,12 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
 	Register("link", NewLinkResource)
+	RegisterPrunable("link", "path")
 }
 
 // LinkResource manages symbolic links
@@ -61,6 +63,14 @@ func (r *LinkResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *LinkResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *LinkResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 func (r *LinkResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -227,3 +237,24 @@ func (r *LinkResource) Apply(ctx context.Context, plan *Plan, apply bool) error
 
 	return nil
 }
+
+// Revert removes the link if it was created, or points it back at its
+// previous target
+func (r *LinkResource) Revert(ctx context.Context, before *State) error {
+	if isSymlink, _ := before.Attributes["is_symlink"].(bool); before.Exists && !isSymlink {
+		return fmt.Errorf("cannot restore %s, it was not a symlink", r.config.Path)
+	}
+
+	if err := os.Remove(r.config.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove link: %w", err)
+	}
+	if !before.Exists {
+		return nil
+	}
+
+	target, _ := before.Attributes["target"].(string)
+	if err := os.Symlink(target, r.config.Path); err != nil {
+		return fmt.Errorf("failed to restore symlink: %w", err)
+	}
+	return nil
+}
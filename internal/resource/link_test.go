@@ -716,3 +716,47 @@ func TestLinkResource_Idempotent(t *testing.T) {
 		t.Errorf("second run should be noop, got %v", plan2.Action)
 	}
 }
+
+func TestLinkResource_Revert(t *testing.T) {
+	tmpDir := t.TempDir()
+	linkPath := filepath.Join(tmpDir, "link")
+	oldTarget := filepath.Join(tmpDir, "old")
+	newTarget := filepath.Join(tmpDir, "new")
+
+	if err := os.Symlink(oldTarget, linkPath); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	body := parseLinkHCL(t, `
+		path   = "`+linkPath+`"
+		target = "`+newTarget+`"
+	`)
+	r, err := NewLinkResource("test", body, nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+
+	ctx := context.Background()
+	before, err := r.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	plan, err := r.Diff(ctx, before)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	if err := r.(Reversible).Revert(ctx, before); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		t.Fatalf("failed to read link: %v", err)
+	}
+	if target != oldTarget {
+		t.Errorf("expected target %q to be restored, got %q", oldTarget, target)
+	}
+}
@@ -9,10 +9,12 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
 	Register("package", NewPackageResource)
+	RegisterPrunable("package", "name")
 }
 
 // PackageManager represents a system package manager
@@ -69,6 +71,14 @@ func (r *PackageResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *PackageResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *PackageResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 func (r *PackageResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -172,6 +182,17 @@ func (r *PackageResource) Apply(ctx context.Context, plan *Plan, apply bool) err
 	return nil
 }
 
+// Revert uninstalls the package if it was installed, or reinstalls the
+// previous version
+func (r *PackageResource) Revert(ctx context.Context, before *State) error {
+	if !before.Exists {
+		return r.pm.Remove(ctx, r.config.Name)
+	}
+
+	version, _ := before.Attributes["version"].(string)
+	return r.pm.Install(ctx, r.config.Name, version)
+}
+
 // detectPackageManager detects and returns the appropriate package manager
 func detectPackageManager() (PackageManager, error) {
 	// Check OS first for BSD and macOS systems
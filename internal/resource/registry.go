@@ -1,10 +1,13 @@
 package resource
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/dynblock"
+	hcljson "github.com/hashicorp/hcl/v2/json"
 	"github.com/zclconf/go-cty/cty"
 
 	"github.com/z0mbix/hostcfg/internal/config"
@@ -17,12 +20,15 @@ type Factory func(name string, body hcl.Body, dependsOn []string, description st
 type Registry struct {
 	mu        sync.RWMutex
 	factories map[string]Factory
+	pruneKeys map[string][]string // resource type -> state attributes that identify it
+	resolver  func(resourceType string) error
 }
 
 // NewRegistry creates a new resource registry
 func NewRegistry() *Registry {
 	return &Registry{
 		factories: make(map[string]Factory),
+		pruneKeys: make(map[string][]string),
 	}
 }
 
@@ -33,6 +39,118 @@ func (r *Registry) Register(resourceType string, factory Factory) {
 	r.factories[resourceType] = factory
 }
 
+// Unregister removes a resource type from the registry
+func (r *Registry) Unregister(resourceType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.factories, resourceType)
+	delete(r.pruneKeys, resourceType)
+}
+
+// SetResolver sets the function called with resource types that are not
+// registered, which may register them, such as by starting the provider that
+// implements them. A nil resolver removes it.
+func (r *Registry) SetResolver(resolver func(resourceType string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolver = resolver
+}
+
+// Has returns true if a resource type is registered or the resolver
+// registers it
+func (r *Registry) Has(resourceType string) bool {
+	_, err := r.factory(resourceType)
+	return err == nil
+}
+
+// Registered returns true if a resource type is registered, without calling
+// the resolver
+func (r *Registry) Registered(resourceType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.factories[resourceType]
+	return ok
+}
+
+// factory returns the factory of a resource type, calling the resolver if it
+// is not registered
+func (r *Registry) factory(resourceType string) (Factory, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[resourceType]
+	resolver := r.resolver
+	r.mu.RUnlock()
+	if ok {
+		return factory, nil
+	}
+
+	if resolver != nil {
+		if err := resolver(resourceType); err != nil {
+			return nil, err
+		}
+		r.mu.RLock()
+		factory, ok = r.factories[resourceType]
+		r.mu.RUnlock()
+		if ok {
+			return factory, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown resource type: %s", resourceType)
+}
+
+// RegisterPrunable marks a resource type as removable once its block is
+// deleted from the configuration. keys are the state attributes needed to
+// rebuild the resource with ensure = "absent" so it can remove itself.
+func (r *Registry) RegisterPrunable(resourceType string, keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruneKeys[resourceType] = keys
+}
+
+// IsPrunable returns true if orphaned resources of this type can be removed
+func (r *Registry) IsPrunable(resourceType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.pruneKeys[resourceType]
+	return ok
+}
+
+// CreateOrphan rebuilds a resource that is no longer in the configuration
+// from its recorded state attributes, with ensure = "absent" so that planning
+// it produces a delete using the resource type's own removal logic
+func (r *Registry) CreateOrphan(resourceType, name string, attrs map[string]interface{}, deps []string, description string) (Resource, error) {
+	factory, err := r.factory(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	keys, prunable := r.pruneKeys[resourceType]
+	r.mu.RUnlock()
+
+	if !prunable {
+		return nil, fmt.Errorf("resource type %s cannot be pruned", resourceType)
+	}
+
+	values := map[string]interface{}{"ensure": "absent"}
+	for _, key := range keys {
+		v, ok := attrs[key]
+		if !ok {
+			return nil, fmt.Errorf("%s.%s: state is missing attribute %q", resourceType, name, key)
+		}
+		values[key] = v
+	}
+
+	src, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("%s.%s: failed to encode state attributes: %w", resourceType, name, err)
+	}
+	file, diags := hcljson.Parse(src, resourceType+"."+name)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("%s.%s: %s", resourceType, name, diags.Error())
+	}
+
+	return factory(name, file.Body, deps, description, nil)
+}
+
 // evaluateDescription evaluates the description expression and returns the result as a string.
 // Returns empty string if description is nil or evaluation fails.
 func evaluateDescription(desc hcl.Expression, ctx *hcl.EvalContext) string {
@@ -40,6 +158,7 @@ func evaluateDescription(desc hcl.Expression, ctx *hcl.EvalContext) string {
 		return ""
 	}
 	val, diags := desc.Value(ctx)
+	val, _ = val.UnmarkDeep()
 	if diags.HasErrors() || val.IsNull() || val.Type() != cty.String {
 		return ""
 	}
@@ -48,16 +167,13 @@ func evaluateDescription(desc hcl.Expression, ctx *hcl.EvalContext) string {
 
 // Create creates a resource from an HCL resource block
 func (r *Registry) Create(block *config.ResourceBlock, ctx *hcl.EvalContext) (Resource, error) {
-	r.mu.RLock()
-	factory, ok := r.factories[block.Type]
-	r.mu.RUnlock()
-
-	if !ok {
-		return nil, fmt.Errorf("unknown resource type: %s", block.Type)
+	factory, err := r.factory(block.Type)
+	if err != nil {
+		return nil, err
 	}
 
 	description := evaluateDescription(block.Description, ctx)
-	return factory(block.Name, block.Body, block.DependsOn, description, ctx)
+	return factory(block.Name, expandBody(block.Body, ctx), block.DependsOn, description, ctx)
 }
 
 // DefaultRegistry is the global default registry
@@ -68,6 +184,11 @@ func Register(resourceType string, factory Factory) {
 	DefaultRegistry.Register(resourceType, factory)
 }
 
+// RegisterPrunable marks a resource type as prunable in the default registry
+func RegisterPrunable(resourceType string, keys ...string) {
+	DefaultRegistry.RegisterPrunable(resourceType, keys...)
+}
+
 // Create creates a resource using the default registry
 func Create(block *config.ResourceBlock, ctx *hcl.EvalContext) (Resource, error) {
 	return DefaultRegistry.Create(block, ctx)
@@ -75,16 +196,89 @@ func Create(block *config.ResourceBlock, ctx *hcl.EvalContext) (Resource, error)
 
 // CreateWithDeps creates a resource with explicit dependencies (merging implicit and explicit)
 func (r *Registry) CreateWithDeps(block *config.ResourceBlock, deps []string, ctx *hcl.EvalContext) (Resource, error) {
-	r.mu.RLock()
-	factory, ok := r.factories[block.Type]
-	r.mu.RUnlock()
-
-	if !ok {
-		return nil, fmt.Errorf("unknown resource type: %s", block.Type)
+	factory, err := r.factory(block.Type)
+	if err != nil {
+		return nil, err
 	}
 
 	description := evaluateDescription(block.Description, ctx)
-	return factory(block.Name, block.Body, deps, description, ctx)
+	return factory(block.Name, expandBody(block.Body, ctx), deps, description, ctx)
+}
+
+// expandBody expands the dynamic blocks in a resource body, such as
+// dynamic "key" { for_each = ... }, into the nested blocks they generate, so
+// every resource type supports them
+func expandBody(body hcl.Body, ctx *hcl.EvalContext) hcl.Body {
+	return unmarkedBody{dynblock.Expand(body, ctx)}
+}
+
+// unmarkedBody removes the marks from the values of a body's attributes, such
+// as the mark of sensitive values, which cannot be decoded into Go values.
+// The engine tracks which attributes were sensitive.
+type unmarkedBody struct {
+	hcl.Body
+}
+
+func (b unmarkedBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	content, diags := b.Body.Content(schema)
+	return unmarkContent(content), diags
+}
+
+func (b unmarkedBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	content, remain, diags := b.Body.PartialContent(schema)
+	if remain != nil {
+		remain = unmarkedBody{remain}
+	}
+	return unmarkContent(content), remain, diags
+}
+
+func (b unmarkedBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	attrs, diags := b.Body.JustAttributes()
+	return unmarkAttributes(attrs), diags
+}
+
+func unmarkContent(content *hcl.BodyContent) *hcl.BodyContent {
+	if content == nil {
+		return nil
+	}
+	result := *content
+	result.Attributes = unmarkAttributes(content.Attributes)
+	result.Blocks = make(hcl.Blocks, len(content.Blocks))
+	for i, block := range content.Blocks {
+		unmarked := *block
+		unmarked.Body = unmarkedBody{block.Body}
+		result.Blocks[i] = &unmarked
+	}
+	return &result
+}
+
+func unmarkAttributes(attrs hcl.Attributes) hcl.Attributes {
+	if attrs == nil {
+		return nil
+	}
+	result := make(hcl.Attributes, len(attrs))
+	for name, attr := range attrs {
+		unmarked := *attr
+		unmarked.Expr = unmarkedExpr{attr.Expr}
+		result[name] = &unmarked
+	}
+	return result
+}
+
+// unmarkedExpr is an expression whose value has its marks removed
+type unmarkedExpr struct {
+	hcl.Expression
+}
+
+func (e unmarkedExpr) Value(ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+	val, diags := e.Expression.Value(ctx)
+	val, _ = val.UnmarkDeep()
+	return val, diags
+}
+
+// UnwrapExpression lets hcl.ExprList and hcl.ExprMap see the expression
+func (e unmarkedExpr) UnwrapExpression() hcl.Expression {
+	return e.Expression
 }
 
 // CreateWithDeps creates a resource with explicit dependencies using the default registry
@@ -2,6 +2,8 @@ package resource
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/hashicorp/hcl/v2"
@@ -139,3 +141,66 @@ func TestDefaultRegistry_HasBuiltinTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestRegistry_CreateOrphan(t *testing.T) {
+	r := NewRegistry()
+	r.Register("file", NewFileResource)
+	r.RegisterPrunable("file", "path")
+
+	if !r.IsPrunable("file") {
+		t.Fatal("expected file to be prunable")
+	}
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "orphan.txt")
+	if err := os.WriteFile(path, []byte("left behind"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	res, err := r.CreateOrphan("file", "old", map[string]interface{}{
+		"path":    path,
+		"content": "left behind",
+	}, nil, "removed from configuration")
+	if err != nil {
+		t.Fatalf("CreateOrphan failed: %v", err)
+	}
+	if res.Description() != "removed from configuration" {
+		t.Errorf("unexpected description: %q", res.Description())
+	}
+
+	ctx := context.Background()
+	state, err := res.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	plan, err := res.Diff(ctx, state)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if plan.Action != ActionDelete {
+		t.Fatalf("expected ActionDelete, got %v", plan.Action)
+	}
+	if err := res.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected orphaned file to be removed")
+	}
+}
+
+func TestRegistry_CreateOrphan_Errors(t *testing.T) {
+	r := NewRegistry()
+	r.Register("file", NewFileResource)
+	r.Register("exec", NewExecResource)
+	r.RegisterPrunable("file", "path")
+
+	if _, err := r.CreateOrphan("unknown", "x", nil, nil, ""); err == nil {
+		t.Error("expected error for unknown type")
+	}
+	if _, err := r.CreateOrphan("exec", "x", nil, nil, ""); err == nil {
+		t.Error("expected error for type that cannot be pruned")
+	}
+	if _, err := r.CreateOrphan("file", "x", map[string]interface{}{}, nil, ""); err == nil {
+		t.Error("expected error for missing identifying attribute")
+	}
+}
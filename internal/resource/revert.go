@@ -0,0 +1,55 @@
+package resource
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// restorePathAttributes restores the mode, owner and group of path to the
+// values recorded in a state read before it was changed
+func restorePathAttributes(path string, before *State) error {
+	if mode, ok := before.Attributes["mode"].(string); ok {
+		parsed, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode: %w", err)
+		}
+		if err := os.Chmod(path, os.FileMode(parsed)); err != nil {
+			return fmt.Errorf("failed to restore mode: %w", err)
+		}
+	}
+
+	uid, gid := -1, -1
+	if owner, ok := before.Attributes["owner"].(string); ok {
+		if u, err := user.Lookup(owner); err == nil {
+			uid, _ = strconv.Atoi(u.Uid)
+		} else if id, err := strconv.Atoi(owner); err == nil {
+			uid = id
+		}
+	}
+	if group, ok := before.Attributes["group"].(string); ok {
+		if g, err := user.LookupGroup(group); err == nil {
+			gid, _ = strconv.Atoi(g.Gid)
+		} else if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		}
+	}
+
+	// Only chown when something differs, so unprivileged runs that never
+	// changed ownership can still be reverted
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if (uid == -1 || uid == int(stat.Uid)) && (gid == -1 || gid == int(stat.Gid)) {
+			return nil
+		}
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to restore ownership: %w", err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
@@ -29,6 +30,10 @@ type ServiceManager interface {
 	Start(ctx context.Context, name string) error
 	// Stop stops the service
 	Stop(ctx context.Context, name string) error
+	// Restart stops and starts the service
+	Restart(ctx context.Context, name string) error
+	// Reload asks the running service to reload its configuration
+	Reload(ctx context.Context, name string) error
 	// Enable enables the service at boot
 	Enable(ctx context.Context, name string) error
 	// Disable disables the service at boot
@@ -80,6 +85,12 @@ func (r *ServiceResource) Validate() error {
 			return fmt.Errorf("service.%s: ensure must be 'running' or 'stopped'", r.name)
 		}
 	}
+	if r.config.OnNotify != nil {
+		onNotify := *r.config.OnNotify
+		if onNotify != "restart" && onNotify != "reload" {
+			return fmt.Errorf("service.%s: on_notify must be 'restart' or 'reload'", r.name)
+		}
+	}
 	return nil
 }
 
@@ -87,6 +98,14 @@ func (r *ServiceResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *ServiceResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *ServiceResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
 func (r *ServiceResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -242,6 +261,77 @@ func (r *ServiceResource) Apply(ctx context.Context, plan *Plan, apply bool) err
 	return nil
 }
 
+// Revert restores whether the service was running and enabled
+func (r *ServiceResource) Revert(ctx context.Context, before *State) error {
+	if !before.Exists {
+		return nil
+	}
+
+	current, err := r.Read(ctx)
+	if err != nil {
+		return err
+	}
+	if !current.Exists {
+		return nil
+	}
+
+	if ensure, _ := before.Attributes["ensure"].(string); ensure != current.Attributes["ensure"] {
+		if ensure == "running" {
+			err = r.sm.Start(ctx, r.config.Name)
+		} else {
+			err = r.sm.Stop(ctx, r.config.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore service state: %w", err)
+		}
+	}
+
+	if enabled, _ := before.Attributes["enabled"].(bool); enabled != current.Attributes["enabled"] {
+		if enabled {
+			err = r.sm.Enable(ctx, r.config.Name)
+		} else {
+			err = r.sm.Disable(ctx, r.config.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore service enablement: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// NotifyAction returns what the service does when notified
+func (r *ServiceResource) NotifyAction() string {
+	if r.config.OnNotify != nil {
+		return *r.config.OnNotify
+	}
+	return "restart"
+}
+
+// Notify restarts or reloads the service. Services that are not running are
+// left alone, as they pick up the change the next time they start.
+func (r *ServiceResource) Notify(ctx context.Context) error {
+	isRunning, err := r.sm.IsRunning(ctx, r.config.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check if service is running: %w", err)
+	}
+	if !isRunning {
+		return nil
+	}
+
+	if r.NotifyAction() == "reload" {
+		if err := r.sm.Reload(ctx, r.config.Name); err != nil {
+			return fmt.Errorf("failed to reload service: %w", err)
+		}
+		return nil
+	}
+
+	if err := r.sm.Restart(ctx, r.config.Name); err != nil {
+		return fmt.Errorf("failed to restart service: %w", err)
+	}
+	return nil
+}
+
 // detectServiceManager detects and returns the appropriate service manager
 func detectServiceManager() (ServiceManager, error) {
 	switch runtime.GOOS {
@@ -57,6 +57,24 @@ func (m *FreeBSDServiceManager) Stop(ctx context.Context, name string) error {
 	return nil
 }
 
+func (m *FreeBSDServiceManager) Restart(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "service", name, "restart")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("service restart failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *FreeBSDServiceManager) Reload(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "service", name, "reload")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("service reload failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (m *FreeBSDServiceManager) Enable(ctx context.Context, name string) error {
 	// Add service_enable="YES" to /etc/rc.conf
 	cmd := exec.CommandContext(ctx, "sysrc", name+"_enable=YES")
@@ -130,6 +148,24 @@ func (m *OpenBSDServiceManager) Stop(ctx context.Context, name string) error {
 	return nil
 }
 
+func (m *OpenBSDServiceManager) Restart(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "rcctl", "restart", name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rcctl restart failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *OpenBSDServiceManager) Reload(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "rcctl", "reload", name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rcctl reload failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (m *OpenBSDServiceManager) Enable(ctx context.Context, name string) error {
 	cmd := exec.CommandContext(ctx, "rcctl", "enable", name)
 	output, err := cmd.CombinedOutput()
@@ -202,6 +238,24 @@ func (m *NetBSDServiceManager) Stop(ctx context.Context, name string) error {
 	return nil
 }
 
+func (m *NetBSDServiceManager) Restart(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "/etc/rc.d/"+name, "restart")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rc.d restart failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *NetBSDServiceManager) Reload(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "/etc/rc.d/"+name, "reload")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rc.d reload failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (m *NetBSDServiceManager) Enable(ctx context.Context, name string) error {
 	// Append service=YES to /etc/rc.conf if not already present
 	data, err := os.ReadFile("/etc/rc.conf")
@@ -176,6 +176,30 @@ func (m *LaunchctlServiceManager) Stop(ctx context.Context, name string) error {
 	return nil
 }
 
+func (m *LaunchctlServiceManager) Restart(ctx context.Context, name string) error {
+	label := m.getServiceLabel(name)
+
+	// kickstart -k kills the running instance before starting it again
+	cmd := exec.CommandContext(ctx, "launchctl", "kickstart", "-k", "gui/"+fmt.Sprint(os.Getuid())+"/"+label)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl restart failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *LaunchctlServiceManager) Reload(ctx context.Context, name string) error {
+	label := m.getServiceLabel(name)
+
+	// launchd has no reload, so send SIGHUP like most daemons expect
+	cmd := exec.CommandContext(ctx, "launchctl", "kill", "HUP", "gui/"+fmt.Sprint(os.Getuid())+"/"+label)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl reload failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (m *LaunchctlServiceManager) Enable(ctx context.Context, name string) error {
 	label := m.getServiceLabel(name)
 
@@ -55,6 +55,24 @@ func (m *SMFServiceManager) Stop(ctx context.Context, name string) error {
 	return nil
 }
 
+func (m *SMFServiceManager) Restart(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "svcadm", "restart", name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("svcadm restart failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *SMFServiceManager) Reload(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "svcadm", "refresh", name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("svcadm refresh failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (m *SMFServiceManager) Enable(ctx context.Context, name string) error {
 	cmd := exec.CommandContext(ctx, "svcadm", "enable", name)
 	output, err := cmd.CombinedOutput()
@@ -51,6 +51,24 @@ func (m *SystemdServiceManager) Stop(ctx context.Context, name string) error {
 	return nil
 }
 
+func (m *SystemdServiceManager) Restart(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "systemctl", "restart", name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl restart failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *SystemdServiceManager) Reload(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "systemctl", "reload", name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl reload failed: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
 func (m *SystemdServiceManager) Enable(ctx context.Context, name string) error {
 	cmd := exec.CommandContext(ctx, "systemctl", "enable", name)
 	output, err := cmd.CombinedOutput()
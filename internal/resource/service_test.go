@@ -0,0 +1,97 @@
+package resource
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/z0mbix/hostcfg/internal/config"
+)
+
+// fakeServiceManager records the calls made to it
+type fakeServiceManager struct {
+	running bool
+	calls   []string
+}
+
+func (m *fakeServiceManager) Name() string { return "fake" }
+func (m *fakeServiceManager) Exists(ctx context.Context, name string) (bool, error) {
+	return true, nil
+}
+func (m *fakeServiceManager) IsRunning(ctx context.Context, name string) (bool, error) {
+	return m.running, nil
+}
+func (m *fakeServiceManager) IsEnabled(ctx context.Context, name string) (bool, error) {
+	return true, nil
+}
+func (m *fakeServiceManager) record(call, name string) error {
+	m.calls = append(m.calls, call+" "+name)
+	return nil
+}
+func (m *fakeServiceManager) Start(ctx context.Context, name string) error {
+	return m.record("start", name)
+}
+func (m *fakeServiceManager) Stop(ctx context.Context, name string) error {
+	return m.record("stop", name)
+}
+func (m *fakeServiceManager) Restart(ctx context.Context, name string) error {
+	return m.record("restart", name)
+}
+func (m *fakeServiceManager) Reload(ctx context.Context, name string) error {
+	return m.record("reload", name)
+}
+func (m *fakeServiceManager) Enable(ctx context.Context, name string) error {
+	return m.record("enable", name)
+}
+func (m *fakeServiceManager) Disable(ctx context.Context, name string) error {
+	return m.record("disable", name)
+}
+
+func TestServiceResource_Notify(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name       string
+		onNotify   *string
+		running    bool
+		wantAction string
+		wantCalls  string
+	}{
+		{"default restarts", nil, true, "restart", "restart nginx"},
+		{"reload", strPtr("reload"), true, "reload", "reload nginx"},
+		{"stopped service is left alone", nil, false, "restart", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &fakeServiceManager{running: tt.running}
+			r := &ServiceResource{
+				name:   "nginx",
+				config: config.ServiceResourceConfig{Name: "nginx", OnNotify: tt.onNotify},
+				sm:     sm,
+			}
+
+			var n Notifiable = r
+			if n.NotifyAction() != tt.wantAction {
+				t.Errorf("NotifyAction() = %q, want %q", n.NotifyAction(), tt.wantAction)
+			}
+			if err := n.Notify(context.Background()); err != nil {
+				t.Fatalf("Notify failed: %v", err)
+			}
+			if got := strings.Join(sm.calls, ","); got != tt.wantCalls {
+				t.Errorf("calls = %q, want %q", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestServiceResource_Validate_OnNotify(t *testing.T) {
+	invalid := "bounce"
+	r := &ServiceResource{
+		name:   "nginx",
+		config: config.ServiceResourceConfig{Name: "nginx", OnNotify: &invalid},
+	}
+	if err := r.Validate(); err == nil {
+		t.Error("expected error for invalid on_notify")
+	}
+}
@@ -0,0 +1,396 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+func init() {
+	Register("json_setting", settingFactory("json_setting", jsonFormat{}))
+	Register("yaml_setting", settingFactory("yaml_setting", yamlFormat{}))
+	Register("toml_setting", settingFactory("toml_setting", tomlFormat{}))
+	RegisterPrunable("json_setting", "path", "key")
+	RegisterPrunable("yaml_setting", "path", "key")
+	RegisterPrunable("toml_setting", "path", "key")
+}
+
+// settingFormat reads and edits the settings of a structured file format.
+// Settings are addressed by their key path, and values are those decoded
+// from JSON: strings, float64, bool, nil, []interface{} and
+// map[string]interface{}.
+type settingFormat interface {
+	// get returns the value at key and whether it is set
+	get(content string, key []string) (interface{}, bool, error)
+
+	// set returns content with key set to value, keeping the rest of the
+	// file's formatting as far as the format allows
+	set(content string, key []string, value interface{}) (string, error)
+
+	// remove returns content without key
+	remove(content string, key []string) (string, error)
+}
+
+// SettingResource manages a single setting in a JSON, YAML or TOML file
+type SettingResource struct {
+	resourceType string
+	name         string
+	description  string
+	config       config.SettingResourceConfig
+	dependsOn    []string
+	format       settingFormat
+}
+
+// settingFactory returns the factory of a setting resource type for format
+func settingFactory(resourceType string, format settingFormat) Factory {
+	return func(name string, body hcl.Body, dependsOn []string, description string, ctx *hcl.EvalContext) (Resource, error) {
+		var cfg config.SettingResourceConfig
+		diags := gohcl.DecodeBody(body, ctx, &cfg)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("failed to decode %s resource: %s", resourceType, diags.Error())
+		}
+
+		return &SettingResource{
+			resourceType: resourceType,
+			name:         name,
+			description:  description,
+			config:       cfg,
+			dependsOn:    dependsOn,
+			format:       format,
+		}, nil
+	}
+}
+
+func (r *SettingResource) Type() string        { return r.resourceType }
+func (r *SettingResource) Name() string        { return r.name }
+func (r *SettingResource) Description() string { return r.description }
+
+func (r *SettingResource) Validate() error {
+	if r.config.Path == "" {
+		return fmt.Errorf("%s.%s: path is required", r.resourceType, r.name)
+	}
+	if _, err := settingKey(r.config.Key); err != nil {
+		return fmt.Errorf("%s.%s: %w", r.resourceType, r.name, err)
+	}
+	ensure := r.ensure()
+	if ensure != "present" && ensure != "absent" {
+		return fmt.Errorf("%s.%s: ensure must be \"present\" or \"absent\"", r.resourceType, r.name)
+	}
+	if ensure == "present" {
+		if r.config.Value.IsNull() {
+			return fmt.Errorf("%s.%s: value is required", r.resourceType, r.name)
+		}
+		if _, err := plainValue(r.config.Value); err != nil {
+			return fmt.Errorf("%s.%s: invalid value: %w", r.resourceType, r.name, err)
+		}
+	}
+	return nil
+}
+
+func (r *SettingResource) Dependencies() []string {
+	return r.dependsOn
+}
+
+func (r *SettingResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *SettingResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
+func (r *SettingResource) ensure() string {
+	if r.config.Ensure != nil {
+		return *r.config.Ensure
+	}
+	return "present"
+}
+
+func (r *SettingResource) Read(ctx context.Context) (*State, error) {
+	key, err := settingKey(r.config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%s.%s: %w", r.resourceType, r.name, err)
+	}
+	return readSetting(r.format, r.config.Path, r.config.Key, key)
+}
+
+func (r *SettingResource) Diff(ctx context.Context, current *State) (*Plan, error) {
+	present := r.ensure() == "present"
+	var desired interface{}
+	if present {
+		value, err := plainValue(r.config.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: invalid value: %w", r.resourceType, r.name, err)
+		}
+		desired = value
+	}
+	return planSetting(r.resourceType+"."+r.name, current, r.config.Path, r.config.Key, desired, present, r.config.Create)
+}
+
+func (r *SettingResource) Apply(ctx context.Context, plan *Plan, apply bool) error {
+	if !apply || !plan.HasChanges() {
+		return nil
+	}
+	key, err := settingKey(r.config.Key)
+	if err != nil {
+		return err
+	}
+	var desired interface{}
+	if r.ensure() == "present" {
+		if desired, err = plainValue(r.config.Value); err != nil {
+			return err
+		}
+	}
+	return applySetting(r.format, r.config.Path, key, desired, r.ensure() == "present", r.config.Create)
+}
+
+// Revert restores the setting's previous value, or removes the file if it
+// was created
+func (r *SettingResource) Revert(ctx context.Context, before *State) error {
+	key, err := settingKey(r.config.Key)
+	if err != nil {
+		return err
+	}
+	return revertSetting(r.format, r.config.Path, key, before)
+}
+
+// settingKey splits a dotted key into its parts
+func settingKey(key string) ([]string, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	parts := strings.Split(key, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return parts, nil
+}
+
+// plainValue converts a value to its JSON decoded form
+func plainValue(val cty.Value) (interface{}, error) {
+	if !val.IsWhollyKnown() {
+		return nil, fmt.Errorf("value is not known")
+	}
+	val, _ = val.UnmarkDeep()
+	src, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(src, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// normalizeValue converts decoded values to their JSON decoded form, so
+// values read from different formats can be compared
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case uint64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case json.Number:
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		result := make([]interface{}, len(val))
+		for i, item := range val {
+			result[i] = normalizeValue(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			result[k] = normalizeValue(item)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			result[fmt.Sprint(k)] = normalizeValue(item)
+		}
+		return result
+	default:
+		return val
+	}
+}
+
+// diffSettings returns a change for each key under prefix whose value
+// differs, so changing one key of an object only shows that key
+func diffSettings(prefix string, old, new interface{}) []Change {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if !oldIsMap || !newIsMap {
+		if reflect.DeepEqual(old, new) {
+			return nil
+		}
+		return []Change{{Attribute: prefix, Old: old, New: new}}
+	}
+
+	keys := make([]string, 0, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		keys = append(keys, k)
+	}
+	for k := range newMap {
+		if _, ok := oldMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []Change
+	for _, k := range keys {
+		changes = append(changes, diffSettings(prefix+"."+k, oldMap[k], newMap[k])...)
+	}
+	return changes
+}
+
+// readSetting reads the value of a setting. The setting exists when its
+// key is set in the file.
+func readSetting(format settingFormat, path, keyName string, key []string) (*State, error) {
+	state := NewState()
+
+	content, exists, err := readFileContent(path)
+	if err != nil {
+		return nil, err
+	}
+	state.Attributes["path"] = path
+	state.Attributes["key"] = keyName
+	if !exists {
+		return state, nil
+	}
+	state.Attributes["file_exists"] = true
+
+	value, ok, err := format.get(content, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if ok {
+		state.Exists = true
+		state.Attributes["value"] = normalizeValue(value)
+	}
+	return state, nil
+}
+
+// planSetting plans setting the key to desired, or removing it
+func planSetting(id string, current *State, path, keyName string, desired interface{}, present bool, create *bool) (*Plan, error) {
+	plan := &Plan{
+		Before: current,
+		After:  NewState(),
+	}
+	for k, v := range current.Attributes {
+		plan.After.Attributes[k] = v
+	}
+	plan.After.Exists = current.Exists
+
+	fileExists, _ := current.Attributes["file_exists"].(bool)
+	old := current.Attributes["value"]
+
+	if !present {
+		if current.Exists {
+			plan.Action = ActionDelete
+			plan.After.Exists = false
+			delete(plan.After.Attributes, "value")
+			plan.Changes = append(plan.Changes, Change{Attribute: keyName, Old: old, New: nil})
+		}
+		return plan, nil
+	}
+
+	if !fileExists && (create == nil || !*create) {
+		return nil, fmt.Errorf("%s: %s does not exist, set create = true to create it", id, path)
+	}
+	if current.Exists && reflect.DeepEqual(old, desired) {
+		return plan, nil
+	}
+
+	plan.After.Exists = true
+	plan.After.Attributes["value"] = desired
+	if current.Exists {
+		plan.Action = ActionUpdate
+	} else {
+		plan.Action = ActionCreate
+	}
+	if !fileExists {
+		plan.Changes = append(plan.Changes, Change{Attribute: "path", Old: nil, New: path})
+	}
+	if !current.Exists {
+		old = nil
+	}
+	plan.Changes = append(plan.Changes, diffSettings(keyName, old, desired)...)
+	return plan, nil
+}
+
+// revertSetting restores a setting to its value before apply, removing it
+// if it was not set, and removes the file if it did not exist. Only the
+// setting is restored, so changes other resources made to the file are kept.
+func revertSetting(format settingFormat, path string, key []string, before *State) error {
+	if fileExists, _ := before.Attributes["file_exists"].(bool); !fileExists {
+		unlock := lockFileEdit(path)
+		defer unlock()
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		return nil
+	}
+	create := false
+	return applySetting(format, path, key, before.Attributes["value"], before.Exists, &create)
+}
+
+// applySetting sets or removes a setting in the file as it is when applied,
+// leaving the file untouched if it already has the desired value
+func applySetting(format settingFormat, path string, key []string, desired interface{}, present bool, create *bool) error {
+	unlock := lockFileEdit(path)
+	defer unlock()
+
+	content, exists, err := readFileContent(path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if !present {
+			return nil
+		}
+		if create == nil || !*create {
+			return fmt.Errorf("%s does not exist", path)
+		}
+	}
+
+	current, ok, err := format.get(content, key)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var updated string
+	switch {
+	case !present && !ok:
+		return nil
+	case !present:
+		updated, err = format.remove(content, key)
+	case ok && exists && reflect.DeepEqual(normalizeValue(current), desired):
+		return nil
+	default:
+		updated, err = format.set(content, key, desired)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return writeFileContent(path, updated)
+}
@@ -0,0 +1,273 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func init() {
+	Register("ini_setting", NewIniSettingResource)
+	RegisterPrunable("ini_setting", "path", "section", "key")
+}
+
+// IniSettingResource manages a single key in a section of an INI file
+type IniSettingResource struct {
+	name        string
+	description string
+	config      config.IniSettingResourceConfig
+	dependsOn   []string
+}
+
+// NewIniSettingResource creates a new ini_setting resource from HCL
+func NewIniSettingResource(name string, body hcl.Body, dependsOn []string, description string, ctx *hcl.EvalContext) (Resource, error) {
+	var cfg config.IniSettingResourceConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to decode ini_setting resource: %s", diags.Error())
+	}
+
+	return &IniSettingResource{
+		name:        name,
+		description: description,
+		config:      cfg,
+		dependsOn:   dependsOn,
+	}, nil
+}
+
+func (r *IniSettingResource) Type() string        { return "ini_setting" }
+func (r *IniSettingResource) Name() string        { return r.name }
+func (r *IniSettingResource) Description() string { return r.description }
+
+func (r *IniSettingResource) Validate() error {
+	if r.config.Path == "" {
+		return fmt.Errorf("ini_setting.%s: path is required", r.name)
+	}
+	if r.config.Key == "" {
+		return fmt.Errorf("ini_setting.%s: key is required", r.name)
+	}
+	if strings.ContainsAny(r.config.Key, "=\n") {
+		return fmt.Errorf("ini_setting.%s: invalid key %q", r.name, r.config.Key)
+	}
+	if strings.ContainsAny(r.section(), "[]\n") {
+		return fmt.Errorf("ini_setting.%s: invalid section %q", r.name, r.section())
+	}
+	ensure := r.ensure()
+	if ensure != "present" && ensure != "absent" {
+		return fmt.Errorf("ini_setting.%s: ensure must be \"present\" or \"absent\"", r.name)
+	}
+	if ensure == "present" {
+		if r.config.Value == nil {
+			return fmt.Errorf("ini_setting.%s: value is required", r.name)
+		}
+		if strings.Contains(*r.config.Value, "\n") {
+			return fmt.Errorf("ini_setting.%s: value must be a single line", r.name)
+		}
+	}
+	return nil
+}
+
+func (r *IniSettingResource) Dependencies() []string {
+	return r.dependsOn
+}
+
+func (r *IniSettingResource) AttributeSchema() map[string]Attribute {
+	return configAttributes(r.config)
+}
+
+func (r *IniSettingResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	return configValues(r.config), nil
+}
+
+func (r *IniSettingResource) ensure() string {
+	if r.config.Ensure != nil {
+		return *r.config.Ensure
+	}
+	return "present"
+}
+
+// section returns the section name, empty for keys before the first section
+func (r *IniSettingResource) section() string {
+	if r.config.Section != nil {
+		return *r.config.Section
+	}
+	return ""
+}
+
+// displayKey is the name of the setting in plans
+func (r *IniSettingResource) displayKey() string {
+	if r.section() == "" {
+		return r.config.Key
+	}
+	return r.section() + "." + r.config.Key
+}
+
+func (r *IniSettingResource) Read(ctx context.Context) (*State, error) {
+	state, err := readSetting(iniFormat{}, r.config.Path, r.config.Key, []string{r.section(), r.config.Key})
+	if err != nil {
+		return nil, err
+	}
+	state.Attributes["section"] = r.section()
+	return state, nil
+}
+
+func (r *IniSettingResource) Diff(ctx context.Context, current *State) (*Plan, error) {
+	present := r.ensure() == "present"
+	var desired interface{}
+	if present {
+		desired = *r.config.Value
+	}
+	return planSetting("ini_setting."+r.name, current, r.config.Path, r.displayKey(), desired, present, r.config.Create)
+}
+
+func (r *IniSettingResource) Apply(ctx context.Context, plan *Plan, apply bool) error {
+	if !apply || !plan.HasChanges() {
+		return nil
+	}
+	present := r.ensure() == "present"
+	var desired interface{}
+	if present {
+		desired = *r.config.Value
+	}
+	return applySetting(iniFormat{}, r.config.Path, []string{r.section(), r.config.Key}, desired, present, r.config.Create)
+}
+
+// Revert restores the setting's previous value, or removes the file if it
+// was created
+func (r *IniSettingResource) Revert(ctx context.Context, before *State) error {
+	return revertSetting(iniFormat{}, r.config.Path, []string{r.section(), r.config.Key}, before)
+}
+
+// iniFormat edits INI files line by line. Keys are a section and a key
+// within it, and values are strings.
+type iniFormat struct{}
+
+// iniLine is a parsed line of an INI file
+type iniLine struct {
+	section string
+	header  bool
+	key     string
+	value   string
+	entry   bool
+}
+
+func parseINILines(lines []string) []iniLine {
+	parsed := make([]iniLine, len(lines))
+	section := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#"):
+			parsed[i] = iniLine{section: section}
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			parsed[i] = iniLine{section: section, header: true}
+		default:
+			k, v, ok := strings.Cut(trimmed, "=")
+			parsed[i] = iniLine{
+				section: section,
+				key:     strings.TrimSpace(k),
+				value:   strings.TrimSpace(v),
+				entry:   ok,
+			}
+		}
+	}
+	return parsed
+}
+
+// findINIKey returns the line index of key in section, or -1
+func findINIKey(parsed []iniLine, section, key string) int {
+	for i, l := range parsed {
+		if l.entry && l.section == section && l.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f iniFormat) get(content string, key []string) (interface{}, bool, error) {
+	lines, _ := splitLines(content)
+	parsed := parseINILines(lines)
+	if i := findINIKey(parsed, key[0], key[1]); i >= 0 {
+		return parsed[i].value, true, nil
+	}
+	return nil, false, nil
+}
+
+func (f iniFormat) set(content string, key []string, value interface{}) (string, error) {
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("INI values must be strings")
+	}
+	section, name := key[0], key[1]
+	lines, trailing := splitLines(content)
+	parsed := parseINILines(lines)
+
+	// Keep everything up to the value, including the spacing after "="
+	if i := findINIKey(parsed, section, name); i >= 0 {
+		line := lines[i]
+		eq := strings.Index(line, "=") + 1
+		for eq < len(line) && (line[eq] == ' ' || line[eq] == '\t') {
+			eq++
+		}
+		lines[i] = line[:eq] + text
+		return joinLines(lines, trailing), nil
+	}
+
+	separator := " = "
+	for i, l := range parsed {
+		if l.entry {
+			if !strings.Contains(lines[i], " =") {
+				separator = "="
+			}
+			break
+		}
+	}
+	entry := name + separator + text
+
+	// Add the key after the last entry of its section, or after its header
+	at := -1
+	for i, l := range parsed {
+		if l.section == section && (l.entry || l.header) {
+			at = i + 1
+		}
+	}
+	if at < 0 && section == "" {
+		// The root section has no entries, so add the key before the
+		// first section
+		at = len(lines)
+		for i, l := range parsed {
+			if l.header {
+				at = i
+				entry += "\n"
+				break
+			}
+		}
+	} else if at < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+section+"]")
+		at = len(lines)
+	}
+
+	atEnd := at == len(lines)
+	lines = append(lines[:at], append(strings.Split(entry, "\n"), lines[at:]...)...)
+	return joinLines(lines, trailing || atEnd), nil
+}
+
+func (f iniFormat) remove(content string, key []string) (string, error) {
+	lines, trailing := splitLines(content)
+	parsed := parseINILines(lines)
+	i := findINIKey(parsed, key[0], key[1])
+	if i < 0 {
+		return content, nil
+	}
+	lines = append(lines[:i], lines[i+1:]...)
+	return joinLines(lines, trailing), nil
+}
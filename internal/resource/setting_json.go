@@ -0,0 +1,421 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// jsonFormat edits JSON files in place: only the text of the value that is
+// set or removed changes, and new values follow the file's indentation
+type jsonFormat struct{}
+
+// jsonObject is a JSON object that remembers the order of its keys and,
+// when it was parsed, where it and its values are in the file
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+
+	start, end int // offsets of the braces, end after the closing one
+	spans      map[string]jsonSpan
+}
+
+// jsonSpan is where a key of a parsed object and its value are in the file
+type jsonSpan struct {
+	key        int // offset of the key
+	start, end int // offsets of the value
+}
+
+func (f jsonFormat) get(content string, key []string) (interface{}, bool, error) {
+	doc, err := parseJSONDocument(content)
+	if err != nil {
+		return nil, false, err
+	}
+	value := interface{}(doc)
+	for _, part := range key {
+		obj, ok := value.(*jsonObject)
+		if !ok {
+			return nil, false, nil
+		}
+		if value, ok = obj.values[part]; !ok {
+			return nil, false, nil
+		}
+	}
+	return jsonPlain(value), true, nil
+}
+
+func (f jsonFormat) set(content string, key []string, value interface{}) (string, error) {
+	doc, err := parseJSONDocument(content)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(content) == "" {
+		setJSONKey(doc, key, value)
+		return formatJSONDocument(content, doc)
+	}
+
+	obj := doc
+	for i, part := range key[:len(key)-1] {
+		next, ok := obj.values[part]
+		if !ok {
+			// The rest of the key is added as new objects
+			nested := jsonOrdered(value)
+			for j := len(key) - 1; j > i; j-- {
+				child := &jsonObject{values: make(map[string]interface{})}
+				child.put(key[j], nested)
+				nested = child
+			}
+			return insertJSONKey(content, obj, part, nested)
+		}
+		if obj, ok = next.(*jsonObject); !ok {
+			return "", fmt.Errorf("%s is not an object", strings.Join(key[:i+1], "."))
+		}
+	}
+
+	last := key[len(key)-1]
+	span, ok := obj.spans[last]
+	if !ok {
+		return insertJSONKey(content, obj, last, jsonOrdered(value))
+	}
+	text, err := formatJSONValue(content, jsonOrdered(value), span.key)
+	if err != nil {
+		return "", err
+	}
+	return content[:span.start] + text + content[span.end:], nil
+}
+
+func (f jsonFormat) remove(content string, key []string) (string, error) {
+	doc, err := parseJSONDocument(content)
+	if err != nil {
+		return "", err
+	}
+	obj := doc
+	for _, part := range key[:len(key)-1] {
+		next, ok := obj.values[part].(*jsonObject)
+		if !ok {
+			return content, nil
+		}
+		obj = next
+	}
+
+	last := key[len(key)-1]
+	span, ok := obj.spans[last]
+	if !ok {
+		return content, nil
+	}
+	index := 0
+	for index < len(obj.keys) && obj.keys[index] != last {
+		index++
+	}
+	switch {
+	case len(obj.keys) == 1:
+		return content[:obj.start] + "{}" + content[obj.end:], nil
+	case index > 0:
+		// Remove the key with the comma after the value before it
+		prev := obj.spans[obj.keys[index-1]]
+		return content[:prev.end] + content[span.end:], nil
+	default:
+		next := obj.spans[obj.keys[1]]
+		return content[:span.key] + content[next.key:], nil
+	}
+}
+
+// setJSONKey sets a key in an object that is formatted as a whole, adding
+// objects for the parts of the key that are missing
+func setJSONKey(obj *jsonObject, key []string, value interface{}) {
+	for _, part := range key[:len(key)-1] {
+		next, ok := obj.values[part].(*jsonObject)
+		if !ok {
+			next = &jsonObject{values: make(map[string]interface{})}
+			obj.put(part, next)
+		}
+		obj = next
+	}
+	obj.put(key[len(key)-1], jsonOrdered(value))
+}
+
+// insertJSONKey adds a key to the end of a parsed object. An empty object is
+// formatted again as a whole.
+func insertJSONKey(content string, obj *jsonObject, key string, value interface{}) (string, error) {
+	if len(obj.keys) == 0 {
+		obj.put(key, value)
+		text, err := formatJSONValue(content, obj, obj.start)
+		if err != nil {
+			return "", err
+		}
+		return content[:obj.start] + text + content[obj.end:], nil
+	}
+
+	last := obj.spans[obj.keys[len(obj.keys)-1]]
+	var buf bytes.Buffer
+	if err := writeJSONScalar(&buf, key); err != nil {
+		return "", err
+	}
+	buf.WriteString(": ")
+	text, err := formatJSONValue(content, value, last.key)
+	if err != nil {
+		return "", err
+	}
+	buf.WriteString(text)
+
+	separator := ", "
+	if prefix, ok := linePrefix(content, last.key); ok {
+		separator = ",\n" + prefix
+	}
+	return content[:last.end] + separator + buf.String() + content[last.end:], nil
+}
+
+// formatJSONValue formats a value to go on the line of the key at offset.
+// Values in an object that is on a single line are formatted compactly.
+func formatJSONValue(content string, value interface{}, offset int) (string, error) {
+	var buf bytes.Buffer
+	prefix, ok := linePrefix(content, offset)
+	if !ok {
+		if err := writeJSONScalar(&buf, jsonPlain(value)); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	if err := writeJSONValue(&buf, value, jsonIndent(content), prefix); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// linePrefix returns the whitespace before offset on its line, reporting
+// false if there is anything else before it
+func linePrefix(content string, offset int) (string, bool) {
+	start := strings.LastIndex(content[:offset], "\n") + 1
+	prefix := content[start:offset]
+	if strings.TrimLeft(prefix, " \t") != "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// put sets a key, adding it after the existing keys if it is new
+func (o *jsonObject) put(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *jsonObject) delete(key string) {
+	if _, ok := o.values[key]; !ok {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// parseJSONDocument parses a JSON file whose top level value is an object.
+// An empty file is an empty object.
+func parseJSONDocument(content string) (*jsonObject, error) {
+	if strings.TrimSpace(content) == "" {
+		return &jsonObject{values: make(map[string]interface{})}, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(content))
+	dec.UseNumber()
+	value, err := decodeJSONValue(dec, content)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top level value")
+	}
+	obj, ok := value.(*jsonObject)
+	if !ok {
+		return nil, fmt.Errorf("the top level value is not an object")
+	}
+	return obj, nil
+}
+
+// decodeJSONValue decodes the next value, keeping the order of object keys
+// and where objects and their values are in content
+func decodeJSONValue(dec *json.Decoder, content string) (interface{}, error) {
+	start := skipJSONSeparators(content, int(dec.InputOffset()))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &jsonObject{values: make(map[string]interface{}), start: start, spans: make(map[string]jsonSpan)}
+		for dec.More() {
+			keyStart := skipJSONSeparators(content, int(dec.InputOffset()))
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			valueStart := skipJSONSeparators(content, int(dec.InputOffset()))
+			value, err := decodeJSONValue(dec, content)
+			if err != nil {
+				return nil, err
+			}
+			obj.put(keyTok.(string), value)
+			obj.spans[keyTok.(string)] = jsonSpan{key: keyStart, start: valueStart, end: int(dec.InputOffset())}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		obj.end = int(dec.InputOffset())
+		return obj, nil
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			value, err := decodeJSONValue(dec, content)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return list, nil
+	default:
+		return tok, nil
+	}
+}
+
+// skipJSONSeparators returns the offset of the next token at or after
+// offset, past whitespace, commas and colons
+func skipJSONSeparators(content string, offset int) int {
+	for offset < len(content) && strings.IndexByte(" \t\r\n,:", content[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// jsonPlain converts an ordered value to its JSON decoded form
+func jsonPlain(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *jsonObject:
+		result := make(map[string]interface{}, len(v.keys))
+		for k, item := range v.values {
+			result[k] = jsonPlain(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = jsonPlain(item)
+		}
+		return result
+	default:
+		return normalizeValue(v)
+	}
+}
+
+// jsonOrdered converts a JSON decoded value to an ordered one, with object
+// keys in name order
+func jsonOrdered(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		obj := &jsonObject{values: make(map[string]interface{}, len(v))}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			obj.put(k, jsonOrdered(v[k]))
+		}
+		return obj
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = jsonOrdered(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// jsonIndent returns the indentation of content, two spaces if it has none
+func jsonIndent(content string) string {
+	for _, line := range strings.Split(content, "\n")[1:] {
+		if trimmed := strings.TrimLeft(line, " \t"); trimmed != "" && trimmed != line {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+// formatJSONDocument encodes doc with the indentation of the original
+// content
+func formatJSONDocument(original string, doc *jsonObject) (string, error) {
+	var buf bytes.Buffer
+	if err := writeJSONValue(&buf, doc, jsonIndent(original), ""); err != nil {
+		return "", err
+	}
+	buf.WriteString("\n")
+	return buf.String(), nil
+}
+
+func writeJSONValue(buf *bytes.Buffer, value interface{}, indent, prefix string) error {
+	switch v := value.(type) {
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, k := range v.keys {
+			buf.WriteString(prefix + indent)
+			if err := writeJSONScalar(buf, k); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := writeJSONValue(buf, v.values[k], indent, prefix+indent); err != nil {
+				return err
+			}
+			if i < len(v.keys)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(prefix + "}")
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range v {
+			buf.WriteString(prefix + indent)
+			if err := writeJSONValue(buf, item, indent, prefix+indent); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(prefix + "]")
+	default:
+		return writeJSONScalar(buf, v)
+	}
+	return nil
+}
+
+func writeJSONScalar(buf *bytes.Buffer, value interface{}) error {
+	var scalar bytes.Buffer
+	enc := json.NewEncoder(&scalar)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimSuffix(scalar.Bytes(), []byte("\n")))
+	return nil
+}
@@ -0,0 +1,641 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSettingFormats_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  settingFormat
+		content string
+		key     []string
+		value   interface{}
+		want    string
+	}{
+		{
+			name:    "json replace keeps key order",
+			format:  jsonFormat{},
+			content: "{\n    \"b\": 1,\n    \"a\": {\n        \"c\": true\n    }\n}\n",
+			key:     []string{"a", "c"},
+			value:   false,
+			want:    "{\n    \"b\": 1,\n    \"a\": {\n        \"c\": false\n    }\n}\n",
+		},
+		{
+			name:    "json new nested key",
+			format:  jsonFormat{},
+			content: "{\n\t\"debug\": true,\n\t\"hosts\": [\"a\", \"b\"]\n}",
+			key:     []string{"log-opts", "max-size"},
+			value:   "10m",
+			want:    "{\n\t\"debug\": true,\n\t\"hosts\": [\"a\", \"b\"],\n\t\"log-opts\": {\n\t\t\"max-size\": \"10m\"\n\t}\n}",
+		},
+		{
+			name:    "json keeps the formatting of other values",
+			format:  jsonFormat{},
+			content: "{\n  \"hosts\" : [ \"a\",\"b\" ],\n  \"debug\":false\n}\n",
+			key:     []string{"debug"},
+			value:   true,
+			want:    "{\n  \"hosts\" : [ \"a\",\"b\" ],\n  \"debug\":true\n}\n",
+		},
+		{
+			name:    "json single line object",
+			format:  jsonFormat{},
+			content: "{\"debug\": true}",
+			key:     []string{"log-level"},
+			value:   "warn",
+			want:    "{\"debug\": true, \"log-level\": \"warn\"}",
+		},
+		{
+			name:    "json empty file",
+			format:  jsonFormat{},
+			content: "",
+			key:     []string{"hosts"},
+			value:   []interface{}{"a", "b"},
+			want:    "{\n  \"hosts\": [\n    \"a\",\n    \"b\"\n  ]\n}\n",
+		},
+		{
+			name:    "yaml keeps comments",
+			format:  yamlFormat{},
+			content: "# server settings\nserver:\n  port: 80 # http\n  host: localhost\n",
+			key:     []string{"server", "port"},
+			value:   float64(8080),
+			want:    "# server settings\nserver:\n  port: 8080 # http\n  host: localhost\n",
+		},
+		{
+			name:    "yaml new key",
+			format:  yamlFormat{},
+			content: "server:\n    host: localhost\n",
+			key:     []string{"server", "tls", "enabled"},
+			value:   true,
+			want:    "server:\n    host: localhost\n    tls:\n        enabled: true\n",
+		},
+		{
+			name:    "toml replace in place",
+			format:  tomlFormat{},
+			content: "# settings\ntitle = \"x\"\n\n[server]\nport = 80   # http\nhost = \"localhost\"\n",
+			key:     []string{"server", "port"},
+			value:   float64(8080),
+			want:    "# settings\ntitle = \"x\"\n\n[server]\nport = 8080   # http\nhost = \"localhost\"\n",
+		},
+		{
+			name:    "toml new key in table",
+			format:  tomlFormat{},
+			content: "[server]\nport = 80\n\n[client]\nretries = 3\n",
+			key:     []string{"server", "host"},
+			value:   "0.0.0.0",
+			want:    "[server]\nport = 80\nhost = \"0.0.0.0\"\n\n[client]\nretries = 3\n",
+		},
+		{
+			name:    "toml new root key",
+			format:  tomlFormat{},
+			content: "[server]\nport = 80\n",
+			key:     []string{"title"},
+			value:   "x",
+			want:    "title = \"x\"\n\n[server]\nport = 80\n",
+		},
+		{
+			name:    "toml dotted key in parent table",
+			format:  tomlFormat{},
+			content: "[server]\nport = 80\n",
+			key:     []string{"server", "tls", "enabled"},
+			value:   true,
+			want:    "[server]\nport = 80\ntls.enabled = true\n",
+		},
+		{
+			name:    "toml new table",
+			format:  tomlFormat{},
+			content: "title = \"x\"\n",
+			key:     []string{"server", "ports"},
+			value:   []interface{}{float64(80), float64(443)},
+			want:    "title = \"x\"\n\n[server]\nports = [80, 443]\n",
+		},
+		{
+			name:    "toml inline table",
+			format:  tomlFormat{},
+			content: "server = { port = 80 }\n",
+			key:     []string{"server", "host"},
+			value:   "localhost",
+			want:    "server = { host = \"localhost\", port = 80 }\n",
+		},
+		{
+			name:    "toml table from map",
+			format:  tomlFormat{},
+			content: "[server]\nport = 80\nold = true\n",
+			key:     []string{"server"},
+			value:   map[string]interface{}{"port": float64(8080)},
+			want:    "[server]\nport = 8080\n",
+		},
+		{
+			name:    "ini replace keeps spacing",
+			format:  iniFormat{},
+			content: "; main\n[main]\nname=web\nport =  80\n",
+			key:     []string{"main", "port"},
+			value:   "8080",
+			want:    "; main\n[main]\nname=web\nport =  8080\n",
+		},
+		{
+			name:    "ini new key uses file style",
+			format:  iniFormat{},
+			content: "[main]\nname=web\n\n[other]\nx=1\n",
+			key:     []string{"main", "port"},
+			value:   "80",
+			want:    "[main]\nname=web\nport=80\n\n[other]\nx=1\n",
+		},
+		{
+			name:    "ini new section",
+			format:  iniFormat{},
+			content: "[main]\nname = web\n",
+			key:     []string{"extra", "debug"},
+			value:   "true",
+			want:    "[main]\nname = web\n\n[extra]\ndebug = true\n",
+		},
+		{
+			name:    "ini root key",
+			format:  iniFormat{},
+			content: "[main]\nname = web\n",
+			key:     []string{"", "version"},
+			value:   "2",
+			want:    "version = 2\n\n[main]\nname = web\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.format.set(tt.content, tt.key, tt.value)
+			if err != nil {
+				t.Fatalf("set failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected content:\n%q\nwant:\n%q", got, tt.want)
+			}
+
+			value, ok, err := tt.format.get(got, tt.key)
+			if err != nil || !ok {
+				t.Fatalf("get failed: ok = %v, err = %v", ok, err)
+			}
+			if !reflect.DeepEqual(normalizeValue(value), tt.value) {
+				t.Errorf("expected value %#v, got %#v", tt.value, value)
+			}
+		})
+	}
+}
+
+func TestSettingFormats_Remove(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  settingFormat
+		content string
+		key     []string
+		want    string
+	}{
+		{
+			name:    "json",
+			format:  jsonFormat{},
+			content: "{\n  \"a\": 1,\n  \"b\": 2\n}\n",
+			key:     []string{"a"},
+			want:    "{\n  \"b\": 2\n}\n",
+		},
+		{
+			name:    "json first key",
+			format:  jsonFormat{},
+			content: "{\n  \"a\": 1,\n  \"b\": {\"c\": 2}\n}\n",
+			key:     []string{"a"},
+			want:    "{\n  \"b\": {\"c\": 2}\n}\n",
+		},
+		{
+			name:    "json only key",
+			format:  jsonFormat{},
+			content: "{\n  \"a\": {\n    \"b\": 1\n  }\n}\n",
+			key:     []string{"a", "b"},
+			want:    "{\n  \"a\": {}\n}\n",
+		},
+		{
+			name:    "yaml",
+			format:  yamlFormat{},
+			content: "a: 1\nb:\n  c: 2\n  d: 3\n",
+			key:     []string{"b", "c"},
+			want:    "a: 1\nb:\n  d: 3\n",
+		},
+		{
+			name:    "toml entry",
+			format:  tomlFormat{},
+			content: "a = 1\nb = 2\n",
+			key:     []string{"a"},
+			want:    "b = 2\n",
+		},
+		{
+			name:    "toml table",
+			format:  tomlFormat{},
+			content: "a = 1\n\n[b]\nc = 2\n\n[b.d]\ne = 3\n",
+			key:     []string{"b"},
+			want:    "a = 1\n",
+		},
+		{
+			name:    "ini",
+			format:  iniFormat{},
+			content: "[main]\na = 1\nb = 2\n",
+			key:     []string{"main", "a"},
+			want:    "[main]\nb = 2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.format.remove(tt.content, tt.key)
+			if err != nil {
+				t.Fatalf("remove failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected content:\n%q\nwant:\n%q", got, tt.want)
+			}
+			if _, ok, _ := tt.format.get(got, tt.key); ok {
+				t.Error("expected the key to be removed")
+			}
+		})
+	}
+}
+
+func TestTOMLFormat_Get(t *testing.T) {
+	content := `# example
+title = "TOML \"example\""
+literal = 'C:\path'
+multi = """
+one \
+  two"""
+ints = [ 1_000, 0x10, # comment
+  0o7, 0b11, ]
+float = 6.5e-1
+date = 1979-05-27 07:32:00Z
+point = { x = 1, y.z = 2 }
+
+[server."web.1"]
+enabled = true
+
+[[products]]
+name = "a"
+`
+	tests := []struct {
+		key  []string
+		want interface{}
+	}{
+		{[]string{"title"}, `TOML "example"`},
+		{[]string{"literal"}, `C:\path`},
+		{[]string{"multi"}, "one two"},
+		{[]string{"ints"}, []interface{}{float64(1000), float64(16), float64(7), float64(3)}},
+		{[]string{"float"}, 0.65},
+		{[]string{"date"}, "1979-05-27 07:32:00Z"},
+		{[]string{"point", "y", "z"}, float64(2)},
+		{[]string{"server", "web.1", "enabled"}, true},
+		{[]string{"products"}, []interface{}{map[string]interface{}{"name": "a"}}},
+	}
+
+	for _, tt := range tests {
+		got, ok, err := tomlFormat{}.get(content, tt.key)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("get(%v) = %#v, %v, want %#v", tt.key, got, ok, tt.want)
+		}
+	}
+
+	if _, err := (tomlFormat{}).set(content, []string{"products", "name"}, "b"); err == nil {
+		t.Error("expected error setting a key in an array of tables")
+	}
+	if _, _, err := (tomlFormat{}).get("a = 1\na = 2\n", []string{"a"}); err == nil {
+		t.Error("expected error for a duplicate key")
+	}
+}
+
+func TestTOMLFormat_RoundTrip(t *testing.T) {
+	content := `big = 9007199254740993
+large = 1000000000000000
+ratio = 3.0
+small = 1e-07
+date = 1979-05-27T07:32:00Z
+point = { when = 07:32:00, x = 9007199254740993, y = 3.0 }
+
+[[products]]
+name = "a"
+sku = 738594937
+
+[[products]]
+name = "b"
+`
+	// Each edit only rewrites the line it changes, keeping the types of the
+	// values it replaces
+	tests := []struct {
+		name     string
+		key      []string
+		value    interface{}
+		old, new string
+	}{
+		{
+			name:  "integer",
+			key:   []string{"large"},
+			value: float64(2e15),
+			old:   "large = 1000000000000000",
+			new:   "large = 2000000000000000",
+		},
+		{
+			name:  "float",
+			key:   []string{"ratio"},
+			value: float64(4),
+			old:   "ratio = 3.0",
+			new:   "ratio = 4.0",
+		},
+		{
+			name:  "date",
+			key:   []string{"date"},
+			value: "2000-01-01T00:00:00Z",
+			old:   "date = 1979-05-27T07:32:00Z",
+			new:   "date = 2000-01-01T00:00:00Z",
+		},
+		{
+			name:  "inline table",
+			key:   []string{"point", "z"},
+			value: float64(1),
+			old:   "point = { when = 07:32:00, x = 9007199254740993, y = 3.0 }",
+			new:   "point = { when = 07:32:00, x = 9007199254740993, y = 3.0, z = 1 }",
+		},
+		{
+			name:  "new key",
+			key:   []string{"count"},
+			value: float64(5),
+			old:   "point = { when = 07:32:00, x = 9007199254740993, y = 3.0 }\n",
+			new:   "point = { when = 07:32:00, x = 9007199254740993, y = 3.0 }\ncount = 5\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tomlFormat{}.set(content, tt.key, tt.value)
+			if err != nil {
+				t.Fatalf("set failed: %v", err)
+			}
+			if want := strings.Replace(content, tt.old, tt.new, 1); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+
+	got, err := tomlFormat{}.remove(content, []string{"point", "when"})
+	if err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if want := strings.Replace(content, "{ when = 07:32:00, ", "{ ", 1); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestYAMLFormat_MultipleDocuments(t *testing.T) {
+	content := "a: 1\n---\nb: 2\n"
+	if _, err := (yamlFormat{}).set(content, []string{"a"}, float64(5)); err == nil {
+		t.Error("expected error for a file with more than one document")
+	}
+	if _, _, err := (yamlFormat{}).get(content, []string{"a"}); err == nil {
+		t.Error("expected error for a file with more than one document")
+	}
+}
+
+func TestSettingResource_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hcl     string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			hcl: `
+				path  = "/etc/docker/daemon.json"
+				key   = "log-opts.max-size"
+				value = "10m"
+			`,
+		},
+		{
+			name: "absent without value",
+			hcl: `
+				path   = "/etc/docker/daemon.json"
+				key    = "debug"
+				ensure = "absent"
+			`,
+		},
+		{
+			name: "missing value",
+			hcl: `
+				path = "/etc/docker/daemon.json"
+				key  = "debug"
+			`,
+			wantErr: true,
+		},
+		{
+			name: "empty key part",
+			hcl: `
+				path  = "/etc/docker/daemon.json"
+				key   = "log-opts..max-size"
+				value = "10m"
+			`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := settingFactory("json_setting", jsonFormat{})("test", parseFileHCL(t, tt.hcl), nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			err = r.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSettingResource_Edit(t *testing.T) {
+	const daemon = "{\n  \"debug\": false,\n  \"log-opts\": {\n    \"max-size\": \"10m\"\n  }\n}\n"
+
+	tests := []struct {
+		name    string
+		hcl     string
+		content string
+		want    string
+		action  Action
+		changes []string
+	}{
+		{
+			name:    "update",
+			hcl:     "key = \"debug\"\nvalue = true",
+			content: daemon,
+			want:    "{\n  \"debug\": true,\n  \"log-opts\": {\n    \"max-size\": \"10m\"\n  }\n}\n",
+			action:  ActionUpdate,
+			changes: []string{"debug"},
+		},
+		{
+			name:    "unchanged",
+			hcl:     "key = \"log-opts.max-size\"\nvalue = \"10m\"",
+			content: daemon,
+			want:    daemon,
+			action:  ActionNoop,
+		},
+		{
+			name:    "one change per key",
+			hcl:     "key = \"log-opts\"\nvalue = { max-size = \"20m\", max-file = 3 }",
+			content: daemon,
+			want:    "{\n  \"debug\": false,\n  \"log-opts\": {\n    \"max-file\": 3,\n    \"max-size\": \"20m\"\n  }\n}\n",
+			action:  ActionUpdate,
+			changes: []string{"log-opts.max-file", "log-opts.max-size"},
+		},
+		{
+			name:    "create",
+			hcl:     "key = \"storage-driver\"\nvalue = \"overlay2\"",
+			content: daemon,
+			want:    "{\n  \"debug\": false,\n  \"log-opts\": {\n    \"max-size\": \"10m\"\n  },\n  \"storage-driver\": \"overlay2\"\n}\n",
+			action:  ActionCreate,
+			changes: []string{"storage-driver"},
+		},
+		{
+			name:    "absent",
+			hcl:     "key = \"log-opts\"\nensure = \"absent\"",
+			content: daemon,
+			want:    "{\n  \"debug\": false\n}\n",
+			action:  ActionDelete,
+			changes: []string{"log-opts"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "daemon.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			body := parseFileHCL(t, `path = "`+path+`"`+"\n"+tt.hcl)
+			r, err := settingFactory("json_setting", jsonFormat{})("daemon", body, nil, "", nil)
+			if err != nil {
+				t.Fatalf("failed to create resource: %v", err)
+			}
+			if err := r.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+
+			ctx := context.Background()
+			current, err := r.Read(ctx)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			plan, err := r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.Action != tt.action {
+				t.Errorf("expected action %s, got %s", tt.action, plan.Action)
+			}
+			var changes []string
+			for _, c := range plan.Changes {
+				changes = append(changes, c.Attribute)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("expected changes %v, got %v", tt.changes, changes)
+			}
+
+			if err := r.Apply(ctx, plan, true); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, data)
+			}
+
+			current, _ = r.Read(ctx)
+			plan, err = r.Diff(ctx, current)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if plan.HasChanges() {
+				t.Errorf("expected no changes on the second run, got %+v", plan.Changes)
+			}
+		})
+	}
+}
+
+func TestSettingResource_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	hcl := `
+		path  = "` + path + `"
+		key   = "server.port"
+		value = 8080
+	`
+	r, _ := settingFactory("toml_setting", tomlFormat{})("config", parseFileHCL(t, hcl), nil, "", nil)
+	ctx := context.Background()
+	current, err := r.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if _, err := r.Diff(ctx, current); err == nil {
+		t.Error("expected error for a missing file without create")
+	}
+
+	r, _ = settingFactory("toml_setting", tomlFormat{})("config", parseFileHCL(t, hcl+"create = true\n"), nil, "", nil)
+	plan, err := r.Diff(ctx, current)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "[server]\nport = 8080\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestIniSettingResource_Edit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "php.ini")
+	if err := os.WriteFile(path, []byte("[PHP]\nmemory_limit = 128M\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	r, err := NewIniSettingResource("memory", parseFileHCL(t, `
+		path    = "`+path+`"
+		section = "PHP"
+		key     = "memory_limit"
+		value   = "512M"
+	`), nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create resource: %v", err)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	ctx := context.Background()
+	before, _ := r.Read(ctx)
+	if _, ok := before.Attributes["content"]; ok {
+		t.Error("expected the file's content not to be kept in state")
+	}
+	plan, err := r.Diff(ctx, before)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if plan.Action != ActionUpdate || len(plan.Changes) != 1 || plan.Changes[0].Attribute != "PHP.memory_limit" {
+		t.Errorf("unexpected plan %s %+v", plan.Action, plan.Changes)
+	}
+	if err := r.Apply(ctx, plan, true); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "[PHP]\nmemory_limit = 512M\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+
+	if err := r.(Reversible).Revert(ctx, before); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "[PHP]\nmemory_limit = 128M\n" {
+		t.Errorf("expected the original content, got %q", data)
+	}
+}
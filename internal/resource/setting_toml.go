@@ -0,0 +1,1064 @@
+package resource
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// tomlFormat edits TOML files in place. Only the text of the entries that
+// change is rewritten, so comments, ordering and the formatting of other
+// entries are kept. Dates and times are read as strings.
+type tomlFormat struct{}
+
+// tomlDateTime is a date, time or date-time, kept as written so it is
+// written back unquoted
+type tomlDateTime string
+
+// tomlDocument is a parsed TOML file with the positions of its entries and
+// tables
+type tomlDocument struct {
+	content string
+	entries []*tomlEntry
+	tables  []*tomlTable
+	root    *tomlTable
+	values  map[string]interface{}
+}
+
+// tomlEntry is a key/value line, with its key including the table it is in
+type tomlEntry struct {
+	key        []string
+	table      *tomlTable
+	start, end int
+	valueStart int
+	valueEnd   int
+}
+
+// tomlTable is a [table] or [[array]] section. The root table holds the
+// entries before the first header.
+type tomlTable struct {
+	key        []string
+	array      bool
+	start, end int
+
+	// insertAt is where a new entry of the table goes: after its last
+	// entry, or after its header. It is -1 for an empty root table.
+	insertAt int
+}
+
+func (f tomlFormat) get(content string, key []string) (interface{}, bool, error) {
+	doc, err := parseTOMLDocument(content)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := doc.lookup(key)
+	return plainTOMLValue(value), ok, nil
+}
+
+func (f tomlFormat) set(content string, key []string, value interface{}) (string, error) {
+	doc, err := parseTOMLDocument(content)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range doc.tables {
+		if t.array && len(key) > len(t.key) && keyHasPrefix(key, t.key) {
+			return "", fmt.Errorf("%s is an array of tables", strings.Join(t.key, "."))
+		}
+	}
+	current, _ := doc.lookup(key)
+	value = typedTOMLValue(value, current)
+
+	// Keys set by an entry, or inside an inline table, are replaced in place
+	for _, e := range doc.entries {
+		if e.table.array || !keyHasPrefix(key, e.key) {
+			continue
+		}
+		if len(key) > len(e.key) {
+			current, _ := doc.lookup(e.key)
+			table, ok := current.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("%s is not a table", strings.Join(e.key, "."))
+			}
+			if err := setTOMLPath(table, key[len(e.key):], value); err != nil {
+				return "", err
+			}
+			value = table
+		}
+		text, err := encodeTOMLValue(value)
+		if err != nil {
+			return "", err
+		}
+		return content[:e.valueStart] + text + content[e.valueEnd:], nil
+	}
+
+	desired, isMap := value.(map[string]interface{})
+	if current, ok := doc.lookup(key); ok {
+		if table, ok := current.(map[string]interface{}); ok && isMap {
+			return f.setTable(content, key, table, desired)
+		}
+		if content, err = f.remove(content, key); err != nil {
+			return "", err
+		}
+		if doc, err = parseTOMLDocument(content); err != nil {
+			return "", err
+		}
+	}
+	if isMap && len(desired) > 0 {
+		return f.setTable(content, key, nil, desired)
+	}
+
+	text, err := encodeTOMLValue(value)
+	if err != nil {
+		return "", err
+	}
+	return doc.insert(key, text), nil
+}
+
+// setTable sets each key of a table, removing the keys that are not wanted
+func (f tomlFormat) setTable(content string, key []string, current, desired map[string]interface{}) (string, error) {
+	var err error
+	for _, k := range sortedKeys(desired) {
+		if content, err = f.set(content, childKey(key, k), desired[k]); err != nil {
+			return "", err
+		}
+	}
+	for _, k := range sortedKeys(current) {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if content, err = f.remove(content, childKey(key, k)); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+func (f tomlFormat) remove(content string, key []string) (string, error) {
+	doc, err := parseTOMLDocument(content)
+	if err != nil {
+		return "", err
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	for _, e := range doc.entries {
+		if e.table.array {
+			continue
+		}
+		if len(key) > len(e.key) && keyHasPrefix(key, e.key) {
+			// The key is inside an inline table
+			current, _ := doc.lookup(e.key)
+			table, ok := current.(map[string]interface{})
+			if !ok || !deleteTOMLPath(table, key[len(e.key):]) {
+				return content, nil
+			}
+			text, err := encodeTOMLValue(table)
+			if err != nil {
+				return "", err
+			}
+			return content[:e.valueStart] + text + content[e.valueEnd:], nil
+		}
+		if keyHasPrefix(e.key, key) {
+			spans = append(spans, span{e.start, e.end})
+		}
+	}
+	for _, t := range doc.tables {
+		if keyHasPrefix(t.key, key) {
+			spans = append(spans, span{t.start, t.end})
+		}
+	}
+	if len(spans) == 0 {
+		return content, nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	pos := 0
+	for _, s := range spans {
+		if s.start > pos {
+			b.WriteString(content[pos:s.start])
+		}
+		if s.end > pos {
+			pos = s.end
+		}
+	}
+	b.WriteString(content[pos:])
+
+	result := b.String()
+	if pos == len(content) && strings.TrimSpace(result) != "" {
+		// Drop the blank lines left before a removed last table
+		result = strings.TrimRight(result, "\n") + "\n"
+	}
+	return result, nil
+}
+
+// lookup returns the value at key
+func (d *tomlDocument) lookup(key []string) (interface{}, bool) {
+	var value interface{} = d.values
+	for _, part := range key {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = table[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// insert adds a new entry for key, in the table that holds its parent if
+// there is one, or in a new table at the end of the file
+func (d *tomlDocument) insert(key []string, text string) string {
+	parent, last := key[:len(key)-1], key[len(key)-1:]
+	entry := func(k []string) string {
+		return formatTOMLKey(k) + " = " + text + "\n"
+	}
+
+	if len(parent) == 0 {
+		switch {
+		case d.root.insertAt >= 0:
+			return insertText(d.content, d.root.insertAt, entry(last))
+		case len(d.tables) > 0:
+			return insertText(d.content, d.tables[0].start, entry(last)+"\n")
+		default:
+			return insertText(d.content, len(d.content), entry(last))
+		}
+	}
+
+	var deepest *tomlTable
+	for _, t := range d.tables {
+		if t.array || !keyHasPrefix(parent, t.key) {
+			continue
+		}
+		if len(t.key) == len(parent) {
+			return insertText(d.content, t.insertAt, entry(last))
+		}
+		if deepest == nil || len(t.key) > len(deepest.key) {
+			deepest = t
+		}
+	}
+	if deepest != nil {
+		return insertText(d.content, deepest.insertAt, entry(key[len(deepest.key):]))
+	}
+
+	// Tables defined by dotted keys in the root table get another dotted key
+	for _, e := range d.entries {
+		if e.table == d.root && len(e.key) > 1 && e.key[0] == parent[0] {
+			return insertText(d.content, d.root.insertAt, entry(key))
+		}
+	}
+
+	content := d.content
+	if content != "" {
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += "\n"
+	}
+	return content + "[" + formatTOMLKey(parent) + "]\n" + entry(last)
+}
+
+// insertText inserts text at offset, starting a new line if needed
+func insertText(content string, offset int, text string) string {
+	if offset > 0 && content[offset-1] != '\n' {
+		text = "\n" + text
+	}
+	return content[:offset] + text + content[offset:]
+}
+
+func keyHasPrefix(key, prefix []string) bool {
+	if len(prefix) > len(key) {
+		return false
+	}
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func childKey(key []string, child string) []string {
+	return append(append([]string(nil), key...), child)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setTOMLPath sets a value in nested tables, creating missing tables
+func setTOMLPath(table map[string]interface{}, key []string, value interface{}) error {
+	for i, part := range key[:len(key)-1] {
+		switch next := table[part].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			table[part] = child
+			table = child
+		case map[string]interface{}:
+			table = next
+		default:
+			return fmt.Errorf("%s is not a table", strings.Join(key[:i+1], "."))
+		}
+	}
+	table[key[len(key)-1]] = value
+	return nil
+}
+
+// deleteTOMLPath removes a value from nested tables, reporting whether it
+// was there
+func deleteTOMLPath(table map[string]interface{}, key []string) bool {
+	for _, part := range key[:len(key)-1] {
+		next, ok := table[part].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		table = next
+	}
+	if _, ok := table[key[len(key)-1]]; !ok {
+		return false
+	}
+	delete(table, key[len(key)-1])
+	return true
+}
+
+// formatTOMLKey formats a dotted key, quoting the parts that are not bare
+func formatTOMLKey(key []string) string {
+	parts := make([]string, len(key))
+	for i, part := range key {
+		bare := part != ""
+		for j := 0; j < len(part); j++ {
+			if !isTOMLBareKeyChar(part[j]) {
+				bare = false
+				break
+			}
+		}
+		if bare {
+			parts[i] = part
+		} else {
+			parts[i] = encodeTOMLString(part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// plainTOMLValue converts a parsed value to its JSON decoded form, as
+// settingFormat returns values
+func plainTOMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case tomlDateTime:
+		return string(v)
+	case int64:
+		return float64(v)
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = plainTOMLValue(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = plainTOMLValue(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// typedTOMLValue converts a value in its JSON decoded form to the TOML types
+// of the value it replaces. Whole numbers are written as integers unless
+// they replace a float, and strings replacing a date-time stay date-times.
+func typedTOMLValue(value, current interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if _, ok := current.(float64); !ok && v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v)
+		}
+		return v
+	case string:
+		if _, ok := current.(tomlDateTime); ok && isTOMLDateTime(v) {
+			return tomlDateTime(v)
+		}
+		return v
+	case []interface{}:
+		items, _ := current.([]interface{})
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			var old interface{}
+			if i < len(items) {
+				old = items[i]
+			}
+			result[i] = typedTOMLValue(item, old)
+		}
+		return result
+	case map[string]interface{}:
+		table, _ := current.(map[string]interface{})
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = typedTOMLValue(item, table[k])
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// tomlDateTimeLayouts are the forms of TOML dates and times, with a "T"
+// between the date and time
+var tomlDateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999",
+}
+
+// isTOMLDateTime reports whether s can be written as a TOML date-time
+func isTOMLDateTime(s string) bool {
+	if len(s) > 10 && (s[10] == ' ' || s[10] == 't') {
+		s = s[:10] + "T" + s[11:]
+	}
+	s = strings.ToUpper(s)
+	for _, layout := range tomlDateTimeLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeTOMLValue formats a value, using inline tables for maps
+func encodeTOMLValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML has no null value")
+	case string:
+		return encodeTOMLString(v), nil
+	case tomlDateTime:
+		return string(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "nan", nil
+		case math.IsInf(v, 1):
+			return "inf", nil
+		case math.IsInf(v, -1):
+			return "-inf", nil
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, err := encodeTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "{}", nil
+		}
+		items := make([]string, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			text, err := encodeTOMLValue(v[k])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, formatTOMLKey([]string{k})+" = "+text)
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+func encodeTOMLString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// tomlParser reads a TOML document, recording where its entries and tables
+// are
+type tomlParser struct {
+	src string
+	pos int
+}
+
+func parseTOMLDocument(content string) (*tomlDocument, error) {
+	p := &tomlParser{src: content}
+	doc := &tomlDocument{
+		content: content,
+		root:    &tomlTable{insertAt: -1, end: len(content)},
+		values:  make(map[string]interface{}),
+	}
+	current := doc.root
+
+	for {
+		lineStart := p.pos
+		p.skipSpace()
+		p.skipComment()
+		if p.pos >= len(p.src) {
+			break
+		}
+
+		switch c := p.src[p.pos]; {
+		case c == '\r' || c == '\n':
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+
+		case c == '[':
+			array := strings.HasPrefix(p.src[p.pos:], "[[")
+			closing := "]"
+			p.pos++
+			if array {
+				closing = "]]"
+				p.pos++
+			}
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if !strings.HasPrefix(p.src[p.pos:], closing) {
+				return nil, p.errorf("expected %q", closing)
+			}
+			p.pos += len(closing)
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+
+			if len(doc.tables) == 0 {
+				doc.root.end = lineStart
+			} else {
+				doc.tables[len(doc.tables)-1].end = lineStart
+			}
+			current = &tomlTable{key: key, array: array, start: lineStart, end: len(p.src), insertAt: p.pos}
+			doc.tables = append(doc.tables, current)
+			if err := doc.defineTable(key, array); err != nil {
+				return nil, p.errorf("%s", err)
+			}
+
+		default:
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if p.pos >= len(p.src) || p.src[p.pos] != '=' {
+				return nil, p.errorf("expected \"=\" after key")
+			}
+			p.pos++
+			p.skipSpace()
+			valueStart := p.pos
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			valueEnd := p.pos
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+
+			full := append(append([]string(nil), current.key...), key...)
+			doc.entries = append(doc.entries, &tomlEntry{
+				key:        full,
+				table:      current,
+				start:      lineStart,
+				end:        p.pos,
+				valueStart: valueStart,
+				valueEnd:   valueEnd,
+			})
+			current.insertAt = p.pos
+			if err := doc.defineValue(full, value); err != nil {
+				return nil, p.errorf("%s", err)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// parent returns the table that holds the last part of key, creating
+// missing tables. Arrays of tables resolve to their last element.
+func (d *tomlDocument) parent(key []string) (map[string]interface{}, error) {
+	table := d.values
+	for i, part := range key[:len(key)-1] {
+		switch next := table[part].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			table[part] = child
+			table = child
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			last, ok := next[len(next)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", strings.Join(key[:i+1], "."))
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", strings.Join(key[:i+1], "."))
+		}
+	}
+	return table, nil
+}
+
+func (d *tomlDocument) defineTable(key []string, array bool) error {
+	table, err := d.parent(key)
+	if err != nil {
+		return err
+	}
+	last := key[len(key)-1]
+	switch existing := table[last].(type) {
+	case nil:
+		if array {
+			table[last] = []interface{}{make(map[string]interface{})}
+		} else {
+			table[last] = make(map[string]interface{})
+		}
+	case map[string]interface{}:
+		if array {
+			return fmt.Errorf("%s is already a table", strings.Join(key, "."))
+		}
+	case []interface{}:
+		if !array {
+			return fmt.Errorf("%s is already an array", strings.Join(key, "."))
+		}
+		table[last] = append(existing, make(map[string]interface{}))
+	default:
+		return fmt.Errorf("%s is already a value", strings.Join(key, "."))
+	}
+	return nil
+}
+
+func (d *tomlDocument) defineValue(key []string, value interface{}) error {
+	table, err := d.parent(key)
+	if err != nil {
+		return err
+	}
+	last := key[len(key)-1]
+	if _, ok := table[last]; ok {
+		return fmt.Errorf("duplicate key %s", strings.Join(key, "."))
+	}
+	table[last] = value
+	return nil
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if p.pos < len(p.src) && p.src[p.pos] == '#' {
+		for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+}
+
+// skipBlank skips whitespace, comments and newlines, as allowed in arrays
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipSpace()
+		p.skipComment()
+		if p.pos < len(p.src) && (p.src[p.pos] == '\r' || p.src[p.pos] == '\n') {
+			p.pos++
+			continue
+		}
+		return
+	}
+}
+
+// endOfLine consumes the rest of a line, which may only hold a comment
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	p.skipComment()
+	if p.pos < len(p.src) && p.src[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return nil
+	}
+	if p.src[p.pos] != '\n' {
+		return p.errorf("unexpected %q", p.src[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseKey() ([]string, error) {
+	var parts []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("expected a key")
+		}
+		switch p.src[p.pos] {
+		case '"':
+			part, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		case '\'':
+			part, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && isTOMLBareKeyChar(p.src[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a key")
+			}
+			parts = append(parts, p.src[start:p.pos])
+		}
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '.' {
+			p.pos++
+			continue
+		}
+		return parts, nil
+	}
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+	switch p.src[p.pos] {
+	case '"':
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			return p.parseMultilineString(`"`)
+		}
+		return p.parseBasicString()
+	case '\'':
+		if strings.HasPrefix(p.src[p.pos:], "'''") {
+			return p.parseMultilineString("'")
+		}
+		return p.parseLiteralString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	default:
+		return p.parseScalar()
+	}
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		switch c := p.src[p.pos]; c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != '\'' {
+		if p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	return p.src[start : p.pos-1], nil
+}
+
+// parseMultilineString reads a multi-line basic or literal string
+func (p *tomlParser) parseMultilineString(quote string) (string, error) {
+	delim := strings.Repeat(quote, 3)
+	p.pos += 3
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+	} else if strings.HasPrefix(p.src[p.pos:], "\n") {
+		p.pos++
+	}
+
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			return "", p.errorf("unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			// Up to two quotes may come right before the closing ones
+			n := 3
+			for n < 5 && p.pos+n < len(p.src) && p.src[p.pos+n] == quote[0] {
+				n++
+			}
+			b.WriteString(strings.Repeat(quote, n-3))
+			p.pos += n
+			return b.String(), nil
+		}
+		c := p.src[p.pos]
+		if c != '\\' || quote == "'" {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		// A backslash at the end of a line trims the following whitespace
+		rest := strings.TrimLeft(p.src[p.pos+1:], " \t")
+		if strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n") {
+			p.pos = len(p.src) - len(strings.TrimLeft(rest, " \t\r\n"))
+			continue
+		}
+		if err := p.parseEscape(&b); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	p.pos++
+	if p.pos >= len(p.src) {
+		return p.errorf("unterminated string")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return p.errorf("invalid escape")
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf("invalid escape")
+		}
+		b.WriteRune(rune(code))
+		p.pos += n
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) parseArray() (interface{}, error) {
+	p.pos++
+	list := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.pos < len(p.src) && p.src[p.pos] == ']' {
+			p.pos++
+			return list, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+		p.skipBlank()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return list, nil
+		default:
+			return nil, p.errorf("expected \",\" or \"]\" in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (interface{}, error) {
+	p.pos++
+	table := make(map[string]interface{})
+	for {
+		p.skipBlank()
+		if p.pos < len(p.src) && p.src[p.pos] == '}' {
+			p.pos++
+			return table, nil
+		}
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != '=' {
+			return nil, p.errorf("expected \"=\" after key")
+		}
+		p.pos++
+		p.skipSpace()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := setTOMLPath(table, key, value); err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		p.skipBlank()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated inline table")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, p.errorf("expected \",\" or \"}\" in inline table")
+		}
+	}
+}
+
+func isTOMLScalarChar(c byte) bool {
+	return isTOMLBareKeyChar(c) || c == '+' || c == '.' || c == ':'
+}
+
+// parseScalar reads a boolean, number or date-time. Integers are read as
+// int64 and floats as float64, so each is written back as it was.
+func (p *tomlParser) parseScalar() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.src) && isTOMLScalarChar(p.src[p.pos]) {
+		p.pos++
+	}
+	// A date and time may be separated by a space
+	if tok := p.src[start:p.pos]; len(tok) == 10 && tok[4] == '-' && tok[7] == '-' &&
+		p.pos+1 < len(p.src) && p.src[p.pos] == ' ' && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+		p.pos++
+		for p.pos < len(p.src) && isTOMLScalarChar(p.src[p.pos]) {
+			p.pos++
+		}
+	}
+
+	tok := p.src[start:p.pos]
+	switch tok {
+	case "":
+		return nil, p.errorf("expected a value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	if strings.Contains(tok, ":") || len(tok) >= 10 && tok[4] == '-' {
+		return tomlDateTime(tok), nil
+	}
+
+	clean := strings.ReplaceAll(tok, "_", "")
+	bases := map[string]int{"0x": 16, "0o": 8, "0b": 2}
+	if base, ok := bases[strings.ToLower(clean[:min(2, len(clean))])]; ok {
+		n, err := strconv.ParseInt(clean[2:], base, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok)
+		}
+		return n, nil
+	}
+	if !strings.ContainsAny(clean, ".eE") {
+		n, err := strconv.ParseInt(clean, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid value %q", tok)
+		}
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(clean, 64)
+	if err != nil {
+		return nil, p.errorf("invalid value %q", tok)
+	}
+	return f, nil
+}
@@ -0,0 +1,162 @@
+package resource
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlFormat edits YAML files through their node tree, which keeps the
+// order of keys, comments and the style of values that are not changed
+type yamlFormat struct{}
+
+func (f yamlFormat) get(content string, key []string) (interface{}, bool, error) {
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		return nil, false, err
+	}
+	node := doc.Content[0]
+	for _, part := range key {
+		if node = yamlMappingValue(node, part); node == nil {
+			return nil, false, nil
+		}
+	}
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (f yamlFormat) set(content string, key []string, value interface{}) (string, error) {
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		return "", err
+	}
+
+	node := doc.Content[0]
+	for i, part := range key[:len(key)-1] {
+		next := yamlMappingValue(node, part)
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			yamlAppend(node, part, next)
+		} else if next.Kind != yaml.MappingNode {
+			return "", fmt.Errorf("%s is not a mapping", strings.Join(key[:i+1], "."))
+		}
+		node = next
+	}
+
+	var replacement yaml.Node
+	if err := replacement.Encode(value); err != nil {
+		return "", err
+	}
+	last := key[len(key)-1]
+	if existing := yamlMappingValue(node, last); existing != nil {
+		replacement.HeadComment = existing.HeadComment
+		replacement.LineComment = existing.LineComment
+		replacement.FootComment = existing.FootComment
+		*existing = replacement
+	} else {
+		yamlAppend(node, last, &replacement)
+	}
+	return formatYAMLDocument(content, doc)
+}
+
+func (f yamlFormat) remove(content string, key []string) (string, error) {
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		return "", err
+	}
+	node := doc.Content[0]
+	for _, part := range key[:len(key)-1] {
+		if node = yamlMappingValue(node, part); node == nil {
+			return content, nil
+		}
+	}
+	last := key[len(key)-1]
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == last {
+				node.Content = append(node.Content[:i], node.Content[i+2:]...)
+				break
+			}
+		}
+	}
+	return formatYAMLDocument(content, doc)
+}
+
+// parseYAMLDocument parses a YAML file whose top level value is a mapping.
+// An empty file is an empty mapping. Files with more than one document are
+// refused, as writing back the first would drop the others.
+func parseYAMLDocument(content string) (*yaml.Node, error) {
+	var doc yaml.Node
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	if err := dec.Decode(&doc); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("files with more than one document are not supported")
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the top level value is not a mapping")
+	}
+	return &doc, nil
+}
+
+// yamlMappingValue returns the value of key in a mapping node, or nil
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlAppend adds a key to the end of a mapping node
+func yamlAppend(node *yaml.Node, key string, value *yaml.Node) {
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		value,
+	)
+}
+
+// formatYAMLDocument encodes doc with the indentation of the original
+// content, two spaces if it had none
+func formatYAMLDocument(original string, doc *yaml.Node) (string, error) {
+	indent := 2
+	for _, line := range strings.Split(original, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed != "" && trimmed != line && !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "- ") {
+			indent = len(line) - len(trimmed)
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
 )
 
 func init() {
@@ -56,6 +58,58 @@ func (r *StatResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+// statAttributes are the attributes stat gathers, with the values exported
+// when the path does not exist
+var statAttributes = map[string]struct {
+	ty      cty.Type
+	missing cty.Value
+}{
+	"exists": {cty.Bool, cty.False},
+	"isdir":  {cty.Bool, cty.False},
+	"isfile": {cty.Bool, cty.False},
+	"islink": {cty.Bool, cty.False},
+	"size":   {cty.Number, cty.Zero},
+	"mode":   {cty.String, cty.StringVal("")},
+	"owner":  {cty.String, cty.StringVal("")},
+	"group":  {cty.String, cty.StringVal("")},
+	"uid":    {cty.Number, cty.NumberIntVal(-1)},
+	"gid":    {cty.Number, cty.NumberIntVal(-1)},
+	"mtime":  {cty.Number, cty.Zero},
+	"atime":  {cty.Number, cty.Zero},
+}
+
+func (r *StatResource) AttributeSchema() map[string]Attribute {
+	attrs := configAttributes(r.config)
+	for name, a := range statAttributes {
+		attrs[name] = Attribute{Type: a.ty, Computed: true}
+	}
+	return attrs
+}
+
+// ExportedAttributes stats the path so its details can be referenced by
+// other resources
+func (r *StatResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	state, err := r.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("stat.%s: %w", r.name, err)
+	}
+
+	values := configValues(r.config)
+	for name, a := range statAttributes {
+		v, ok := state.Attributes[name]
+		if !ok {
+			values[name] = a.missing
+			continue
+		}
+		val, err := gocty.ToCtyValue(v, a.ty)
+		if err != nil {
+			return nil, fmt.Errorf("stat.%s: attribute %s: %w", r.name, name, err)
+		}
+		values[name] = val
+	}
+	return values, nil
+}
+
 func (r *StatResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
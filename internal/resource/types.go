@@ -6,8 +6,8 @@ import (
 
 // State represents the current state of a resource
 type State struct {
-	Exists     bool
-	Attributes map[string]interface{}
+	Exists     bool                   `json:"exists"`
+	Attributes map[string]interface{} `json:"attributes"`
 }
 
 // NewState creates a new State with initialized attributes map
@@ -24,6 +24,18 @@ type Change struct {
 	New       interface{}
 }
 
+// unknownValue is the value of a change that is known after apply
+type unknownValue struct{}
+
+func (unknownValue) String() string { return "(known after apply)" }
+
+func (unknownValue) MarshalJSON() ([]byte, error) {
+	return []byte(`"(known after apply)"`), nil
+}
+
+// Unknown is the new value of a change that is only known after apply
+var Unknown interface{} = unknownValue{}
+
 // Action represents the type of change being made
 type Action int
 
@@ -59,6 +71,14 @@ type Plan struct {
 	Before     *State
 	After      *State
 	SkipReason string // Reason for skipping: "when condition false" or "dependency skipped"
+
+	// Deferred is set when the resource references values that are known
+	// after apply. Its changes are worked out just before it is applied.
+	Deferred bool
+
+	// Sensitive holds the attributes set from sensitive values, whose
+	// changes are shown as (sensitive value)
+	Sensitive map[string]bool
 }
 
 // HasChanges returns true if there are any changes in the plan
@@ -66,6 +86,16 @@ func (p *Plan) HasChanges() bool {
 	return p.Action != ActionNoop
 }
 
+// SkipError is returned by Apply when a change is left undone on purpose, so
+// the resource is reported as skipped rather than failed
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return e.Reason
+}
+
 // Resource is the interface that all resources must implement
 type Resource interface {
 	// Type returns the resource type (e.g., "file", "directory")
@@ -93,6 +123,28 @@ type Resource interface {
 	Dependencies() []string
 }
 
+// Notifiable is implemented by resources that can be notified when other
+// resources change, such as a service that restarts after its config changes
+type Notifiable interface {
+	Resource
+
+	// NotifyAction returns the action Notify performs (e.g., "restart")
+	NotifyAction() string
+
+	// Notify performs the notify action
+	Notify(ctx context.Context) error
+}
+
+// Reversible is implemented by resources that can undo an apply, so that a
+// partially applied run can be rolled back
+type Reversible interface {
+	Resource
+
+	// Revert restores the state that Read returned before the resource was
+	// applied
+	Revert(ctx context.Context, before *State) error
+}
+
 // ID returns the fully qualified resource ID (type.name)
 func ID(r Resource) string {
 	return r.Type() + "." + r.Name()
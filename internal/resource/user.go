@@ -6,15 +6,19 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func init() {
 	Register("user", NewUserResource)
+	RegisterPrunable("user", "name")
 }
 
 // UserResource manages system users
@@ -49,6 +53,14 @@ func (r *UserResource) Validate() error {
 	if r.config.Name == "" {
 		return fmt.Errorf("user.%s: name is required", r.name)
 	}
+	for _, key := range r.config.Keys {
+		if strings.TrimSpace(key.PublicKey) == "" {
+			return fmt.Errorf("user.%s: key public_key must not be empty", r.name)
+		}
+		if strings.ContainsAny(key.PublicKey, "\r\n") {
+			return fmt.Errorf("user.%s: key public_key must be a single line", r.name)
+		}
+	}
 	return nil
 }
 
@@ -56,6 +68,32 @@ func (r *UserResource) Dependencies() []string {
 	return r.dependsOn
 }
 
+func (r *UserResource) AttributeSchema() map[string]Attribute {
+	attrs := configAttributes(r.config)
+	for _, name := range []string{"uid", "gid"} {
+		attrs[name] = Attribute{Type: cty.String, Computed: true, KnownAfterApply: true}
+	}
+	return attrs
+}
+
+// ExportedAttributes exports the configuration, with the uid and gid the user
+// actually has once it exists
+func (r *UserResource) ExportedAttributes(ctx context.Context) (map[string]cty.Value, error) {
+	values := configValues(r.config)
+	state, err := r.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("user.%s: %w", r.name, err)
+	}
+	if state.Exists {
+		for _, name := range []string{"uid", "gid"} {
+			if id, ok := state.Attributes[name].(string); ok {
+				values[name] = cty.StringVal(id)
+			}
+		}
+	}
+	return values, nil
+}
+
 func (r *UserResource) Read(ctx context.Context) (*State, error) {
 	state := NewState()
 
@@ -83,6 +121,15 @@ func (r *UserResource) Read(ctx context.Context) (*State, error) {
 			groups, _ := r.getUserGroups(r.config.Name)
 			state.Attributes["groups"] = groups
 
+			// Only the configured keys are tracked
+			if len(r.config.Keys) > 0 {
+				present, err := presentKeys(authorizedKeysPath(fields[5]), r.authorizedKeys())
+				if err != nil {
+					return nil, err
+				}
+				state.Attributes["keys"] = present
+			}
+
 			return state, nil
 		}
 	}
@@ -169,6 +216,13 @@ func (r *UserResource) Diff(ctx context.Context, current *State) (*Plan, error)
 				New:       *r.config.Comment,
 			})
 		}
+		for _, key := range r.authorizedKeys() {
+			plan.Changes = append(plan.Changes, Change{
+				Attribute: "key",
+				Old:       nil,
+				New:       key,
+			})
+		}
 		return plan, nil
 	}
 
@@ -219,6 +273,17 @@ func (r *UserResource) Diff(ctx context.Context, current *State) (*Plan, error)
 		}
 	}
 
+	if len(r.config.Keys) > 0 {
+		present, _ := current.Attributes["keys"].([]string)
+		for _, key := range missingKeys(r.authorizedKeys(), present) {
+			plan.Changes = append(plan.Changes, Change{
+				Attribute: "key",
+				Old:       nil,
+				New:       key,
+			})
+		}
+	}
+
 	if len(plan.Changes) > 0 {
 		plan.Action = ActionUpdate
 	}
@@ -273,6 +338,8 @@ func (r *UserResource) Apply(ctx context.Context, plan *Plan, apply bool) error
 			return fmt.Errorf("failed to create user: %w\nOutput: %s", err, string(output))
 		}
 
+		return r.applyKeys(ctx, plan)
+
 	case ActionUpdate:
 		args := []string{}
 
@@ -299,11 +366,215 @@ func (r *UserResource) Apply(ctx context.Context, plan *Plan, apply bool) error
 				return fmt.Errorf("failed to modify user: %w\nOutput: %s", err, string(output))
 			}
 		}
+
+		return r.applyKeys(ctx, plan)
 	}
 
 	return nil
 }
 
+// applyKeys adds the keys planned to be added to the user's authorized_keys
+func (r *UserResource) applyKeys(ctx context.Context, plan *Plan) error {
+	var keys []string
+	for _, change := range plan.Changes {
+		if change.Attribute == "key" {
+			keys = append(keys, change.New.(string))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	state, err := r.Read(ctx)
+	if err != nil {
+		return err
+	}
+	if !state.Exists {
+		return fmt.Errorf("user %s does not exist", r.config.Name)
+	}
+	home, _ := state.Attributes["home"].(string)
+	uid, err := strconv.Atoi(state.Attributes["uid"].(string))
+	if err != nil {
+		return fmt.Errorf("invalid uid for user %s: %w", r.config.Name, err)
+	}
+	gid, err := strconv.Atoi(state.Attributes["gid"].(string))
+	if err != nil {
+		return fmt.Errorf("invalid gid for user %s: %w", r.config.Name, err)
+	}
+
+	if err := addAuthorizedKeys(authorizedKeysPath(home), keys, uid, gid); err != nil {
+		return fmt.Errorf("failed to add authorized keys: %w", err)
+	}
+	return nil
+}
+
+// Revert deletes the user if it was created, or restores its previous shell,
+// home, comment and groups. A deleted user is recreated with its old IDs.
+func (r *UserResource) Revert(ctx context.Context, before *State) error {
+	installed, err := r.Read(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !before.Exists {
+		if !installed.Exists {
+			return nil
+		}
+		cmd := exec.CommandContext(ctx, "userdel", r.config.Name)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to delete user: %w\nOutput: %s", err, string(output))
+		}
+		return nil
+	}
+
+	var args []string
+	for _, attr := range []struct{ flag, name string }{
+		{"-u", "uid"},
+		{"-g", "gid"},
+		{"-d", "home"},
+		{"-s", "shell"},
+		{"-c", "comment"},
+	} {
+		if value, ok := before.Attributes[attr.name].(string); ok {
+			args = append(args, attr.flag, value)
+		}
+	}
+	if groups, ok := before.Attributes["groups"].([]string); ok && len(groups) > 0 {
+		args = append(args, "-G", strings.Join(groups, ","))
+	}
+	args = append(args, r.config.Name)
+
+	command := "usermod"
+	if !installed.Exists {
+		command = "useradd"
+	}
+	cmd := exec.CommandContext(ctx, command, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to restore user: %w\nOutput: %s", err, string(output))
+	}
+
+	// Remove the keys that were added
+	kept, _ := before.Attributes["keys"].([]string)
+	if added := missingKeys(r.authorizedKeys(), kept); len(added) > 0 {
+		home, _ := before.Attributes["home"].(string)
+		if err := removeAuthorizedKeys(authorizedKeysPath(home), added); err != nil {
+			return fmt.Errorf("failed to remove authorized keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// authorizedKeys returns the authorized_keys lines of the configured keys
+func (r *UserResource) authorizedKeys() []string {
+	lines := make([]string, 0, len(r.config.Keys))
+	for _, key := range r.config.Keys {
+		line := strings.TrimSpace(key.PublicKey)
+		if key.Options != nil && *key.Options != "" {
+			line = *key.Options + " " + line
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// authorizedKeysPath returns the authorized_keys file of a home directory
+func authorizedKeysPath(home string) string {
+	return filepath.Join(home, ".ssh", "authorized_keys")
+}
+
+// presentKeys returns the keys that are lines of the authorized_keys file
+func presentKeys(path string, keys []string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		lines[strings.TrimSpace(line)] = true
+	}
+	present := []string{}
+	for _, key := range keys {
+		if lines[key] {
+			present = append(present, key)
+		}
+	}
+	return present, nil
+}
+
+// missingKeys returns the keys that are not present
+func missingKeys(keys, present []string) []string {
+	found := make(map[string]bool, len(present))
+	for _, key := range present {
+		found[key] = true
+	}
+	var missing []string
+	for _, key := range keys {
+		if !found[key] {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// addAuthorizedKeys appends keys to an authorized_keys file, creating it and
+// its .ssh directory owned by the user with the permissions sshd requires
+func addAuthorizedKeys(path string, keys []string, uid, gid int) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	for _, key := range keys {
+		content += key + "\n"
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// removeAuthorizedKeys removes keys from an authorized_keys file
+func removeAuthorizedKeys(path string, keys []string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	remove := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		remove[key] = true
+	}
+	var kept []string
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if !remove[strings.TrimSpace(line)] {
+			kept = append(kept, line)
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(kept, "")), 0600)
+}
+
 func stringSlicesEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -0,0 +1,99 @@
+package resource
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestUserResource_DynamicKeys(t *testing.T) {
+	body := parseDirHCL(t, `
+name = "deploy"
+
+key {
+  public_key = "ssh-ed25519 AAAA1 admin"
+  options    = "no-pty"
+}
+
+dynamic "key" {
+  for_each = var.keys
+  content {
+    public_key = key.value
+  }
+}
+`)
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{
+				"keys": cty.ListVal([]cty.Value{
+					cty.StringVal("ssh-ed25519 AAAA2 ci"),
+					cty.StringVal("ssh-ed25519 AAAA3 backup"),
+				}),
+			}),
+		},
+	}
+
+	r, err := Create(&config.ResourceBlock{Type: "user", Name: "deploy", Body: body}, ctx)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	want := []string{
+		"no-pty ssh-ed25519 AAAA1 admin",
+		"ssh-ed25519 AAAA2 ci",
+		"ssh-ed25519 AAAA3 backup",
+	}
+	if got := r.(*UserResource).authorizedKeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected keys %v, got %v", want, got)
+	}
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ssh", "authorized_keys")
+	keys := []string{"ssh-ed25519 AAAA1 a", "ssh-ed25519 AAAA2 b"}
+
+	present, err := presentKeys(path, keys)
+	if err != nil || len(present) != 0 {
+		t.Fatalf("expected no keys in a missing file, got %v: %v", present, err)
+	}
+
+	// Existing lines are kept
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAA0 other"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := addAuthorizedKeys(path, keys[:1], os.Getuid(), os.Getgid()); err != nil {
+		t.Fatalf("addAuthorizedKeys failed: %v", err)
+	}
+
+	present, err = presentKeys(path, keys)
+	if err != nil {
+		t.Fatalf("presentKeys failed: %v", err)
+	}
+	if missing := missingKeys(keys, present); !reflect.DeepEqual(missing, keys[1:]) {
+		t.Errorf("expected %v to be missing, got %v", keys[1:], missing)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	if err := removeAuthorizedKeys(path, keys[:1]); err != nil {
+		t.Fatalf("removeAuthorizedKeys failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "ssh-rsa AAAA0 other\n" {
+		t.Errorf("unexpected authorized_keys after removal: %q", data)
+	}
+}
@@ -1,6 +1,7 @@
 package role
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/source"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -18,6 +20,7 @@ type Loader struct {
 	mainParser  *config.Parser
 	mainBaseDir string
 	cliVars     map[string]cty.Value
+	fetcher     *source.Fetcher
 }
 
 // NewLoader creates a new role loader
@@ -29,14 +32,26 @@ func NewLoader(parser *config.Parser, baseDir string, cliVars map[string]cty.Val
 		mainParser:  parser,
 		mainBaseDir: baseDir,
 		cliVars:     cliVars,
+		fetcher:     source.NewFetcher(source.DefaultCacheDir()),
 	}
 }
 
+// SetFetcher sets the fetcher used for remote role sources
+func (l *Loader) SetFetcher(f *source.Fetcher) {
+	l.fetcher = f
+}
+
 // LoadRole loads a role from its source directory
 func (l *Loader) LoadRole(block *config.RoleBlock) (*Role, error) {
-	// 1. Resolve source path relative to main config
+	// 1. Fetch remote sources, or resolve the path relative to main config
 	roleDir := block.Source
-	if !filepath.IsAbs(roleDir) {
+	if source.IsRemote(block.Source) {
+		dir, err := l.fetcher.Fetch(context.Background(), block.Source)
+		if err != nil {
+			return nil, err
+		}
+		roleDir = dir
+	} else if !filepath.IsAbs(roleDir) {
 		roleDir = filepath.Join(l.mainBaseDir, block.Source)
 	}
 
@@ -77,8 +92,12 @@ func (l *Loader) LoadRole(block *config.RoleBlock) (*Role, error) {
 			return nil, fmt.Errorf("failed to evaluate role variables: %s", diags.Error())
 		}
 
+		// Marks on the whole object, such as a sensitive variable passed as
+		// is, are carried by each of its values
+		val, marks := val.Unmark()
 		if val.Type().IsObjectType() || val.Type().IsMapType() {
 			for k, v := range val.AsValueMap() {
+				v = v.WithMarks(marks)
 				// Validate against type constraint if one exists
 				if constraint, hasType := role.TypeConstraints[k]; hasType {
 					varRange := block.Variables.Range()
@@ -98,6 +117,24 @@ func (l *Loader) LoadRole(block *config.RoleBlock) (*Role, error) {
 	// 4. Build final variable scope with precedence
 	finalVars := role.BuildVariableScope(l.cliVars)
 
+	// Check values against nullable and the validation rules
+	for _, v := range role.Declarations {
+		if v.Sensitive {
+			l.mainParser.SetSensitive(v.Name)
+		}
+		val, exists := finalVars[v.Name]
+		if !exists {
+			val = cty.NullVal(cty.DynamicPseudoType)
+		}
+		checked, diags := config.CheckVariable(v, val, l.variableSource(block, role, v))
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("role %s: %s", block.Name, diags.Error())
+		}
+		if exists || !checked.IsNull() {
+			finalVars[v.Name] = checked
+		}
+	}
+
 	// 5. Set role context for template path resolution
 	l.mainParser.SetRoleContext(absRoleDir)
 	defer l.mainParser.ClearRoleContext()
@@ -108,18 +145,23 @@ func (l *Loader) LoadRole(block *config.RoleBlock) (*Role, error) {
 	}
 
 	// 6. Parse role's HCL files
-	resources, err := l.parseRoleResources(absRoleDir)
+	cfg, err := l.parseRoleConfig(absRoleDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse role resources: %w", err)
 	}
+	role.Outputs = cfg.Outputs
+	role.Locals = cfg.Locals
 
 	// 7. Prefix all resource names, transform dependencies, and set role base dir
+	resources := cfg.Resources
 	for _, res := range resources {
 		originalName := res.Name
 		res.Name = role.PrefixResourceName(originalName)
 
 		// Transform internal dependencies to use prefixes
 		res.DependsOn = l.transformDependencies(res.DependsOn, role.Name)
+		res.Notify = l.transformDependencies(res.Notify, role.Name)
+		res.Subscribe = l.transformDependencies(res.Subscribe, role.Name)
 
 		// Set the role base directory for template path resolution
 		res.RoleBaseDir = absRoleDir
@@ -160,6 +202,8 @@ func (l *Loader) loadDefaults(role *Role) error {
 		return fmt.Errorf("failed to decode defaults: %s", diags.Error())
 	}
 
+	role.Declarations = defaults.Variables
+
 	// Extract type constraints and default values
 	for _, v := range defaults.Variables {
 		// Parse type constraint if specified
@@ -191,10 +235,36 @@ func (l *Loader) loadDefaults(role *Role) error {
 	return nil
 }
 
-// parseRoleResources parses HCL files in the role directory
-func (l *Loader) parseRoleResources(roleDir string) ([]*config.ResourceBlock, error) {
-	var resources []*config.ResourceBlock
+// variableSource describes where the value of a role variable was set, in
+// the order of precedence of BuildVariableScope
+func (l *Loader) variableSource(block *config.RoleBlock, role *Role, v *config.Variable) string {
+	_, instantiated := role.Variables[v.Name]
+	_, hasDefault := role.Defaults[v.Name]
+	if _, ok := l.cliVars[v.Name]; ok && (instantiated || hasDefault) {
+		if source := l.mainParser.VariableSource(v.Name); source != "" {
+			return source
+		}
+		return "the command line"
+	}
+	if instantiated {
+		return fmt.Sprintf("the variables of role %q at %s", block.Name, block.Variables.Range())
+	}
+	if config.HasExpression(v.Default) {
+		return "the default value"
+	}
+	return ""
+}
+
+// roleConfig is the configuration in the HCL files of a role directory
+type roleConfig struct {
+	Resources []*config.ResourceBlock `hcl:"resource,block"`
+	Outputs   []*config.Output        `hcl:"output,block"`
+	Locals    []*config.Locals        `hcl:"locals,block"`
+}
 
+// parseRoleConfig parses the resources, outputs and locals in the HCL files
+// of the role directory
+func (l *Loader) parseRoleConfig(roleDir string) (*roleConfig, error) {
 	// Find all .hcl files in role directory (not recursive, excluding defaults/)
 	entries, err := os.ReadDir(roleDir)
 	if err != nil {
@@ -242,16 +312,13 @@ func (l *Loader) parseRoleResources(roleDir string) ([]*config.ResourceBlock, er
 
 	if len(files) == 0 {
 		// No HCL files in role directory is okay (might just have files/)
-		return resources, nil
+		return &roleConfig{}, nil
 	}
 
 	// Merge all file bodies
 	body := hcl.MergeFiles(files)
 
 	// Decode resources using main parser's context (with role variables)
-	type roleConfig struct {
-		Resources []*config.ResourceBlock `hcl:"resource,block"`
-	}
 	var cfg roleConfig
 	ctx := l.mainParser.GetEvalContext()
 	diags := gohcl.DecodeBody(body, ctx, &cfg)
@@ -259,7 +326,7 @@ func (l *Loader) parseRoleResources(roleDir string) ([]*config.ResourceBlock, er
 		return nil, fmt.Errorf("failed to decode role config: %s", diags.Error())
 	}
 
-	return cfg.Resources, nil
+	return &cfg, nil
 }
 
 // transformDependencies prefixes internal dependencies with role name
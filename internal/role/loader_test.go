@@ -1,11 +1,18 @@
 package role
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/z0mbix/hostcfg/internal/config"
+	"github.com/z0mbix/hostcfg/internal/source"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -372,6 +379,77 @@ role "missing" {
 	}
 }
 
+func TestLoader_LoadRole_RemoteSource(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Serve a tarball containing the role in a subdirectory
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"roles/redis/variables.hcl": `variable "port" { default = 6379 }`,
+		"roles/redis/resources.hcl": `
+resource "file" "config" {
+  path    = "/etc/redis/redis.conf"
+  content = "port ${var.port}"
+}
+`,
+	}
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(archive.Bytes())
+	}))
+	defer server.Close()
+
+	mainHCL := `
+role "redis" {
+  source = "` + server.URL + `/roles.tar.gz//roles/redis"
+}
+`
+	mainPath := filepath.Join(tmpDir, "main.hcl")
+	if err := os.WriteFile(mainPath, []byte(mainHCL), 0644); err != nil {
+		t.Fatalf("failed to write main.hcl: %v", err)
+	}
+
+	parser := config.NewParser()
+	cfg, diags := parser.ParseFile(mainPath)
+	if diags.HasErrors() {
+		t.Fatalf("failed to parse main config: %s", diags.Error())
+	}
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	loader := NewLoader(parser, tmpDir, nil)
+	loader.SetFetcher(source.NewFetcher(cacheDir))
+	role, err := loader.LoadRole(cfg.Roles[0])
+	if err != nil {
+		t.Fatalf("LoadRole failed: %v", err)
+	}
+
+	if !strings.HasPrefix(role.BaseDir, cacheDir) {
+		t.Errorf("role.BaseDir = %q, want a directory in %s", role.BaseDir, cacheDir)
+	}
+	if len(role.Resources) != 1 || role.Resources[0].Name != "redis_config" {
+		t.Fatalf("expected resource redis_config, got %+v", role.Resources)
+	}
+	if !role.Defaults["port"].Equals(cty.NumberIntVal(6379)).True() {
+		t.Errorf("default port = %v, want 6379", role.Defaults["port"])
+	}
+}
+
 func TestLoader_LoadRole_InvalidHCL(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -688,3 +766,84 @@ role "broken" {
 		t.Error("expected error for file as role source, got nil")
 	}
 }
+
+func TestLoader_LoadRole_VariableValidation(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	roleDir := filepath.Join(tmpDir, "roles", "redis")
+	if err := os.MkdirAll(roleDir, 0755); err != nil {
+		t.Fatalf("failed to create role dir: %v", err)
+	}
+	defaultsHCL := `
+variable "port" {
+  type    = number
+  default = 6379
+
+  validation {
+    condition     = var.port > 1024
+    error_message = "The port must be unprivileged."
+  }
+}
+`
+	if err := os.WriteFile(filepath.Join(roleDir, "variables.hcl"), []byte(defaultsHCL), 0644); err != nil {
+		t.Fatalf("failed to write variables.hcl: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		vars    string
+		cliVars map[string]cty.Value
+		wantErr string
+	}{
+		{name: "default", vars: `{}`},
+		{name: "valid", vars: `{ port = 6380 }`},
+		{
+			name: "instantiation",
+			vars: `{ port = 80 }`,
+			wantErr: `The port must be unprivileged.
+
+The value was set by the variables of role "redis" at ` + filepath.Join(tmpDir, "main.hcl"),
+		},
+		{
+			name:    "cli",
+			vars:    `{ port = 6380 }`,
+			cliVars: map[string]cty.Value{"port": cty.StringVal("443")},
+			wantErr: "The value was set by the -e port flag.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mainHCL := `
+role "redis" {
+  source    = "./roles/redis"
+  variables = ` + tt.vars + `
+}
+`
+			mainPath := filepath.Join(tmpDir, "main.hcl")
+			if err := os.WriteFile(mainPath, []byte(mainHCL), 0644); err != nil {
+				t.Fatalf("failed to write main.hcl: %v", err)
+			}
+
+			parser := config.NewParser()
+			for name := range tt.cliVars {
+				parser.SetVariableSource(name, "the -e "+name+" flag")
+			}
+			cfg, diags := parser.ParseFile(mainPath)
+			if diags.HasErrors() {
+				t.Fatalf("failed to parse main config: %s", diags.Error())
+			}
+
+			_, err := NewLoader(parser, tmpDir, tt.cliVars).LoadRole(cfg.Roles[0])
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -13,7 +13,10 @@ type Role struct {
 	Defaults        map[string]cty.Value     // From defaults/variables.hcl
 	Variables       map[string]cty.Value     // From instantiation
 	TypeConstraints map[string]cty.Type      // Variable type constraints
+	Declarations    []*config.Variable       // Variable blocks from variables.hcl
 	Resources       []*config.ResourceBlock  // Prefixed resources
+	Outputs         []*config.Output         // Values exported to the caller
+	Locals          []*config.Locals         // Values local to the role
 	DependsOn       []string                 // Role-level dependencies
 }
 
@@ -0,0 +1,140 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+)
+
+// runCommand runs a secret store's command and returns its output. Error
+// messages only include what the command wrote to stderr.
+func runCommand(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s failed: %w: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s failed: %w", name, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// sopsBackend decrypts files encrypted with sops. The keys of YAML, JSON,
+// INI and dotenv files are fields, with nested keys joined by dots.
+type sopsBackend struct {
+	ageKeyFile string
+}
+
+// sopsConfig is the secrets "sops" block
+type sopsConfig struct {
+	AgeKeyFile *string `hcl:"age_key_file,optional"` // Sets SOPS_AGE_KEY_FILE
+}
+
+func (b *sopsBackend) readsFiles() {}
+
+func (b *sopsBackend) Configure(body hcl.Body, ctx *hcl.EvalContext) hcl.Diagnostics {
+	var cfg sopsConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	if cfg.AgeKeyFile != nil {
+		b.ageKeyFile = *cfg.AgeKeyFile
+	}
+	return diags
+}
+
+func (b *sopsBackend) Fetch(ctx context.Context, path string) (*Secret, error) {
+	var env []string
+	if b.ageKeyFile != "" {
+		env = append(env, "SOPS_AGE_KEY_FILE="+b.ageKeyFile)
+	}
+	output, err := runCommand(ctx, env, "sops", "--decrypt", "--output-type", "json", path)
+	if err != nil {
+		return nil, err
+	}
+	fields := jsonFields(output)
+	if fields == nil {
+		return nil, fmt.Errorf("sops did not return a JSON object for %s", path)
+	}
+	return &Secret{Fields: fields}, nil
+}
+
+// ageBackend decrypts files encrypted with age. The decrypted content is the
+// secret; if it is a JSON object its keys are also fields.
+type ageBackend struct {
+	identity string
+}
+
+// ageConfig is the secrets "age" block
+type ageConfig struct {
+	Identity string `hcl:"identity"` // Identity file to decrypt with
+}
+
+func (b *ageBackend) readsFiles() {}
+
+func (b *ageBackend) Configure(body hcl.Body, ctx *hcl.EvalContext) hcl.Diagnostics {
+	var cfg ageConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	b.identity = cfg.Identity
+	return diags
+}
+
+func (b *ageBackend) Fetch(ctx context.Context, path string) (*Secret, error) {
+	if b.identity == "" {
+		return nil, fmt.Errorf("age identity is not set, set identity in a secrets \"age\" block")
+	}
+	output, err := runCommand(ctx, nil, "age", "--decrypt", "--identity", b.identity, path)
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{Value: string(output), Fields: jsonFields(output)}, nil
+}
+
+// passBackend reads secrets from pass, the standard unix password manager.
+// The first line of an entry is the secret, and the "key: value" lines that
+// follow it are fields.
+type passBackend struct {
+	storeDir string
+}
+
+// passConfig is the secrets "pass" block
+type passConfig struct {
+	Store *string `hcl:"store,optional"` // Sets PASSWORD_STORE_DIR
+}
+
+func (b *passBackend) Configure(body hcl.Body, ctx *hcl.EvalContext) hcl.Diagnostics {
+	var cfg passConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	if cfg.Store != nil {
+		b.storeDir = *cfg.Store
+	}
+	return diags
+}
+
+func (b *passBackend) Fetch(ctx context.Context, path string) (*Secret, error) {
+	var env []string
+	if b.storeDir != "" {
+		env = append(env, "PASSWORD_STORE_DIR="+b.storeDir)
+	}
+	output, err := runCommand(ctx, env, "pass", "show", path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	secret := &Secret{Value: lines[0], Fields: make(map[string]string)}
+	for _, line := range lines[1:] {
+		if key, value, ok := strings.Cut(line, ":"); ok && key != "" {
+			secret.Fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,241 @@
+// Package secrets reads secret values, such as passwords and TLS keys, from
+// secret stores. A secret is referenced as
+//
+//	backend:path#key
+//
+// where backend is one of sops, age, pass or vault, path locates the secret
+// in the store and the optional key selects one of its fields:
+//
+//	sops:secrets/db.enc.yaml#password
+//	age:tls/server.key.age
+//	pass:servers/db#username
+//	vault:myapp/db#password
+//
+// Each secret is fetched once and cached for the rest of the run.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// fetchTimeout limits how long fetching a single secret can take
+const fetchTimeout = 30 * time.Second
+
+// Secret is a secret read from a store. Value is the secret itself, for
+// stores that hold a single value; Fields holds its named values.
+type Secret struct {
+	Value  string
+	Fields map[string]string
+}
+
+// Backend reads secrets from a secret store
+type Backend interface {
+	// Configure sets the backend's options from a secrets block
+	Configure(body hcl.Body, ctx *hcl.EvalContext) hcl.Diagnostics
+
+	// Fetch reads the secret at path
+	Fetch(ctx context.Context, path string) (*Secret, error)
+}
+
+// fileBackend is implemented by backends that read local files, whose
+// relative paths are resolved against the configuration directory
+type fileBackend interface {
+	readsFiles()
+}
+
+// Store fetches secrets from the configured backends and caches them
+type Store struct {
+	mu         sync.Mutex
+	backends   map[string]Backend
+	configured map[string]bool
+	cache      map[string]*Secret // "backend:path" -> secret
+}
+
+// NewStore creates a store with every backend, using its defaults until
+// it is configured
+func NewStore() *Store {
+	return &Store{
+		backends: map[string]Backend{
+			"sops":  &sopsBackend{},
+			"age":   &ageBackend{},
+			"pass":  &passBackend{},
+			"vault": newVaultBackend(),
+		},
+		configured: make(map[string]bool),
+		cache:      make(map[string]*Secret),
+	}
+}
+
+// Configure sets the options of a backend from a secrets "<backend>" block
+func (s *Store) Configure(name string, body hcl.Body, ctx *hcl.EvalContext, subject hcl.Range) hcl.Diagnostics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backend, ok := s.backends[name]
+	if !ok {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unknown secrets backend",
+			Detail:   fmt.Sprintf("Backend %q is not supported, use one of %s.", name, strings.Join(s.names(), ", ")),
+			Subject:  &subject,
+		}}
+	}
+	if s.configured[name] {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Duplicate secrets block",
+			Detail:   fmt.Sprintf("The %s backend is already configured.", name),
+			Subject:  &subject,
+		}}
+	}
+	s.configured[name] = true
+	return backend.Configure(body, ctx)
+}
+
+// names returns the backend names in order
+func (s *Store) names() []string {
+	names := make([]string, 0, len(s.backends))
+	for name := range s.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the value of a secret reference. Relative paths of backends
+// that read files are resolved against baseDir.
+func (s *Store) Get(ref, baseDir string) (string, error) {
+	_, _, key, err := ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	secret, err := s.fetch(ref, baseDir)
+	if err != nil {
+		return "", err
+	}
+
+	if key != "" {
+		value, ok := secret.Fields[key]
+		if !ok {
+			return "", fmt.Errorf("secret %s has no key %q", ref, key)
+		}
+		return value, nil
+	}
+	if secret.Value != "" || len(secret.Fields) == 0 {
+		return secret.Value, nil
+	}
+	if len(secret.Fields) == 1 {
+		for _, value := range secret.Fields {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("secret %s has several keys, select one with #key", ref)
+}
+
+// GetFields returns the named values of a secret reference, which cannot
+// have a key
+func (s *Store) GetFields(ref, baseDir string) (map[string]string, error) {
+	_, _, key, err := ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		return nil, fmt.Errorf("secret %s: a key cannot be selected when reading every key", ref)
+	}
+	secret, err := s.fetch(ref, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Fields, nil
+}
+
+// fetch returns the secret a reference points at, from the cache if it was
+// already fetched
+func (s *Store) fetch(ref, baseDir string) (*Secret, error) {
+	name, path, _, err := ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backend, ok := s.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s: unknown backend %q, use one of %s", ref, name, strings.Join(s.names(), ", "))
+	}
+	if _, ok := backend.(fileBackend); ok && !filepath.IsAbs(path) && baseDir != "" {
+		path = filepath.Join(baseDir, path)
+	}
+
+	cacheKey := name + ":" + path
+	if secret, ok := s.cache[cacheKey]; ok {
+		return secret, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	secret, err := backend.Fetch(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	s.cache[cacheKey] = secret
+	return secret, nil
+}
+
+// ParseRef splits a secret reference into its backend, path and key
+func ParseRef(ref string) (backend, path, key string, err error) {
+	backend, rest, ok := strings.Cut(ref, ":")
+	if !ok || backend == "" {
+		return "", "", "", fmt.Errorf("invalid secret reference %q, expected backend:path#key", ref)
+	}
+	path, key, _ = strings.Cut(rest, "#")
+	if path == "" {
+		return "", "", "", fmt.Errorf("invalid secret reference %q: the path is empty", ref)
+	}
+	return backend, path, key, nil
+}
+
+// flattenJSON converts a decoded JSON object into fields, joining the keys
+// of nested objects with dots, such as "db.password". Values that are not
+// strings are kept in their JSON encoding.
+func flattenJSON(prefix string, value interface{}, fields map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			flattenJSON(name, item, fields)
+		}
+	case string:
+		fields[prefix] = v
+	case nil:
+		fields[prefix] = ""
+	default:
+		encoded, _ := json.Marshal(v)
+		fields[prefix] = string(encoded)
+	}
+}
+
+// jsonFields parses a JSON object into fields, returning nil if data is not
+// a JSON object
+func jsonFields(data []byte) map[string]string {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	fields := make(map[string]string)
+	flattenJSON("", doc, fields)
+	return fields
+}
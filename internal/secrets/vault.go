@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+)
+
+// vaultBackend reads secrets from the KV version 2 secrets engine of
+// HashiCorp Vault over its HTTP API
+type vaultBackend struct {
+	address   string
+	token     string
+	namespace string
+	mount     string
+	client    *http.Client
+}
+
+// vaultConfig is the secrets "vault" block
+type vaultConfig struct {
+	Address   *string `hcl:"address,optional"`   // Defaults to $VAULT_ADDR
+	Token     *string `hcl:"token,optional"`     // Defaults to $VAULT_TOKEN
+	Namespace *string `hcl:"namespace,optional"` // Defaults to $VAULT_NAMESPACE
+	Mount     *string `hcl:"mount,optional"`     // KV mount path, defaults to "secret"
+}
+
+func newVaultBackend() *vaultBackend {
+	return &vaultBackend{
+		address:   os.Getenv("VAULT_ADDR"),
+		token:     os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		mount:     "secret",
+		client:    &http.Client{Timeout: fetchTimeout},
+	}
+}
+
+func (b *vaultBackend) Configure(body hcl.Body, ctx *hcl.EvalContext) hcl.Diagnostics {
+	var cfg vaultConfig
+	diags := gohcl.DecodeBody(body, ctx, &cfg)
+	if diags.HasErrors() {
+		return diags
+	}
+	if cfg.Address != nil {
+		b.address = *cfg.Address
+	}
+	if cfg.Token != nil {
+		b.token = *cfg.Token
+	}
+	if cfg.Namespace != nil {
+		b.namespace = *cfg.Namespace
+	}
+	if cfg.Mount != nil {
+		b.mount = *cfg.Mount
+	}
+	return nil
+}
+
+// vaultResponse is the part of a KV v2 read response that holds the secret
+type vaultResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (b *vaultBackend) Fetch(ctx context.Context, path string) (*Secret, error) {
+	if b.address == "" {
+		return nil, fmt.Errorf("vault address is not set, set address in a secrets \"vault\" block or VAULT_ADDR")
+	}
+	if b.token == "" {
+		return nil, fmt.Errorf("vault token is not set, set token in a secrets \"vault\" block or VAULT_TOKEN")
+	}
+
+	endpoint, err := url.JoinPath(b.address, "v1", strings.Trim(b.mount, "/"), "data", strings.Trim(path, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault address %q: %w", b.address, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", b.token)
+	if b.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", b.namespace)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var decoded vaultResponse
+	if err := json.Unmarshal(body, &decoded); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(decoded.Errors) > 0 {
+			return nil, fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(decoded.Errors, "; "))
+		}
+		return nil, fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	fields := make(map[string]string)
+	flattenJSON("", decoded.Data.Data, fields)
+	return &Secret{Fields: fields}, nil
+}
@@ -0,0 +1,329 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Fetcher downloads remote sources into a cache directory
+type Fetcher struct {
+	CacheDir   string
+	HTTPClient *http.Client
+}
+
+// NewFetcher creates a fetcher that caches sources in cacheDir
+func NewFetcher(cacheDir string) *Fetcher {
+	return &Fetcher{
+		CacheDir:   cacheDir,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Fetch fetches a remote source and returns the local directory holding it.
+// Pinned sources already in the cache are not fetched again; anything else
+// is refreshed on every call.
+func (f *Fetcher) Fetch(ctx context.Context, raw string) (string, error) {
+	src, err := Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(f.CacheDir, src.cacheKey())
+	if _, err := os.Stat(dir); err != nil || !src.Pinned() {
+		if err := f.fetch(ctx, src, dir); err != nil {
+			return "", fmt.Errorf("failed to fetch %s: %w", raw, err)
+		}
+	}
+
+	root := filepath.Join(dir, filepath.FromSlash(src.Subdir))
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", fmt.Errorf("source %s has no directory %s", raw, src.Subdir)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("source %s: %s is not a directory", raw, src.Subdir)
+	}
+	return root, nil
+}
+
+// fetch downloads src into a temporary directory and moves it into place, so
+// an interrupted fetch never leaves a partial copy in the cache
+func (f *Fetcher) fetch(ctx context.Context, src *Source, dir string) error {
+	if err := os.MkdirAll(f.CacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp(f.CacheDir, ".fetch-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	switch src.Kind {
+	case KindGit:
+		err = fetchGit(ctx, src, tmpDir)
+	case KindHTTP:
+		err = f.fetchArchive(ctx, src, tmpDir)
+	default:
+		err = fmt.Errorf("unsupported source kind %q", src.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to replace cached copy: %w", err)
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return fmt.Errorf("failed to move source into cache: %w", err)
+	}
+	return nil
+}
+
+// fetchGit checks out a single commit of a repository, without history
+func fetchGit(ctx context.Context, src *Source, dir string) error {
+	ref := src.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	commands := [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth", "1", src.URL, ref},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	}
+	for _, args := range commands {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		// Never wait for credentials on a terminal
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	// Only the files are needed
+	return os.RemoveAll(filepath.Join(dir, ".git"))
+}
+
+// fetchArchive downloads an archive, verifies its checksum and extracts it
+func (f *Fetcher) fetchArchive(ctx context.Context, src *Source, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	// Keep the download on disk rather than in memory
+	archive, err := os.CreateTemp(f.CacheDir, ".archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		_ = archive.Close()
+		_ = os.Remove(archive.Name())
+	}()
+
+	var w io.Writer = archive
+	var h hash.Hash
+	var expected []byte
+	if src.Checksum != "" {
+		if h, expected, err = checksumHash(src.Checksum); err != nil {
+			return err
+		}
+		w = io.MultiWriter(archive, h)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download archive: %w", err)
+	}
+	if h != nil {
+		if actual := h.Sum(nil); !bytes.Equal(actual, expected) {
+			return fmt.Errorf("checksum mismatch: expected %x, got %x", expected, actual)
+		}
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return extractArchive(archive, dir)
+}
+
+// extractArchive extracts a tar, gzipped tar or zip archive into dir,
+// detecting the format from its contents. Entries are written through a
+// root, so links extracted by earlier entries cannot lead later ones outside
+// dir.
+func extractArchive(archive *os.File, dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = root.Close() }()
+
+	br := bufio.NewReader(archive)
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		info, err := archive.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(archive, info.Size())
+		if err != nil {
+			return fmt.Errorf("failed to read zip archive: %w", err)
+		}
+		return extractZip(zr, root)
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip archive: %w", err)
+		}
+		defer func() { _ = gz.Close() }()
+		return extractTar(tar.NewReader(gz), root)
+
+	default:
+		return extractTar(tar.NewReader(br), root)
+	}
+}
+
+func extractTar(tr *tar.Reader, root *os.Root) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		name, err := archivePath(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0755); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(root, name, tr, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := writeArchiveLink(root, name, hdr.Linkname); err != nil {
+				return err
+			}
+		default:
+			// Hard links, devices and pax headers are not needed for configuration
+			continue
+		}
+	}
+}
+
+func extractZip(zr *zip.Reader, root *os.Root) error {
+	for _, zf := range zr.File {
+		name, err := archivePath(zf.Name)
+		if err != nil {
+			return err
+		}
+
+		if zf.FileInfo().IsDir() {
+			if err := root.MkdirAll(name, 0755); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", zf.Name, err)
+			}
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s from zip archive: %w", zf.Name, err)
+		}
+		err = writeArchiveFile(root, name, rc, zf.Mode().Perm())
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath returns the path of an archive entry relative to the
+// directory it is extracted into, refusing entries that would land outside
+// it
+func archivePath(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("archive entry %s is outside the archive", name)
+	}
+	return clean, nil
+}
+
+// writeArchiveFile writes a file to name in root. Whatever is already there
+// is removed first rather than written through.
+func writeArchiveFile(root *os.Root, name string, r io.Reader, mode os.FileMode) error {
+	if err := root.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := root.Remove(name); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	out, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	return out.Close()
+}
+
+// writeArchiveLink creates a symlink at name in root, refusing absolute
+// targets and targets that resolve outside the archive, including through
+// links extracted before it
+func writeArchiveLink(root *os.Root, name, linkname string) error {
+	if filepath.IsAbs(linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), linkname)) {
+		return fmt.Errorf("archive link %s points outside the archive", linkname)
+	}
+	if err := root.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if err := root.Symlink(linkname, name); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+
+	// Links to entries that come later in the archive do not resolve yet,
+	// but the root refuses any that lead outside it
+	if _, err := root.Stat(name); err != nil && !os.IsNotExist(err) {
+		_ = root.Remove(name)
+		return fmt.Errorf("archive link %s points outside the archive", linkname)
+	}
+	return nil
+}
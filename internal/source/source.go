@@ -0,0 +1,182 @@
+// Package source fetches configuration and roles from remote locations into
+// a local cache so they can be loaded like any other directory.
+//
+// Two kinds of remote source are supported:
+//
+//	git::https://example.com/repo.git//hosts/web?ref=v1.2
+//	https://example.com/config.tar.gz//hosts/web?checksum=sha256:<hex>
+//
+// The optional "//subdir" selects a directory inside the repository or
+// archive. Anything else is treated as a local path.
+package source
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// KindGit is a git repository, fetched with the git command
+	KindGit = "git"
+	// KindHTTP is a tar, tar.gz or zip archive fetched over HTTP(S)
+	KindHTTP = "http"
+)
+
+// SystemCacheDir is the default cache location when running as root
+const SystemCacheDir = "/var/cache/hostcfg/sources"
+
+// commitRef matches a full git commit hash, which never moves
+var commitRef = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// Source is a parsed remote source address
+type Source struct {
+	Kind     string
+	URL      string // repository or archive URL, without subdir and hostcfg parameters
+	Subdir   string // directory within the repository or archive
+	Ref      string // git branch, tag or commit (git only)
+	Checksum string // "sha256:<hex>" or "sha512:<hex>" (http only)
+}
+
+// IsRemote returns true if raw is a remote source rather than a local path
+func IsRemote(raw string) bool {
+	return strings.HasPrefix(raw, "git::") ||
+		strings.HasPrefix(raw, "http://") ||
+		strings.HasPrefix(raw, "https://")
+}
+
+// Parse parses a remote source address
+func Parse(raw string) (*Source, error) {
+	if !IsRemote(raw) {
+		return nil, fmt.Errorf("%s is not a remote source", raw)
+	}
+
+	src := &Source{Kind: KindHTTP}
+	addr := raw
+	if rest, ok := strings.CutPrefix(raw, "git::"); ok {
+		src.Kind = KindGit
+		addr = rest
+	}
+
+	addr, rawQuery, _ := strings.Cut(addr, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %s: %w", raw, err)
+	}
+
+	addr, subdir := splitSubdir(addr)
+	if addr == "" {
+		return nil, fmt.Errorf("invalid source %s: missing URL", raw)
+	}
+	if subdir != "" {
+		subdir = path.Clean(subdir)
+		if path.IsAbs(subdir) || subdir == ".." || strings.HasPrefix(subdir, "../") {
+			return nil, fmt.Errorf("invalid source %s: subdirectory %s is outside the source", raw, subdir)
+		}
+		if subdir != "." {
+			src.Subdir = subdir
+		}
+	}
+
+	switch src.Kind {
+	case KindGit:
+		src.Ref = query.Get("ref")
+		query.Del("ref")
+		for param := range query {
+			return nil, fmt.Errorf("invalid source %s: unknown parameter %q", raw, param)
+		}
+	case KindHTTP:
+		src.Checksum = query.Get("checksum")
+		query.Del("checksum")
+		if src.Checksum != "" {
+			if _, _, err := checksumHash(src.Checksum); err != nil {
+				return nil, fmt.Errorf("invalid source %s: %w", raw, err)
+			}
+		}
+		// Other parameters belong to the archive URL, e.g. signed URLs
+		if len(query) > 0 {
+			addr += "?" + query.Encode()
+		}
+	}
+
+	src.URL = addr
+	return src, nil
+}
+
+// Pinned returns true if the source always has the same content: a git
+// commit hash or an archive with a checksum. Pinned sources are fetched once
+// and then served from the cache.
+func (s *Source) Pinned() bool {
+	switch s.Kind {
+	case KindGit:
+		return commitRef.MatchString(s.Ref)
+	case KindHTTP:
+		return s.Checksum != ""
+	}
+	return false
+}
+
+// cacheKey identifies the fetched repository or archive in the cache. The
+// subdirectory is not part of it, so sources sharing a repository and ref
+// share a single checkout.
+func (s *Source) cacheKey() string {
+	sum := sha256.Sum256([]byte(s.Kind + "\x00" + s.URL + "\x00" + s.Ref + "\x00" + s.Checksum))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// splitSubdir splits "scheme://host/repo//sub/dir" into the URL and subdir
+func splitSubdir(addr string) (string, string) {
+	start := 0
+	if i := strings.Index(addr, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	i := strings.Index(addr[start:], "//")
+	if i < 0 {
+		return addr, ""
+	}
+	return addr[:start+i], addr[start+i+2:]
+}
+
+// checksumHash returns the hash and expected digest for "algo:hex"
+func checksumHash(checksum string) (hash.Hash, []byte, error) {
+	algo, digest, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("checksum must be in the form sha256:<hex>, got %q", checksum)
+	}
+
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q (use sha256 or sha512)", algo)
+	}
+
+	expected, err := hex.DecodeString(digest)
+	if err != nil || len(expected) != h.Size() {
+		return nil, nil, fmt.Errorf("invalid %s checksum %q", algo, digest)
+	}
+	return h, expected, nil
+}
+
+// DefaultCacheDir returns the default cache location. Root uses the system
+// path; other users get a per-user cache so hostcfg works without privileges.
+func DefaultCacheDir() string {
+	if os.Geteuid() == 0 {
+		return SystemCacheDir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "hostcfg", "sources")
+	}
+	return SystemCacheDir
+}
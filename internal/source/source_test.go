@@ -0,0 +1,369 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsRemote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"git::https://example.com/repo.git", true},
+		{"git::file:///srv/repo.git", true},
+		{"https://example.com/config.tar.gz", true},
+		{"http://example.com/config.tar.gz", true},
+		{"./config", false},
+		{"/etc/hostcfg", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := IsRemote(tt.input); got != tt.expected {
+				t.Errorf("IsRemote(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Source
+	}{
+		{
+			input:    "git::https://example.com/repo.git",
+			expected: Source{Kind: KindGit, URL: "https://example.com/repo.git"},
+		},
+		{
+			input:    "git::https://example.com/repo.git//hosts/web?ref=v1.2",
+			expected: Source{Kind: KindGit, URL: "https://example.com/repo.git", Subdir: "hosts/web", Ref: "v1.2"},
+		},
+		{
+			input:    "git::file:///srv/repo.git//roles/nginx/",
+			expected: Source{Kind: KindGit, URL: "file:///srv/repo.git", Subdir: "roles/nginx"},
+		},
+		{
+			input:    "git::git@example.com:org/repo.git//hosts?ref=main",
+			expected: Source{Kind: KindGit, URL: "git@example.com:org/repo.git", Subdir: "hosts", Ref: "main"},
+		},
+		{
+			input: "https://example.com/config.tar.gz?checksum=sha256:" + strings.Repeat("ab", 32),
+			expected: Source{Kind: KindHTTP, URL: "https://example.com/config.tar.gz",
+				Checksum: "sha256:" + strings.Repeat("ab", 32)},
+		},
+		{
+			input:    "https://example.com/config.tar.gz//hosts/web?token=abc",
+			expected: Source{Kind: KindHTTP, URL: "https://example.com/config.tar.gz?token=abc", Subdir: "hosts/web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			src, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if *src != tt.expected {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.input, *src, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []string{
+		"./local/path",
+		"git::https://example.com/repo.git?depth=1",
+		"git::https://example.com/repo.git//../escape",
+		"https://example.com/config.tar.gz?checksum=abc",
+		"https://example.com/config.tar.gz?checksum=md5:abc",
+		"https://example.com/config.tar.gz?checksum=sha256:abc",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			if _, err := Parse(input); err == nil {
+				t.Errorf("expected error for %q", input)
+			}
+		})
+	}
+}
+
+func TestSource_Pinned(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"git::https://example.com/repo.git", false},
+		{"git::https://example.com/repo.git?ref=main", false},
+		{"git::https://example.com/repo.git?ref=" + strings.Repeat("a", 40), true},
+		{"https://example.com/config.tar.gz", false},
+		{"https://example.com/config.tar.gz?checksum=sha256:" + strings.Repeat("ab", 32), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			src, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if got := src.Pinned(); got != tt.expected {
+				t.Errorf("Pinned() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// makeTarGz builds a gzipped tarball from a map of file names to contents
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetcher_HTTP(t *testing.T) {
+	archive := makeTarGz(t, map[string]string{
+		"config/hosts/web/main.hcl": `resource "file" "motd" {}`,
+		"config/README":             "readme",
+	})
+	sum := sha256.Sum256(archive)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	f := NewFetcher(t.TempDir())
+	ctx := context.Background()
+
+	dir, err := f.Fetch(ctx, server.URL+"/config.tar.gz//config/hosts/web?checksum="+checksum)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "main.hcl"))
+	if err != nil {
+		t.Fatalf("expected main.hcl in fetched directory: %v", err)
+	}
+	if string(content) != `resource "file" "motd" {}` {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	// A pinned archive is served from the cache, whichever subdirectory is used
+	if _, err := f.Fetch(ctx, server.URL+"/config.tar.gz//config?checksum="+checksum); err != nil {
+		t.Fatalf("second Fetch failed: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected pinned archive to be downloaded once, got %d requests", requests)
+	}
+
+	// An unpinned archive is downloaded every time
+	for range 2 {
+		if _, err := f.Fetch(ctx, server.URL+"/config.tar.gz//config"); err != nil {
+			t.Fatalf("unpinned Fetch failed: %v", err)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("expected unpinned archive to be downloaded each time, got %d requests", requests)
+	}
+}
+
+func TestFetcher_HTTP_Errors(t *testing.T) {
+	archive := makeTarGz(t, map[string]string{"main.hcl": ""})
+	escaping := makeTarGz(t, map[string]string{"../escape.hcl": ""})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.tar.gz":
+			_, _ = w.Write(archive)
+		case "/escape.tar.gz":
+			_, _ = w.Write(escaping)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"checksum mismatch", "/config.tar.gz?checksum=sha256:" + strings.Repeat("0", 64), "checksum mismatch"},
+		{"not found", "/missing.tar.gz", "404"},
+		{"missing subdirectory", "/config.tar.gz//hosts", "has no directory hosts"},
+		{"path traversal", "/escape.tar.gz", "outside the archive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			f := NewFetcher(cacheDir)
+			_, err := f.Fetch(context.Background(), server.URL+tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+
+			// Failed fetches leave nothing behind but the cache directory
+			entries, _ := os.ReadDir(cacheDir)
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), ".") {
+					t.Errorf("temporary file %s left in cache", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestFetcher_HTTP_SymlinkChain(t *testing.T) {
+	// Each link is inside the archive on its own, but e/ resolves to the
+	// parent of the extraction directory through the links before it
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	_ = tw.WriteHeader(&tar.Header{Name: "d", Linkname: ".", Typeflag: tar.TypeSymlink})
+	_ = tw.WriteHeader(&tar.Header{Name: "d/e", Linkname: "..", Typeflag: tar.TypeSymlink})
+	_ = tw.WriteHeader(&tar.Header{Name: "e/pwned.txt", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	_, _ = tw.Write([]byte("x"))
+	_ = tw.Close()
+	_ = gz.Close()
+	archive := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	f := NewFetcher(cacheDir)
+	_, err := f.Fetch(context.Background(), server.URL+"/chain.tar.gz")
+	if err == nil || !strings.Contains(err.Error(), "outside the archive") {
+		t.Fatalf("expected error containing %q, got: %v", "outside the archive", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "pwned.txt")); !os.IsNotExist(err) {
+		t.Error("expected nothing to be written outside the archive")
+	}
+}
+
+// git runs a git command in dir, failing the test on error
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestFetcher_Git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	tmpDir := t.TempDir()
+	bareDir := filepath.Join(tmpDir, "config.git")
+	workDir := filepath.Join(tmpDir, "work")
+
+	// Build a bare repository with a tagged release and a newer commit
+	for _, dir := range []string{bareDir, workDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	git(t, bareDir, "init", "--quiet", "--bare")
+	git(t, workDir, "init", "--quiet")
+
+	hostDir := filepath.Join(workDir, "hosts", "web")
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		t.Fatalf("failed to create host dir: %v", err)
+	}
+	writeMain := func(content string) {
+		if err := os.WriteFile(filepath.Join(hostDir, "main.hcl"), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write main.hcl: %v", err)
+		}
+		git(t, workDir, "add", "-A")
+		git(t, workDir, "commit", "--quiet", "-m", content)
+	}
+	writeMain("v1")
+	git(t, workDir, "tag", "v1.0")
+	v1 := git(t, workDir, "rev-parse", "HEAD")
+	writeMain("v2")
+	git(t, workDir, "push", "--quiet", "--tags", bareDir, "HEAD:refs/heads/main")
+	git(t, bareDir, "symbolic-ref", "HEAD", "refs/heads/main")
+
+	f := NewFetcher(filepath.Join(tmpDir, "cache"))
+	repo := "git::file://" + bareDir
+
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{"default branch", repo + "//hosts/web", "v2"},
+		{"branch", repo + "//hosts/web?ref=main", "v2"},
+		{"tag", repo + "//hosts/web?ref=v1.0", "v1"},
+		{"commit", repo + "//hosts/web?ref=" + v1, "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := f.Fetch(context.Background(), tt.source)
+			if err != nil {
+				t.Fatalf("Fetch failed: %v", err)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "main.hcl"))
+			if err != nil {
+				t.Fatalf("expected main.hcl in fetched directory: %v", err)
+			}
+			if string(content) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, content)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "..", "..", ".git")); !os.IsNotExist(err) {
+				t.Error("expected .git to be removed from the fetched source")
+			}
+		})
+	}
+
+	if _, err := f.Fetch(context.Background(), repo+"?ref=no-such-branch"); err == nil {
+		t.Error("expected error fetching a missing ref")
+	}
+}
@@ -0,0 +1,254 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FormatVersion is the version of the state file format written by this build
+const FormatVersion = 1
+
+// SystemPath is the default state file location when running as root
+const SystemPath = "/var/lib/hostcfg/state.json"
+
+// State is the record of everything hostcfg has managed on this host
+type State struct {
+	Version   int                       `json:"version"`
+	Config    string                    `json:"config,omitempty"` // config path of the last apply
+	UpdatedAt time.Time                 `json:"updated_at"`
+	Resources map[string]*ResourceState `json:"resources"`
+	Outputs   map[string]*Output        `json:"outputs,omitempty"`
+}
+
+// Output is an output value recorded by the last apply
+type Output struct {
+	Value       json.RawMessage `json:"value"`
+	Type        json.RawMessage `json:"type"` // cty type of the value, in its JSON encoding
+	Description string          `json:"description,omitempty"`
+	Sensitive   bool            `json:"sensitive,omitempty"` // the value is not recorded
+}
+
+// ResourceState is the last-applied state of a single managed resource
+type ResourceState struct {
+	Type       string                 `json:"type"`
+	Name       string                 `json:"name"`
+	Attributes map[string]interface{} `json:"attributes"`
+	DependsOn  []string               `json:"depends_on,omitempty"`
+	AppliedAt  time.Time              `json:"applied_at"`
+}
+
+// New creates an empty state
+func New() *State {
+	return &State{
+		Version:   FormatVersion,
+		Resources: make(map[string]*ResourceState),
+	}
+}
+
+// Set records the last-applied attributes and dependencies of a resource
+func (s *State) Set(resourceType, name string, attrs map[string]interface{}, deps []string) {
+	if attrs == nil {
+		attrs = make(map[string]interface{})
+	}
+	s.Resources[resourceType+"."+name] = &ResourceState{
+		Type:       resourceType,
+		Name:       name,
+		Attributes: attrs,
+		DependsOn:  deps,
+		AppliedAt:  time.Now().UTC(),
+	}
+}
+
+// Remove forgets a resource
+func (s *State) Remove(id string) {
+	delete(s.Resources, id)
+}
+
+// Get returns the recorded state for a resource ID
+func (s *State) Get(id string) (*ResourceState, bool) {
+	rs, ok := s.Resources[id]
+	return rs, ok
+}
+
+// IDs returns all recorded resource IDs in sorted order
+func (s *State) IDs() []string {
+	ids := make([]string, 0, len(s.Resources))
+	for id := range s.Resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Store reads and writes the state file
+type Store struct {
+	path string
+}
+
+// NewStore creates a store backed by the given file path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the state file path
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the state file. A missing file returns an empty state.
+func (s *Store) Load() (*State, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	st := New()
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", s.path, err)
+	}
+	if st.Version > FormatVersion {
+		return nil, fmt.Errorf("state file %s has version %d, this hostcfg supports up to %d",
+			s.path, st.Version, FormatVersion)
+	}
+	if st.Resources == nil {
+		st.Resources = make(map[string]*ResourceState)
+	}
+	return st, nil
+}
+
+// Save writes the state file atomically, creating its directory if needed
+func (s *Store) Save(st *State) error {
+	st.Version = FormatVersion
+	st.UpdatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	data = append(data, '\n')
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Write to a temp file in the same directory for atomic rename
+	tmpFile, err := os.CreateTemp(dir, ".state-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		// Clean up temp file on error
+		if tmpPath != "" {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if err := tmpFile.Chmod(0600); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("failed to set state file mode: %w", err)
+	}
+	_, err = tmpFile.Write(data)
+	_ = tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to move state file into place: %w", err)
+	}
+	tmpPath = ""
+
+	return nil
+}
+
+// DefaultPath returns the default state file location. Root uses the system
+// path; other users get a per-user file so hostcfg works without privileges.
+func DefaultPath() string {
+	if os.Geteuid() == 0 {
+		return SystemPath
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "hostcfg", "state.json")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "hostcfg", "state.json")
+	}
+	return SystemPath
+}
+
+// Lock is an exclusive lock held while hostcfg changes the host
+type Lock struct {
+	path string
+}
+
+// Lock takes the lock file next to the state file, so that two runs never
+// change the host at the same time. A lock left behind by a process that no
+// longer exists is taken over.
+func (s *Store) Lock() (*Lock, error) {
+	path := s.path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			_ = f.Close()
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file: %w", err)
+			}
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		pid, alive := lockHolder(path)
+		if alive {
+			return nil, fmt.Errorf("another hostcfg run (pid %d) holds the lock %s", pid, path)
+		}
+		// Stale lock from a run that died, remove it and try again
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale lock file: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("failed to take the lock %s", path)
+}
+
+// Release removes the lock file
+func (l *Lock) Release() error {
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+// lockHolder returns the PID in a lock file and whether that process is alive
+func lockHolder(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	// Signal 0 checks the process exists without signalling it
+	err = syscall.Kill(pid, 0)
+	return pid, err == nil || errors.Is(err, syscall.EPERM)
+}
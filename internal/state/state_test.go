@@ -0,0 +1,151 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_LoadMissing(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "state.json"))
+
+	st, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(st.Resources) != 0 {
+		t.Errorf("expected empty state, got %d resources", len(st.Resources))
+	}
+	if st.Version != FormatVersion {
+		t.Errorf("expected version %d, got %d", FormatVersion, st.Version)
+	}
+}
+
+func TestStore_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+	store := NewStore(path)
+
+	st := New()
+	st.Set("file", "motd", map[string]interface{}{
+		"path": "/etc/motd",
+		"mode": "0644",
+	}, []string{"package.nginx"})
+	st.Set("package", "nginx", map[string]interface{}{
+		"name":    "nginx",
+		"version": "1.24.0",
+	}, nil)
+
+	if err := store.Save(st); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("state file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %04o", info.Mode().Perm())
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	ids := loaded.IDs()
+	if len(ids) != 2 || ids[0] != "file.motd" || ids[1] != "package.nginx" {
+		t.Fatalf("unexpected IDs: %v", ids)
+	}
+
+	rs, ok := loaded.Get("file.motd")
+	if !ok {
+		t.Fatal("file.motd not found")
+	}
+	if rs.Type != "file" || rs.Name != "motd" {
+		t.Errorf("unexpected type/name: %s/%s", rs.Type, rs.Name)
+	}
+	if rs.Attributes["path"] != "/etc/motd" {
+		t.Errorf("unexpected path attribute: %v", rs.Attributes["path"])
+	}
+	if len(rs.DependsOn) != 1 || rs.DependsOn[0] != "package.nginx" {
+		t.Errorf("unexpected dependencies: %v", rs.DependsOn)
+	}
+	if rs.AppliedAt.IsZero() {
+		t.Error("expected applied_at to be set")
+	}
+}
+
+func TestStore_LoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if _, err := NewStore(path).Load(); err == nil {
+		t.Error("expected error for invalid state file")
+	}
+}
+
+func TestStore_LoadNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "resources": {}}`), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if _, err := NewStore(path).Load(); err == nil {
+		t.Error("expected error for newer state format")
+	}
+}
+
+func TestState_Remove(t *testing.T) {
+	st := New()
+	st.Set("file", "a", nil, nil)
+	st.Remove("file.a")
+
+	if _, ok := st.Get("file.a"); ok {
+		t.Error("expected file.a to be removed")
+	}
+}
+
+func TestStore_Lock(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "nested", "state.json"))
+
+	lock, err := store.Lock()
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	// A second run cannot take the lock while it is held
+	if _, err := store.Lock(); err == nil {
+		t.Fatal("expected second Lock to fail while the lock is held")
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := os.Stat(store.Path() + ".lock"); !os.IsNotExist(err) {
+		t.Error("expected lock file to be removed")
+	}
+
+	lock, err = store.Lock()
+	if err != nil {
+		t.Fatalf("Lock after Release failed: %v", err)
+	}
+	_ = lock.Release()
+}
+
+func TestStore_Lock_Stale(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "state.json"))
+
+	// A lock left behind by a process that no longer exists is taken over
+	for _, content := range []string{"999999999\n", "", "garbage"} {
+		if err := os.WriteFile(store.Path()+".lock", []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write lock file: %v", err)
+		}
+		lock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("expected stale lock %q to be taken over, got: %v", content, err)
+		}
+		_ = lock.Release()
+	}
+}